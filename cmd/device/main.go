@@ -2,19 +2,25 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/acl"
+	"example.com/me/myproxy/internal/constants"
 	"example.com/me/myproxy/internal/device/client"
+	"example.com/me/myproxy/internal/dns"
 	"example.com/me/myproxy/internal/logger"
 )
 
 func main() {
-	var debug bool
+	var debug, check bool
 	flag.BoolVar(&debug, "debug", false, "Enable debug logging")
+	flag.BoolVar(&check, "check", false, "Validate configuration and exit")
 
 	// Load configuration (this will call flag.Parse())
 	cfg, err := config.LoadDeviceConfig()
@@ -22,12 +28,28 @@ func main() {
 		log.Fatalf("Failed to load device configuration: %v", err)
 	}
 
+	// Check mode: the file must exist (a missing file silently falls back to defaults)
+	if check {
+		configFile := flag.Lookup("config").Value.String()
+		if _, err := os.Stat(configFile); err != nil && configFile != config.StdinPath {
+			log.Fatalf("Failed to load device configuration: %v", err)
+		}
+		fmt.Printf("Configuration %s is valid\n", configFile)
+		return
+	}
+
 	// Set debug level after flags are parsed
 	if debug {
 		logger.SetLevel(logger.LevelDebug)
 		logger.Debug("main", "Debug logging enabled")
 	}
 
+	// Create destination policy
+	policy, err := acl.NewPolicy(cfg.DestinationPolicy)
+	if err != nil {
+		log.Fatalf("Invalid destination policy: %v", err)
+	}
+
 	// Create device client
 	deviceClient := client.NewClient(
 		cfg.ProxyHost,
@@ -36,8 +58,27 @@ func main() {
 		cfg.DeviceID,
 		cfg.TLSEnabled,
 		cfg.TLSSkipVerify,
+		policy,
 	)
 
+	// Resolver override: domains are resolved on the device, by default with the system resolver
+	if cfg.DNS != nil {
+		resolver, err := dns.New(cfg.DNS)
+		if err != nil {
+			log.Fatalf("Invalid DNS configuration: %v", err)
+		}
+		deviceClient.SetResolver(resolver)
+	}
+
+	// Report the public egress IP to the POP
+	if cfg.EgressCheckURL != "" {
+		interval := cfg.EgressCheckInterval
+		if interval == 0 {
+			interval = constants.DefaultEgressCheckInterval
+		}
+		deviceClient.SetEgressCheck(cfg.EgressCheckURL, time.Duration(interval)*time.Second)
+	}
+
 	// Start device client
 	if err := deviceClient.Start(cfg.Location, cfg.Tags, cfg.HeartbeatInterval); err != nil {
 		log.Fatalf("Failed to start device client: %v", err)
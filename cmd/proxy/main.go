@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -10,11 +11,13 @@ import (
 	"example.com/me/myproxy/config"
 	"example.com/me/myproxy/internal/logger"
 	"example.com/me/myproxy/internal/server"
+	"example.com/me/myproxy/internal/upgrade"
 )
 
 func main() {
-	var debug bool
+	var debug, check bool
 	flag.BoolVar(&debug, "debug", false, "Enable debug logging")
+	flag.BoolVar(&check, "check", false, "Validate configuration and exit")
 
 	// Load configuration (this will call flag.Parse())
 	cfg, err := config.Load()
@@ -22,14 +25,27 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Check mode: an explicitly passed -config file must exist, config.Load rejects a missing one
+	if check {
+		fmt.Printf("Configuration %s is valid\n", flag.Lookup("config").Value.String())
+		return
+	}
+
 	// Set debug level after flags are parsed
 	if debug {
 		logger.SetLevel(logger.LevelDebug)
 		logger.Debug("main", "Debug logging enabled")
 	}
 
+	// Sockets inherited from the previous process (zero-downtime upgrade)
+	upgrader, err := upgrade.New()
+	if err != nil {
+		log.Fatalf("Failed to prepare upgrader: %v", err)
+	}
+
 	// Create and initialize server
 	srv := server.NewServer(cfg)
+	srv.SetUpgrader(upgrader)
 	if err := srv.Initialize(); err != nil {
 		log.Fatalf("Failed to initialize server: %v", err)
 	}
@@ -39,10 +55,30 @@ func main() {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 
-	// Wait for shutdown signal
+	// Wait for shutdown signal, SIGHUP reloads configuration,
+	// SIGUSR2 hands listening sockets over to a new process and drains this one
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+loop:
+	for sig := range sigChan {
+		switch sig {
+		case syscall.SIGHUP:
+			logger.Info("main", "Received SIGHUP, reloading configuration...")
+			if err := srv.Reload(); err != nil {
+				logger.Error("main", "Configuration reload failed, keeping current configuration: %v", err)
+			}
+		case syscall.SIGUSR2:
+			logger.Info("main", "Received SIGUSR2, starting new process...")
+			if err := srv.Upgrade(); err != nil {
+				logger.Error("main", "Upgrade failed, keeping current process: %v", err)
+				continue
+			}
+			logger.Info("main", "New process is ready, draining current process")
+			break loop
+		default:
+			break loop
+		}
+	}
 
 	logger.Info("main", "Shutting down proxy...")
 	if err := srv.Stop(); err != nil {
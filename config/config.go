@@ -2,16 +2,103 @@ package config
 
 // InboundConfig представляет конфигурацию inbound
 type InboundConfig struct {
-	Type string `json:"type"` // "socks5" для этапа 1
-	Port int    `json:"port"`
-	ID   string `json:"id,omitempty"` // Идентификатор inbound (опционально, для плагинов)
+	Type   string   `json:"type"`             // "socks5", "redirect" или "tproxy" (прозрачный прокси, Linux)
+	Listen string   `json:"listen,omitempty"` // Адрес интерфейса для bind (пусто - все интерфейсы)
+	Port   int      `json:"port"`
+	ID     string   `json:"id,omitempty"`    // Идентификатор inbound (опционально, для плагинов)
+	Allow  []string `json:"allow,omitempty"` // Разрешенные подсети клиентов (CIDR), пусто - все
+	Deny   []string `json:"deny,omitempty"`  // Запрещенные подсети клиентов (CIDR), приоритет над allow
+	// Аутентификация клиентов SOCKS5 по логину и паролю (RFC 1929), пусто - без аутентификации.
+	// Логин клиента становится UserID соединения для плагинов (лимиты и квоты по пользователю)
+	Users []InboundUserConfig `json:"users,omitempty"`
+	// Прозрачный прокси (для типа "tproxy")
+	Network    string `json:"network,omitempty"`     // "tcp", "udp" или "tcp_udp" (default)
+	UDPTimeout int    `json:"udp_timeout,omitempty"` // Время простоя UDP сессии (секунды, default: 60)
+	// Определение домена по первым байтам клиента, если адрес назначения - IP (опционально)
+	Sniffing *SniffingConfig `json:"sniffing,omitempty"`
+}
+
+// InboundUserConfig представляет учетную запись клиента SOCKS5 inbound
+type InboundUserConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// SniffingConfig представляет параметры определения протокола и домена назначения
+// по TLS ClientHello (SNI) и заголовку Host HTTP запроса
+type SniffingConfig struct {
+	Enabled bool `json:"enabled"`
+	// Подключаться к определенному домену вместо IP: router и outbound получают домен с исходным портом
+	OverrideDestination bool `json:"override_destination,omitempty"`
+	// Время ожидания первых байт клиента (миллисекунды, default: 300)
+	Timeout int `json:"timeout,omitempty"`
+	// Для SOCKS5 inbound: отправлять reply об успехе до подключения, чтобы получить первые байты клиента.
+	// Клиент не получит код ошибки подключения, без этого SOCKS5 соединения не определяются
+	EarlyReply bool `json:"early_reply,omitempty"`
 }
 
 // OutboundConfig представляет конфигурацию outbound
 type OutboundConfig struct {
-	Type         string `json:"type"`          // "direct" или "socks5"
-	ProxyAddress string `json:"proxy_address"` // Адрес SOCKS5 прокси (для типа "socks5")
-	ID           string `json:"id,omitempty"`  // Идентификатор outbound (опционально, для плагинов)
+	Type         string `json:"type"`                 // "direct", "socks5", "http", "group", "chain", "device" или "block"
+	ProxyAddress string `json:"proxy_address"`        // Адрес прокси (для типов "socks5" и "http")
+	ID           string `json:"id,omitempty"`         // Идентификатор outbound (опционально, для плагинов)
+	Username     string `json:"username,omitempty"`   // Логин вышестоящего SOCKS5 или HTTP прокси (пусто - без аутентификации)
+	Password     string `json:"password,omitempty"`   // Пароль вышестоящего SOCKS5 или HTTP прокси
+	DeviceID     string `json:"device_id,omitempty"`  // Устройство пула (для типа "device", пусто - выбирает router)
+	BlockMode    string `json:"block_mode,omitempty"` // Для типа "block": "reject" (default) или "http" (ответ 403 на HTTP запрос)
+	Resolve      string `json:"resolve,omitempty"`    // Разрешение доменов: "local" (на POP) или "remote" (прокси/устройство, default кроме direct)
+
+	// HTTP прокси (для типа "http")
+	TLS     *OutboundTLSConfig `json:"tls,omitempty"`     // TLS до прокси (опционально)
+	Headers map[string]string  `json:"headers,omitempty"` // Дополнительные заголовки запроса CONNECT
+
+	// Прямое подключение (для типа "direct")
+	SourceAddresses []string         `json:"source_addresses,omitempty"` // Локальные IP исходящих соединений, по очереди для каждого соединения
+	Interface       string           `json:"interface,omitempty"`        // Привязка к сетевому интерфейсу (SO_BINDTODEVICE, Linux)
+	Mark            int              `json:"mark,omitempty"`             // Метка пакетов для policy routing (SO_MARK, Linux)
+	IPPreference    string           `json:"ip_preference,omitempty"`    // "ipv4", "ipv6", "prefer_ipv4" или "prefer_ipv6" (пусто - как решит resolver)
+	TCPNoDelay      *bool            `json:"tcp_nodelay,omitempty"`      // TCP_NODELAY (default: включен)
+	KeepAlive       *KeepAliveConfig `json:"keepalive,omitempty"`        // TCP keepalive (default: включен с параметрами Go)
+
+	// Таймауты подключения (для типов "direct", "socks5" и "http")
+	ConnectTimeout   int `json:"connect_timeout,omitempty"`   // TCP подключение к цели или прокси (секунды, default: 10)
+	HandshakeTimeout int `json:"handshake_timeout,omitempty"` // Handshake с прокси, включая ответ на CONNECT (секунды, default: 10)
+
+	// Группа (для типа "group") и цепочка (для типа "chain")
+	Outbounds     []OutboundConfig `json:"outbounds,omitempty"`      // Участники группы или звенья цепочки по порядку
+	Strategy      string           `json:"strategy,omitempty"`       // "failover" (default), "round_robin" или "random"
+	ProbeAddress  string           `json:"probe_address,omitempty"`  // host:port для фоновой проверки участников (пусто - без проверок)
+	ProbeInterval int              `json:"probe_interval,omitempty"` // Интервал проверки (секунды, default: 30)
+	Weight        int              `json:"weight,omitempty"`         // Вес участника для стратегии "random" (default: 1)
+}
+
+// UsesDevice проверяет, подключается ли outbound (или один из его участников) через устройство пула
+func (c *OutboundConfig) UsesDevice() bool {
+	if c.Type == "device" {
+		return true
+	}
+	for i := range c.Outbounds {
+		if c.Outbounds[i].UsesDevice() {
+			return true
+		}
+	}
+	return false
+}
+
+// KeepAliveConfig представляет параметры TCP keepalive
+type KeepAliveConfig struct {
+	Enabled  bool `json:"enabled"`
+	Idle     int  `json:"idle,omitempty"`     // Время простоя до первой проверки (секунды, default: 15)
+	Interval int  `json:"interval,omitempty"` // Интервал между проверками (секунды, default: 15)
+	Count    int  `json:"count,omitempty"`    // Количество неотвеченных проверок до разрыва (default: 9)
+}
+
+// OutboundTLSConfig представляет конфигурацию TLS подключения к вышестоящему прокси
+type OutboundTLSConfig struct {
+	Enabled            bool   `json:"enabled"`
+	ServerName         string `json:"server_name,omitempty"`          // Имя для SNI и проверки сертификата (default: хост proxy_address)
+	CAFile             string `json:"ca_file,omitempty"`              // PEM файл доверенных CA (default: системные)
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"` // Не проверять сертификат прокси
 }
 
 // PluginConfig представляет конфигурацию плагина
@@ -24,6 +111,8 @@ type PluginConfig struct {
 type PluginsConfig struct {
 	TrafficInbound  *PluginConfig `json:"traffic_inbound,omitempty"`
 	TrafficOutbound *PluginConfig `json:"traffic_outbound,omitempty"`
+	Quota           *PluginConfig `json:"quota,omitempty"`     // Квоты и ограничение скорости по пользователю
+	ConnLimit       *PluginConfig `json:"connlimit,omitempty"` // Лимиты количества и частоты соединений
 }
 
 // TLSConfig представляет конфигурацию TLS
@@ -41,6 +130,105 @@ type OutboundPoolConfig struct {
 	TLS               *TLSConfig `json:"tls,omitempty"`       // TLS конфигурация (опционально)
 	HeartbeatInterval int        `json:"heartbeat_interval"` // Интервал heartbeat (секунды, default: 30)
 	HeartbeatTimeout  int        `json:"heartbeat_timeout"`   // Таймаут offline (секунды, default: 90)
+	DialTimeout       int        `json:"dial_timeout"`       // Таймаут подключения через устройство: stream и ответ устройства (секунды, default: 20)
+	// Хранилище метаданных и счетчиков устройств между перезапусками (опционально)
+	Store *RegistryStoreConfig `json:"store,omitempty"`
+	// Оценка здоровья устройств и автоматический карантин (опционально, по умолчанию включены)
+	Health *DeviceHealthConfig `json:"health,omitempty"`
+	// Повторное подключение через другое устройство, если подключение не удалось (опционально, по умолчанию включено)
+	Retry *DialRetryConfig `json:"retry,omitempty"`
+	// Разрешение доменов: "local" (на POP, устройству передается IP) или "remote" (на устройстве, default)
+	Resolve string `json:"resolve,omitempty"`
+}
+
+// DialRetryConfig представляет параметры повторных попыток подключения через другие устройства
+type DialRetryConfig struct {
+	Attempts int `json:"attempts,omitempty"` // Всего попыток, включая первую (default: 3, 1 - без повторов)
+	Budget   int `json:"budget,omitempty"`   // Общее время на попытки (секунды, default: 15)
+}
+
+// DeviceHealthConfig представляет параметры оценки здоровья устройств
+type DeviceHealthConfig struct {
+	Window       int     `json:"window,omitempty"`        // Окно статистики подключений (секунды, default: 300)
+	MinDials     int     `json:"min_dials,omitempty"`     // Минимум подключений в окне для оценки (default: 10)
+	MinScore     float64 `json:"min_score,omitempty"`     // Оценка, ниже которой устройство помещается в карантин (default: 0.3)
+	Cooldown     int     `json:"cooldown,omitempty"`      // Время до повторной проверки устройства в карантине (секунды, default: 60)
+	ProbeAddress string  `json:"probe_address,omitempty"` // host:port для повторной проверки (без него - возврат в выбор после cooldown)
+}
+
+// RegistryStoreConfig представляет конфигурацию хранилища реестра устройств
+type RegistryStoreConfig struct {
+	Type          string `json:"type"`                     // "file" или "redis"
+	Path          string `json:"path,omitempty"`           // Путь к файлу (для типа "file")
+	Address       string `json:"address,omitempty"`        // Адрес host:port (для типа "redis")
+	Password      string `json:"password,omitempty"`       // Пароль (для типа "redis")
+	DB            int    `json:"db,omitempty"`             // Номер базы (для типа "redis")
+	Prefix        string `json:"prefix,omitempty"`         // Префикс ключей (для типа "redis", default: "myproxy:")
+	FlushInterval int    `json:"flush_interval,omitempty"` // Интервал сохранения изменений (секунды, default: 10)
+}
+
+// DestinationPolicyConfig представляет политику допустимых адресов назначения
+type DestinationPolicyConfig struct {
+	BlockPrivate bool     `json:"block_private"`          // Запретить private, loopback, link-local и другие непубличные диапазоны
+	AllowCIDRs   []string `json:"allow_cidrs,omitempty"`  // Исключения из запретов по подсетям (приоритет над deny)
+	DenyCIDRs    []string `json:"deny_cidrs,omitempty"`   // Запрещенные подсети назначения
+	DenyPorts    []int    `json:"deny_ports,omitempty"`   // Запрещенные порты назначения (например, 25)
+	DenyDomains  []string `json:"deny_domains,omitempty"` // Запрещенные домены (включая поддомены)
+	// Разрешать домены назначения на POP и проверять их IP правилами подсетей и block_private
+	// (устройство всегда проверяет фактический IP при подключении)
+	ResolveDomains bool `json:"resolve_domains,omitempty"`
+}
+
+// RoutingConfig представляет правила выбора outbound по адресу назначения
+// Правила проверяются по порядку, соединение получает outbound первого совпавшего правила;
+// без совпадений используется outbound (или устройство пула), выбранный как обычно
+type RoutingConfig struct {
+	Rules []RoutingRuleConfig `json:"rules,omitempty"`
+}
+
+// RoutingRuleConfig представляет правило выбора outbound
+// Правило совпадает, если назначение подходит под любой из доменов или подсетей
+type RoutingRuleConfig struct {
+	Domains []string `json:"domains,omitempty"` // Домены назначения (включая поддомены)
+	// Подсети назначения (CIDR или отдельный IP); домен назначения проверяется по его IP,
+	// разрешенным resolver секции dns
+	IPs      []string       `json:"ips,omitempty"`
+	Outbound OutboundConfig `json:"outbound"` // Outbound создается для каждого совпавшего соединения
+}
+
+// DNSConfig представляет конфигурацию разрешения доменов на POP
+type DNSConfig struct {
+	// DNS серверы по порядку: "1.1.1.1", "udp://1.1.1.1:53", "tcp://1.1.1.1", "tls://1.1.1.1" (DoT),
+	// "https://cloudflare-dns.com/dns-query" (DoH); пусто - системный resolver
+	Servers   []string            `json:"servers,omitempty"`
+	Hosts     map[string][]string `json:"hosts,omitempty"`      // Статические адреса доменов
+	Timeout   int                 `json:"timeout,omitempty"`    // Таймаут запроса к одному серверу (секунды, default: 5)
+	CacheSize int                 `json:"cache_size,omitempty"` // Максимум доменов в кеше (default: 4096)
+	MinTTL    int                 `json:"min_ttl,omitempty"`    // Минимальное время хранения ответа в кеше (секунды)
+	MaxTTL    int                 `json:"max_ttl,omitempty"`    // Максимальное время хранения ответа в кеше (секунды, default: 3600)
+}
+
+// ClusterConfig представляет конфигурацию кластера POP
+// POP обмениваются списками подключенных устройств (gossip) и передают соединения
+// POP, к которому подключено устройство, через QUIC туннель
+type ClusterConfig struct {
+	NodeID    string   `json:"node_id"`             // Уникальный идентификатор POP в кластере
+	Listen    string   `json:"listen"`              // UDP адрес inter-POP QUIC (например, "0.0.0.0:7443")
+	Advertise string   `json:"advertise,omitempty"` // Адрес для подключения других POP (default: listen)
+	Peers     []string `json:"peers,omitempty"`     // Адреса POP для начального обмена (host:port)
+	Secret    string   `json:"secret"`              // Общий секрет для взаимной аутентификации POP
+	// Интервал обмена списками устройств (секунды, default: 2)
+	GossipInterval int `json:"gossip_interval,omitempty"`
+	// Время, после которого POP без обновлений исключается из кластера (секунды, default: 10)
+	NodeTimeout int `json:"node_timeout,omitempty"`
+	// Надбавка к стоимости устройства другого POP в активных соединениях (default: 100)
+	RemotePenalty int `json:"remote_penalty,omitempty"`
+}
+
+// AdminConfig представляет конфигурацию административного HTTP API
+type AdminConfig struct {
+	Listen string `json:"listen"`          // Адрес HTTP API (например, "127.0.0.1:9090")
+	Token  string `json:"token,omitempty"` // Bearer токен запросов (обязателен, если listen не loopback)
 }
 
 // Config представляет полную конфигурацию приложения
@@ -49,5 +237,19 @@ type Config struct {
 	Outbound     OutboundConfig     `json:"outbound"`
 	Plugins      PluginsConfig      `json:"plugins,omitempty"`
 	OutboundPool *OutboundPoolConfig `json:"outbound_pool,omitempty"`
+	// Политика адресов назначения, проверяется до выбора outbound/устройства
+	DestinationPolicy *DestinationPolicyConfig `json:"destination_policy,omitempty"`
+	Admin             *AdminConfig             `json:"admin,omitempty"` // Административный API (перезагрузка и т.п.)
+	// Время ожидания завершения активных соединений при остановке (секунды, default: 30)
+	ShutdownTimeout int `json:"shutdown_timeout,omitempty"`
+	// Кластер POP: обмен списками устройств и соединения через устройства других POP (опционально)
+	Cluster *ClusterConfig `json:"cluster,omitempty"`
+	// Разрешение доменов на POP: DNS серверы, кеш и статические адреса (опционально)
+	DNS *DNSConfig `json:"dns,omitempty"`
+	// Правила выбора outbound по домену и IP назначения (опционально)
+	Routing *RoutingConfig `json:"routing,omitempty"`
+
+	path         string // Файл, из которого загружена конфигурация (для перезагрузки)
+	portOverride int    // Порт inbound из CLI, переопределяет файл и при перезагрузке
 }
 
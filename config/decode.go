@@ -0,0 +1,166 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// decodeStrict разбирает JSON в v, отклоняя неизвестные поля
+// Ошибки содержат путь к полю (например, "outbound_pool.wss_port"), для синтаксических ошибок - строку и столбец
+func decodeStrict(data []byte, v interface{}) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return describeJSONError(data, err)
+	}
+
+	var errs validator
+	checkUnknownFields(raw, reflect.TypeOf(v), "", &errs)
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return describeJSONError(data, err)
+	}
+	return nil
+}
+
+// describeJSONError добавляет к ошибке encoding/json место в документе
+func describeJSONError(data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		// Offset указывает на позицию после ошибочного символа
+		line, column := position(data, syntaxErr.Offset-1)
+		return fmt.Errorf("line %d, column %d: %s", line, column, syntaxErr.Error())
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		path := typeErr.Field
+		if path == "" {
+			path = "(root)"
+		}
+		return &ValidationError{Errors: []*FieldError{{
+			Path:    path,
+			Message: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value),
+		}}}
+	}
+
+	return err
+}
+
+// position вычисляет строку и столбец (с 1) для смещения в документе
+func position(data []byte, offset int64) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// checkUnknownFields сравнивает разобранный документ со структурой конфигурации
+// и добавляет ошибку для каждого поля, которого нет в структуре
+// Как и encoding/json, имена полей сравниваются без учета регистра
+func checkUnknownFields(value interface{}, t reflect.Type, path string, errs *validator) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(object) {
+			field, ok := lookupField(fields, key)
+			if !ok {
+				errs.add(joinPath(path, key), "unknown field")
+				continue
+			}
+			checkUnknownFields(object[key], field.Type, joinPath(path, key), errs)
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range items {
+			checkUnknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.Map:
+		// Произвольные параметры (например, config плагинов) проверяет их владелец
+		if t.Elem().Kind() == reflect.Interface {
+			return
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, key := range sortedKeys(object) {
+			checkUnknownFields(object[key], t.Elem(), joinPath(path, key), errs)
+		}
+	}
+}
+
+// jsonFields возвращает экспортируемые поля структуры по их JSON именам
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// lookupField ищет поле по имени: сначала точное совпадение, затем без учета регистра
+func lookupField(fields map[string]reflect.StructField, key string) (reflect.StructField, bool) {
+	if field, ok := fields[key]; ok {
+		return field, true
+	}
+	for name, field := range fields {
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// sortedKeys возвращает ключи объекта по порядку (ошибки выводятся в стабильном порядке)
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// joinPath добавляет имя поля к пути
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
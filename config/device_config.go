@@ -1,10 +1,8 @@
 package config
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
-	"os"
 )
 
 // DeviceConfig представляет конфигурацию device client
@@ -18,9 +16,19 @@ type DeviceConfig struct {
 	HeartbeatInterval int      `json:"heartbeat_interval"`
 	TLSEnabled       bool     `json:"tls_enabled"`         // Использовать TLS (default: false)
 	TLSSkipVerify    bool     `json:"tls_skip_verify"`     // Пропустить проверку TLS сертификатов (для тестирования)
+	// Политика адресов назначения, проверяется после разрешения DNS
+	// (по умолчанию запрещены непубличные диапазоны и порт 25)
+	DestinationPolicy *DestinationPolicyConfig `json:"destination_policy,omitempty"`
+	// Resolver доменов назначения (по умолчанию системный)
+	DNS *DNSConfig `json:"dns,omitempty"`
+	// URL, ответ которого - публичный IP device (например, "https://api.ipify.org");
+	// IP сообщается POP в heartbeat и результате подключения. Пусто - не определяется
+	EgressCheckURL      string `json:"egress_check_url,omitempty"`
+	EgressCheckInterval int    `json:"egress_check_interval,omitempty"` // Интервал определения публичного IP (секунды, default: 300)
 }
 
-// LoadDeviceConfig загружает конфигурацию device из файла и переопределяет через CLI аргументы
+// LoadDeviceConfig загружает конфигурацию device из файла и переопределяет
+// через переменные окружения MYPROXY_* и CLI аргументы (в порядке возрастания приоритета)
 func LoadDeviceConfig() (*DeviceConfig, error) {
 	var configFile string
 	var proxyHost string
@@ -31,7 +39,7 @@ func LoadDeviceConfig() (*DeviceConfig, error) {
 	var heartbeatInterval int
 	var tlsSkipVerify bool
 
-	flag.StringVar(&configFile, "config", "device_config.json", "Path to device configuration file")
+	flag.StringVar(&configFile, "config", "device_config.json", "Path to device configuration file (.json, .yaml, .toml or - for stdin)")
 	flag.StringVar(&proxyHost, "proxy", "", "Proxy host")
 	flag.IntVar(&wssPort, "wss-port", 0, "WSS control-plane port (default: 443)")
 	flag.IntVar(&quicPort, "quic-port", 0, "QUIC data-plane port (default: 443)")
@@ -48,18 +56,26 @@ func LoadDeviceConfig() (*DeviceConfig, error) {
 		HeartbeatInterval: 30,
 		TLSEnabled:       false,
 		TLSSkipVerify:    false,
+		DestinationPolicy: &DestinationPolicyConfig{
+			BlockPrivate: true,
+			DenyPorts:    []int{25},
+		},
 	}
 
 	// Load from file if exists
-	if _, err := os.Stat(configFile); err == nil {
-		data, err := os.ReadFile(configFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read config: %w", err)
+	data, format, found, err := readSource(configFile, !flagPassed("config"))
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if err := decode(data, format, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config %s: %w", configFile, err)
 		}
+	}
 
-		if err := json.Unmarshal(data, cfg); err != nil {
-			return nil, fmt.Errorf("failed to parse config: %w", err)
-		}
+	// Override via environment variables
+	if err := applyEnvOverrides(cfg, EnvPrefix); err != nil {
+		return nil, err
 	}
 
 	// Override via CLI arguments
@@ -85,11 +101,9 @@ func LoadDeviceConfig() (*DeviceConfig, error) {
 		cfg.TLSSkipVerify = true
 	}
 
-	// Validate required fields
-	if cfg.DeviceID == "" {
-		return nil, fmt.Errorf("device_id is required")
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
-
 	return cfg, nil
 }
 
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix префикс переменных окружения, переопределяющих конфигурацию device
+const EnvPrefix = "MYPROXY_"
+
+// envReference ссылка на переменную окружения: ${NAME} или ${NAME:-default}
+var envReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv раскрывает ссылки ${NAME} и ${NAME:-default} в строковом значении конфигурации
+// Значение по умолчанию используется, если переменная не задана или пуста; "$$" записывает "$".
+// Ссылка на незаданную переменную без значения по умолчанию - ошибка
+func expandEnv(data []byte) ([]byte, error) {
+	var missing []string
+	result := envReference.ReplaceAllFunc(data, func(match []byte) []byte {
+		if bytes.Equal(match, []byte("$$")) {
+			return []byte("$")
+		}
+		groups := envReference.FindSubmatch(match)
+		name, hasDefault := string(groups[1]), len(groups[2]) > 0
+		if value := os.Getenv(name); value != "" {
+			return []byte(value)
+		}
+		if hasDefault {
+			return groups[3]
+		}
+		if _, ok := os.LookupEnv(name); !ok {
+			missing = append(missing, name)
+		}
+		return nil
+	})
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return result, nil
+}
+
+// expandEnvValues раскрывает ссылки на переменные окружения в строковых значениях
+// разобранного документа; t - тип, в который он будет разобран (nil - неизвестен).
+// Значение со ссылкой в числовом или логическом поле преобразуется в число или bool
+func expandEnvValues(value interface{}, t reflect.Type, path string, errs *validator) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		var fields map[string]reflect.StructField
+		if t != nil && t.Kind() == reflect.Struct {
+			fields = jsonFields(t)
+		}
+		for key, item := range v {
+			var itemType reflect.Type
+			if fields != nil {
+				if field, ok := lookupField(fields, key); ok {
+					itemType = field.Type
+				}
+			} else if t != nil && t.Kind() == reflect.Map {
+				itemType = t.Elem()
+			}
+			v[key] = expandEnvValues(item, itemType, joinPath(path, key), errs)
+		}
+	case []interface{}:
+		var itemType reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			itemType = t.Elem()
+		}
+		for i, item := range v {
+			v[i] = expandEnvValues(item, itemType, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case string:
+		if !strings.Contains(v, "$") {
+			return v
+		}
+		expanded, err := expandEnv([]byte(v))
+		if err != nil {
+			errs.add(path, "%v", err)
+			return v
+		}
+		return typedValue(string(expanded), t)
+	}
+	return value
+}
+
+// typedValue преобразует раскрытое значение в число или bool, если этого требует тип поля
+// Значение, которое не удалось преобразовать, остается строкой (ошибку типа вернет разбор)
+func typedValue(s string, t reflect.Type) interface{} {
+	if t == nil {
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		decoder := json.NewDecoder(strings.NewReader(s))
+		decoder.UseNumber()
+		var parsed interface{}
+		if err := decoder.Decode(&parsed); err == nil && !decoder.More() {
+			if n, ok := parsed.(json.Number); ok {
+				return n
+			}
+		}
+	}
+	return s
+}
+
+// applyEnvOverrides переопределяет поля верхнего уровня из переменных окружения
+// prefix + JSON имя поля в верхнем регистре (MYPROXY_DEVICE_ID, MYPROXY_WSS_PORT, ...)
+// Поддерживаются строки, числа, bool и списки строк (через запятую); вложенные объекты не переопределяются
+func applyEnvOverrides(v interface{}, prefix string) error {
+	var errs validator
+	value := reflect.ValueOf(v).Elem()
+	for name, field := range jsonFields(value.Type()) {
+		envName := prefix + strings.ToUpper(name)
+		raw, ok := os.LookupEnv(envName)
+		if !ok {
+			continue
+		}
+
+		target := value.FieldByIndex(field.Index)
+		switch target.Kind() {
+		case reflect.String:
+			target.SetString(raw)
+		case reflect.Int:
+			n, err := strconv.Atoi(strings.TrimSpace(raw))
+			if err != nil {
+				errs.add(envName, "expected integer, got %q", raw)
+				continue
+			}
+			target.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(strings.TrimSpace(raw))
+			if err != nil {
+				errs.add(envName, "expected boolean, got %q", raw)
+				continue
+			}
+			target.SetBool(b)
+		case reflect.Slice:
+			if target.Type().Elem().Kind() != reflect.String {
+				continue
+			}
+			var items []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			target.Set(reflect.ValueOf(items))
+		}
+	}
+	return errs.err()
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("MYPROXY_TEST_HOST", "10.0.0.1")
+	t.Setenv("MYPROXY_TEST_EMPTY", "")
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`"${MYPROXY_TEST_HOST}:1080"`, `"10.0.0.1:1080"`},
+		{`${MYPROXY_TEST_HOST:-127.0.0.1}`, `10.0.0.1`},
+		{`${MYPROXY_TEST_UNSET:-127.0.0.1}`, `127.0.0.1`},
+		{`${MYPROXY_TEST_EMPTY:-fallback}`, `fallback`},
+		{`${MYPROXY_TEST_EMPTY}`, ``},
+		{`pa$$word $HOME`, `pa$word $HOME`},
+	}
+	for _, tt := range tests {
+		got, err := expandEnv([]byte(tt.input))
+		if err != nil {
+			t.Fatalf("expandEnv(%q): %v", tt.input, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("expandEnv(%q) = %q, ожидалось %q", tt.input, got, tt.want)
+		}
+	}
+
+	_, err := expandEnv([]byte(`${MYPROXY_TEST_UNSET_A} ${MYPROXY_TEST_UNSET_B}`))
+	if err == nil || !strings.Contains(err.Error(), "MYPROXY_TEST_UNSET_A, MYPROXY_TEST_UNSET_B") {
+		t.Errorf("Ожидалась ошибка со списком незаданных переменных, получено %v", err)
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	t.Setenv("MYPROXY_PROXY_HOST", "pop.example.com")
+	t.Setenv("MYPROXY_WSS_PORT", "8443")
+	t.Setenv("MYPROXY_TLS_ENABLED", "true")
+	t.Setenv("MYPROXY_TAGS", "mobile, eu ,")
+
+	cfg := &DeviceConfig{ProxyHost: "127.0.0.1", WSSPort: 443, DeviceID: "d1"}
+	if err := applyEnvOverrides(cfg, EnvPrefix); err != nil {
+		t.Fatalf("applyEnvOverrides: %v", err)
+	}
+
+	if cfg.ProxyHost != "pop.example.com" || cfg.WSSPort != 8443 || !cfg.TLSEnabled || cfg.DeviceID != "d1" {
+		t.Errorf("Неверная конфигурация после переопределения: %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.Tags, []string{"mobile", "eu"}) {
+		t.Errorf("Неверные теги: %v", cfg.Tags)
+	}
+
+	t.Setenv("MYPROXY_QUIC_PORT", "abc")
+	err := applyEnvOverrides(cfg, EnvPrefix)
+	if err == nil || !strings.Contains(err.Error(), "MYPROXY_QUIC_PORT: expected integer") {
+		t.Errorf("Ожидалась ошибка разбора числа, получено %v", err)
+	}
+}
+
+func TestLoadFile_EnvValues(t *testing.T) {
+	// Кавычки и обратная косая черта в значении не меняют структуру документа
+	t.Setenv("MYPROXY_TEST_PASSWORD", `p"a\ss", "type": "direct`)
+	t.Setenv("MYPROXY_TEST_PORT", "2080")
+
+	files := map[string]string{
+		"config.json": `{
+			"inbound": {"type": "socks5", "port": "${MYPROXY_TEST_PORT}"},
+			"outbound": {"type": "socks5", "proxy_address": "127.0.0.1:9050", "username": "user", "password": "${MYPROXY_TEST_PASSWORD}"}
+		}`,
+		"config.yaml": `
+inbound:
+  type: socks5
+  port: ${MYPROXY_TEST_PORT}
+outbound:
+  type: socks5
+  proxy_address: 127.0.0.1:9050
+  username: user
+  password: ${MYPROXY_TEST_PASSWORD}
+`,
+		"config.toml": `
+[inbound]
+type = "socks5"
+port = "${MYPROXY_TEST_PORT}"
+
+[outbound]
+type = "socks5"
+proxy_address = "127.0.0.1:9050"
+username = "user"
+password = "${MYPROXY_TEST_PASSWORD}"
+`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Ошибка записи конфига: %v", err)
+		}
+		cfg, err := LoadFile(path)
+		if err != nil {
+			t.Fatalf("Ошибка загрузки %s: %v", name, err)
+		}
+		if cfg.Outbound.Type != "socks5" || cfg.Outbound.Password != `p"a\ss", "type": "direct` {
+			t.Errorf("%s: значение переменной изменило конфигурацию: %+v", name, cfg.Outbound)
+		}
+		if cfg.Inbound.Port != 2080 {
+			t.Errorf("%s: неверный порт из переменной: %d", name, cfg.Inbound.Port)
+		}
+	}
+
+	// Незаданная переменная - ошибка с путем к полю
+	path := filepath.Join(dir, "missing.json")
+	if err := os.WriteFile(path, []byte(`{"outbound": {"password": "${MYPROXY_TEST_UNSET}"}}`), 0644); err != nil {
+		t.Fatalf("Ошибка записи конфига: %v", err)
+	}
+	_, err := LoadFile(path)
+	if err == nil || !strings.Contains(err.Error(), "outbound.password") || !strings.Contains(err.Error(), "MYPROXY_TEST_UNSET") {
+		t.Errorf("Ожидалась ошибка для outbound.password, получено %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// StdinPath путь конфигурации, при котором она читается из стандартного ввода
+const StdinPath = "-"
+
+// Форматы файлов конфигурации
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// tomlKeyLine строка TOML вида "key = value"
+var tomlKeyLine = regexp.MustCompile(`^[A-Za-z0-9_."-]+\s*=`)
+
+// readSource читает конфигурацию из файла или stdin ("-")
+// found=false, если файл не существует и optional (используются значения по умолчанию);
+// отсутствующий обязательный файл и ошибки доступа возвращаются как ошибка
+func readSource(path string, optional bool) (data []byte, format string, found bool, err error) {
+	if path == StdinPath {
+		data, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, "", false, fmt.Errorf("failed to read config from stdin: %w", err)
+		}
+		format = detectFormat(data)
+	} else {
+		if _, err := os.Stat(path); err != nil {
+			if optional && errors.Is(err, fs.ErrNotExist) {
+				return nil, "", false, nil
+			}
+			return nil, "", false, fmt.Errorf("failed to read config: %w", err)
+		}
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, "", false, fmt.Errorf("failed to read config: %w", err)
+		}
+		format, err = formatFromPath(path)
+		if err != nil {
+			return nil, "", false, err
+		}
+	}
+
+	return data, format, true, nil
+}
+
+// formatFromPath определяет формат по расширению файла
+// Файлы без расширения читаются как JSON
+func formatFromPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", "":
+		return FormatJSON, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".toml":
+		return FormatTOML, nil
+	default:
+		return "", fmt.Errorf("unsupported config format %q (expected .json, .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+}
+
+// detectFormat определяет формат конфигурации из stdin по содержимому:
+// объект JSON, TOML (таблицы [section] или строки key = value), иначе YAML
+func detectFormat(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "{"):
+			return FormatJSON
+		case strings.HasPrefix(line, "[") || tomlKeyLine.MatchString(line):
+			return FormatTOML
+		default:
+			return FormatYAML
+		}
+	}
+	return FormatJSON
+}
+
+// decode разбирает конфигурацию в v с проверкой неизвестных полей
+// YAML и TOML приводятся к JSON, поэтому правила разбора и ошибки одинаковы для всех форматов.
+// Переменные окружения раскрываются в строковых значениях уже разобранного документа,
+// поэтому значение переменной не может изменить его структуру
+func decode(data []byte, format string, v interface{}) error {
+	var raw interface{}
+	switch format {
+	case FormatJSON:
+		// Синтаксические ошибки с позицией в документе
+		var probe interface{}
+		if err := json.Unmarshal(data, &probe); err != nil {
+			return describeJSONError(data, err)
+		}
+		// Числа без потери точности при повторном кодировании
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&raw); err != nil {
+			return err
+		}
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		if raw == nil {
+			// Пустой документ
+			return nil
+		}
+	case FormatTOML:
+		table := make(map[string]interface{})
+		if _, err := toml.Decode(string(data), &table); err != nil {
+			return err
+		}
+		raw = table
+	default:
+		return fmt.Errorf("unsupported config format %q", format)
+	}
+
+	var errs validator
+	raw = expandEnvValues(raw, reflect.TypeOf(v), "", &errs)
+	if err := errs.err(); err != nil {
+		return err
+	}
+	return decodeConverted(raw, v)
+}
+
+// decodeConverted перекодирует разобранный документ в JSON и разбирает его в v
+func decodeConverted(raw interface{}, v interface{}) error {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("unsupported value in config: %w", err)
+	}
+	return decodeStrict(data, v)
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadFile_Formats(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{
+			"inbound": {"type": "socks5", "port": 1081, "allow": ["10.0.0.0/8"]},
+			"outbound": {"type": "socks5", "proxy_address": "127.0.0.1:9050"},
+			"plugins": {"quota": {"enabled": true, "config": {"daily_limit": 100}}}
+		}`,
+		"config.yaml": `
+inbound:
+  type: socks5
+  port: 1081
+  allow: ["10.0.0.0/8"]
+outbound:
+  type: socks5
+  proxy_address: 127.0.0.1:9050
+plugins:
+  quota:
+    enabled: true
+    config:
+      daily_limit: 100
+`,
+		"config.toml": `
+[inbound]
+type = "socks5"
+port = 1081
+allow = ["10.0.0.0/8"]
+
+[outbound]
+type = "socks5"
+proxy_address = "127.0.0.1:9050"
+
+[plugins.quota]
+enabled = true
+config = { daily_limit = 100 }
+`,
+	}
+
+	dir := t.TempDir()
+	var configs []*Config
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(files[name]), 0644); err != nil {
+			t.Fatalf("Ошибка записи конфига: %v", err)
+		}
+		cfg, err := LoadFile(path)
+		if err != nil {
+			t.Fatalf("Ошибка загрузки %s: %v", name, err)
+		}
+		cfg.path = ""
+		configs = append(configs, cfg)
+	}
+
+	for i, name := range []string{"config.yaml", "config.toml"} {
+		if !reflect.DeepEqual(configs[0], configs[i+1]) {
+			t.Errorf("%s разобран иначе, чем JSON: %+v", name, configs[i+1])
+		}
+	}
+}
+
+func TestLoadFile_UnknownFieldYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte("inbound:\n  type: socks5\n  prot: 1080\n"), 0644); err != nil {
+		t.Fatalf("Ошибка записи конфига: %v", err)
+	}
+	_, err := LoadFile(path)
+	if err == nil || !strings.Contains(err.Error(), "inbound.prot: unknown field") {
+		t.Errorf("Ожидалась ошибка с путем неизвестного поля, получено %v", err)
+	}
+}
+
+func TestLoadFile_UnsupportedExtension(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.ini")
+	if err := os.WriteFile(path, []byte("port=1080"), 0644); err != nil {
+		t.Fatalf("Ошибка записи конфига: %v", err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("Ожидалась ошибка для неподдерживаемого расширения")
+	}
+}
+
+func TestLoadFile_Stdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	originalStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = originalStdin }()
+
+	go func() {
+		w.Write([]byte("inbound:\n  type: socks5\n  port: 2080\n"))
+		w.Close()
+	}()
+
+	cfg, err := LoadFile(StdinPath)
+	if err != nil {
+		t.Fatalf("Ошибка загрузки из stdin: %v", err)
+	}
+	if cfg.Inbound.Port != 2080 {
+		t.Errorf("Неверный порт: ожидалось 2080, получено %d", cfg.Inbound.Port)
+	}
+	if _, err := cfg.Reload(); err == nil {
+		t.Error("Перезагрузка конфигурации из stdin должна быть отклонена")
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string]string{
+		`{"inbound": {}}`:                   FormatJSON,
+		"# comment\n[inbound]\nport = 1080": FormatTOML,
+		"shutdown_timeout = 10":             FormatTOML,
+		"inbound:\n  port: 1080":            FormatYAML,
+		"":                                  FormatJSON,
+	}
+	for data, want := range tests {
+		if got := detectFormat([]byte(data)); got != want {
+			t.Errorf("detectFormat(%q) = %s, ожидалось %s", data, got, want)
+		}
+	}
+}
@@ -1,10 +1,8 @@
 package config
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
-	"os"
 )
 
 // Load загружает конфигурацию из файла и переопределяет через CLI аргументы
@@ -12,10 +10,43 @@ func Load() (*Config, error) {
 	var configFile string
 	var port int
 
-	flag.StringVar(&configFile, "config", "config.json", "Path to configuration file")
+	flag.StringVar(&configFile, "config", "config.json", "Path to configuration file (.json, .yaml, .toml or - for stdin)")
 	flag.IntVar(&port, "port", 0, "Port for inbound (overrides config)")
 	flag.Parse()
 
+	// Без -config отсутствующий файл по умолчанию заменяется значениями по умолчанию
+	cfg, err := readFile(configFile, !flagPassed("config"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Override via CLI arguments
+	cfg.portOverride = port
+	cfg.applyOverrides()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile загружает и проверяет конфигурацию из файла (без CLI аргументов)
+// Отсутствующий файл - ошибка
+func LoadFile(configFile string) (*Config, error) {
+	cfg, err := readFile(configFile, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readFile читает конфигурацию из файла поверх значений по умолчанию
+// Неизвестные поля отклоняются, семантическая проверка выполняется вызывающим
+// Если optional, отсутствующий файл означает конфигурацию по умолчанию
+func readFile(configFile string, optional bool) (*Config, error) {
 	cfg := &Config{
 		Inbound: InboundConfig{
 			Type: "socks5",
@@ -24,25 +55,63 @@ func Load() (*Config, error) {
 		Outbound: OutboundConfig{
 			Type: "direct",
 		},
+		path: configFile,
 	}
 
 	// Load from file if exists
-	if _, err := os.Stat(configFile); err == nil {
-		data, err := os.ReadFile(configFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read config: %w", err)
+	data, format, found, err := readSource(configFile, optional)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if err := decode(data, format, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config %s: %w", configFile, err)
 		}
+	}
 
-		if err := json.Unmarshal(data, cfg); err != nil {
-			return nil, fmt.Errorf("failed to parse config: %w", err)
-		}
+	return cfg, nil
+}
+
+// Reload повторно читает файл, из которого была загружена конфигурация,
+// и применяет те же переопределения CLI. Текущая конфигурация не изменяется
+func (c *Config) Reload() (*Config, error) {
+	if c.path == "" || c.path == StdinPath {
+		return nil, fmt.Errorf("configuration was not loaded from a file")
 	}
 
-	// Override via CLI arguments
-	if port > 0 {
-		cfg.Inbound.Port = port
+	// Удаленный или недоступный файл не заменяется значениями по умолчанию: перезагрузка отклоняется
+	cfg, err := readFile(c.path, false)
+	if err != nil {
+		return nil, err
 	}
+	cfg.portOverride = c.portOverride
+	cfg.applyOverrides()
 
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
+// Path возвращает путь к файлу конфигурации
+func (c *Config) Path() string {
+	return c.path
+}
+
+// applyOverrides применяет переопределения из CLI аргументов
+func (c *Config) applyOverrides() {
+	if c.portOverride > 0 {
+		c.Inbound.Port = c.portOverride
+	}
+}
+
+// flagPassed проверяет, задан ли флаг в командной строке явно
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
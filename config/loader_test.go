@@ -156,3 +156,72 @@ func TestLoad_PortOverride(t *testing.T) {
 	}
 }
 
+func TestConfig_Reload(t *testing.T) {
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "test_config.json")
+
+	write := func(content string) {
+		if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+			t.Fatalf("Ошибка записи конфига: %v", err)
+		}
+	}
+	write(`{"inbound": {"type": "socks5", "port": 8080}, "outbound": {"type": "direct"}}`)
+
+	originalArgs := os.Args
+	defer func() {
+		os.Args = originalArgs
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	}()
+	os.Args = []string{"test", "-config", configFile, "-port", "9090"}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+	if cfg.Path() != configFile {
+		t.Errorf("Неверный путь: ожидалось %s, получено %s", configFile, cfg.Path())
+	}
+
+	write(`{"inbound": {"type": "socks5", "port": 8080}, "outbound": {"type": "socks5", "proxy_address": "127.0.0.1:1081"}}`)
+
+	newCfg, err := cfg.Reload()
+	if err != nil {
+		t.Fatalf("Ошибка перезагрузки конфигурации: %v", err)
+	}
+	if newCfg.Outbound.Type != "socks5" {
+		t.Errorf("Outbound не обновлен: получено %s", newCfg.Outbound.Type)
+	}
+	if newCfg.Inbound.Port != 9090 {
+		t.Errorf("Переопределение порта потеряно: ожидалось 9090, получено %d", newCfg.Inbound.Port)
+	}
+	if cfg.Outbound.Type != "direct" {
+		t.Errorf("Старая конфигурация изменена: получено %s", cfg.Outbound.Type)
+	}
+
+	write(`{"inbound": `)
+	if _, err := cfg.Reload(); err == nil {
+		t.Error("Ожидалась ошибка при перезагрузке невалидного JSON")
+	}
+
+	// Удаленный файл не заменяется значениями по умолчанию
+	os.Remove(configFile)
+	if _, err := cfg.Reload(); err == nil {
+		t.Error("Ожидалась ошибка при перезагрузке удаленного файла")
+	}
+}
+
+func TestLoad_MissingExplicitConfig(t *testing.T) {
+	originalArgs := os.Args
+	defer func() {
+		os.Args = originalArgs
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	}()
+	os.Args = []string{"test", "-config", filepath.Join(t.TempDir(), "missing.json")}
+
+	if _, err := Load(); err == nil {
+		t.Error("Ожидалась ошибка для отсутствующего файла, заданного через -config")
+	}
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Ожидалась ошибка LoadFile для отсутствующего файла")
+	}
+}
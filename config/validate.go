@@ -0,0 +1,561 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// FieldError ошибка в значении поля конфигурации
+type FieldError struct {
+	Path    string // Путь к полю, например "outbound.proxy_address" или "inbound.allow[1]"
+	Message string
+}
+
+// Error реализует интерфейс error
+func (e *FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationError все ошибки, найденные при проверке конфигурации
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+// Error реализует интерфейс error
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		messages[i] = fieldErr.Error()
+	}
+	return "invalid configuration: " + strings.Join(messages, "; ")
+}
+
+// validator накапливает ошибки проверки, чтобы сообщить обо всех сразу
+type validator struct {
+	errors []*FieldError
+}
+
+// add добавляет ошибку для поля
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, &FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// err возвращает *ValidationError или nil, если ошибок нет
+func (v *validator) err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errors}
+}
+
+// port проверяет номер порта; optional допускает 0 (значение по умолчанию)
+func (v *validator) port(path string, port int, optional bool) {
+	if optional && port == 0 {
+		return
+	}
+	if port < 1 || port > 65535 {
+		v.add(path, "must be between 1 and 65535, got %d", port)
+	}
+}
+
+// nonNegative проверяет, что значение не отрицательное
+func (v *validator) nonNegative(path string, value int) {
+	if value < 0 {
+		v.add(path, "must not be negative, got %d", value)
+	}
+}
+
+// hostPort проверяет адрес вида host:port
+func (v *validator) hostPort(path, address string) {
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		v.add(path, "must be host:port, got %q", address)
+		return
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		v.add(path, "invalid port in %q", address)
+	}
+}
+
+// isLoopback проверяет, что адрес host:port слушает только loopback интерфейс
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// oneOf проверяет, что значение входит в список допустимых
+func (v *validator) oneOf(path, value string, allowed ...string) {
+	if value == "" {
+		v.add(path, "is required (one of: %s)", strings.Join(allowed, ", "))
+		return
+	}
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add(path, "unsupported value %q (one of: %s)", value, strings.Join(allowed, ", "))
+}
+
+// cidrs проверяет список подсетей; отдельный IP допустим
+func (v *validator) cidrs(path string, entries []string) {
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				v.add(fmt.Sprintf("%s[%d]", path, i), "invalid CIDR %q", entry)
+			}
+		} else if net.ParseIP(entry) == nil {
+			v.add(fmt.Sprintf("%s[%d]", path, i), "invalid IP address %q", entry)
+		}
+	}
+}
+
+// inboundUsers проверяет учетные записи клиентов SOCKS5 inbound (ограничения RFC 1929)
+func (v *validator) inboundUsers(path string, cfg *InboundConfig) {
+	if len(cfg.Users) == 0 {
+		return
+	}
+	if cfg.Type != "socks5" {
+		v.add(path, "is only allowed for socks5 inbound")
+		return
+	}
+	usernames := make(map[string]bool, len(cfg.Users))
+	for i, user := range cfg.Users {
+		userPath := fmt.Sprintf("%s[%d]", path, i)
+		if user.Username == "" || len(user.Username) > 255 {
+			v.add(userPath+".username", "must be 1 to 255 bytes, got %d", len(user.Username))
+		} else if usernames[user.Username] {
+			v.add(userPath+".username", "duplicate username %q", user.Username)
+		}
+		usernames[user.Username] = true
+		if user.Password == "" || len(user.Password) > 255 {
+			v.add(userPath+".password", "must be 1 to 255 bytes, got %d", len(user.Password))
+		}
+	}
+}
+
+// destinationPolicy проверяет политику адресов назначения
+func (v *validator) destinationPolicy(path string, policy *DestinationPolicyConfig) {
+	if policy == nil {
+		return
+	}
+	v.cidrs(path+".allow_cidrs", policy.AllowCIDRs)
+	v.cidrs(path+".deny_cidrs", policy.DenyCIDRs)
+	for i, port := range policy.DenyPorts {
+		v.port(fmt.Sprintf("%s.deny_ports[%d]", path, i), port, false)
+	}
+	for i, domain := range policy.DenyDomains {
+		if strings.TrimSpace(domain) == "" {
+			v.add(fmt.Sprintf("%s.deny_domains[%d]", path, i), "must not be empty")
+		}
+	}
+}
+
+// outbound проверяет конфигурацию outbound, для группы и цепочки - рекурсивно их участников
+// Идентификаторы outbound и всех участников регистрируются в seen
+func (v *validator) outbound(path string, cfg *OutboundConfig, seen ids) {
+	seen.add(v, path+".id", cfg.ID)
+	v.oneOf(path+".type", cfg.Type, "direct", "socks5", "http", "group", "chain", "device", "block")
+	proxied := cfg.Type == "socks5" || cfg.Type == "http"
+	if proxied && cfg.ProxyAddress == "" {
+		v.add(path+".proxy_address", "is required for %s outbound", cfg.Type)
+	} else if cfg.ProxyAddress != "" {
+		v.hostPort(path+".proxy_address", cfg.ProxyAddress)
+	}
+	if cfg.Username != "" || cfg.Password != "" {
+		if !proxied {
+			v.add(path+".username", "is only allowed for socks5 and http outbound")
+		} else if cfg.Username == "" {
+			v.add(path+".username", "is required with password")
+		} else if cfg.Type == "socks5" && len(cfg.Username) > 255 {
+			v.add(path+".username", "must be at most 255 bytes, got %d", len(cfg.Username))
+		}
+		if cfg.Type == "socks5" && len(cfg.Password) > 255 {
+			v.add(path+".password", "must be at most 255 bytes, got %d", len(cfg.Password))
+		}
+	}
+	if cfg.TLS != nil && cfg.TLS.Enabled && cfg.Type != "http" {
+		v.add(path+".tls", "is only allowed for http outbound")
+	}
+	if len(cfg.Headers) > 0 && cfg.Type != "http" {
+		v.add(path+".headers", "is only allowed for http outbound")
+	}
+	for name, value := range cfg.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			v.add(path+".headers", "invalid header name %q", name)
+		} else if strings.ContainsAny(value, "\r\n") {
+			v.add(path+".headers."+name, "must not contain line breaks")
+		}
+	}
+	if cfg.DeviceID != "" && cfg.Type != "device" {
+		v.add(path+".device_id", "is only allowed for device outbound")
+	}
+	if cfg.BlockMode != "" {
+		if cfg.Type != "block" {
+			v.add(path+".block_mode", "is only allowed for block outbound")
+		} else {
+			v.oneOf(path+".block_mode", cfg.BlockMode, "reject", "http")
+		}
+	}
+	v.directOptions(path, cfg)
+	if cfg.Resolve != "" {
+		v.oneOf(path+".resolve", cfg.Resolve, "local", "remote")
+		if cfg.Type == "direct" && cfg.Resolve == "remote" {
+			v.add(path+".resolve", "direct outbound always resolves locally")
+		} else if cfg.Type == "block" {
+			v.add(path+".resolve", "is not allowed for block outbound")
+		}
+	}
+	v.nonNegative(path+".weight", cfg.Weight)
+	v.nonNegative(path+".connect_timeout", cfg.ConnectTimeout)
+	v.nonNegative(path+".handshake_timeout", cfg.HandshakeTimeout)
+
+	switch cfg.Type {
+	case "group":
+		if len(cfg.Outbounds) == 0 {
+			v.add(path+".outbounds", "is required for group outbound")
+		}
+		if cfg.Strategy != "" {
+			v.oneOf(path+".strategy", cfg.Strategy, "failover", "round_robin", "random")
+		}
+		if cfg.ProbeAddress != "" {
+			v.hostPort(path+".probe_address", cfg.ProbeAddress)
+		}
+		v.nonNegative(path+".probe_interval", cfg.ProbeInterval)
+	case "chain":
+		if len(cfg.Outbounds) < 2 {
+			v.add(path+".outbounds", "chain outbound requires at least 2 members, got %d", len(cfg.Outbounds))
+		}
+		// Звенья после первого подключаются поверх соединения предыдущего и должны быть прокси
+		for i := 1; i < len(cfg.Outbounds); i++ {
+			if memberType := cfg.Outbounds[i].Type; memberType != "socks5" && memberType != "http" {
+				v.add(fmt.Sprintf("%s.outbounds[%d].type", path, i), "chain member after the first must be socks5 or http, got %q", memberType)
+			}
+			if cfg.Outbounds[i].Resolve != "" {
+				v.add(fmt.Sprintf("%s.outbounds[%d].resolve", path, i), "is not allowed for chain member after the first (set it on the chain)")
+			}
+		}
+	default:
+		if len(cfg.Outbounds) > 0 {
+			v.add(path+".outbounds", "is only allowed for group and chain outbound")
+		}
+		return
+	}
+	for i := range cfg.Outbounds {
+		v.outbound(fmt.Sprintf("%s.outbounds[%d]", path, i), &cfg.Outbounds[i], seen)
+	}
+}
+
+// directOptions проверяет параметры сокета direct outbound
+func (v *validator) directOptions(path string, cfg *OutboundConfig) {
+	if cfg.Type != "direct" {
+		if len(cfg.SourceAddresses) > 0 || cfg.Interface != "" || cfg.Mark != 0 || cfg.IPPreference != "" ||
+			cfg.TCPNoDelay != nil || cfg.KeepAlive != nil {
+			v.add(path, "source_addresses, interface, mark, ip_preference, tcp_nodelay and keepalive are only allowed for direct outbound")
+		}
+		return
+	}
+	for i, source := range cfg.SourceAddresses {
+		ip := net.ParseIP(source)
+		if ip == nil {
+			v.add(fmt.Sprintf("%s.source_addresses[%d]", path, i), "must be an IP address, got %q", source)
+			continue
+		}
+		if (cfg.IPPreference == "ipv4" && ip.To4() == nil) || (cfg.IPPreference == "ipv6" && ip.To4() != nil) {
+			v.add(fmt.Sprintf("%s.source_addresses[%d]", path, i), "address family conflicts with ip_preference %q", cfg.IPPreference)
+		}
+	}
+	v.nonNegative(path+".mark", cfg.Mark)
+	if cfg.IPPreference != "" {
+		v.oneOf(path+".ip_preference", cfg.IPPreference, "ipv4", "ipv6", "prefer_ipv4", "prefer_ipv6")
+	}
+	if keepAlive := cfg.KeepAlive; keepAlive != nil {
+		v.nonNegative(path+".keepalive.idle", keepAlive.Idle)
+		v.nonNegative(path+".keepalive.interval", keepAlive.Interval)
+		v.nonNegative(path+".keepalive.count", keepAlive.Count)
+	}
+}
+
+// ids проверяет уникальность идентификаторов inbound/outbound
+type ids map[string]string
+
+// add регистрирует идентификатор, пустой идентификатор не проверяется
+func (seen ids) add(v *validator, path, id string) {
+	if id == "" {
+		return
+	}
+	if previous, ok := seen[id]; ok {
+		v.add(path, "duplicate id %q (already used by %s)", id, previous)
+		return
+	}
+	seen[id] = path
+}
+
+// Validate проверяет конфигурацию proxy и возвращает *ValidationError со всеми найденными ошибками
+func (c *Config) Validate() error {
+	var v validator
+	seen := ids{}
+
+	// Inbound
+	v.oneOf("inbound.type", c.Inbound.Type, "socks5", "redirect", "tproxy")
+	v.port("inbound.port", c.Inbound.Port, false)
+	if c.Inbound.Listen != "" && net.ParseIP(c.Inbound.Listen) == nil {
+		v.add("inbound.listen", "must be an IP address, got %q", c.Inbound.Listen)
+	}
+	v.cidrs("inbound.allow", c.Inbound.Allow)
+	v.cidrs("inbound.deny", c.Inbound.Deny)
+	if c.Inbound.Type == "tproxy" {
+		if c.Inbound.Network != "" {
+			v.oneOf("inbound.network", c.Inbound.Network, "tcp", "udp", "tcp_udp")
+		}
+		v.nonNegative("inbound.udp_timeout", c.Inbound.UDPTimeout)
+	} else if c.Inbound.Network != "" || c.Inbound.UDPTimeout != 0 {
+		v.add("inbound", "network and udp_timeout are only allowed for tproxy inbound")
+	}
+	if sniffing := c.Inbound.Sniffing; sniffing != nil {
+		v.nonNegative("inbound.sniffing.timeout", sniffing.Timeout)
+		if sniffing.EarlyReply && c.Inbound.Type != "socks5" {
+			v.add("inbound.sniffing.early_reply", "is only allowed for socks5 inbound")
+		}
+	}
+	v.inboundUsers("inbound.users", &c.Inbound)
+	seen.add(&v, "inbound.id", c.Inbound.ID)
+
+	// Outbound
+	v.outbound("outbound", &c.Outbound, seen)
+	if c.Outbound.UsesDevice() && (c.OutboundPool == nil || !c.OutboundPool.Enabled) {
+		v.add("outbound", "device outbound requires enabled outbound_pool")
+	}
+
+	// Outbound pool
+	if pool := c.OutboundPool; pool != nil {
+		v.port("outbound_pool.wss_port", pool.WSSPort, true)
+		v.port("outbound_pool.quic_port", pool.QUICPort, true)
+		v.nonNegative("outbound_pool.heartbeat_interval", pool.HeartbeatInterval)
+		v.nonNegative("outbound_pool.heartbeat_timeout", pool.HeartbeatTimeout)
+		v.nonNegative("outbound_pool.dial_timeout", pool.DialTimeout)
+		if pool.HeartbeatInterval > 0 && pool.HeartbeatTimeout > 0 && pool.HeartbeatTimeout <= pool.HeartbeatInterval {
+			v.add("outbound_pool.heartbeat_timeout", "must be greater than heartbeat_interval (%d), got %d",
+				pool.HeartbeatInterval, pool.HeartbeatTimeout)
+		}
+		if tls := pool.TLS; tls != nil && tls.Enabled && (tls.CertFile == "") != (tls.KeyFile == "") {
+			v.add("outbound_pool.tls", "cert_file and key_file must be set together")
+		}
+		if store := pool.Store; store != nil {
+			v.oneOf("outbound_pool.store.type", store.Type, "file", "redis")
+			switch store.Type {
+			case "file":
+				if store.Path == "" {
+					v.add("outbound_pool.store.path", "is required for file store")
+				}
+			case "redis":
+				if store.Address == "" {
+					v.add("outbound_pool.store.address", "is required for redis store")
+				} else {
+					v.hostPort("outbound_pool.store.address", store.Address)
+				}
+			}
+			v.nonNegative("outbound_pool.store.db", store.DB)
+			v.nonNegative("outbound_pool.store.flush_interval", store.FlushInterval)
+		}
+		if health := pool.Health; health != nil {
+			v.nonNegative("outbound_pool.health.window", health.Window)
+			v.nonNegative("outbound_pool.health.min_dials", health.MinDials)
+			v.nonNegative("outbound_pool.health.cooldown", health.Cooldown)
+			if health.MinScore < 0 || health.MinScore > 1 {
+				v.add("outbound_pool.health.min_score", "must be between 0 and 1, got %g", health.MinScore)
+			}
+			if health.ProbeAddress != "" {
+				v.hostPort("outbound_pool.health.probe_address", health.ProbeAddress)
+			}
+		}
+		if retry := pool.Retry; retry != nil {
+			v.nonNegative("outbound_pool.retry.attempts", retry.Attempts)
+			v.nonNegative("outbound_pool.retry.budget", retry.Budget)
+		}
+		if pool.Resolve != "" {
+			v.oneOf("outbound_pool.resolve", pool.Resolve, "local", "remote")
+		}
+	}
+
+	v.destinationPolicy("destination_policy", c.DestinationPolicy)
+
+	if c.DNS != nil {
+		v.dns("dns", c.DNS)
+	}
+
+	if c.Routing != nil {
+		poolEnabled := c.OutboundPool != nil && c.OutboundPool.Enabled
+		v.routing("routing", c.Routing, poolEnabled, seen)
+	}
+
+	if c.Admin != nil {
+		if c.Admin.Listen == "" {
+			v.add("admin.listen", "is required")
+		} else {
+			v.hostPort("admin.listen", c.Admin.Listen)
+			// API перезагружает конфигурацию и меняет статус устройств, без токена он доступен только локально
+			if c.Admin.Token == "" && !isLoopback(c.Admin.Listen) {
+				v.add("admin.token", "is required when admin.listen is not a loopback address")
+			}
+		}
+	}
+
+	v.nonNegative("shutdown_timeout", c.ShutdownTimeout)
+
+	if c.Cluster != nil {
+		v.cluster("cluster", c.Cluster)
+		if c.OutboundPool == nil || !c.OutboundPool.Enabled {
+			v.add("cluster", "requires enabled outbound_pool")
+		}
+	}
+
+	return v.err()
+}
+
+// dns проверяет конфигурацию разрешения доменов
+func (v *validator) dns(path string, cfg *DNSConfig) {
+	for i, server := range cfg.Servers {
+		serverPath := fmt.Sprintf("%s.servers[%d]", path, i)
+		if !strings.Contains(server, "://") {
+			server = "udp://" + server
+		}
+		u, err := url.Parse(server)
+		if err != nil || u.Host == "" {
+			v.add(serverPath, "invalid DNS server %q", cfg.Servers[i])
+			continue
+		}
+		switch u.Scheme {
+		case "udp", "tcp", "tls", "https":
+		default:
+			v.add(serverPath, "unsupported scheme %q (one of: udp, tcp, tls, https)", u.Scheme)
+		}
+	}
+	for host, ips := range cfg.Hosts {
+		if strings.TrimSpace(host) == "" {
+			v.add(path+".hosts", "domain must not be empty")
+		}
+		for i, ip := range ips {
+			if net.ParseIP(ip) == nil {
+				v.add(fmt.Sprintf("%s.hosts.%s[%d]", path, host, i), "must be an IP address, got %q", ip)
+			}
+		}
+	}
+	v.nonNegative(path+".timeout", cfg.Timeout)
+	v.nonNegative(path+".cache_size", cfg.CacheSize)
+	v.nonNegative(path+".min_ttl", cfg.MinTTL)
+	v.nonNegative(path+".max_ttl", cfg.MaxTTL)
+	if cfg.MaxTTL > 0 && cfg.MinTTL > cfg.MaxTTL {
+		v.add(path+".min_ttl", "must not exceed max_ttl (%d), got %d", cfg.MaxTTL, cfg.MinTTL)
+	}
+}
+
+// routing проверяет правила выбора outbound
+func (v *validator) routing(path string, cfg *RoutingConfig, poolEnabled bool, seen ids) {
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		rulePath := fmt.Sprintf("%s.rules[%d]", path, i)
+		if len(rule.Domains) == 0 && len(rule.IPs) == 0 {
+			v.add(rulePath, "domains or ips is required")
+		}
+		for j, domain := range rule.Domains {
+			if strings.TrimSpace(domain) == "" {
+				v.add(fmt.Sprintf("%s.domains[%d]", rulePath, j), "must not be empty")
+			}
+		}
+		v.cidrs(rulePath+".ips", rule.IPs)
+		v.outbound(rulePath+".outbound", &rule.Outbound, seen)
+		if rule.Outbound.UsesDevice() && !poolEnabled {
+			v.add(rulePath+".outbound", "device outbound requires enabled outbound_pool")
+		}
+		// Outbound правила создается для каждого соединения, фоновые проверки группы не успеют накопить состояние
+		if hasProbes(&rule.Outbound) {
+			v.add(rulePath+".outbound", "probe_address is not supported for routing rule outbounds")
+		}
+	}
+}
+
+// hasProbes проверяет, включены ли фоновые проверки у группы outbound или ее участников
+func hasProbes(cfg *OutboundConfig) bool {
+	if cfg.ProbeAddress != "" {
+		return true
+	}
+	for i := range cfg.Outbounds {
+		if hasProbes(&cfg.Outbounds[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// cluster проверяет конфигурацию кластера POP
+func (v *validator) cluster(path string, cluster *ClusterConfig) {
+	if cluster.NodeID == "" {
+		v.add(path+".node_id", "is required")
+	}
+	if cluster.Secret == "" {
+		v.add(path+".secret", "is required")
+	}
+	if cluster.Listen == "" {
+		v.add(path+".listen", "is required")
+	} else {
+		v.hostPort(path+".listen", cluster.Listen)
+	}
+	if cluster.Advertise != "" {
+		v.hostPort(path+".advertise", cluster.Advertise)
+	} else if host, _, err := net.SplitHostPort(cluster.Listen); err == nil {
+		// Другие POP не могут подключиться к адресу без хоста или к 0.0.0.0
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			v.add(path+".advertise", "is required when listen has no specific host")
+		}
+	}
+	for i, peer := range cluster.Peers {
+		v.hostPort(fmt.Sprintf("%s.peers[%d]", path, i), peer)
+	}
+	v.nonNegative(path+".gossip_interval", cluster.GossipInterval)
+	v.nonNegative(path+".node_timeout", cluster.NodeTimeout)
+	v.nonNegative(path+".remote_penalty", cluster.RemotePenalty)
+	if cluster.GossipInterval > 0 && cluster.NodeTimeout > 0 && cluster.NodeTimeout <= cluster.GossipInterval {
+		v.add(path+".node_timeout", "must be greater than gossip_interval (%d), got %d",
+			cluster.GossipInterval, cluster.NodeTimeout)
+	}
+}
+
+// Validate проверяет конфигурацию device и возвращает *ValidationError со всеми найденными ошибками
+func (c *DeviceConfig) Validate() error {
+	var v validator
+
+	if c.DeviceID == "" {
+		v.add("device_id", "is required")
+	}
+	if c.ProxyHost == "" {
+		v.add("proxy_host", "is required")
+	}
+	v.port("wss_port", c.WSSPort, false)
+	v.port("quic_port", c.QUICPort, false)
+	v.nonNegative("heartbeat_interval", c.HeartbeatInterval)
+	v.destinationPolicy("destination_policy", c.DestinationPolicy)
+	if c.DNS != nil {
+		v.dns("dns", c.DNS)
+	}
+	if c.EgressCheckURL != "" {
+		if u, err := url.Parse(c.EgressCheckURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add("egress_check_url", "must be an http or https URL, got %q", c.EgressCheckURL)
+		}
+	}
+	v.nonNegative("egress_check_interval", c.EgressCheckInterval)
+
+	return v.err()
+}
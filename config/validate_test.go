@@ -0,0 +1,278 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fieldPaths возвращает пути полей из ошибки проверки
+func fieldPaths(t *testing.T, err error) []string {
+	t.Helper()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("ожидалась *ValidationError, получено %T: %v", err, err)
+	}
+	paths := make([]string, len(validationErr.Errors))
+	for i, fieldErr := range validationErr.Errors {
+		paths[i] = fieldErr.Path
+	}
+	return paths
+}
+
+func TestLoadFile_UnknownFields(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	content := `{
+		"inbound": {"type": "socks5", "port": 1080},
+		"outbund": {"type": "direct"},
+		"outbound_pool": {"enabled": true, "wss_prot": 8443},
+		"plugins": {"quota": {"enabled": true, "config": {"any_key": 1}}}
+	}`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Ошибка записи конфига: %v", err)
+	}
+
+	_, err := LoadFile(configFile)
+	if err == nil {
+		t.Fatal("Ожидалась ошибка для неизвестных полей")
+	}
+	got := strings.Join(fieldPaths(t, err), ",")
+	if got != "outbound_pool.wss_prot,outbund" {
+		t.Errorf("Неверные пути неизвестных полей: %s", got)
+	}
+}
+
+func TestLoadFile_TypeAndSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"type", `{"outbound_pool": {"wss_port": "8443"}}`, "outbound_pool.wss_port: expected int, got string"},
+		{"syntax", "{\n  \"inbound\": {\n    \"port\": 1080,\n  }\n}", "line 4, column 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(configFile, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Ошибка записи конфига: %v", err)
+			}
+			_, err := LoadFile(configFile)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Ожидалась ошибка с %q, получено %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			Inbound:  InboundConfig{Type: "socks5", Port: 1080, ID: "in"},
+			Outbound: OutboundConfig{Type: "direct", ID: "out"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		want   []string
+	}{
+		{"valid", func(cfg *Config) {}, nil},
+		{"zero port", func(cfg *Config) { cfg.Inbound.Port = 0 }, []string{"inbound.port"}},
+		{"unknown inbound", func(cfg *Config) { cfg.Inbound.Type = "http" }, []string{"inbound.type"}},
+		{"listen not ip", func(cfg *Config) { cfg.Inbound.Listen = "0.0.0.0:1080" }, []string{"inbound.listen"}},
+		{"bad cidr", func(cfg *Config) { cfg.Inbound.Deny = []string{"10.0.0.0/8", "bad"} }, []string{"inbound.deny[1]"}},
+		{"tproxy network", func(cfg *Config) { cfg.Inbound.Type, cfg.Inbound.Network = "tproxy", "sctp" }, []string{"inbound.network"}},
+		{"network without tproxy", func(cfg *Config) { cfg.Inbound.Network = "udp" }, []string{"inbound"}},
+		{"negative sniffing timeout", func(cfg *Config) { cfg.Inbound.Sniffing = &SniffingConfig{Enabled: true, Timeout: -1} }, []string{"inbound.sniffing.timeout"}},
+		{"early reply without socks5", func(cfg *Config) {
+			cfg.Inbound.Type, cfg.Inbound.Sniffing = "redirect", &SniffingConfig{Enabled: true, EarlyReply: true}
+		}, []string{"inbound.sniffing.early_reply"}},
+		{"inbound users", func(cfg *Config) {
+			cfg.Inbound.Users = []InboundUserConfig{{Username: "alice", Password: "secret"}, {Username: "alice"}, {Password: "secret"}}
+		}, []string{"inbound.users[1].username", "inbound.users[1].password", "inbound.users[2].username"}},
+		{"users without socks5", func(cfg *Config) {
+			cfg.Inbound.Type, cfg.Inbound.Users = "redirect", []InboundUserConfig{{Username: "alice", Password: "secret"}}
+		}, []string{"inbound.users"}},
+		{"socks5 without address", func(cfg *Config) { cfg.Outbound.Type = "socks5" }, []string{"outbound.proxy_address"}},
+		{"bad proxy address", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "socks5", ProxyAddress: "127.0.0.1"}
+		}, []string{"outbound.proxy_address"}},
+		{"duplicate id", func(cfg *Config) { cfg.Outbound.ID = "in" }, []string{"outbound.id"}},
+		{"duplicate nested id", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "group", ID: "pool", Outbounds: []OutboundConfig{
+				{Type: "direct", ID: "exit"},
+				{Type: "chain", Outbounds: []OutboundConfig{
+					{Type: "direct", ID: "exit"},
+					{Type: "socks5", ID: "pool", ProxyAddress: "127.0.0.1:1080"},
+				}},
+			}}
+		}, []string{"outbound.outbounds[1].outbounds[0].id", "outbound.outbounds[1].outbounds[1].id"}},
+		{"group", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "group", Strategy: "random", ProbeAddress: "1.1.1.1:443", Outbounds: []OutboundConfig{
+				{Type: "direct", Weight: 3},
+				{Type: "socks5", ProxyAddress: "127.0.0.1:1081"},
+			}}
+		}, nil},
+		{"group errors", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "group", Strategy: "fastest", Outbounds: []OutboundConfig{
+				{Type: "socks5"},
+				{Type: "group"},
+			}}
+		}, []string{"outbound.strategy", "outbound.outbounds[0].proxy_address", "outbound.outbounds[1].outbounds"}},
+		{"routing", func(cfg *Config) {
+			cfg.Routing = &RoutingConfig{Rules: []RoutingRuleConfig{
+				{Domains: []string{"ads.example"}, Outbound: OutboundConfig{Type: "block", ID: "ads"}},
+				{IPs: []string{"10.0.0.0/8", "192.0.2.1"}, Outbound: OutboundConfig{Type: "direct"}},
+			}}
+		}, nil},
+		{"routing errors", func(cfg *Config) {
+			cfg.Routing = &RoutingConfig{Rules: []RoutingRuleConfig{
+				{Outbound: OutboundConfig{Type: "direct"}},
+				{IPs: []string{"10.0.0.0/33"}, Outbound: OutboundConfig{Type: "block", ID: "out"}},
+				{Domains: []string{"a.example"}, Outbound: OutboundConfig{Type: "device"}},
+				{Domains: []string{""}, Outbound: OutboundConfig{Type: "group", ProbeAddress: "1.1.1.1:443", Outbounds: []OutboundConfig{{Type: "direct"}}}},
+			}}
+		}, []string{"routing.rules[0]", "routing.rules[1].ips[0]", "routing.rules[1].outbound.id", "routing.rules[2].outbound",
+			"routing.rules[3].domains[0]", "routing.rules[3].outbound"}},
+		{"outbound timeouts", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "socks5", ProxyAddress: "127.0.0.1:1080", ConnectTimeout: -1, HandshakeTimeout: -5}
+			cfg.OutboundPool = &OutboundPoolConfig{DialTimeout: -1}
+		}, []string{"outbound.connect_timeout", "outbound.handshake_timeout", "outbound_pool.dial_timeout"}},
+		{"outbound credentials", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "group", Outbounds: []OutboundConfig{
+				{Type: "socks5", ProxyAddress: "127.0.0.1:1080", Password: "secret"},
+				{Type: "direct", Username: "user"},
+			}}
+		}, []string{"outbound.outbounds[0].username", "outbound.outbounds[1].username"}},
+		{"http outbound", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "http", ProxyAddress: "proxy.example.net:3128", Username: "user", Password: "secret",
+				TLS: &OutboundTLSConfig{Enabled: true}, Headers: map[string]string{"X-Session": "42"}}
+		}, nil},
+		{"http outbound errors", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "group", Outbounds: []OutboundConfig{
+				{Type: "http", Headers: map[string]string{"X-Bad": "a\r\nb"}},
+				{Type: "direct", TLS: &OutboundTLSConfig{Enabled: true}},
+			}}
+		}, []string{"outbound.outbounds[0].proxy_address", "outbound.outbounds[0].headers.X-Bad", "outbound.outbounds[1].tls"}},
+		{"chain outbound", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "chain", Outbounds: []OutboundConfig{
+				{Type: "device", DeviceID: "phone-1"},
+				{Type: "socks5", ProxyAddress: "127.0.0.1:1080"},
+				{Type: "http", ProxyAddress: "proxy.example.net:3128"},
+			}}
+			cfg.OutboundPool = &OutboundPoolConfig{Enabled: true}
+		}, nil},
+		{"chain outbound errors", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "group", Outbounds: []OutboundConfig{
+				{Type: "chain", Outbounds: []OutboundConfig{{Type: "socks5", ProxyAddress: "127.0.0.1:1080"}, {Type: "direct"}}},
+				{Type: "chain", Outbounds: []OutboundConfig{{Type: "direct"}}},
+				{Type: "direct", DeviceID: "phone-1"},
+			}}
+		}, []string{"outbound.outbounds[0].outbounds[1].type", "outbound.outbounds[1].outbounds", "outbound.outbounds[2].device_id"}},
+		{"device outbound without pool", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "device"}
+		}, []string{"outbound"}},
+		{"block outbound", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "group", Outbounds: []OutboundConfig{
+				{Type: "block", ID: "ads", BlockMode: "http"},
+				{Type: "block", BlockMode: "drop"},
+				{Type: "direct", BlockMode: "reject"},
+			}}
+		}, []string{"outbound.outbounds[1].block_mode", "outbound.outbounds[2].block_mode"}},
+		{"direct socket options", func(cfg *Config) {
+			noDelay := false
+			cfg.Outbound = OutboundConfig{Type: "direct", SourceAddresses: []string{"10.0.0.5", "10.0.0.6"}, Interface: "eth1",
+				Mark: 100, IPPreference: "prefer_ipv6", TCPNoDelay: &noDelay, KeepAlive: &KeepAliveConfig{Enabled: true, Idle: 60}}
+		}, nil},
+		{"direct socket option errors", func(cfg *Config) {
+			cfg.Outbound = OutboundConfig{Type: "group", Outbounds: []OutboundConfig{
+				{Type: "direct", SourceAddresses: []string{"10.0.0.5", "::1", "eth0"}, IPPreference: "ipv4", Mark: -1,
+					KeepAlive: &KeepAliveConfig{Enabled: true, Count: -1}},
+				{Type: "direct", IPPreference: "ipv5"},
+				{Type: "socks5", ProxyAddress: "127.0.0.1:1080", Interface: "eth1"},
+			}}
+		}, []string{"outbound.outbounds[0].source_addresses[1]", "outbound.outbounds[0].source_addresses[2]", "outbound.outbounds[0].mark",
+			"outbound.outbounds[0].keepalive.count", "outbound.outbounds[1].ip_preference", "outbound.outbounds[2]"}},
+		{"outbounds without group", func(cfg *Config) {
+			cfg.Outbound.Outbounds = []OutboundConfig{{Type: "direct"}}
+		}, []string{"outbound.outbounds"}},
+		{"pool", func(cfg *Config) {
+			cfg.OutboundPool = &OutboundPoolConfig{
+				WSSPort:           70000,
+				HeartbeatInterval: 30,
+				HeartbeatTimeout:  10,
+				TLS:               &TLSConfig{Enabled: true, KeyFile: "key.pem"},
+			}
+		}, []string{"outbound_pool.wss_port", "outbound_pool.heartbeat_timeout", "outbound_pool.tls"}},
+		{"pool health", func(cfg *Config) {
+			cfg.OutboundPool = &OutboundPoolConfig{
+				Health: &DeviceHealthConfig{Window: -1, MinScore: 1.5, ProbeAddress: "1.1.1.1"},
+				Retry:  &DialRetryConfig{Attempts: -1},
+			}
+		}, []string{"outbound_pool.health.window", "outbound_pool.health.min_score", "outbound_pool.health.probe_address", "outbound_pool.retry.attempts"}},
+		{"destination policy", func(cfg *Config) {
+			cfg.DestinationPolicy = &DestinationPolicyConfig{DenyCIDRs: []string{"1.2.3.0/40"}, DenyPorts: []int{0}}
+		}, []string{"destination_policy.deny_cidrs[0]", "destination_policy.deny_ports[0]"}},
+		{"admin", func(cfg *Config) { cfg.Admin = &AdminConfig{} }, []string{"admin.listen"}},
+		{"admin loopback", func(cfg *Config) { cfg.Admin = &AdminConfig{Listen: "127.0.0.1:9090"} }, nil},
+		{"admin public without token", func(cfg *Config) { cfg.Admin = &AdminConfig{Listen: "0.0.0.0:9090"} }, []string{"admin.token"}},
+		{"admin public with token", func(cfg *Config) { cfg.Admin = &AdminConfig{Listen: ":9090", Token: "secret"} }, nil},
+		{"shutdown timeout", func(cfg *Config) { cfg.ShutdownTimeout = -1 }, []string{"shutdown_timeout"}},
+		{"cluster", func(cfg *Config) {
+			cfg.OutboundPool = &OutboundPoolConfig{Enabled: true}
+			cfg.Cluster = &ClusterConfig{NodeID: "a", Secret: "s", Listen: "127.0.0.1:7443", Peers: []string{"127.0.0.1:7444"}}
+		}, nil},
+		{"cluster errors", func(cfg *Config) {
+			cfg.Cluster = &ClusterConfig{Listen: ":7443", Peers: []string{"pop-b"}, GossipInterval: 5, NodeTimeout: 5}
+		}, []string{"cluster.node_id", "cluster.secret", "cluster.advertise", "cluster.peers[0]", "cluster.node_timeout", "cluster"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Неожиданная ошибка: %v", err)
+				}
+				return
+			}
+			if got, want := strings.Join(fieldPaths(t, err), ","), strings.Join(tt.want, ","); got != want {
+				t.Errorf("Неверные пути ошибок: ожидалось %s, получено %s (%v)", want, got, err)
+			}
+		})
+	}
+}
+
+func TestDeviceConfig_Validate(t *testing.T) {
+	cfg := &DeviceConfig{ProxyHost: "127.0.0.1", WSSPort: 443, QUICPort: 443, DeviceID: "d1"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+
+	cfg = &DeviceConfig{QUICPort: 443, HeartbeatInterval: -1}
+	got := strings.Join(fieldPaths(t, cfg.Validate()), ",")
+	if got != "device_id,proxy_host,wss_port,heartbeat_interval" {
+		t.Errorf("Неверные пути ошибок: %s", got)
+	}
+
+	cfg = &DeviceConfig{
+		ProxyHost:           "127.0.0.1",
+		WSSPort:             443,
+		QUICPort:            443,
+		DeviceID:            "d1",
+		DNS:                 &DNSConfig{Servers: []string{"quic://1.1.1.1"}},
+		EgressCheckURL:      "ftp://example.com",
+		EgressCheckInterval: -1,
+	}
+	got = strings.Join(fieldPaths(t, cfg.Validate()), ",")
+	if got != "dns.servers[0],egress_check_url,egress_check_interval" {
+		t.Errorf("Неверные пути ошибок: %s", got)
+	}
+}
@@ -5,9 +5,11 @@ go 1.24.0
 toolchain go1.24.10
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/quic-go/quic-go v0.57.1
 	golang.org/x/net v0.43.0
 	google.golang.org/protobuf v1.36.10
+	gopkg.in/yaml.v3 v3.0.1
 	nhooyr.io/websocket v1.8.17
 )
 
@@ -0,0 +1,87 @@
+package inbound
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"example.com/me/myproxy/internal/acl"
+)
+
+// IPFilter фильтрует клиентов inbound по IP адресу источника
+// Deny имеет приоритет над allow; пустой allow разрешает всех
+type IPFilter struct {
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	rejected atomic.Int64
+}
+
+// NewIPFilter создает фильтр из списков CIDR (или отдельных IP)
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	allowNets, err := acl.ParseCIDRs(allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow list: %w", err)
+	}
+	denyNets, err := acl.ParseCIDRs(deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny list: %w", err)
+	}
+	return &IPFilter{
+		allow: allowNets,
+		deny:  denyNets,
+	}, nil
+}
+
+// Allowed проверяет, разрешен ли IP
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	if f == nil {
+		return true
+	}
+	for _, n := range f.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, n := range f.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check проверяет адрес соединения и учитывает отказ
+func (f *IPFilter) Check(addr net.Addr) bool {
+	if f == nil {
+		return true
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			host = addr.String()
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip != nil && f.Allowed(ip) {
+		return true
+	}
+	f.rejected.Add(1)
+	return false
+}
+
+// Rejected возвращает количество отклоненных соединений
+func (f *IPFilter) Rejected() int64 {
+	if f == nil {
+		return 0
+	}
+	return f.rejected.Load()
+}
@@ -0,0 +1,78 @@
+package inbound
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/plugin"
+)
+
+func TestIPFilter_Allowed(t *testing.T) {
+	filter, err := NewIPFilter([]string{"10.0.0.0/8", "192.168.1.5"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatalf("Ошибка создания фильтра: %v", err)
+	}
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.0.0.1", true},
+		{"10.1.2.3", false}, // deny имеет приоритет
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+		{"8.8.8.8", false},
+	}
+	for _, tt := range tests {
+		if got := filter.Allowed(net.ParseIP(tt.ip)); got != tt.allowed {
+			t.Errorf("Allowed(%s) = %v, ожидалось %v", tt.ip, got, tt.allowed)
+		}
+	}
+
+	if _, err := NewIPFilter([]string{"not-an-ip"}, nil); err == nil {
+		t.Error("Ожидалась ошибка для невалидного CIDR")
+	}
+}
+
+func TestSOCKS5Inbound_IPFilter(t *testing.T) {
+	filter, err := NewIPFilter(nil, []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("Ошибка создания фильтра: %v", err)
+	}
+
+	s := NewSOCKS5Inbound("127.0.0.1", 0, filter)
+	handled := make(chan struct{}, 1)
+	err = s.Start(func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		handled <- struct{}{}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Ошибка запуска inbound: %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// Соединение должно быть закрыто без ответа на приветствие
+	conn.Write([]byte{0x05, 0x01, 0x00})
+	buf := make([]byte, 2)
+	if n, err := conn.Read(buf); err == nil {
+		t.Errorf("Ожидалось закрытие соединения, получено %d байт", n)
+	}
+
+	select {
+	case <-handled:
+		t.Error("Handler не должен вызываться для отклоненного клиента")
+	default:
+	}
+	if filter.Rejected() != 1 {
+		t.Errorf("Ожидался 1 отказ, получено %d", filter.Rejected())
+	}
+}
@@ -1,16 +1,18 @@
 package inbound
 
 import (
+	"context"
 	"net"
 
 	"example.com/me/myproxy/internal/plugin"
 )
 
 // Handler функция для обработки нового соединения
+// ctx - отменяется, если клиент отключился до установки соединения
 // conn - соединение от клиента
 // targetAddress - целевой адрес для подключения (определяется протоколом)
-// ctx - контекст соединения с метаданными
-type Handler func(conn net.Conn, targetAddress string, ctx *plugin.ConnectionContext) error
+// connCtx - контекст соединения с метаданными
+type Handler func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error
 
 // Inbound интерфейс для inbound обработчиков
 type Inbound interface {
@@ -19,3 +21,8 @@ type Inbound interface {
 	// Stop останавливает слушатель
 	Stop() error
 }
+
+// FilterSetter inbound, поддерживающий замену фильтра клиентов без перезапуска слушателя
+type FilterSetter interface {
+	SetFilter(filter *IPFilter)
+}
@@ -0,0 +1,62 @@
+package inbound
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// RedirectInbound реализует прозрачный прокси для iptables REDIRECT (Linux):
+// адрес назначения восстанавливается через SO_ORIGINAL_DST, только TCP
+// Пример: iptables -t nat -A PREROUTING -p tcp -j REDIRECT --to-ports 12345
+type RedirectInbound struct {
+	transparentInbound
+	listener net.Listener
+	// originalDestination читает SO_ORIGINAL_DST соединения (подменяется в тестах: без iptables адреса нет)
+	originalDestination func(conn *net.TCPConn) (netip.AddrPort, error)
+}
+
+// NewRedirectInbound создает redirect inbound
+// listen - адрес для bind (пусто - все интерфейсы), filter - фильтр клиентов (nil - без фильтрации)
+func NewRedirectInbound(listen string, port int, filter *IPFilter) *RedirectInbound {
+	r := &RedirectInbound{
+		transparentInbound:  transparentInbound{listen: listen, port: port},
+		originalDestination: originalDestination,
+	}
+	r.filter.Store(filter)
+	return r
+}
+
+// Start запускает слушатель
+func (r *RedirectInbound) Start(handler Handler) error {
+	listener, err := r.upgrader.Listen("tcp", r.address())
+	if err != nil {
+		return fmt.Errorf("failed to start redirect listener: %w", err)
+	}
+	r.listener = listener
+
+	go r.serveTCP(listener, "Redirect", r.destination, handler)
+	return nil
+}
+
+// Stop останавливает слушатель
+func (r *RedirectInbound) Stop() error {
+	if r.listener != nil {
+		return r.listener.Close()
+	}
+	return nil
+}
+
+// destination возвращает адрес назначения до REDIRECT
+// Соединение напрямую с портом inbound (без REDIRECT) отклоняется, иначе прокси подключился бы сам к себе
+func (r *RedirectInbound) destination(conn *net.TCPConn) (netip.AddrPort, error) {
+	destination, err := r.originalDestination(conn)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	local := unmap(conn.LocalAddr().(*net.TCPAddr).AddrPort())
+	if unmap(destination) == local {
+		return netip.AddrPort{}, fmt.Errorf("connection to %s was not redirected", local)
+	}
+	return destination, nil
+}
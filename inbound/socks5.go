@@ -1,32 +1,65 @@
 package inbound
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"example.com/me/myproxy/internal/logger"
 	"example.com/me/myproxy/internal/plugin"
 	"example.com/me/myproxy/internal/protocol/socks5"
+	"example.com/me/myproxy/internal/upgrade"
 )
 
 // SOCKS5Inbound реализует SOCKS5 inbound
 type SOCKS5Inbound struct {
+	listen   string // Адрес интерфейса (пусто - все интерфейсы)
 	port     int
+	filter   atomic.Pointer[IPFilter] // Заменяется при перезагрузке конфигурации
+	users    map[string]string        // Логин -> пароль клиентов (пусто - без аутентификации)
 	listener net.Listener
+	upgrader *upgrade.Upgrader
 }
 
 // NewSOCKS5Inbound создает новый SOCKS5 inbound
-func NewSOCKS5Inbound(port int) *SOCKS5Inbound {
-	return &SOCKS5Inbound{
-		port: port,
+// listen - адрес для bind (пусто - все интерфейсы), filter - фильтр клиентов (nil - без фильтрации)
+func NewSOCKS5Inbound(listen string, port int, filter *IPFilter) *SOCKS5Inbound {
+	s := &SOCKS5Inbound{
+		listen: listen,
+		port:   port,
 	}
+	s.filter.Store(filter)
+	return s
+}
+
+// SetFilter заменяет фильтр клиентов, применяется к новым соединениям
+func (s *SOCKS5Inbound) SetFilter(filter *IPFilter) {
+	s.filter.Store(filter)
+}
+
+// SetUsers включает аутентификацию клиентов по логину и паролю (RFC 1929)
+// users - логин -> пароль; логин клиента становится UserID соединения. Вызывается до Start
+func (s *SOCKS5Inbound) SetUsers(users map[string]string) {
+	s.users = users
+}
+
+// SetUpgrader задает Upgrader для создания слушателя (передача сокета при обновлении бинарника)
+func (s *SOCKS5Inbound) SetUpgrader(upgrader *upgrade.Upgrader) {
+	s.upgrader = upgrader
 }
 
 // Start запускает SOCKS5 слушатель
 func (s *SOCKS5Inbound) Start(handler Handler) error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+	listener, err := s.upgrader.Listen("tcp", net.JoinHostPort(s.listen, strconv.Itoa(s.port)))
 	if err != nil {
 		return fmt.Errorf("failed to start SOCKS5 listener: %w", err)
 	}
@@ -42,6 +75,15 @@ func (s *SOCKS5Inbound) Start(handler Handler) error {
 			}
 
 			remoteAddr := conn.RemoteAddr().String()
+
+			// Фильтрация по IP до SOCKS5 handshake
+			filter := s.filter.Load()
+			if !filter.Check(conn.RemoteAddr()) {
+				logger.Debug("inbound", "Connection from %s rejected by IP filter (total rejected: %d)", remoteAddr, filter.Rejected())
+				conn.Close()
+				continue
+			}
+
 			logger.Debug("inbound", "New inbound connection from %s", remoteAddr)
 
 			go func(c net.Conn) {
@@ -95,27 +137,34 @@ func (s *SOCKS5Inbound) handleSOCKS5(conn net.Conn, handler Handler) error {
 		return fmt.Errorf("failed to read methods: %w", err)
 	}
 
-	// Look for method 0x00 (no authentication)
-	hasNoAuth := false
-	for i := 0; i < nmethods; i++ {
-		if buf[2+i] == 0x00 {
-			hasNoAuth = true
-			break
-		}
+	// С учетными записями клиент обязан аутентифицироваться по логину и паролю,
+	// без них - только метод 0x00 (no authentication)
+	method := byte(socks5.MethodNoAuth)
+	if len(s.users) > 0 {
+		method = socks5.MethodUserPass
 	}
-
-	if !hasNoAuth {
+	if !slices.Contains(buf[2:2+nmethods], method) {
 		// Send 0xFF (no acceptable methods)
-		conn.Write([]byte{0x05, 0xFF})
+		conn.Write([]byte{0x05, socks5.MethodNoAcceptable})
+		if method == socks5.MethodUserPass {
+			return fmt.Errorf("client does not support username/password authentication")
+		}
 		return fmt.Errorf("authentication required (not supported)")
 	}
 
 	// Send response: [VER, METHOD]
-	_, err = conn.Write([]byte{0x05, 0x00})
+	_, err = conn.Write([]byte{0x05, method})
 	if err != nil {
 		return fmt.Errorf("failed to send greeting response: %w", err)
 	}
 
+	var userID string
+	if method == socks5.MethodUserPass {
+		if userID, err = s.authenticate(conn); err != nil {
+			return err
+		}
+	}
+
 	logger.Debug("inbound", "SOCKS5 greeting completed for %s", remoteAddr)
 
 	// Step 2: Connection request
@@ -190,20 +239,27 @@ func (s *SOCKS5Inbound) handleSOCKS5(conn net.Conn, handler Handler) error {
 
 	logger.Debug("inbound", "SOCKS5 connection request from %s to %s", remoteAddr, targetAddress)
 
-	// Send success response используя общий парсер
-	response := socks5.BuildResponse(socks5.ReplySuccess)
-	_, err = conn.Write(response)
-	if err != nil {
-		return fmt.Errorf("failed to send response: %w", err)
-	}
-
-	logger.Debug("inbound", "SOCKS5 connection established from %s to %s", remoteAddr, targetAddress)
+	// Ответ клиенту откладывается до установки outbound соединения,
+	// чтобы ошибки (лимиты, ACL, недоступность цели) вернулись правильным reply code.
+	// Пока ответа нет, отключение клиента отменяет ctx и незавершенное подключение
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replyConn := &socks5Conn{Conn: conn}
+	replyConn.watch(cancel)
 
 	// Создаем контекст соединения
-	ctx := plugin.NewConnectionContext(remoteAddr, targetAddress)
+	connCtx := plugin.NewConnectionContext(remoteAddr, targetAddress)
+	connCtx.UserID = userID
 
 	// Now forward the connection through handler
-	err = handler(conn, targetAddress, ctx)
+	err = handler(ctx, replyConn, targetAddress, connCtx)
+	if !replyConn.replied {
+		// Handler завершился до установки соединения - отправляем ошибку
+		if err == nil {
+			err = fmt.Errorf("connection to %s not established", targetAddress)
+		}
+		replyConn.Reply(err)
+	}
 	if err != nil {
 		logger.Debug("inbound", "SOCKS5 connection from %s to %s closed with error: %v", remoteAddr, targetAddress, err)
 	} else {
@@ -211,3 +267,103 @@ func (s *SOCKS5Inbound) handleSOCKS5(conn net.Conn, handler Handler) error {
 	}
 	return err
 }
+
+// authenticate проверяет логин и пароль клиента (RFC 1929) и возвращает логин
+// Ответ: [VER=0x01, STATUS] (0x00 - успех, иначе соединение закрывается)
+func (s *SOCKS5Inbound) authenticate(conn net.Conn) (string, error) {
+	username, password, err := socks5.ParseUserPassAuth(conn)
+	if err != nil {
+		return "", err
+	}
+	expected, ok := s.users[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+		conn.Write([]byte{0x01, 0x01})
+		return "", fmt.Errorf("authentication failed for user %q", username)
+	}
+	if _, err := conn.Write([]byte{0x01, 0x00}); err != nil {
+		return "", fmt.Errorf("failed to send authentication response: %w", err)
+	}
+	logger.Debug("inbound", "SOCKS5 client %s authenticated as %s", conn.RemoteAddr(), username)
+	return username, nil
+}
+
+// socks5Conn соединение SOCKS5 клиента с отложенным ответом на CONNECT
+// До ответа соединение читается в фоне, чтобы заметить отключение клиента;
+// данные, отправленные клиентом до ответа, возвращаются следующим Read
+type socks5Conn struct {
+	net.Conn
+	mu      sync.Mutex
+	replied bool
+
+	watchDone chan struct{}
+	stopOnce  sync.Once
+	stopping  atomic.Bool
+	early     []byte // Прочитано в фоне до ответа
+}
+
+// watch читает соединение до ответа клиенту и вызывает cancel, если клиент отключился
+func (c *socks5Conn) watch(cancel context.CancelFunc) {
+	c.watchDone = make(chan struct{})
+	go func() {
+		defer close(c.watchDone)
+		buf := make([]byte, 1)
+		n, err := c.Conn.Read(buf)
+		c.early = buf[:n]
+		if err != nil && !c.stopping.Load() {
+			logger.Debug("inbound", "SOCKS5 client %s disconnected before reply: %v", c.RemoteAddr(), err)
+			cancel()
+		}
+	}()
+}
+
+// stopWatch останавливает фоновое чтение, прерывая его дедлайном
+func (c *socks5Conn) stopWatch() {
+	c.stopOnce.Do(func() {
+		if c.watchDone == nil {
+			return
+		}
+		c.stopping.Store(true)
+		c.Conn.SetReadDeadline(time.Unix(1, 0))
+		<-c.watchDone
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+// Read возвращает сначала данные, прочитанные в фоне до ответа
+func (c *socks5Conn) Read(b []byte) (int, error) {
+	c.stopWatch()
+	if len(c.early) > 0 {
+		n := copy(b, c.early)
+		c.early = c.early[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// Close закрывает соединение; если reply еще не отправлен, клиент получает ошибку
+// (general failure), а не сброс соединения
+func (c *socks5Conn) Close() error {
+	c.Reply(errors.New("connection closed before reply"))
+	return c.Conn.Close()
+}
+
+// Reply отправляет SOCKS5 reply (err == nil - успех), повторные вызовы игнорируются
+func (c *socks5Conn) Reply(err error) error {
+	c.stopWatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.replied {
+		return nil
+	}
+	c.replied = true
+
+	if _, writeErr := c.Conn.Write(socks5.BuildResponse(socks5.ReplyCodeForError(err))); writeErr != nil {
+		return fmt.Errorf("failed to send response: %w", writeErr)
+	}
+	if err == nil {
+		logger.Debug("inbound", "SOCKS5 connection established from %s", c.RemoteAddr())
+	}
+	return nil
+}
@@ -1,9 +1,17 @@
 package inbound
 
 import (
+	"context"
 	"encoding/binary"
+	"fmt"
+	"io"
 	"net"
 	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/plugin"
+	"example.com/me/myproxy/internal/protocol/socks5"
 )
 
 func TestSOCKS5Inbound_Greeting(t *testing.T) {
@@ -205,3 +213,210 @@ func TestSOCKS5Inbound_UnsupportedCommand(t *testing.T) {
 	clientConn.Close()
 }
 
+func TestSOCKS5Inbound_HandlerErrorReply(t *testing.T) {
+	s := NewSOCKS5Inbound("127.0.0.1", 0, nil)
+	err := s.Start(func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		return fmt.Errorf("limit: %w", connerr.ErrNotAllowed)
+	})
+	if err != nil {
+		t.Fatalf("Ошибка запуска inbound: %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	conn.Write([]byte{0x05, 0x01, 0x00})
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		t.Fatalf("Ошибка чтения ответа на приветствие: %v", err)
+	}
+
+	conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x50})
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Ошибка чтения reply: %v", err)
+	}
+	if reply[1] != socks5.ReplyConnectionNotAllowed {
+		t.Errorf("Неверный reply code: ожидалось %d, получено %d", socks5.ReplyConnectionNotAllowed, reply[1])
+	}
+}
+
+// sendConnect подключается к inbound и отправляет greeting и CONNECT без ожидания reply
+func sendConnect(t *testing.T, s *SOCKS5Inbound) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	conn.Write([]byte{0x05, 0x01, 0x00})
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		t.Fatalf("Ошибка чтения ответа на приветствие: %v", err)
+	}
+	conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x50})
+	return conn
+}
+
+func TestSOCKS5Inbound_ClientDisconnectCancels(t *testing.T) {
+	canceled := make(chan bool, 1)
+	s := NewSOCKS5Inbound("127.0.0.1", 0, nil)
+	err := s.Start(func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		select {
+		case <-ctx.Done():
+			canceled <- true
+		case <-time.After(2 * time.Second):
+			canceled <- false
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("Ошибка запуска inbound: %v", err)
+	}
+	defer s.Stop()
+
+	// Клиент отключается, не дождавшись reply
+	sendConnect(t, s).Close()
+	if !<-canceled {
+		t.Error("Контекст не отменен после отключения клиента")
+	}
+}
+
+func TestSOCKS5Inbound_EarlyData(t *testing.T) {
+	received := make(chan string, 1)
+	s := NewSOCKS5Inbound("127.0.0.1", 0, nil)
+	err := s.Start(func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		// Ждем, пока данные клиента будут прочитаны в фоне
+		time.Sleep(50 * time.Millisecond)
+		if err := conn.(*socks5Conn).Reply(nil); err != nil {
+			return err
+		}
+		buf := make([]byte, 5)
+		_, err := io.ReadFull(conn, buf)
+		received <- string(buf)
+		if ctx.Err() != nil {
+			t.Error("Контекст отменен, хотя клиент не отключался")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Ошибка запуска inbound: %v", err)
+	}
+	defer s.Stop()
+
+	// Клиент отправляет данные, не дождавшись reply
+	conn := sendConnect(t, s)
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Ошибка чтения reply: %v", err)
+	}
+	if reply[1] != socks5.ReplySuccess {
+		t.Errorf("Неверный reply code: ожидалось %d, получено %d", socks5.ReplySuccess, reply[1])
+	}
+	if got := <-received; got != "hello" {
+		t.Errorf("Данные клиента до reply потеряны: получено %q", got)
+	}
+}
+
+func TestSOCKS5Inbound_ReplyBeforeClose(t *testing.T) {
+	// Handler закрывает соединение до возврата ошибки: клиент все равно получает reply
+	s := NewSOCKS5Inbound("127.0.0.1", 0, nil)
+	err := s.Start(func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		conn.Close()
+		return fmt.Errorf("limit: %w", connerr.ErrNotAllowed)
+	})
+	if err != nil {
+		t.Fatalf("Ошибка запуска inbound: %v", err)
+	}
+	defer s.Stop()
+
+	conn := sendConnect(t, s)
+	defer conn.Close()
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Ошибка чтения reply: %v", err)
+	}
+	if reply[1] != socks5.ReplyGeneralFailure {
+		t.Errorf("Неверный reply code: ожидалось %d, получено %d", socks5.ReplyGeneralFailure, reply[1])
+	}
+}
+
+func TestSOCKS5Inbound_UserPassAuth(t *testing.T) {
+	userIDs := make(chan string, 1)
+	s := NewSOCKS5Inbound("127.0.0.1", 0, nil)
+	s.SetUsers(map[string]string{"alice": "secret"})
+	err := s.Start(func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		userIDs <- connCtx.UserID
+		return fmt.Errorf("limit: %w", connerr.ErrNotAllowed)
+	})
+	if err != nil {
+		t.Fatalf("Ошибка запуска inbound: %v", err)
+	}
+	defer s.Stop()
+
+	// authenticate проходит приветствие с методом methods и аутентификацию, возвращает статус
+	authenticate := func(methods []byte, username, password string) (net.Conn, byte) {
+		conn, err := net.Dial("tcp", s.listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Ошибка подключения: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write(append([]byte{0x05, byte(len(methods))}, methods...))
+		greeting := make([]byte, 2)
+		if _, err := io.ReadFull(conn, greeting); err != nil {
+			t.Fatalf("Ошибка чтения ответа на приветствие: %v", err)
+		}
+		if greeting[1] != socks5.MethodUserPass {
+			return conn, greeting[1]
+		}
+		request, _ := socks5.BuildUserPassAuth(username, password)
+		conn.Write(request)
+		status := make([]byte, 2)
+		if _, err := io.ReadFull(conn, status); err != nil {
+			t.Fatalf("Ошибка чтения ответа на аутентификацию: %v", err)
+		}
+		return conn, status[1]
+	}
+
+	// Логин клиента становится UserID соединения
+	conn, status := authenticate([]byte{socks5.MethodNoAuth, socks5.MethodUserPass}, "alice", "secret")
+	defer conn.Close()
+	if status != 0x00 {
+		t.Fatalf("Аутентификация не прошла: статус %d", status)
+	}
+	conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x50})
+	if userID := <-userIDs; userID != "alice" {
+		t.Errorf("Неверный UserID: %q", userID)
+	}
+
+	// Неверный пароль: отказ, handler не вызывается
+	conn, status = authenticate([]byte{socks5.MethodUserPass}, "alice", "wrong")
+	defer conn.Close()
+	if status == 0x00 {
+		t.Error("Аутентификация с неверным паролем прошла")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Соединение не закрыто после неудачной аутентификации")
+	}
+
+	// Клиент без поддержки аутентификации по логину и паролю
+	conn, method := authenticate([]byte{socks5.MethodNoAuth}, "", "")
+	defer conn.Close()
+	if method != socks5.MethodNoAcceptable {
+		t.Errorf("Ожидался отказ в методе аутентификации, получено %d", method)
+	}
+	select {
+	case userID := <-userIDs:
+		t.Errorf("Handler вызван без аутентификации (UserID %q)", userID)
+	default:
+	}
+}
@@ -0,0 +1,334 @@
+package inbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/me/myproxy/internal/constants"
+	"example.com/me/myproxy/internal/logger"
+	"example.com/me/myproxy/internal/plugin"
+)
+
+const (
+	// maxUDPDatagram максимальный размер UDP датаграммы
+	maxUDPDatagram = 65535
+	// udpSessionQueue датаграмм клиента в очереди сессии, сверх нее датаграммы отбрасываются
+	udpSessionQueue = 64
+)
+
+// TProxyInbound реализует прозрачный прокси для iptables TPROXY (Linux) для TCP и UDP:
+// сокеты с IP_TRANSPARENT принимают соединения и датаграммы на чужие адреса,
+// адрес назначения - локальный адрес соединения или IP_ORIGDSTADDR датаграммы.
+// Датаграммы одного клиента одному адресу назначения образуют UDP сессию,
+// которая передается handler как соединение и завершается после простоя
+// Пример: iptables -t mangle -A PREROUTING -p udp -j TPROXY --on-port 12345 --tproxy-mark 1
+type TProxyInbound struct {
+	transparentInbound
+	tcp, udp   bool
+	udpTimeout time.Duration // Время простоя UDP сессии
+
+	listener   net.Listener
+	packetConn *net.UDPConn
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession // Ключ - клиент и адрес назначения
+}
+
+// NewTProxyInbound создает tproxy inbound для TCP и UDP
+// listen - адрес для bind (пусто - все интерфейсы), filter - фильтр клиентов (nil - без фильтрации)
+func NewTProxyInbound(listen string, port int, filter *IPFilter) *TProxyInbound {
+	t := &TProxyInbound{
+		transparentInbound: transparentInbound{listen: listen, port: port},
+		tcp:                true,
+		udp:                true,
+		udpTimeout:         constants.DefaultUDPSessionTimeout * time.Second,
+		sessions:           make(map[string]*udpSession),
+	}
+	t.filter.Store(filter)
+	return t
+}
+
+// SetNetwork задает принимаемые протоколы
+func (t *TProxyInbound) SetNetwork(tcp, udp bool) {
+	t.tcp, t.udp = tcp, udp
+}
+
+// SetUDPTimeout задает время простоя, после которого UDP сессия завершается
+func (t *TProxyInbound) SetUDPTimeout(timeout time.Duration) {
+	t.udpTimeout = timeout
+}
+
+// Start запускает слушатели
+func (t *TProxyInbound) Start(handler Handler) error {
+	if t.tcp {
+		listener, err := t.upgrader.ListenConfig(&net.ListenConfig{Control: transparentControl(false)}, "tcp", t.address())
+		if err != nil {
+			return fmt.Errorf("failed to start TPROXY TCP listener: %w", err)
+		}
+		t.listener = listener
+		go t.serveTCP(listener, "TPROXY", tproxyDestination, handler)
+	}
+
+	if t.udp {
+		packetConn, err := t.upgrader.ListenPacketConfig(&net.ListenConfig{Control: transparentControl(true)}, "udp", t.address())
+		if err != nil {
+			t.Stop()
+			return fmt.Errorf("failed to start TPROXY UDP listener: %w", err)
+		}
+		t.packetConn = packetConn.(*net.UDPConn)
+		go t.serveUDP(t.packetConn, handler)
+	}
+	return nil
+}
+
+// Stop останавливает слушатели, UDP сессии завершаются после простоя
+func (t *TProxyInbound) Stop() error {
+	var errs []error
+	if t.listener != nil {
+		errs = append(errs, t.listener.Close())
+	}
+	if t.packetConn != nil {
+		errs = append(errs, t.packetConn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// tproxyDestination возвращает адрес назначения TCP соединения: при TPROXY это локальный адрес
+func tproxyDestination(conn *net.TCPConn) (netip.AddrPort, error) {
+	return conn.LocalAddr().(*net.TCPAddr).AddrPort(), nil
+}
+
+// serveUDP читает датаграммы до закрытия conn и распределяет их по UDP сессиям
+func (t *TProxyInbound) serveUDP(conn *net.UDPConn, handler Handler) {
+	buf := make([]byte, maxUDPDatagram)
+	oob := make([]byte, 1024)
+	for {
+		n, oobn, _, source, err := conn.ReadMsgUDPAddrPort(buf, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Debug("inbound", "TPROXY UDP read error: %v", err)
+			continue
+		}
+		source = unmap(source)
+
+		filter := t.filter.Load()
+		if !filter.Check(net.UDPAddrFromAddrPort(source)) {
+			logger.Debug("inbound", "Datagram from %s rejected by IP filter (total rejected: %d)", source, filter.Rejected())
+			continue
+		}
+		destination, err := udpOriginalDestination(oob[:oobn])
+		if err != nil {
+			logger.Debug("inbound", "TPROXY UDP datagram from %s dropped: %v", source, err)
+			continue
+		}
+
+		session, err := t.session(source, unmap(destination), handler)
+		if err != nil {
+			logger.Error("inbound", "Error handling TPROXY UDP from %s to %s: %v", source, destination, err)
+			continue
+		}
+		session.push(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// session возвращает UDP сессию клиента source с адресом назначения destination,
+// новая сессия передается handler
+func (t *TProxyInbound) session(source, destination netip.AddrPort, handler Handler) (*udpSession, error) {
+	key := source.String() + "/" + destination.String()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if session, ok := t.sessions[key]; ok {
+		return session, nil
+	}
+
+	reply, err := dialTransparentUDP(destination, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reply socket: %w", err)
+	}
+	session := newUDPSession(reply, t.udpTimeout, func() {
+		t.mu.Lock()
+		delete(t.sessions, key)
+		t.mu.Unlock()
+	})
+	t.sessions[key] = session
+
+	go func() {
+		defer session.Close()
+		remoteAddr, targetAddress := source.String(), destination.String()
+		logger.Debug("inbound", "TPROXY UDP session from %s to %s", remoteAddr, targetAddress)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if err := handler(ctx, session, targetAddress, plugin.NewConnectionContext(remoteAddr, targetAddress)); err != nil {
+			logger.Debug("inbound", "TPROXY UDP session from %s to %s closed with error: %v", remoteAddr, targetAddress, err)
+		} else {
+			logger.Debug("inbound", "TPROXY UDP session from %s to %s closed normally", remoteAddr, targetAddress)
+		}
+	}()
+	return session, nil
+}
+
+// udpSession датаграммы одного клиента одному адресу назначения как net.Conn:
+// Read возвращает датаграмму клиента, Write отправляет датаграмму клиенту от имени адреса назначения.
+// Read и WriteTo завершаются (io.EOF), если в сессии не было датаграмм дольше timeout
+type udpSession struct {
+	reply   *net.UDPConn // Локальный адрес - адрес назначения, подключен к клиенту
+	timeout time.Duration
+	packets chan []byte
+	done    chan struct{}
+
+	closeOnce  sync.Once
+	onClose    func()
+	lastActive atomic.Int64 // UnixNano последней датаграммы
+}
+
+// newUDPSession создает сессию и начинает читать reply: после его создания ядро доставляет
+// датаграммы клиента ему, а не слушателю
+func newUDPSession(reply *net.UDPConn, timeout time.Duration, onClose func()) *udpSession {
+	s := &udpSession{
+		reply:   reply,
+		timeout: timeout,
+		packets: make(chan []byte, udpSessionQueue),
+		done:    make(chan struct{}),
+		onClose: onClose,
+	}
+	s.touch()
+	go s.readReply()
+	return s
+}
+
+// touch отмечает активность сессии
+func (s *udpSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// push добавляет датаграмму клиента в очередь, при переполнении датаграмма отбрасывается
+func (s *udpSession) push(packet []byte) {
+	select {
+	case s.packets <- packet:
+	case <-s.done:
+	default:
+		logger.Debug("inbound", "TPROXY UDP session from %s: queue full, datagram dropped", s.RemoteAddr())
+	}
+}
+
+// readReply читает датаграммы клиента, доставленные сокету сессии
+func (s *udpSession) readReply() {
+	buf := make([]byte, maxUDPDatagram)
+	for {
+		n, err := s.reply.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Например, ICMP port unreachable от клиента
+			continue
+		}
+		s.push(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// next возвращает следующую датаграмму клиента
+func (s *udpSession) next() ([]byte, error) {
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case packet := <-s.packets:
+			s.touch()
+			return packet, nil
+		case <-s.done:
+			return nil, net.ErrClosed
+		case <-timer.C:
+			idle := time.Since(time.Unix(0, s.lastActive.Load()))
+			if idle >= s.timeout {
+				return nil, io.EOF
+			}
+			timer.Reset(s.timeout - idle)
+		}
+	}
+}
+
+// Read возвращает следующую датаграмму клиента
+// Датаграмма больше b не обрезается: она отбрасывается, Read возвращает io.ErrShortBuffer
+func (s *udpSession) Read(b []byte) (int, error) {
+	packet, err := s.next()
+	if err != nil {
+		return 0, err
+	}
+	if len(packet) > len(b) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(b, packet), nil
+}
+
+// WriteTo передает датаграммы клиента в w целиком до завершения сессии
+// io.Copy использует его вместо Read, поэтому датаграммы больше буфера копирования не теряются
+func (s *udpSession) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		packet, err := s.next()
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+		n, err := w.Write(packet)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Write отправляет датаграмму клиенту
+func (s *udpSession) Write(b []byte) (int, error) {
+	s.touch()
+	return s.reply.Write(b)
+}
+
+// Close завершает сессию, следующие датаграммы клиента создадут новую
+func (s *udpSession) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.reply.Close()
+		s.onClose()
+	})
+	return err
+}
+
+// LocalAddr возвращает адрес назначения клиента
+func (s *udpSession) LocalAddr() net.Addr {
+	return s.reply.LocalAddr()
+}
+
+// RemoteAddr возвращает адрес клиента
+func (s *udpSession) RemoteAddr() net.Addr {
+	return s.reply.RemoteAddr()
+}
+
+// SetDeadline дедлайны не поддерживаются: сессия завершается по времени простоя
+func (s *udpSession) SetDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadDeadline дедлайны не поддерживаются: сессия завершается по времени простоя
+func (s *udpSession) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline задает дедлайн отправки клиенту
+func (s *udpSession) SetWriteDeadline(t time.Time) error {
+	return s.reply.SetWriteDeadline(t)
+}
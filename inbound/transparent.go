@@ -0,0 +1,82 @@
+package inbound
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+
+	"example.com/me/myproxy/internal/logger"
+	"example.com/me/myproxy/internal/plugin"
+	"example.com/me/myproxy/internal/upgrade"
+)
+
+// transparentInbound общая часть inbound прозрачного прокси (redirect, tproxy):
+// клиент не знает о прокси, адрес назначения определяется по сокету
+type transparentInbound struct {
+	listen   string // Адрес интерфейса (пусто - все интерфейсы)
+	port     int
+	filter   atomic.Pointer[IPFilter] // Заменяется при перезагрузке конфигурации
+	upgrader *upgrade.Upgrader
+}
+
+// SetFilter заменяет фильтр клиентов, применяется к новым соединениям
+func (t *transparentInbound) SetFilter(filter *IPFilter) {
+	t.filter.Store(filter)
+}
+
+// SetUpgrader задает Upgrader для создания слушателя (передача сокета при обновлении бинарника)
+func (t *transparentInbound) SetUpgrader(upgrader *upgrade.Upgrader) {
+	t.upgrader = upgrader
+}
+
+// address возвращает адрес слушателя
+func (t *transparentInbound) address() string {
+	return net.JoinHostPort(t.listen, strconv.Itoa(t.port))
+}
+
+// serveTCP принимает соединения listener до его закрытия и передает их handler
+// с адресом назначения, который возвращает destination
+func (t *transparentInbound) serveTCP(listener net.Listener, name string, destination func(*net.TCPConn) (netip.AddrPort, error), handler Handler) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// Listener closed
+			return
+		}
+
+		remoteAddr := conn.RemoteAddr().String()
+		filter := t.filter.Load()
+		if !filter.Check(conn.RemoteAddr()) {
+			logger.Debug("inbound", "Connection from %s rejected by IP filter (total rejected: %d)", remoteAddr, filter.Rejected())
+			conn.Close()
+			continue
+		}
+
+		go func(c *net.TCPConn) {
+			defer c.Close()
+			target, err := destination(c)
+			if err != nil {
+				logger.Error("inbound", "Error handling %s connection from %s: %v", name, remoteAddr, err)
+				return
+			}
+			targetAddress := unmap(target).String()
+			logger.Debug("inbound", "%s connection from %s to %s", name, remoteAddr, targetAddress)
+
+			// Клиент отправляет данные сразу, ответа о подключении не ждет
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := handler(ctx, c, targetAddress, plugin.NewConnectionContext(remoteAddr, targetAddress)); err != nil {
+				logger.Debug("inbound", "%s connection from %s to %s closed with error: %v", name, remoteAddr, targetAddress, err)
+			} else {
+				logger.Debug("inbound", "%s connection from %s to %s closed normally", name, remoteAddr, targetAddress)
+			}
+		}(conn.(*net.TCPConn))
+	}
+}
+
+// unmap приводит IPv4-mapped IPv6 адрес (dual-stack сокет) к IPv4
+func unmap(addr netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+}
@@ -0,0 +1,151 @@
+//go:build linux
+
+package inbound
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+const (
+	// soOriginalDst SO_ORIGINAL_DST (IPv4) и IP6T_SO_ORIGINAL_DST (IPv6): адрес до iptables REDIRECT
+	soOriginalDst = 80
+	// ipv6Transparent IPV6_TRANSPARENT (в пакете syscall нет)
+	ipv6Transparent = 75
+	// ipv6RecvOrigDstAddr IPV6_RECVORIGDSTADDR, он же тип сообщения IPV6_ORIGDSTADDR
+	ipv6RecvOrigDstAddr = 74
+)
+
+// originalDestination возвращает адрес назначения соединения до iptables REDIRECT (SO_ORIGINAL_DST)
+func originalDestination(conn *net.TCPConn) (netip.AddrPort, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	local := conn.LocalAddr().(*net.TCPAddr).AddrPort()
+
+	var destination netip.AddrPort
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if local.Addr().Unmap().Is4() {
+			// sockaddr_in умещается в ipv6_mreq
+			var mreq *syscall.IPv6Mreq
+			mreq, sockErr = syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+			if sockErr == nil {
+				destination = netip.AddrPortFrom(netip.AddrFrom4([4]byte(mreq.Multiaddr[4:8])), binary.BigEndian.Uint16(mreq.Multiaddr[2:4]))
+			}
+			return
+		}
+		// sockaddr_in6 умещается в ip6_mtuinfo
+		var info *syscall.IPv6MTUInfo
+		info, sockErr = syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, soOriginalDst)
+		if sockErr == nil {
+			destination = netip.AddrPortFrom(netip.AddrFrom16(info.Addr.Addr), networkPort(info.Addr.Port))
+		}
+	})
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if sockErr != nil {
+		return netip.AddrPort{}, fmt.Errorf("failed to get original destination: %w", sockErr)
+	}
+	return destination, nil
+}
+
+// networkPort возвращает порт из поля sockaddr, хранящего его в сетевом порядке байт
+func networkPort(port uint16) uint16 {
+	var b [2]byte
+	binary.NativeEndian.PutUint16(b[:], port)
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// transparentControl возвращает Control для сокетов tproxy: IP_TRANSPARENT (прием соединений
+// и датаграмм на чужие адреса, отправка с них), для UDP также адрес назначения датаграмм (IP_RECVORIGDSTADDR)
+// SO_REUSEADDR позволяет нескольким UDP сокетам отвечать с одного адреса назначения
+func transparentControl(udp bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = setTransparent(int(fd), udp)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+// sockopt целочисленная опция сокета
+type sockopt struct {
+	level, name int
+	option      string // Имя для сообщения об ошибке
+}
+
+// setTransparent задает опции tproxy сокету fd
+func setTransparent(fd int, udp bool) error {
+	// IPv6 сокет принимает и IPv4 (dual-stack): опции задаются для обоих протоколов
+	domain, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_DOMAIN)
+	if err != nil {
+		return fmt.Errorf("failed to get socket domain: %w", err)
+	}
+	options := []sockopt{
+		{syscall.SOL_SOCKET, syscall.SO_REUSEADDR, "SO_REUSEADDR"},
+		{syscall.SOL_IP, syscall.IP_TRANSPARENT, "IP_TRANSPARENT"},
+	}
+	if udp {
+		options = append(options, sockopt{syscall.SOL_IP, syscall.IP_RECVORIGDSTADDR, "IP_RECVORIGDSTADDR"})
+	}
+	if domain == syscall.AF_INET6 {
+		options = append(options, sockopt{syscall.SOL_IPV6, ipv6Transparent, "IPV6_TRANSPARENT"})
+		if udp {
+			options = append(options, sockopt{syscall.SOL_IPV6, ipv6RecvOrigDstAddr, "IPV6_RECVORIGDSTADDR"})
+		}
+	}
+	for _, o := range options {
+		if err := syscall.SetsockoptInt(fd, o.level, o.name, 1); err != nil {
+			return fmt.Errorf("failed to set %s (requires CAP_NET_ADMIN): %w", o.option, err)
+		}
+	}
+	return nil
+}
+
+// udpOriginalDestination возвращает адрес назначения датаграммы из control сообщений
+// (IP_ORIGDSTADDR или IPV6_ORIGDSTADDR)
+func udpOriginalDestination(oob []byte) (netip.AddrPort, error) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("failed to parse control messages: %w", err)
+	}
+	for _, m := range messages {
+		switch {
+		case m.Header.Level == syscall.SOL_IP && m.Header.Type == syscall.IP_ORIGDSTADDR && len(m.Data) >= 8:
+			// sockaddr_in: family, port, addr
+			return netip.AddrPortFrom(netip.AddrFrom4([4]byte(m.Data[4:8])), binary.BigEndian.Uint16(m.Data[2:4])), nil
+		case m.Header.Level == syscall.SOL_IPV6 && m.Header.Type == ipv6RecvOrigDstAddr && len(m.Data) >= 24:
+			// sockaddr_in6: family, port, flowinfo, addr
+			return netip.AddrPortFrom(netip.AddrFrom16([16]byte(m.Data[8:24])).Unmap(), binary.BigEndian.Uint16(m.Data[2:4])), nil
+		}
+	}
+	return netip.AddrPort{}, fmt.Errorf("original destination not found in control messages")
+}
+
+// dialTransparentUDP создает UDP сокет с адресом local (адрес назначения клиента, обычно чужой),
+// подключенный к клиенту remote: ответы клиенту отправляются от имени адреса назначения
+func dialTransparentUDP(local, remote netip.AddrPort) (*net.UDPConn, error) {
+	network := "udp6"
+	if local.Addr().Is4() {
+		network = "udp4"
+	}
+	dialer := &net.Dialer{
+		LocalAddr: net.UDPAddrFromAddrPort(local),
+		Control:   transparentControl(false),
+	}
+	conn, err := dialer.Dial(network, remote.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
@@ -0,0 +1,241 @@
+package inbound
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/plugin"
+)
+
+// startTProxy запускает tproxy inbound на 127.0.0.1, пропускает тест без CAP_NET_ADMIN
+func startTProxy(t *testing.T, tproxy *TProxyInbound, handler Handler) {
+	t.Helper()
+	if err := tproxy.Start(handler); err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			t.Skipf("IP_TRANSPARENT недоступен: %v", err)
+		}
+		t.Fatalf("Ошибка запуска tproxy inbound: %v", err)
+	}
+	t.Cleanup(func() { tproxy.Stop() })
+}
+
+func TestTProxyInbound_TCP(t *testing.T) {
+	tproxy := NewTProxyInbound("127.0.0.1", 0, nil)
+	tproxy.SetNetwork(true, false)
+	targets := make(chan string, 1)
+	startTProxy(t, tproxy, func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		targets <- targetAddress
+		_, err := io.Copy(conn, conn)
+		return err
+	})
+
+	// Без правил TPROXY адрес назначения - адрес слушателя
+	address := tproxy.listener.Addr().String()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Неверный ответ: %q, %v", buf, err)
+	}
+	if target := <-targets; target != address {
+		t.Errorf("Неверный адрес назначения: ожидалось %s, получено %s", address, target)
+	}
+}
+
+func TestTProxyInbound_UDP(t *testing.T) {
+	tproxy := NewTProxyInbound("127.0.0.1", 0, nil)
+	tproxy.SetNetwork(false, true)
+	tproxy.SetUDPTimeout(200 * time.Millisecond)
+	targets := make(chan string, 2)
+	closed := make(chan error, 2)
+	startTProxy(t, tproxy, func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		targets <- targetAddress
+		if _, ok := conn.LocalAddr().(*net.UDPAddr); !ok {
+			t.Errorf("Локальный адрес UDP сессии не UDP: %v", conn.LocalAddr())
+		}
+		buf := make([]byte, maxUDPDatagram)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				closed <- err
+				return nil
+			}
+			conn.Write(append([]byte("re:"), buf[:n]...))
+		}
+	})
+
+	address := tproxy.packetConn.LocalAddr().String()
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Ошибка создания клиента: %v", err)
+	}
+	defer client.Close()
+	server, _ := net.ResolveUDPAddr("udp", address)
+
+	// Обе датаграммы в одной сессии, ответы приходят от адреса назначения
+	buf := make([]byte, 64)
+	for _, data := range []string{"one", "two"} {
+		client.WriteToUDP([]byte(data), server)
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, from, err := client.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("Ошибка чтения ответа: %v", err)
+		}
+		if string(buf[:n]) != "re:"+data || from.String() != address {
+			t.Errorf("Неверный ответ %q от %s", buf[:n], from)
+		}
+	}
+	if target := <-targets; target != address {
+		t.Errorf("Неверный адрес назначения: ожидалось %s, получено %s", address, target)
+	}
+
+	// Сессия завершается после простоя
+	select {
+	case err := <-closed:
+		if err != io.EOF {
+			t.Errorf("Ожидалось завершение по простою, получено %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("UDP сессия не завершилась после простоя")
+	}
+	if len(targets) != 0 {
+		t.Error("Датаграммы клиента создали несколько сессий")
+	}
+}
+
+func TestRedirectInbound_NotRedirected(t *testing.T) {
+	redirect := NewRedirectInbound("127.0.0.1", 0, nil)
+	handled := make(chan string, 1)
+	if err := redirect.Start(func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		handled <- targetAddress
+		return nil
+	}); err != nil {
+		t.Fatalf("Ошибка запуска redirect inbound: %v", err)
+	}
+	defer redirect.Stop()
+
+	// Подключение напрямую (без REDIRECT) отклоняется, а не передается handler
+	conn, err := net.Dial("tcp", redirect.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Ожидалось закрытие соединения, получено %v", err)
+	}
+	select {
+	case target := <-handled:
+		t.Errorf("Соединение без REDIRECT передано handler с адресом %s", target)
+	default:
+	}
+}
+
+func TestRedirectInbound_Destination(t *testing.T) {
+	// SO_ORIGINAL_DST без iptables REDIRECT недоступен: адрес назначения подменяется
+	original := netip.MustParseAddrPort("192.0.2.10:443")
+	redirect := NewRedirectInbound("127.0.0.1", 0, nil)
+	redirect.originalDestination = func(conn *net.TCPConn) (netip.AddrPort, error) {
+		return original, nil
+	}
+	handled := make(chan string, 1)
+	if err := redirect.Start(func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		handled <- targetAddress
+		_, err := conn.Write([]byte("ok"))
+		return err
+	}); err != nil {
+		t.Fatalf("Ошибка запуска redirect inbound: %v", err)
+	}
+	defer redirect.Stop()
+
+	conn, err := net.Dial("tcp", redirect.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ok" {
+		t.Errorf("Неверный ответ handler: %q, %v", buf, err)
+	}
+	if target := <-handled; target != original.String() {
+		t.Errorf("Неверный адрес назначения: ожидалось %s, получено %s", original, target)
+	}
+
+	// Адрес назначения совпадает с адресом inbound: соединение не было перенаправлено
+	direct := NewRedirectInbound("127.0.0.1", 0, nil)
+	direct.originalDestination = func(conn *net.TCPConn) (netip.AddrPort, error) {
+		return conn.LocalAddr().(*net.TCPAddr).AddrPort(), nil
+	}
+	if err := direct.Start(func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		handled <- targetAddress
+		return nil
+	}); err != nil {
+		t.Fatalf("Ошибка запуска redirect inbound: %v", err)
+	}
+	defer direct.Stop()
+	conn, err = net.Dial("tcp", direct.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Ожидалось закрытие соединения, получено %v", err)
+	}
+	select {
+	case target := <-handled:
+		t.Errorf("Соединение без REDIRECT передано handler с адресом %s", target)
+	default:
+	}
+}
+
+func TestUDPSession_LargeDatagram(t *testing.T) {
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Ошибка создания сокета клиента: %v", err)
+	}
+	defer client.Close()
+	reply, err := net.DialUDP("udp", nil, client.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("Ошибка создания сокета сессии: %v", err)
+	}
+	session := newUDPSession(reply, 200*time.Millisecond, func() {})
+	defer session.Close()
+
+	// Датаграмма больше буфера копирования io.Copy (32 КБ)
+	datagram := bytes.Repeat([]byte("x"), 40000)
+	send := func() {
+		if _, err := client.WriteTo(datagram, reply.LocalAddr()); err != nil {
+			t.Fatalf("Ошибка отправки датаграммы: %v", err)
+		}
+	}
+
+	// Read не обрезает датаграмму молча
+	send()
+	if n, err := session.Read(make([]byte, 32*1024)); err != io.ErrShortBuffer {
+		t.Errorf("Ожидалась ошибка io.ErrShortBuffer, получено %d байт, %v", n, err)
+	}
+
+	// io.Copy передает датаграмму целиком и завершается после простоя сессии
+	send()
+	var received bytes.Buffer
+	if _, err := io.Copy(&received, session); err != nil {
+		t.Fatalf("Ошибка копирования: %v", err)
+	}
+	if received.Len() != len(datagram) {
+		t.Errorf("Получено %d байт, ожидалось %d", received.Len(), len(datagram))
+	}
+}
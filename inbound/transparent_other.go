@@ -0,0 +1,35 @@
+//go:build !linux
+
+package inbound
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// errTransparentUnsupported прозрачный прокси (REDIRECT/TPROXY) поддерживается только на Linux
+var errTransparentUnsupported = errors.New("transparent proxy is only supported on Linux")
+
+// originalDestination адрес назначения до REDIRECT доступен только на Linux
+func originalDestination(conn *net.TCPConn) (netip.AddrPort, error) {
+	return netip.AddrPort{}, errTransparentUnsupported
+}
+
+// transparentControl опции tproxy сокетов доступны только на Linux
+func transparentControl(udp bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return errTransparentUnsupported
+	}
+}
+
+// udpOriginalDestination адрес назначения датаграмм доступен только на Linux
+func udpOriginalDestination(oob []byte) (netip.AddrPort, error) {
+	return netip.AddrPort{}, errTransparentUnsupported
+}
+
+// dialTransparentUDP ответы от имени адреса назначения доступны только на Linux
+func dialTransparentUDP(local, remote netip.AddrPort) (*net.UDPConn, error) {
+	return nil, errTransparentUnsupported
+}
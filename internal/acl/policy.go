@@ -0,0 +1,264 @@
+package acl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/connerr"
+)
+
+// privateNets диапазоны, недоступные при BlockPrivate
+// (в дополнение к проверкам net.IP: private, loopback, link-local, multicast, unspecified)
+var privateNets = mustParseCIDRs(
+	"0.0.0.0/8",     // "this network"
+	"100.64.0.0/10", // CGNAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved
+	"64:ff9b::/96",  // NAT64 (может указывать на private IPv4)
+)
+
+// Resolver разрешает домены в IP адреса (см. internal/dns)
+type Resolver interface {
+	LookupIP(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// Policy политика допустимых адресов назначения
+type Policy struct {
+	resolver     Resolver // Разрешение доменов для проверки их IP (nil - только список доменов)
+	blockPrivate bool
+	allowNets    []*net.IPNet
+	denyNets     []*net.IPNet
+	denyPorts    map[int]bool
+	denyDomains  []string
+}
+
+// NewPolicy создает политику из конфигурации (nil конфигурация - nil политика, все разрешено)
+func NewPolicy(cfg *config.DestinationPolicyConfig) (*Policy, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	allowNets, err := ParseCIDRs(cfg.AllowCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow_cidrs: %w", err)
+	}
+	denyNets, err := ParseCIDRs(cfg.DenyCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny_cidrs: %w", err)
+	}
+
+	p := &Policy{
+		blockPrivate: cfg.BlockPrivate,
+		allowNets:    allowNets,
+		denyNets:     denyNets,
+		denyPorts:    make(map[int]bool),
+	}
+	for _, port := range cfg.DenyPorts {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid deny_ports entry: %d", port)
+		}
+		p.denyPorts[port] = true
+	}
+	for _, domain := range cfg.DenyDomains {
+		domain = normalizeDomain(domain)
+		if domain == "" {
+			return nil, fmt.Errorf("empty deny_domains entry")
+		}
+		p.denyDomains = append(p.denyDomains, domain)
+	}
+	return p, nil
+}
+
+// CheckAddress проверяет адрес назначения "host:port" до разрешения DNS
+// Для доменов проверяются только порт и список доменов
+func (p *Policy) CheckAddress(address string) error {
+	if p == nil {
+		return nil
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid target address %q: %w", address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid target port %q: %w", address, err)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return p.CheckIP(ip, port)
+	}
+
+	if err := p.checkPort(port); err != nil {
+		return err
+	}
+	return p.checkDomain(host)
+}
+
+// SetResolver включает проверку IP адресов доменов назначения в CheckAddressContext
+func (p *Policy) SetResolver(resolver Resolver) {
+	if p != nil {
+		p.resolver = resolver
+	}
+}
+
+// CheckAddressContext проверяет адрес назначения как CheckAddress, а с resolver
+// дополнительно проверяет все IP адреса домена правилами подсетей
+// Домен, который не удалось разрешить, запрещается: его адреса нельзя проверить,
+// а outbound может разрешить его иначе (другой resolver, DNS rebinding)
+func (p *Policy) CheckAddressContext(ctx context.Context, address string) error {
+	if err := p.CheckAddress(address); err != nil || p == nil || p.resolver == nil {
+		return err
+	}
+	host, portStr, _ := net.SplitHostPort(address)
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	ips, err := p.resolver.LookupIP(ctx, host)
+	if err != nil {
+		return fmt.Errorf("%w: cannot check addresses of %s: %w", connerr.ErrNotAllowed, host, err)
+	}
+	port, _ := strconv.Atoi(portStr)
+	for _, ip := range ips {
+		if err := p.CheckIP(ip, port); err != nil {
+			return fmt.Errorf("%s resolves to denied address: %w", host, err)
+		}
+	}
+	return nil
+}
+
+// CheckIP проверяет разрешенный IP адрес и порт назначения
+func (p *Policy) CheckIP(ip net.IP, port int) error {
+	if p == nil {
+		return nil
+	}
+	if err := p.checkPort(port); err != nil {
+		return err
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range p.allowNets {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	for _, n := range p.denyNets {
+		if n.Contains(ip) {
+			return fmt.Errorf("%w: destination %s is denied", connerr.ErrNotAllowed, ip)
+		}
+	}
+	if p.blockPrivate && isPrivate(ip) {
+		return fmt.Errorf("%w: destination %s is in a private range", connerr.ErrNotAllowed, ip)
+	}
+	return nil
+}
+
+// DialControl проверяет фактический адрес подключения (после разрешения DNS)
+// Используется как net.Dialer.Control, что исключает обход через DNS rebinding
+func (p *Policy) DialControl(network, address string, _ syscall.RawConn) error {
+	if p == nil {
+		return nil
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid dial address %q: %w", address, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: unresolved dial address %q", connerr.ErrNotAllowed, address)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid dial port %q: %w", address, err)
+	}
+	return p.CheckIP(ip, port)
+}
+
+// checkPort проверяет порт назначения
+func (p *Policy) checkPort(port int) error {
+	if p.denyPorts[port] {
+		return fmt.Errorf("%w: destination port %d is denied", connerr.ErrNotAllowed, port)
+	}
+	return nil
+}
+
+// checkDomain проверяет домен назначения (совпадение домена или поддомена)
+func (p *Policy) checkDomain(host string) error {
+	host = normalizeDomain(host)
+	if p.blockPrivate && (host == "localhost" || strings.HasSuffix(host, ".localhost")) {
+		return fmt.Errorf("%w: destination %s is local", connerr.ErrNotAllowed, host)
+	}
+	for _, domain := range p.denyDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return fmt.Errorf("%w: destination domain %s is denied", connerr.ErrNotAllowed, host)
+		}
+	}
+	return nil
+}
+
+// isPrivate проверяет, относится ли IP к непубличным диапазонам
+func isPrivate(ip net.IP) bool {
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	if ip.Equal(net.IPv4bcast) {
+		return true
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeDomain приводит домен к нижнему регистру без завершающей точки
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// ParseCIDRs разбирает список CIDR; отдельный IP трактуется как /32 (/128 для IPv6)
+func ParseCIDRs(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %q", entry)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %q", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// mustParseCIDRs разбирает статический список CIDR
+func mustParseCIDRs(entries ...string) []*net.IPNet {
+	nets, err := ParseCIDRs(entries)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
@@ -0,0 +1,137 @@
+package acl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/connerr"
+)
+
+func TestPolicy_CheckAddress(t *testing.T) {
+	policy, err := NewPolicy(&config.DestinationPolicyConfig{
+		BlockPrivate: true,
+		AllowCIDRs:   []string{"10.20.0.0/16"},
+		DenyCIDRs:    []string{"203.0.113.0/24"},
+		DenyPorts:    []int{25},
+		DenyDomains:  []string{"internal.example"},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy error: %v", err)
+	}
+
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"example.com:443", true},
+		{"example.com:25", false},
+		{"8.8.8.8:53", true},
+		{"192.168.1.1:80", false},
+		{"10.0.0.1:80", false},
+		{"10.20.1.1:80", true}, // allow имеет приоритет
+		{"127.0.0.1:8080", false},
+		{"169.254.169.254:80", false},
+		{"100.64.0.1:80", false},
+		{"[::1]:80", false},
+		{"[fe80::1]:80", false},
+		{"[::ffff:192.168.0.1]:80", false},
+		{"203.0.113.10:443", false},
+		{"localhost:80", false},
+		{"api.internal.example:443", false},
+		{"INTERNAL.EXAMPLE.:443", false},
+		{"notinternal.example:443", true},
+	}
+	for _, tt := range tests {
+		err := policy.CheckAddress(tt.address)
+		if tt.allowed && err != nil {
+			t.Errorf("CheckAddress(%s): ожидалось разрешение, получено %v", tt.address, err)
+		}
+		if !tt.allowed && !errors.Is(err, connerr.ErrNotAllowed) {
+			t.Errorf("CheckAddress(%s): ожидалось ErrNotAllowed, получено %v", tt.address, err)
+		}
+	}
+}
+
+func TestPolicy_DialControl(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	defer listener.Close()
+
+	policy, err := NewPolicy(&config.DestinationPolicyConfig{BlockPrivate: true})
+	if err != nil {
+		t.Fatalf("NewPolicy error: %v", err)
+	}
+
+	// Домен проходит проверку до разрешения DNS, но фактический IP проверяется при подключении
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	dialer := &net.Dialer{Control: policy.DialControl}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err == nil {
+		conn.Close()
+		t.Fatal("Ожидался отказ при подключении к loopback")
+	}
+	if !errors.Is(err, connerr.ErrNotAllowed) {
+		t.Errorf("Ожидалась ErrNotAllowed, получено %v", err)
+	}
+}
+
+// staticResolver resolver с фиксированными адресами доменов
+type staticResolver map[string][]net.IP
+
+func (r staticResolver) LookupIP(_ context.Context, host string) ([]net.IP, error) {
+	if ips, ok := r[host]; ok {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestPolicy_CheckAddressContext(t *testing.T) {
+	policy, err := NewPolicy(&config.DestinationPolicyConfig{BlockPrivate: true, DenyCIDRs: []string{"203.0.113.0/24"}})
+	if err != nil {
+		t.Fatalf("NewPolicy error: %v", err)
+	}
+	policy.SetResolver(staticResolver{
+		"public.example": {net.ParseIP("93.184.216.34")},
+		"rebind.example": {net.ParseIP("93.184.216.34"), net.ParseIP("10.0.0.1")},
+		"denied.example": {net.ParseIP("203.0.113.5")},
+	})
+
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"public.example:443", true},
+		{"rebind.example:443", false}, // Любой запрещенный адрес запрещает домен
+		{"denied.example:443", false},
+		{"unknown.example:443", false}, // Адреса домена нельзя проверить
+		{"10.0.0.1:443", false},
+	}
+	for _, tt := range tests {
+		err := policy.CheckAddressContext(context.Background(), tt.address)
+		if tt.allowed && err != nil {
+			t.Errorf("CheckAddressContext(%s): ожидалось разрешение, получено %v", tt.address, err)
+		}
+		if !tt.allowed && !errors.Is(err, connerr.ErrNotAllowed) {
+			t.Errorf("CheckAddressContext(%s): ожидалось ErrNotAllowed, получено %v", tt.address, err)
+		}
+	}
+}
+
+func TestNewPolicy_Nil(t *testing.T) {
+	policy, err := NewPolicy(nil)
+	if err != nil {
+		t.Fatalf("NewPolicy error: %v", err)
+	}
+	if err := policy.CheckAddress("127.0.0.1:25"); err != nil {
+		t.Errorf("nil политика должна разрешать все, получено %v", err)
+	}
+
+	if _, err := NewPolicy(&config.DestinationPolicyConfig{DenyPorts: []int{70000}}); err == nil {
+		t.Error("Ожидалась ошибка для невалидного порта")
+	}
+}
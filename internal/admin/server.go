@@ -0,0 +1,111 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/me/myproxy/internal/logger"
+)
+
+// Server административный HTTP API
+// Обработчики регистрируются компонентами через Handle до вызова Start
+// Если задан token, каждый запрос должен содержать заголовок "Authorization: Bearer <token>"
+type Server struct {
+	listen     string
+	token      string
+	mux        *http.ServeMux
+	httpServer *http.Server
+	listener   net.Listener
+}
+
+// NewServer создает новый административный server (пустой token - без аутентификации)
+func NewServer(listen, token string) *Server {
+	return &Server{
+		listen: listen,
+		token:  token,
+		mux:    http.NewServeMux(),
+	}
+}
+
+// Handle регистрирует обработчик (pattern в формате http.ServeMux, например "POST /reload")
+func (s *Server) Handle(pattern string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Start запускает HTTP API в фоне
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return fmt.Errorf("failed to start admin listener: %w", err)
+	}
+	s.listener = listener
+	s.httpServer = &http.Server{
+		Handler:           s.authenticate(s.mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("admin", "Admin API server error: %v", err)
+		}
+	}()
+
+	logger.Info("admin", "Admin API listening on %s", listener.Addr())
+	return nil
+}
+
+// authenticate проверяет bearer токен запроса до передачи его обработчикам
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if s.token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			logger.Debug("admin", "Unauthorized request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Addr возвращает адрес слушателя (nil, если server не запущен)
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Stop останавливает HTTP API
+func (s *Server) Stop() error {
+	if s.httpServer == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.httpServer.Shutdown(ctx)
+}
+
+// WriteJSON отправляет JSON ответ
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Debug("admin", "Failed to write response: %v", err)
+	}
+}
+
+// WriteError отправляет ошибку в формате {"error": "..."}
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, map[string]string{"error": err.Error()})
+}
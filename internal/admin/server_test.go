@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServer_Handle(t *testing.T) {
+	srv := NewServer("127.0.0.1:0", "")
+	srv.Handle("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	srv.Handle("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("bad request"))
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Ошибка запуска admin server: %v", err)
+	}
+	defer srv.Stop()
+
+	base := "http://" + srv.Addr().String()
+
+	resp, err := http.Post(base+"/reload", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Ошибка запроса: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"ok"`) {
+		t.Errorf("Неверный ответ: %d %s", resp.StatusCode, body)
+	}
+
+	// Неверный метод
+	resp, err = http.Get(base + "/reload")
+	if err != nil {
+		t.Fatalf("Ошибка запроса: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Ожидался 405, получено %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(base + "/fail")
+	if err != nil {
+		t.Fatalf("Ошибка запроса: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "bad request") {
+		t.Errorf("Неверный ответ: %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestServer_Token(t *testing.T) {
+	srv := NewServer("127.0.0.1:0", "secret")
+	srv.Handle("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Ошибка запуска admin server: %v", err)
+	}
+	defer srv.Stop()
+
+	url := "http://" + srv.Addr().String() + "/reload"
+	for _, tt := range []struct {
+		authorization string
+		status        int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic secret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, url, nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Ошибка запроса: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("Authorization %q: ожидался %d, получено %d", tt.authorization, tt.status, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/device"
+	"example.com/me/myproxy/outbound"
+)
+
+// fakeLocal устройства POP: подключение через устройство - прямое TCP соединение
+type fakeLocal struct {
+	mu      sync.Mutex
+	devices []*DeviceInfo
+}
+
+func (l *fakeLocal) Devices() []*DeviceInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.devices
+}
+
+func (l *fakeLocal) Dial(ctx context.Context, deviceID, address string) (net.Conn, error) {
+	for _, info := range l.Devices() {
+		if info.ID == deviceID {
+			conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+			if err != nil {
+				return nil, err
+			}
+			return &fakeDeviceConn{Conn: conn}, nil
+		}
+	}
+	return nil, connerr.ErrHostUnreachable
+}
+
+// fakeDeviceConn соединение через устройство со сведениями, сообщенными устройством
+type fakeDeviceConn struct {
+	net.Conn
+}
+
+func (c *fakeDeviceConn) ResolvedAddr() string { return c.RemoteAddr().String() }
+func (c *fakeDeviceConn) EgressIP() string     { return "203.0.113.7" }
+
+// startNode запускает POP на loopback с ускоренным обменом
+func startNode(t *testing.T, id, secret string, local Local, peers ...string) *Node {
+	t.Helper()
+	node := NewNode(&config.ClusterConfig{
+		NodeID: id,
+		Listen: "127.0.0.1:0",
+		Peers:  peers,
+		Secret: secret,
+	}, local)
+	node.interval = 50 * time.Millisecond
+	node.directory.timeout = 500 * time.Millisecond
+	if err := node.Start(); err != nil {
+		t.Fatalf("Start %s: %v", id, err)
+	}
+	t.Cleanup(func() { node.Stop() })
+	return node
+}
+
+// startEcho запускает TCP echo server
+func startEcho(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// waitFor ждет выполнения условия
+func waitFor(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCluster_RemoteDevice(t *testing.T) {
+	echo := startEcho(t)
+
+	// Цепочка A <- B <- C: A узнает об устройстве C только через B
+	a := startNode(t, "a", "secret", &fakeLocal{})
+	b := startNode(t, "b", "secret", &fakeLocal{}, a.advertise)
+	c := startNode(t, "c", "secret", &fakeLocal{devices: []*DeviceInfo{{ID: "dc", Location: "eu", Tags: []string{"mobile"}}}}, b.advertise)
+
+	waitFor(t, "A не узнал об устройстве C", func() bool {
+		return len(a.RemoteDevices(device.NewDeviceCriteria())) == 1
+	})
+	remote := a.RemoteDevices(device.NewDeviceCriteria().WithTags("mobile"))
+	if len(remote) != 1 || remote[0].ID != "dc" || remote[0].NodeID != "c" {
+		t.Fatalf("Неверные устройства других POP: %+v", remote)
+	}
+	if got := a.RemoteDevices(device.NewDeviceCriteria().WithLocation("us")); len(got) != 0 {
+		t.Errorf("Устройство не подходит по локации: %+v", got)
+	}
+
+	// Соединение через устройство C по туннелю A -> C
+	ob, err := a.RemoteOutbound("dc")
+	if err != nil {
+		t.Fatalf("RemoteOutbound: %v", err)
+	}
+	conn, err := ob.DialContext(context.Background(), "tcp", echo)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Ожидался echo, получено %q, %v", buf, err)
+	}
+	// Адрес назначения и публичный IP устройства передаются POP C
+	egressConn, ok := conn.(outbound.EgressConn)
+	if !ok {
+		t.Fatalf("Соединение %T не сообщает сведения устройства", conn)
+	}
+	if egressConn.ResolvedAddr() != echo || egressConn.EgressIP() != "203.0.113.7" {
+		t.Errorf("Неверные сведения устройства: resolved %s, egress %s", egressConn.ResolvedAddr(), egressConn.EgressIP())
+	}
+	conn.Close()
+
+	// Ошибка подключения на стороне C передается с кодом
+	c.local.(*fakeLocal).mu.Lock()
+	c.local.(*fakeLocal).devices = nil
+	c.local.(*fakeLocal).mu.Unlock()
+	if _, err := ob.DialContext(context.Background(), "tcp", echo); !errors.Is(err, connerr.ErrHostUnreachable) {
+		t.Errorf("Ожидалась ErrHostUnreachable, получено %v", err)
+	}
+
+	// Остановленный POP исключается из каталога
+	waitFor(t, "Устройство C не исчезло после публикации пустого списка", func() bool {
+		return len(a.RemoteDevices(device.NewDeviceCriteria())) == 0
+	})
+	c.Stop()
+	waitFor(t, "Остановленный POP C не исключен из каталога", func() bool {
+		return len(a.Nodes()) == 2
+	})
+	if _, err := a.RemoteOutbound("dc"); err == nil {
+		t.Error("Ожидалась ошибка для устройства неизвестного POP")
+	}
+}
+
+// hangingLocal устройство POP, подключение через которое не завершается до отмены ctx
+type hangingLocal struct {
+	started  chan struct{}
+	canceled chan struct{}
+}
+
+func (l *hangingLocal) Devices() []*DeviceInfo {
+	return []*DeviceInfo{{ID: "slow"}}
+}
+
+func (l *hangingLocal) Dial(ctx context.Context, deviceID, address string) (net.Conn, error) {
+	close(l.started)
+	<-ctx.Done()
+	close(l.canceled)
+	return nil, ctx.Err()
+}
+
+func TestCluster_DialCanceledByRequester(t *testing.T) {
+	a := startNode(t, "a", "secret", &fakeLocal{})
+	local := &hangingLocal{started: make(chan struct{}), canceled: make(chan struct{})}
+	startNode(t, "b", "secret", local, a.advertise)
+	waitFor(t, "A не узнал об устройстве B", func() bool {
+		return len(a.RemoteDevices(device.NewDeviceCriteria())) == 1
+	})
+	ob, err := a.RemoteOutbound("slow")
+	if err != nil {
+		t.Fatalf("RemoteOutbound: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, err := ob.DialContext(ctx, "tcp", "192.0.2.1:80")
+		result <- err
+	}()
+	select {
+	case <-local.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("POP B не начал подключение через устройство")
+	}
+
+	// Отмена на стороне A прерывает подключение на POP B, не дожидаясь dialResultTimeout
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("Ожидалась context.Canceled, получено %v", err)
+	}
+	select {
+	case <-local.canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Подключение на POP B не прервано")
+	}
+}
+
+func TestCluster_WrongSecret(t *testing.T) {
+	a := startNode(t, "a", "secret", &fakeLocal{devices: []*DeviceInfo{{ID: "da"}}})
+	b := startNode(t, "b", "other", &fakeLocal{}, a.advertise)
+
+	if err := b.exchange(a.advertise); err == nil {
+		t.Fatal("Ожидалась ошибка аутентификации")
+	}
+	if len(b.RemoteDevices(device.NewDeviceCriteria())) != 0 || len(a.Nodes()) != 1 {
+		t.Error("POP с другим секретом не должны обмениваться устройствами")
+	}
+}
+
+func TestDirectory_Merge(t *testing.T) {
+	d := NewDirectory("self", time.Minute)
+
+	joined := d.Merge([]*NodeState{
+		{NodeID: "self", Version: 100},
+		{NodeID: "b", Version: 2, Devices: []*DeviceInfo{{ID: "d1"}}},
+	})
+	if len(joined) != 1 || joined[0] != "b" {
+		t.Fatalf("Ожидался новый POP b, получено %v", joined)
+	}
+
+	// Устаревшая версия не заменяет актуальную
+	d.Merge([]*NodeState{{NodeID: "b", Version: 1}})
+	if owner, ok := d.Owner("d1"); !ok || owner.NodeID != "b" {
+		t.Errorf("Устройство d1 должно принадлежать b: %+v", owner)
+	}
+
+	// Устройство переподключилось к c: выбирается самое свежее состояние
+	time.Sleep(time.Millisecond)
+	d.Merge([]*NodeState{{NodeID: "c", Version: 1, Devices: []*DeviceInfo{{ID: "d1"}}}})
+	if owner, _ := d.Owner("d1"); owner.NodeID != "c" {
+		t.Errorf("Устройство d1 должно принадлежать c: %+v", owner)
+	}
+}
+
+func TestDirectory_ExpiredNotResurrected(t *testing.T) {
+	d := NewDirectory("self", 0)
+	d.Merge([]*NodeState{{NodeID: "b", Version: 5}})
+	time.Sleep(time.Millisecond)
+	if expired := d.Expire(); len(expired) != 1 {
+		t.Fatalf("Ожидалось исключение b, получено %v", expired)
+	}
+
+	// Другой POP еще хранит ту же версию: она не должна вернуть b в каталог
+	if joined := d.Merge([]*NodeState{{NodeID: "b", Version: 5}}); len(joined) != 0 {
+		t.Errorf("Исключенный POP вернулся в каталог: %v", joined)
+	}
+	// Новая версия означает, что POP снова работает
+	if joined := d.Merge([]*NodeState{{NodeID: "b", Version: 6}}); len(joined) != 1 {
+		t.Errorf("POP с новой версией должен вернуться в каталог: %v", joined)
+	}
+}
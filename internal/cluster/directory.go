@@ -0,0 +1,184 @@
+package cluster
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/me/myproxy/internal/device"
+)
+
+// DeviceInfo устройство в списке, публикуемом POP
+type DeviceInfo struct {
+	ID       string   `json:"id"`
+	Location string   `json:"location,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Load     int      `json:"load"` // Активные соединения через устройство
+}
+
+// NodeState состояние POP, распространяемое через gossip
+// Version увеличивает только сам POP, поэтому из двух копий актуальна копия с большей версией
+type NodeState struct {
+	NodeID  string        `json:"node_id"`
+	Address string        `json:"address"` // Адрес inter-POP QUIC
+	Version int64         `json:"version"`
+	Devices []*DeviceInfo `json:"devices"`
+}
+
+// RemoteDevice устройство, подключенное к другому POP кластера
+type RemoteDevice struct {
+	DeviceInfo
+	NodeID  string
+	Address string
+}
+
+// nodeEntry состояние POP и время получения его последней версии
+type nodeEntry struct {
+	state   *NodeState
+	updated time.Time
+}
+
+// Directory общий каталог устройств кластера
+// Состояние каждого POP публикует сам POP, остальные только распространяют его дальше
+type Directory struct {
+	mu      sync.RWMutex
+	self    string
+	nodes   map[string]*nodeEntry
+	timeout time.Duration
+	// Версии исключенных POP: другие POP могут еще хранить ту же версию,
+	// и без этого исключенный POP возвращался бы в каталог при следующем обмене
+	expired map[string]int64
+}
+
+// NewDirectory создает каталог для POP selfID
+// POP, версия которого не обновлялась дольше timeout, исключается из каталога
+func NewDirectory(selfID string, timeout time.Duration) *Directory {
+	return &Directory{
+		self:    selfID,
+		nodes:   make(map[string]*nodeEntry),
+		timeout: timeout,
+		expired: make(map[string]int64),
+	}
+}
+
+// SetLocal публикует новое состояние этого POP
+func (d *Directory) SetLocal(state *NodeState) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nodes[d.self] = &nodeEntry{state: state, updated: time.Now()}
+}
+
+// Merge принимает состояния, полученные от другого POP
+// Возвращает ID POP, о которых ранее ничего не было известно
+func (d *Directory) Merge(states []*NodeState) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	var joined []string
+	for _, state := range states {
+		if state == nil || state.NodeID == "" || state.NodeID == d.self {
+			continue
+		}
+		entry, exists := d.nodes[state.NodeID]
+		if exists && entry.state.Version >= state.Version {
+			continue
+		}
+		if version, ok := d.expired[state.NodeID]; ok {
+			if state.Version <= version {
+				continue
+			}
+			delete(d.expired, state.NodeID)
+		}
+		if !exists {
+			joined = append(joined, state.NodeID)
+		}
+		d.nodes[state.NodeID] = &nodeEntry{state: state, updated: now}
+	}
+	return joined
+}
+
+// Expire исключает POP без обновлений дольше timeout и возвращает их ID
+func (d *Directory) Expire() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var expired []string
+	for id, entry := range d.nodes {
+		if id != d.self && time.Since(entry.updated) > d.timeout {
+			delete(d.nodes, id)
+			d.expired[id] = entry.state.Version
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
+
+// Snapshot возвращает состояния всех известных POP (включая этот) для отправки другим POP
+func (d *Directory) Snapshot() []*NodeState {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	states := make([]*NodeState, 0, len(d.nodes))
+	for _, entry := range d.nodes {
+		states = append(states, entry.state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].NodeID < states[j].NodeID })
+	return states
+}
+
+// PeerAddresses возвращает адреса других известных POP
+func (d *Directory) PeerAddresses() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	addresses := make([]string, 0, len(d.nodes))
+	for id, entry := range d.nodes {
+		if id != d.self && entry.state.Address != "" {
+			addresses = append(addresses, entry.state.Address)
+		}
+	}
+	return addresses
+}
+
+// RemoteDevices возвращает устройства других POP, подходящие по критериям
+// Если устройство указано несколькими POP (переподключилось), выбирается самое свежее состояние
+func (d *Directory) RemoteDevices(criteria *device.DeviceCriteria) []*RemoteDevice {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	found := make(map[string]*RemoteDevice)
+	updated := make(map[string]time.Time)
+	for id, entry := range d.nodes {
+		if id == d.self {
+			continue
+		}
+		for _, info := range entry.state.Devices {
+			if !criteria.Match(info.ID, info.Location, info.Tags) {
+				continue
+			}
+			if previous, ok := updated[info.ID]; ok && !entry.updated.After(previous) {
+				continue
+			}
+			found[info.ID] = &RemoteDevice{DeviceInfo: *info, NodeID: id, Address: entry.state.Address}
+			updated[info.ID] = entry.updated
+		}
+	}
+
+	devices := make([]*RemoteDevice, 0, len(found))
+	for _, remote := range found {
+		devices = append(devices, remote)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices
+}
+
+// Owner возвращает POP, к которому подключено устройство deviceID
+func (d *Directory) Owner(deviceID string) (*RemoteDevice, bool) {
+	for _, remote := range d.RemoteDevices(&device.DeviceCriteria{}) {
+		if remote.ID == deviceID {
+			return remote, true
+		}
+	}
+	return nil, false
+}
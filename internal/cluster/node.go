@@ -0,0 +1,580 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/constants"
+	"example.com/me/myproxy/internal/device"
+	"example.com/me/myproxy/internal/logger"
+	quicproto "example.com/me/myproxy/internal/protocol/quic"
+	tlsconfig "example.com/me/myproxy/internal/tls"
+	"example.com/me/myproxy/internal/upgrade"
+	"example.com/me/myproxy/outbound"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// gossipFanout количество POP, с которыми происходит обмен за один интервал
+	gossipFanout = 3
+	// dialResultTimeout время ожидания результата подключения от POP устройства
+	dialResultTimeout = 30 * time.Second
+	// keepAlivePeriod поддерживает простаивающие inter-POP соединения
+	keepAlivePeriod = 10 * time.Second
+)
+
+// Local устройства, подключенные к этому POP
+type Local interface {
+	// Devices возвращает доступные устройства для публикации в кластере
+	Devices() []*DeviceInfo
+	// Dial подключается к address через устройство этого POP
+	Dial(ctx context.Context, deviceID, address string) (net.Conn, error)
+}
+
+// Node участник кластера POP
+// Публикует устройства этого POP, получает списки устройств других POP и
+// передает соединения через inter-POP QUIC туннель
+type Node struct {
+	id        string
+	listen    string
+	advertise string
+	seeds     []string
+	secret    string
+	interval  time.Duration
+	local     Local
+	directory *Directory
+	upgrader  *upgrade.Upgrader
+
+	serverTLS  *tls.Config
+	clientTLS  *tls.Config
+	quicConfig *quic.Config
+	udpConn    net.PacketConn
+	transport  *quic.Transport
+	listener   *quic.Listener
+
+	mu       sync.Mutex
+	peers    map[string]*quic.Conn // Исходящие соединения: адрес POP -> соединение
+	version  int64                 // Последняя опубликованная версия состояния
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// NewNode создает участника кластера по конфигурации
+func NewNode(cfg *config.ClusterConfig, local Local) *Node {
+	interval := cfg.GossipInterval
+	if interval == 0 {
+		interval = constants.DefaultClusterGossipInterval
+	}
+	timeout := cfg.NodeTimeout
+	if timeout == 0 {
+		timeout = constants.DefaultClusterNodeTimeout
+	}
+
+	return &Node{
+		id:        cfg.NodeID,
+		listen:    cfg.Listen,
+		advertise: cfg.Advertise,
+		seeds:     append([]string(nil), cfg.Peers...),
+		secret:    cfg.Secret,
+		interval:  time.Duration(interval) * time.Second,
+		local:     local,
+		directory: NewDirectory(cfg.NodeID, time.Duration(timeout)*time.Second),
+		peers:     make(map[string]*quic.Conn),
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// SetUpgrader задает Upgrader для создания UDP сокета (передача сокета при обновлении бинарника)
+func (n *Node) SetUpgrader(upgrader *upgrade.Upgrader) {
+	n.upgrader = upgrader
+}
+
+// Start начинает прием inter-POP соединений и обмен списками устройств
+func (n *Node) Start() error {
+	transport, err := n.upgrader.ListenQUIC("udp", n.listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen UDP: %w", err)
+	}
+	udpConn := transport.Conn
+
+	// Сертификат самоподписанный: POP аутентифицируют друг друга токенами на основе общего секрета
+	serverTLS, err := tlsconfig.NewTLSConfigForQUIC(nil, []string{alpn})
+	if err != nil {
+		udpConn.Close()
+		return err
+	}
+	n.serverTLS = serverTLS
+	n.clientTLS = &tls.Config{InsecureSkipVerify: true, NextProtos: []string{alpn}}
+	n.quicConfig = &quic.Config{KeepAlivePeriod: keepAlivePeriod}
+
+	listener, err := transport.Listen(n.serverTLS, n.quicConfig)
+	if err != nil {
+		transport.Close()
+		udpConn.Close()
+		return fmt.Errorf("failed to create QUIC listener: %w", err)
+	}
+
+	n.udpConn = udpConn
+	n.transport = transport
+	n.listener = listener
+	if n.advertise == "" {
+		n.advertise = udpConn.LocalAddr().String()
+	}
+
+	logger.Info("cluster", "Cluster node %s listening on %s (advertised as %s)", n.id, udpConn.LocalAddr(), n.advertise)
+
+	n.publish()
+	go n.acceptLoop()
+	go n.gossipLoop()
+	return nil
+}
+
+// Stop выходит из кластера: закрывает inter-POP соединения и туннелированные через них соединения
+func (n *Node) Stop() error {
+	n.stopOnce.Do(func() { close(n.stopChan) })
+
+	if n.listener != nil {
+		n.listener.Close()
+	}
+
+	n.mu.Lock()
+	for address, conn := range n.peers {
+		conn.CloseWithError(0, "node stopped")
+		delete(n.peers, address)
+	}
+	n.mu.Unlock()
+
+	if n.transport != nil {
+		if err := n.transport.Close(); err != nil {
+			return err
+		}
+		// Transport не закрывает переданный ему сокет
+		return n.udpConn.Close()
+	}
+	return nil
+}
+
+// ID возвращает идентификатор POP
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Nodes возвращает состояния всех известных POP кластера
+func (n *Node) Nodes() []*NodeState {
+	return n.directory.Snapshot()
+}
+
+// RemoteDevices возвращает устройства других POP, подходящие по критериям
+func (n *Node) RemoteDevices(criteria *device.DeviceCriteria) []*RemoteDevice {
+	return n.directory.RemoteDevices(criteria)
+}
+
+// RemoteOutbound возвращает outbound, передающий соединения POP, к которому подключено устройство
+func (n *Node) RemoteOutbound(deviceID string) (outbound.Outbound, error) {
+	owner, ok := n.directory.Owner(deviceID)
+	if !ok {
+		return nil, fmt.Errorf("device %s is not connected to any cluster node", deviceID)
+	}
+	return &remoteOutbound{node: n, device: owner}, nil
+}
+
+// publish обновляет состояние этого POP в каталоге
+func (n *Node) publish() {
+	n.mu.Lock()
+	version := time.Now().UnixNano()
+	if version <= n.version {
+		version = n.version + 1
+	}
+	n.version = version
+	n.mu.Unlock()
+
+	n.directory.SetLocal(&NodeState{
+		NodeID:  n.id,
+		Address: n.advertise,
+		Version: version,
+		Devices: n.local.Devices(),
+	})
+}
+
+// gossipLoop периодически публикует устройства этого POP и обменивается каталогом со случайными POP
+func (n *Node) gossipLoop() {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	n.gossipRound()
+	for {
+		select {
+		case <-ticker.C:
+			n.publish()
+			for _, id := range n.directory.Expire() {
+				logger.Info("cluster", "Cluster node %s expired", id)
+			}
+			n.gossipRound()
+		case <-n.stopChan:
+			return
+		}
+	}
+}
+
+// gossipRound обменивается каталогом с несколькими POP (известными и из peers конфигурации)
+func (n *Node) gossipRound() {
+	seen := map[string]bool{n.advertise: true}
+	var targets []string
+	for _, address := range append(n.directory.PeerAddresses(), n.seeds...) {
+		if !seen[address] {
+			seen[address] = true
+			targets = append(targets, address)
+		}
+	}
+	rand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+	if len(targets) > gossipFanout {
+		targets = targets[:gossipFanout]
+	}
+
+	for _, address := range targets {
+		go func(address string) {
+			if err := n.exchange(address); err != nil {
+				logger.Debug("cluster", "Gossip with %s failed: %v", address, err)
+			}
+		}(address)
+	}
+}
+
+// exchange отправляет каталог POP по адресу address и принимает его каталог (push-pull)
+func (n *Node) exchange(address string) error {
+	conn, err := n.peerConn(address)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), headerDeadline)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open stream: %w", err)
+	}
+	defer stream.CancelRead(0)
+	stream.SetDeadline(time.Now().Add(headerDeadline))
+
+	if err := writeLine(stream, cmdGossip); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(stream).Encode(n.directory.Snapshot()); err != nil {
+		return err
+	}
+	stream.Close()
+
+	var states []*NodeState
+	if err := json.NewDecoder(stream).Decode(&states); err != nil {
+		return fmt.Errorf("failed to read gossip reply: %w", err)
+	}
+	n.merge(states)
+	return nil
+}
+
+// merge принимает состояния других POP
+func (n *Node) merge(states []*NodeState) {
+	for _, id := range n.directory.Merge(states) {
+		logger.Info("cluster", "Cluster node %s joined", id)
+	}
+}
+
+// peerConn возвращает установленное соединение с POP или устанавливает новое
+func (n *Node) peerConn(address string) (*quic.Conn, error) {
+	n.mu.Lock()
+	conn, ok := n.peers[address]
+	n.mu.Unlock()
+	if ok && conn.Context().Err() == nil {
+		return conn, nil
+	}
+
+	conn, err := n.dialPeer(address)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	select {
+	case <-n.stopChan:
+		conn.CloseWithError(0, "node stopped")
+		return nil, fmt.Errorf("cluster node stopped")
+	default:
+	}
+	// Параллельный вызов мог установить соединение раньше
+	if existing, ok := n.peers[address]; ok && existing != conn && existing.Context().Err() == nil {
+		conn.CloseWithError(0, "duplicate connection")
+		return existing, nil
+	}
+	n.peers[address] = conn
+	return conn, nil
+}
+
+// dialPeer устанавливает соединение с POP и выполняет взаимную аутентификацию
+func (n *Node) dialPeer(address string) (*quic.Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), headerDeadline)
+	defer cancel()
+	conn, err := n.transport.Dial(ctx, udpAddr, n.clientTLS, n.quicConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to cluster node %s: %w", address, err)
+	}
+
+	if err := n.hello(ctx, conn); err != nil {
+		conn.CloseWithError(0, "authentication failed")
+		return nil, fmt.Errorf("cluster node %s: %w", address, err)
+	}
+	return conn, nil
+}
+
+// hello аутентифицирует исходящее соединение
+func (n *Node) hello(ctx context.Context, conn *quic.Conn) error {
+	token, err := authToken(conn, n.secret, clientTokenLabel)
+	if err != nil {
+		return err
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open hello stream: %w", err)
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(headerDeadline))
+
+	if err := writeLine(stream, cmdHello, n.id, token); err != nil {
+		return err
+	}
+	reply, err := readLine(stream)
+	if err != nil {
+		return fmt.Errorf("failed to read hello reply: %w", err)
+	}
+	status, serverToken, _ := strings.Cut(reply, " ")
+	if status != "OK" {
+		return fmt.Errorf("rejected: %s", serverToken)
+	}
+	return checkToken(conn, n.secret, serverTokenLabel, serverToken)
+}
+
+// acceptLoop принимает inter-POP соединения
+func (n *Node) acceptLoop() {
+	for {
+		conn, err := n.listener.Accept(context.Background())
+		if err != nil {
+			if errors.Is(err, quic.ErrServerClosed) {
+				return
+			}
+			logger.Error("cluster", "Failed to accept cluster connection: %v", err)
+			continue
+		}
+		go n.handleConnection(conn)
+	}
+}
+
+// handleConnection аутентифицирует входящее соединение и обрабатывает его streams
+func (n *Node) handleConnection(conn *quic.Conn) {
+	peerID, err := n.acceptHello(conn)
+	if err != nil {
+		logger.Error("cluster", "Cluster connection from %s rejected: %v", conn.RemoteAddr(), err)
+		conn.CloseWithError(0, "authentication failed")
+		return
+	}
+	logger.Debug("cluster", "Cluster node %s connected from %s", peerID, conn.RemoteAddr())
+
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			logger.Debug("cluster", "Cluster connection from %s closed: %v", peerID, err)
+			return
+		}
+		go n.handleStream(conn, stream, peerID)
+	}
+}
+
+// acceptHello проверяет токен входящего соединения и отвечает своим токеном
+func (n *Node) acceptHello(conn *quic.Conn) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), headerDeadline)
+	defer cancel()
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to accept hello stream: %w", err)
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(headerDeadline))
+
+	line, err := readLine(stream)
+	if err != nil {
+		return "", fmt.Errorf("failed to read hello: %w", err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[0] != cmdHello {
+		return "", fmt.Errorf("invalid hello %q", line)
+	}
+	if err := checkToken(conn, n.secret, clientTokenLabel, fields[2]); err != nil {
+		writeLine(stream, "ERR", err.Error())
+		return "", err
+	}
+
+	token, err := authToken(conn, n.secret, serverTokenLabel)
+	if err != nil {
+		return "", err
+	}
+	if err := writeLine(stream, "OK", token); err != nil {
+		return "", err
+	}
+	return fields[1], nil
+}
+
+// handleStream обрабатывает stream аутентифицированного POP
+func (n *Node) handleStream(conn *quic.Conn, stream *quic.Stream, peerID string) {
+	stream.SetReadDeadline(time.Now().Add(headerDeadline))
+	line, err := readLine(stream)
+	if err != nil {
+		logger.Debug("cluster", "Failed to read stream header from %s: %v", peerID, err)
+		stream.CancelRead(0)
+		stream.Close()
+		return
+	}
+
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 1 && fields[0] == cmdGossip:
+		n.handleGossip(stream, peerID)
+	case len(fields) == 3 && fields[0] == cmdDial:
+		stream.SetReadDeadline(time.Time{})
+		n.handleDial(conn, stream, peerID, fields[1], fields[2])
+	default:
+		logger.Error("cluster", "Invalid stream header from %s: %q", peerID, line)
+		stream.CancelRead(0)
+		stream.Close()
+	}
+}
+
+// handleGossip принимает каталог POP и отвечает своим
+func (n *Node) handleGossip(stream *quic.Stream, peerID string) {
+	defer stream.Close()
+	stream.SetWriteDeadline(time.Now().Add(headerDeadline))
+
+	var states []*NodeState
+	if err := json.NewDecoder(stream).Decode(&states); err != nil {
+		logger.Debug("cluster", "Failed to read gossip from %s: %v", peerID, err)
+		stream.CancelRead(0)
+		return
+	}
+	n.merge(states)
+
+	if err := json.NewEncoder(stream).Encode(n.directory.Snapshot()); err != nil {
+		logger.Debug("cluster", "Failed to send gossip reply to %s: %v", peerID, err)
+	}
+}
+
+// handleDial подключается к адресу через локальное устройство и пересылает данные
+func (n *Node) handleDial(conn *quic.Conn, stream *quic.Stream, peerID, deviceID, address string) {
+	logger.Debug("cluster", "Tunneled connection from %s to %s via device %s", peerID, address, deviceID)
+
+	// POP, запросивший подключение, сбрасывает stream, если перестал ждать результат
+	// (отмена на его стороне или закрытие соединения между POP): подключение прерывается
+	ctx, cancel := context.WithTimeout(stream.Context(), dialResultTimeout)
+	target, dialErr := n.local.Dial(ctx, deviceID, address)
+	cancel()
+	var err error
+	if dialErr != nil {
+		err = quicproto.WriteDialResult(stream, dialErr)
+	} else {
+		// Сведения, сообщенные устройством, передаются POP, запросившему подключение
+		var result quicproto.DialResult
+		if egressConn, ok := target.(outbound.EgressConn); ok {
+			result = quicproto.DialResult{ResolvedAddr: egressConn.ResolvedAddr(), EgressIP: egressConn.EgressIP()}
+		}
+		err = quicproto.WriteDialSuccess(stream, result)
+	}
+	if err != nil || dialErr != nil {
+		if dialErr != nil {
+			logger.Debug("cluster", "Tunneled connection from %s to %s via device %s failed: %v", peerID, address, deviceID, dialErr)
+		} else {
+			target.Close()
+		}
+		stream.CancelRead(0)
+		stream.Close()
+		return
+	}
+
+	pipe(&streamConn{Stream: stream, conn: conn}, target)
+}
+
+// remoteOutbound outbound через устройство другого POP
+type remoteOutbound struct {
+	node   *Node
+	device *RemoteDevice
+}
+
+// DialContext открывает stream к POP устройства и запрашивает подключение к address
+// Отмена ctx прерывает ожидание результата, POP устройства получает сброс stream
+func (o *remoteOutbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("unsupported network for cluster outbound: %s", network)
+	}
+
+	conn, err := o.node.peerConn(o.device.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	openCtx, cancel := context.WithTimeout(ctx, headerDeadline)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(openCtx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stream to cluster node %s: %w", o.device.NodeID, err)
+	}
+
+	logger.Debug("cluster", "Dialing %s via device %s on cluster node %s", address, o.device.ID, o.device.NodeID)
+
+	stream.SetDeadline(time.Now().Add(dialResultTimeout))
+	stop := context.AfterFunc(ctx, func() { stream.SetDeadline(time.Now()) })
+	var result quicproto.DialResult
+	err = writeLine(stream, cmdDial, o.device.ID, address)
+	if err != nil {
+		err = fmt.Errorf("failed to send dial request: %w", err)
+	} else {
+		result, err = quicproto.ReadDialResult(stream)
+	}
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		stream.CancelRead(0)
+		stream.Close()
+		return nil, err
+	}
+	stream.SetDeadline(time.Time{})
+
+	return &deviceConn{streamConn: &streamConn{Stream: stream, conn: conn}, result: result}, nil
+}
+
+// deviceConn соединение через устройство другого POP со сведениями, сообщенными устройством
+type deviceConn struct {
+	*streamConn
+	result quicproto.DialResult
+}
+
+// ResolvedAddr возвращает адрес назначения после разрешения домена на устройстве
+func (c *deviceConn) ResolvedAddr() string {
+	return c.result.ResolvedAddr
+}
+
+// EgressIP возвращает публичный IP устройства
+func (c *deviceConn) EgressIP() string {
+	return c.result.EgressIP
+}
@@ -0,0 +1,135 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// alpn протокол inter-POP QUIC соединений
+const alpn = "myproxy-cluster"
+
+// Заголовки streams inter-POP соединения
+// Первый stream соединения: "HELLO <node_id> <token>\n" -> "OK <token>\n" или "ERR <message>\n"
+// Далее каждый stream начинается со строки команды:
+//
+//	"GOSSIP\n" + JSON []*NodeState -> JSON []*NodeState
+//	"DIAL <device_id> <address>\n" -> результат подключения (формат data-plane устройств), затем данные
+const (
+	cmdHello  = "HELLO"
+	cmdGossip = "GOSSIP"
+	cmdDial   = "DIAL"
+)
+
+// maxLineLen максимальная длина строки заголовка
+const maxLineLen = 1024
+
+// Метки для вывода ключевого материала TLS сессии (RFC 5705)
+const (
+	clientTokenLabel = "EXPORTER-myproxy-cluster-client"
+	serverTokenLabel = "EXPORTER-myproxy-cluster-server"
+)
+
+// authToken вычисляет токен стороны соединения: HMAC общего секрета над ключевым материалом TLS сессии
+// Токен привязан к конкретному соединению: перехваченный токен нельзя использовать в другом соединении,
+// а сам секрет не передается
+func authToken(conn *quic.Conn, secret, label string) (string, error) {
+	state := conn.ConnectionState().TLS
+	material, err := state.ExportKeyingMaterial(label, nil, sha256.Size)
+	if err != nil {
+		return "", fmt.Errorf("failed to export keying material: %w", err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(material)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// checkToken сравнивает полученный токен с ожидаемым за постоянное время
+func checkToken(conn *quic.Conn, secret, label, token string) error {
+	expected, err := authToken(conn, secret, label)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(token)) {
+		return fmt.Errorf("invalid cluster secret")
+	}
+	return nil
+}
+
+// writeLine записывает строку заголовка
+func writeLine(w io.Writer, fields ...string) error {
+	_, err := io.WriteString(w, strings.Join(fields, " ")+"\n")
+	return err
+}
+
+// readLine читает строку заголовка побайтно, чтобы не захватить следующие за ней данные
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, buf[0])
+			if len(line) > maxLineLen {
+				return "", fmt.Errorf("header line too long (max %d bytes)", maxLineLen)
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+	}
+}
+
+// streamConn обертка для stream inter-POP соединения, реализующая net.Conn
+type streamConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+// Close закрывает stream в обе стороны
+func (c *streamConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// pipe пересылает данные между stream и соединением до закрытия одной из сторон
+func pipe(stream net.Conn, conn net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn, stream)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(stream, conn)
+		done <- struct{}{}
+	}()
+
+	<-done
+	conn.Close()
+	stream.Close()
+	<-done
+}
+
+// headerDeadline время на обмен заголовками stream
+const headerDeadline = 10 * time.Second
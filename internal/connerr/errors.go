@@ -0,0 +1,18 @@
+package connerr
+
+import "errors"
+
+// Типизированные ошибки установки соединения
+// Inbound преобразует их в коды ответа протокола (например, SOCKS5 reply)
+var (
+	// ErrNotAllowed соединение запрещено правилами (ACL, лимиты, квоты)
+	ErrNotAllowed = errors.New("connection not allowed")
+	// ErrNetworkUnreachable сеть недоступна
+	ErrNetworkUnreachable = errors.New("network unreachable")
+	// ErrHostUnreachable хост недоступен
+	ErrHostUnreachable = errors.New("host unreachable")
+	// ErrConnectionRefused соединение отклонено целевым хостом
+	ErrConnectionRefused = errors.New("connection refused")
+	// ErrTimeout истек таймаут установки соединения
+	ErrTimeout = errors.New("connection timed out")
+)
@@ -18,6 +18,12 @@ const (
 	MaxTargetAddressLen = 256
 )
 
+// Device capabilities (сообщаются в RegisterRequest)
+const (
+	// CapabilityDialResult device отвечает на target address строкой результата подключения (OK/ERR)
+	CapabilityDialResult = "dial_result"
+)
+
 // Timeouts and intervals
 const (
 	// DefaultHeartbeatInterval интервал heartbeat в секундах
@@ -26,6 +32,86 @@ const (
 	DefaultHeartbeatTimeout = 90
 	// RegistrationStreamTimeout таймаут для чтения device_id из QUIC registration stream
 	RegistrationStreamTimeout = 5 * time.Second
+	// DefaultShutdownTimeout время ожидания завершения активных соединений при остановке (секунды)
+	DefaultShutdownTimeout = 30
+	// DrainLogInterval интервал логирования количества активных соединений при остановке
+	DrainLogInterval = 5 * time.Second
+	// DefaultRegistryFlushInterval интервал сохранения состояния устройств в хранилище (секунды)
+	DefaultRegistryFlushInterval = 10
+	// DefaultClusterGossipInterval интервал обмена списками устройств между POP (секунды)
+	DefaultClusterGossipInterval = 2
+	// DefaultClusterNodeTimeout время без обновлений, после которого POP исключается из кластера (секунды)
+	DefaultClusterNodeTimeout = 10
+)
+
+// Device health
+const (
+	// DefaultHealthWindow окно статистики подключений для оценки здоровья устройства (секунды)
+	DefaultHealthWindow = 300
+	// DefaultHealthMinDials минимум подключений в окне, после которого оценка здоровья снижается
+	DefaultHealthMinDials = 10
+	// DefaultHealthMinScore оценка здоровья, ниже которой устройство помещается в карантин
+	DefaultHealthMinScore = 0.3
+	// DefaultHealthCooldown время до повторной проверки устройства в карантине (секунды)
+	DefaultHealthCooldown = 60
+)
+
+// Outbound group
+const (
+	// DefaultGroupProbeInterval интервал фоновой проверки участников группы outbound (секунды)
+	DefaultGroupProbeInterval = 30
+)
+
+// Dial timeouts
+const (
+	// DefaultConnectTimeout таймаут TCP подключения direct и socks5 outbound (секунды)
+	DefaultConnectTimeout = 10
+	// DefaultHandshakeTimeout таймаут SOCKS5 handshake с вышестоящим прокси (секунды)
+	DefaultHandshakeTimeout = 10
+	// DefaultDeviceDialTimeout таймаут подключения через устройство пула: открытие stream и ответ устройства (секунды)
+	DefaultDeviceDialTimeout = 20
+)
+
+// Dial retry
+const (
+	// DefaultDialAttempts попыток подключения через разные устройства пула, включая первую
+	DefaultDialAttempts = 3
+	// DefaultDialBudget общее время на попытки подключения (секунды)
+	DefaultDialBudget = 15
+)
+
+// Cluster
+const (
+	// DefaultClusterRemotePenalty надбавка к стоимости устройства другого POP (в активных соединениях)
+	DefaultClusterRemotePenalty = 100
+)
+
+// DNS
+const (
+	// DefaultDNSTimeout таймаут запроса к одному DNS серверу (секунды)
+	DefaultDNSTimeout = 5
+	// DefaultDNSCacheSize максимальное количество доменов в кеше DNS
+	DefaultDNSCacheSize = 4096
+	// DefaultDNSMaxTTL максимальное время хранения ответа в кеше DNS (секунды)
+	DefaultDNSMaxTTL = 3600
+)
+
+// Sniffing
+const (
+	// DefaultSniffTimeout время ожидания первых байт клиента для определения протокола (миллисекунды)
+	DefaultSniffTimeout = 300
+)
+
+// Transparent proxy
+const (
+	// DefaultUDPSessionTimeout время простоя, после которого UDP сессия tproxy inbound завершается (секунды)
+	DefaultUDPSessionTimeout = 60
+)
+
+// Device egress
+const (
+	// DefaultEgressCheckInterval интервал определения публичного IP device (секунды)
+	DefaultEgressCheckInterval = 300
 )
 
 // Status strings
@@ -4,25 +4,61 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"example.com/me/myproxy/internal/acl"
 	"example.com/me/myproxy/internal/device/client/quic"
 	"example.com/me/myproxy/internal/device/client/wss"
+	"example.com/me/myproxy/internal/dns"
 	"example.com/me/myproxy/internal/logger"
+	pb "example.com/me/myproxy/internal/protocol/pb"
+)
+
+const (
+	// reconnectJitter максимальная случайная задержка перед переподключением после DrainNotice,
+	// чтобы устройства не переподключались к новому POP одновременно
+	reconnectJitter = 2 * time.Second
+	// reconnectMaxBackoff максимальный интервал между попытками переподключения
+	reconnectMaxBackoff = 30 * time.Second
 )
 
 // Client основной клиент для device
 type Client struct {
-	wssClient      *wss.Client
-	quicClient     *quic.Client
-	deviceID       string
-	stopChan       chan struct{}
-	heartbeatStop  chan struct{}
+	proxyHost string
+	wssPort   int
+	quicPort  int
+	tlsConfig *tls.Config
+	deviceID  string
+	stopChan  chan struct{}
+	dialer    *quic.Dialer
+	egress    *egressChecker // nil - публичный IP не определяется
+
+	// Параметры регистрации (для переподключения)
+	location          string
+	tags              []string
+	heartbeatInterval int
+
+	mu       sync.Mutex
+	current  *session   // Активная сессия, через которую POP открывает новые соединения
+	previous []*session // Сессии, POP которых завершает работу (активные streams дорабатывают)
+	stopped  bool
+}
+
+// session одно подключение device к POP (WSS control-plane + QUIC data-plane)
+type session struct {
+	wssClient       *wss.Client
+	quicClient      *quic.Client
+	heartbeatStop   chan struct{}
 	heartbeatTicker *time.Ticker
+	draining        atomic.Bool // POP сообщил о завершении работы
 }
 
 // NewClient создает новый device client
-func NewClient(proxyHost string, wssPort, quicPort int, deviceID string, tlsEnabled bool, tlsSkipVerify bool) *Client {
+// policy - политика допустимых адресов назначения (nil - без ограничений)
+func NewClient(proxyHost string, wssPort, quicPort int, deviceID string, tlsEnabled bool, tlsSkipVerify bool, policy *acl.Policy) *Client {
 	var tlsConfig *tls.Config
 	if tlsEnabled {
 		tlsConfig = &tls.Config{
@@ -31,31 +67,74 @@ func NewClient(proxyHost string, wssPort, quicPort int, deviceID string, tlsEnab
 	}
 
 	return &Client{
-		wssClient:  wss.NewClient(proxyHost, wssPort, deviceID, tlsConfig),
-		quicClient: quic.NewClient(proxyHost, quicPort, deviceID, tlsConfig),
-		deviceID:   deviceID,
-		stopChan:   make(chan struct{}),
+		proxyHost: proxyHost,
+		wssPort:   wssPort,
+		quicPort:  quicPort,
+		tlsConfig: tlsConfig,
+		deviceID:  deviceID,
+		stopChan:  make(chan struct{}),
+		dialer:    quic.NewDialer(policy),
 	}
 }
 
+// SetResolver задает resolver доменов назначения вместо системного
+func (c *Client) SetResolver(resolver *dns.Resolver) {
+	c.dialer.SetResolver(resolver)
+}
+
+// SetEgressCheck включает определение публичного IP device запросом к url раз в interval
+// IP сообщается POP в heartbeat и результатах подключений
+func (c *Client) SetEgressCheck(url string, interval time.Duration) {
+	c.egress = newEgressChecker(url, interval)
+	c.dialer.SetEgressIP(c.egress.IP)
+}
+
 // Start запускает device client
 func (c *Client) Start(location string, tags []string, heartbeatInterval int) error {
-	ctx := context.Background()
+	c.location = location
+	c.tags = tags
+	c.heartbeatInterval = heartbeatInterval
+
+	if c.egress != nil {
+		go c.egress.run(c.stopChan)
+	}
+
+	sess, err := c.connect(context.Background())
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.current = sess
+	c.mu.Unlock()
+
+	logger.Info("device", "Device client started for device %s", c.deviceID)
+	return nil
+}
+
+// connect устанавливает новую сессию с POP: регистрация через WSS, подключение QUIC,
+// запуск обработчиков и heartbeat
+func (c *Client) connect(ctx context.Context) (*session, error) {
+	sess := &session{
+		wssClient: wss.NewClient(c.proxyHost, c.wssPort, c.deviceID, c.tlsConfig),
+		// TLS конфигурация клонируется: QUIC client дописывает в нее NextProtos
+		quicClient: quic.NewClient(c.proxyHost, c.quicPort, c.deviceID, c.tlsConfig.Clone(), c.dialer),
+	}
 
 	// Шаг 1: Подключение к WSS
 	logger.Debug("device", "Step 1: Connecting to WSS...")
-	if err := c.wssClient.Connect(ctx); err != nil {
-		return fmt.Errorf("failed to connect to WSS: %w", err)
+	if err := sess.wssClient.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to WSS: %w", err)
 	}
 	logger.Debug("device", "Step 1: WSS connection established")
 
 	// Шаг 2: Регистрация через WSS
-	logger.Debug("device", "Step 2: Registering device %s (location=%s, tags=%v)...", c.deviceID, location, tags)
-	registerResp, err := c.wssClient.Register(ctx, location, tags)
+	logger.Debug("device", "Step 2: Registering device %s (location=%s, tags=%v)...", c.deviceID, c.location, c.tags)
+	registerResp, err := sess.wssClient.Register(ctx, c.location, c.tags)
 	if err != nil {
 		logger.Error("device", "Step 2: Registration failed: %v", err)
-		c.wssClient.Close()
-		return fmt.Errorf("failed to register device: %w", err)
+		sess.wssClient.Close()
+		return nil, fmt.Errorf("failed to register device: %w", err)
 	}
 
 	logger.Debug("device", "Step 2: Device %s registered successfully, quic_address: %s", c.deviceID, registerResp.QuicAddress)
@@ -63,22 +142,24 @@ func (c *Client) Start(location string, tags []string, heartbeatInterval int) er
 
 	// Шаг 3: Подключение к QUIC
 	logger.Debug("device", "Step 3: Connecting to QUIC...")
-	if err := c.quicClient.Connect(ctx); err != nil {
+	if err := sess.quicClient.Connect(ctx); err != nil {
 		logger.Error("device", "Step 3: QUIC connection failed: %v", err)
-		c.wssClient.Close()
-		return fmt.Errorf("failed to connect to QUIC: %w", err)
+		sess.wssClient.Close()
+		return nil, fmt.Errorf("failed to connect to QUIC: %w", err)
 	}
 	logger.Debug("device", "Step 3: QUIC connection established")
 
 	// Шаг 3.5: Запуск обработки входящих QUIC streams (от POP)
 	logger.Debug("device", "Step 3.5: Starting QUIC stream handler...")
 	go func() {
-		if err := c.quicClient.HandleStreams(ctx); err != nil {
+		if err := sess.quicClient.HandleStreams(ctx); err != nil {
 			// Проверяем, не закрыто ли соединение сервером (device offline)
 			errStr := err.Error()
 			if errStr == "Application error 0x0 (remote): device offline" ||
-			   errStr == "failed to accept stream: Application error 0x0 (remote): device offline" {
+				errStr == "failed to accept stream: Application error 0x0 (remote): device offline" {
 				logger.Debug("device", "QUIC stream handler stopped: connection closed by server (device offline)")
+			} else if sess.draining.Load() {
+				logger.Debug("device", "QUIC stream handler stopped after drain notice: %v", err)
 			} else {
 				logger.Error("device", "QUIC stream handling error: %v", err)
 			}
@@ -87,43 +168,49 @@ func (c *Client) Start(location string, tags []string, heartbeatInterval int) er
 
 	// Шаг 4: Настройка обработчиков команд
 	logger.Debug("device", "Step 4: Setting up command handlers...")
-	c.setupCommandHandlers()
+	c.setupCommandHandlers(sess)
+	sess.wssClient.GetHandler().SetDrainCallback(func(notice *pb.DrainNotice) {
+		c.handleDrainNotice(sess, notice)
+	})
 	logger.Debug("device", "Step 4: Command handlers configured")
 
 	// Шаг 5: Запуск обработки сообщений WSS (команды от POP)
 	// ВАЖНО: Запускаем ПОСЛЕ успешной регистрации, чтобы избежать конфликта чтения
 	logger.Debug("device", "Step 5: Starting WSS message handler...")
 	go func() {
-		if err := c.wssClient.HandleMessages(ctx); err != nil {
+		if err := sess.wssClient.HandleMessages(ctx); err != nil {
+			if sess.draining.Load() {
+				logger.Info("device", "WSS connection closed by draining POP: %v", err)
+				return
+			}
 			logger.Error("device", "WSS message handling error: %v", err)
 		}
 	}()
 
 	// Шаг 6: Запуск heartbeat
-	if heartbeatInterval > 0 {
-		logger.Debug("device", "Step 6: Starting heartbeat (interval=%d seconds)...", heartbeatInterval)
-		c.startHeartbeat(ctx, heartbeatInterval)
+	if c.heartbeatInterval > 0 {
+		logger.Debug("device", "Step 6: Starting heartbeat (interval=%d seconds)...", c.heartbeatInterval)
+		c.startHeartbeat(ctx, sess, c.heartbeatInterval)
 	}
 
-	logger.Info("device", "Device client started for device %s", c.deviceID)
-	return nil
+	return sess, nil
 }
 
 // setupCommandHandlers настраивает обработчики команд от POP
-func (c *Client) setupCommandHandlers() {
-	wssHandler := c.wssClient.GetHandler()
+func (c *Client) setupCommandHandlers(sess *session) {
+	wssHandler := sess.wssClient.GetHandler()
 	wssHandler.SetCallbacks(
 		// onOpenTCP
 		func(connID, targetAddress string) error {
 			logger.Debug("device", "Opening TCP stream: conn_id=%s, target=%s", connID, targetAddress)
-			stream, err := c.quicClient.OpenStream(context.Background(), connID)
+			stream, err := sess.quicClient.OpenStream(context.Background(), connID)
 			if err != nil {
 				return fmt.Errorf("failed to open QUIC stream: %w", err)
 			}
 
 			// Проксируем TCP трафик через QUIC stream
 			go func() {
-				if err := quic.ProxyTCP(stream, targetAddress); err != nil {
+				if err := quic.ProxyTCP(stream, targetAddress, c.dialer); err != nil {
 					logger.Error("device", "Error proxying TCP: %v", err)
 				}
 			}()
@@ -145,31 +232,103 @@ func (c *Client) setupCommandHandlers() {
 	)
 }
 
+// handleDrainNotice обрабатывает уведомление о завершении работы POP
+// Активные streams сессии продолжают работать до их закрытия POP, а device
+// переподключается: при обновлении бинарника POP новое подключение примет новый процесс
+func (c *Client) handleDrainNotice(sess *session, notice *pb.DrainNotice) {
+	if sess.draining.Swap(true) {
+		return
+	}
+	logger.Info("device", "POP is draining (reason: %s), active connections will be closed by %s",
+		notice.Reason, time.Unix(notice.Deadline, 0).Format(time.RFC3339))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped || c.current != sess {
+		return
+	}
+	c.previous = append(c.previous, sess)
+	c.current = nil
+
+	go c.reconnect(sess)
+}
+
+// reconnect устанавливает новую сессию вместо завершающейся, повторяя попытки с backoff
+func (c *Client) reconnect(old *session) {
+	delay := time.Duration(rand.Int63n(int64(reconnectJitter)))
+	backoff := time.Second
+
+	for {
+		select {
+		case <-time.After(delay):
+		case <-c.stopChan:
+			return
+		}
+
+		sess, err := c.connect(context.Background())
+		if err == nil {
+			c.mu.Lock()
+			if c.stopped {
+				c.mu.Unlock()
+				c.closeSession(sess)
+				return
+			}
+			c.current = sess
+			c.mu.Unlock()
+
+			// Heartbeat старой сессии больше не нужен: POP ее не использует для новых соединений
+			c.stopHeartbeat(old)
+			logger.Info("device", "Device %s re-registered after drain notice", c.deviceID)
+			return
+		}
+
+		logger.Info("device", "Reconnect after drain notice failed, retrying in %s: %v", backoff, err)
+		delay = backoff
+		backoff *= 2
+		if backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+// Draining возвращает true, если POP текущей сессии сообщил о завершении работы
+// и новая сессия еще не установлена
+func (c *Client) Draining() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current == nil && len(c.previous) > 0
+}
+
 // startHeartbeat запускает периодическую отправку heartbeat
-func (c *Client) startHeartbeat(ctx context.Context, interval int) {
-	c.heartbeatTicker = time.NewTicker(time.Duration(interval) * time.Second)
-	c.heartbeatStop = make(chan struct{})
+func (c *Client) startHeartbeat(ctx context.Context, sess *session, interval int) {
+	sess.heartbeatTicker = time.NewTicker(time.Duration(interval) * time.Second)
+	sess.heartbeatStop = make(chan struct{})
+	ticker, stop := sess.heartbeatTicker, sess.heartbeatStop
 
 	go func() {
 		for {
 			select {
-			case <-c.heartbeatTicker.C:
-				if err := c.wssClient.SendHeartbeat(ctx); err != nil {
+			case <-ticker.C:
+				if err := sess.wssClient.SendHeartbeat(ctx, c.dialer.EgressIP()); err != nil {
 					// Проверяем, не закрыто ли соединение
 					errStr := err.Error()
-					if errStr == "use of closed network connection" || 
-					   errStr == "failed to get writer: use of closed network connection" ||
-					   errStr == "failed to get reader: use of closed network connection" ||
-					   errStr == "failed to send heartbeat: failed to get writer: failed to get writer: use of closed network connection" ||
-					   errStr == "failed to read heartbeat response: failed to get reader: use of closed network connection" {
+					if errStr == "use of closed network connection" ||
+						errStr == "failed to get writer: use of closed network connection" ||
+						errStr == "failed to get reader: use of closed network connection" ||
+						errStr == "failed to send heartbeat: failed to get writer: failed to get writer: use of closed network connection" ||
+						errStr == "failed to read heartbeat response: failed to get reader: use of closed network connection" {
 						logger.Debug("device", "WSS connection closed, stopping heartbeat")
 						return
 					}
+					if sess.draining.Load() {
+						logger.Debug("device", "Heartbeat failed while POP is draining: %v", err)
+						continue
+					}
 					logger.Error("device", "Heartbeat failed: %v", err)
 				} else {
 					logger.Debug("device", "Heartbeat sent for device %s", c.deviceID)
 				}
-			case <-c.heartbeatStop:
+			case <-stop:
 				return
 			case <-c.stopChan:
 				return
@@ -178,24 +337,52 @@ func (c *Client) startHeartbeat(ctx context.Context, interval int) {
 	}()
 }
 
+// stopHeartbeat останавливает heartbeat сессии (повторный вызов безопасен)
+func (c *Client) stopHeartbeat(sess *session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sess.heartbeatStop != nil {
+		close(sess.heartbeatStop)
+		sess.heartbeatStop = nil
+	}
+	if sess.heartbeatTicker != nil {
+		sess.heartbeatTicker.Stop()
+	}
+}
+
+// closeSession закрывает WSS и QUIC соединения сессии
+func (c *Client) closeSession(sess *session) {
+	c.stopHeartbeat(sess)
+	if sess.wssClient != nil {
+		sess.wssClient.Close()
+	}
+	if sess.quicClient != nil {
+		sess.quicClient.Close()
+	}
+}
+
 // Stop останавливает device client
 func (c *Client) Stop() error {
 	logger.Info("device", "Stopping device client for device %s", c.deviceID)
 
-	close(c.stopChan)
-
-	if c.heartbeatStop != nil {
-		close(c.heartbeatStop)
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return nil
 	}
-	if c.heartbeatTicker != nil {
-		c.heartbeatTicker.Stop()
+	c.stopped = true
+	close(c.stopChan)
+	sessions := c.previous
+	if c.current != nil {
+		sessions = append(sessions, c.current)
 	}
+	c.current = nil
+	c.previous = nil
+	c.mu.Unlock()
 
-	if c.wssClient != nil {
-		c.wssClient.Close()
-	}
-	if c.quicClient != nil {
-		c.quicClient.Close()
+	for _, sess := range sessions {
+		c.closeSession(sess)
 	}
 
 	return nil
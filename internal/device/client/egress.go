@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"example.com/me/myproxy/internal/logger"
+)
+
+const (
+	// egressCheckTimeout таймаут одного запроса публичного IP
+	egressCheckTimeout = 10 * time.Second
+	// maxEgressResponseSize максимальный размер ответа с публичным IP
+	maxEgressResponseSize = 256
+)
+
+// egressChecker периодически определяет публичный IP device запросом к url,
+// ответ которого - IP адрес текстом (например, https://api.ipify.org)
+type egressChecker struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	ip       atomic.Value // string
+}
+
+// newEgressChecker создает проверку публичного IP
+func newEgressChecker(url string, interval time.Duration) *egressChecker {
+	e := &egressChecker{
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: egressCheckTimeout},
+	}
+	e.ip.Store("")
+	return e
+}
+
+// IP возвращает последний определенный публичный IP (пусто - еще не определен)
+func (e *egressChecker) IP() string {
+	return e.ip.Load().(string)
+}
+
+// run определяет публичный IP сразу и затем раз в interval до закрытия stop
+// При ошибке сохраняется последний определенный IP
+func (e *egressChecker) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		ip, err := e.check(context.Background())
+		if err != nil {
+			logger.Error("device", "Failed to determine egress IP: %v", err)
+		} else if previous := e.ip.Swap(ip); previous != ip {
+			logger.Info("device", "Egress IP: %s", ip)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// check запрашивает публичный IP
+func (e *egressChecker) check(ctx context.Context) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := e.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", e.url, response.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxEgressResponseSize))
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return "", fmt.Errorf("%s returned invalid IP address %q", e.url, strings.TrimSpace(string(body)))
+	}
+	return ip.String(), nil
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEgressChecker(t *testing.T) {
+	response := "203.0.113.7\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, response)
+	}))
+	defer server.Close()
+
+	checker := newEgressChecker(server.URL, time.Hour)
+	if checker.IP() != "" {
+		t.Fatalf("IP до проверки: %q", checker.IP())
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		checker.run(stop)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for checker.IP() == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+	if checker.IP() != "203.0.113.7" {
+		t.Errorf("Неверный публичный IP: %q", checker.IP())
+	}
+
+	response = "<html>rate limited</html>"
+	if _, err := checker.check(context.Background()); err == nil {
+		t.Error("Ожидалась ошибка для ответа без IP адреса")
+	}
+}
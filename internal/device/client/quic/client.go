@@ -18,17 +18,18 @@ type Client struct {
 	deviceID  string
 	tlsConfig *tls.Config
 	conn      *quic.Conn
+	udpConn   net.PacketConn // quic.Dial не закрывает переданный UDP сокет
 	handler   *StreamHandler
 }
 
 // NewClient создает новый QUIC client
-func NewClient(proxyHost string, quicPort int, deviceID string, tlsConfig *tls.Config) *Client {
+func NewClient(proxyHost string, quicPort int, deviceID string, tlsConfig *tls.Config, dialer *Dialer) *Client {
 	return &Client{
 		proxyHost: proxyHost,
 		quicPort:  quicPort,
 		deviceID:  deviceID,
 		tlsConfig: tlsConfig,
-		handler:   NewStreamHandler(),
+		handler:   NewStreamHandler(dialer),
 	}
 }
 
@@ -76,6 +77,7 @@ func (c *Client) Connect(ctx context.Context) error {
 	}
 
 	c.conn = conn
+	c.udpConn = udpConn
 	logger.Debug("device", "QUIC connection established to %s", addr)
 
 	// Отправляем device_id в первом stream для идентификации
@@ -162,10 +164,14 @@ func (c *Client) HandleStreams(ctx context.Context) error {
 
 // Close закрывает QUIC соединение
 func (c *Client) Close() error {
+	var err error
 	if c.conn != nil {
-		return c.conn.CloseWithError(0, "closing")
+		err = c.conn.CloseWithError(0, "closing")
 	}
-	return nil
+	if c.udpConn != nil {
+		c.udpConn.Close()
+	}
+	return err
 }
 
 // GetConn возвращает QUIC соединение
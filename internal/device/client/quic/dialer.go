@@ -0,0 +1,87 @@
+package quic
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"example.com/me/myproxy/internal/acl"
+	"example.com/me/myproxy/internal/dns"
+	"example.com/me/myproxy/internal/logger"
+)
+
+// dialTimeout таймаут подключения к адресу назначения, включая разрешение домена
+const dialTimeout = 10 * time.Second
+
+// Dialer подключает device к адресам назначения по запросам POP
+type Dialer struct {
+	// Политика допустимых адресов назначения (nil - без ограничений)
+	policy *acl.Policy
+	// Resolver доменов назначения (nil - системный)
+	resolver *dns.Resolver
+	// Публичный IP device для POP (nil - не сообщается)
+	egressIP func() string
+}
+
+// NewDialer создает dialer с политикой адресов назначения (nil - без ограничений)
+func NewDialer(policy *acl.Policy) *Dialer {
+	return &Dialer{
+		policy: policy,
+	}
+}
+
+// SetResolver задает resolver доменов назначения вместо системного
+func (d *Dialer) SetResolver(resolver *dns.Resolver) {
+	d.resolver = resolver
+}
+
+// SetEgressIP задает источник публичного IP device, который сообщается POP
+// в результате подключения
+func (d *Dialer) SetEgressIP(egressIP func() string) {
+	d.egressIP = egressIP
+}
+
+// EgressIP возвращает публичный IP device (пусто - неизвестен)
+func (d *Dialer) EgressIP() string {
+	if d.egressIP == nil {
+		return ""
+	}
+	return d.egressIP()
+}
+
+// Dial подключается к address
+// Порт и домен проверяются политикой до разрешения DNS, фактический IP - перед подключением
+// (защита от DNS rebinding). С resolver адреса домена пробуются по порядку
+func (d *Dialer) Dial(ctx context.Context, address string) (net.Conn, error) {
+	if err := d.policy.CheckAddress(address); err != nil {
+		return nil, fmt.Errorf("destination %s refused by policy: %w", address, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	dialer := &net.Dialer{Control: d.policy.DialControl}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || d.resolver == nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	ips, err := d.resolver.LookupIP(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		logger.Debug("device", "Failed to connect to %s (%s): %v", address, ip, err)
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
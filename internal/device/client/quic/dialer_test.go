@@ -0,0 +1,61 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/acl"
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/dns"
+)
+
+func TestDialer_Resolver(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// Первый адрес домена не слушается: подключение через следующий
+	resolver := dns.NewResolver(nil)
+	resolver.SetHosts(map[string][]net.IP{"target.test": {net.ParseIP("::1"), net.ParseIP("127.0.0.1")}})
+	dialer := NewDialer(nil)
+	dialer.SetResolver(resolver)
+	dialer.SetEgressIP(func() string { return "203.0.113.7" })
+
+	conn, err := dialer.Dial(context.Background(), net.JoinHostPort("target.test", port))
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	conn.Close()
+	if got := conn.RemoteAddr().String(); got != listener.Addr().String() {
+		t.Errorf("Неверный адрес назначения: %s", got)
+	}
+	if dialer.EgressIP() != "203.0.113.7" {
+		t.Errorf("Неверный публичный IP: %s", dialer.EgressIP())
+	}
+
+	// Адреса, разрешенные resolver, проверяются политикой
+	policy, err := acl.NewPolicy(&config.DestinationPolicyConfig{BlockPrivate: true})
+	if err != nil {
+		t.Fatalf("Ошибка создания политики: %v", err)
+	}
+	dialer = NewDialer(policy)
+	dialer.SetResolver(resolver)
+	if _, err := dialer.Dial(context.Background(), net.JoinHostPort("target.test", port)); !errors.Is(err, connerr.ErrNotAllowed) {
+		t.Errorf("Ожидалась ErrNotAllowed, получено %v", err)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net"
 	"time"
 
 	"example.com/me/myproxy/internal/logger"
@@ -16,11 +15,15 @@ import (
 type StreamHandler struct {
 	// Callback для обработки stream (будет установлен извне)
 	onStream func(connID string, stream *quic.Stream) error
+	// Подключение к адресам назначения
+	dialer *Dialer
 }
 
 // NewStreamHandler создает новый stream handler
-func NewStreamHandler() *StreamHandler {
-	return &StreamHandler{}
+func NewStreamHandler(dialer *Dialer) *StreamHandler {
+	return &StreamHandler{
+		dialer: dialer,
+	}
 }
 
 // SetCallback устанавливает callback для обработки stream
@@ -48,30 +51,39 @@ func (h *StreamHandler) HandleStream(ctx context.Context, stream *quic.Stream) {
 
 	// Проксируем TCP трафик
 	// Теперь stream готов для чтения данных от POP (HTTP запрос уже в stream)
-	if err := ProxyTCP(stream, targetAddress); err != nil {
+	if err := ProxyTCP(stream, targetAddress, h.dialer); err != nil {
 		logger.Error("device", "Error proxying TCP for stream %s: %v", connID, err)
 	}
 }
 
 // ProxyTCP проксирует TCP трафик через QUIC stream
-func ProxyTCP(stream *quic.Stream, targetAddress string) error {
-	// Подключаемся к целевому адресу с таймаутом
-	dialer := &net.Dialer{
-		Timeout: 10 * time.Second, // Таймаут подключения
-	}
-	targetConn, err := dialer.Dial("tcp", targetAddress)
+// Результат подключения к targetAddress (с фактическим адресом назначения и публичным IP device)
+// сообщается POP через stream до начала пересылки данных
+// Подключение прерывается, если POP перестал ждать результат (отменил чтение stream)
+// или соединение с POP закрыто; таймаут подключения задает Dialer
+func ProxyTCP(stream *quic.Stream, targetAddress string, dialer *Dialer) error {
+	targetConn, err := dialer.Dial(stream.Context(), targetAddress)
 	if err != nil {
+		quicproto.WriteDialResult(stream, err)
 		return fmt.Errorf("failed to connect to %s: %w", targetAddress, err)
 	}
 	defer targetConn.Close()
 
+	result := quicproto.DialResult{
+		ResolvedAddr: targetConn.RemoteAddr().String(),
+		EgressIP:     dialer.EgressIP(),
+	}
+	if err := quicproto.WriteDialSuccess(stream, result); err != nil {
+		return err
+	}
+
 	// Устанавливаем таймаут только для TCP соединения (не для QUIC stream)
 	// QUIC stream управляется QUIC протоколом, не нужно устанавливать deadline
 	deadline := time.Now().Add(5 * time.Minute)
 	targetConn.SetDeadline(deadline)
 	// НЕ устанавливаем deadline на stream - QUIC сам управляет таймаутами
 
-	logger.Debug("device", "Proxying TCP traffic: stream -> %s", targetAddress)
+	logger.Debug("device", "Proxying TCP traffic: stream -> %s (%s)", targetAddress, result.ResolvedAddr)
 
 	// Пересылаем данные между stream и target connection
 	done := make(chan error, 2)
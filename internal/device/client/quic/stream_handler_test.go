@@ -0,0 +1,100 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/dns"
+	quicproto "example.com/me/myproxy/internal/protocol/quic"
+	tlsconfig "example.com/me/myproxy/internal/tls"
+	"github.com/quic-go/quic-go"
+)
+
+// hangingUpstream DNS сервер, который не отвечает до отмены запроса
+type hangingUpstream struct {
+	started chan struct{}
+}
+
+func (u *hangingUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	select {
+	case u.started <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (u *hangingUpstream) String() string {
+	return "hanging"
+}
+
+func TestProxyTCP_CanceledByPOP(t *testing.T) {
+	serverTLS, err := tlsconfig.NewTLSConfigForQUIC(nil, []string{"test"})
+	if err != nil {
+		t.Fatalf("NewTLSConfigForQUIC: %v", err)
+	}
+	listener, err := quic.ListenAddr("127.0.0.1:0", serverTLS, nil)
+	if err != nil {
+		t.Fatalf("ListenAddr: %v", err)
+	}
+	defer listener.Close()
+
+	// Подключение device зависает на разрешении домена
+	upstream := &hangingUpstream{started: make(chan struct{}, 1)}
+	dialer := NewDialer(nil)
+	dialer.SetResolver(dns.NewResolver([]dns.Upstream{upstream}))
+	result := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept(context.Background())
+		if err != nil {
+			result <- err
+			return
+		}
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			result <- err
+			return
+		}
+		targetAddress, err := quicproto.ReadTargetAddress(stream)
+		if err != nil {
+			result <- err
+			return
+		}
+		result <- ProxyTCP(stream, targetAddress, dialer)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test"}}, nil)
+	if err != nil {
+		t.Fatalf("DialAddr: %v", err)
+	}
+	defer conn.CloseWithError(0, "")
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+	if err := quicproto.WriteTargetAddress(stream, net.JoinHostPort("slow.test", "80")); err != nil {
+		t.Fatalf("WriteTargetAddress: %v", err)
+	}
+	select {
+	case <-upstream.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Device не начал подключение")
+	}
+
+	// POP перестал ждать результат: подключение прерывается, не дожидаясь таймаутов DNS и Dialer
+	stream.CancelRead(0)
+	stream.Close()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("Ожидалась ошибка подключения")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Подключение не прервано отменой stream")
+	}
+}
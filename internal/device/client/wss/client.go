@@ -67,6 +67,8 @@ func (c *Client) Register(ctx context.Context, location string, tags []string) (
 		DeviceId: c.deviceID,
 		Location: location,
 		Tags:     tags,
+		// Device отвечает на target address результатом подключения (internal/device/client/quic)
+		Capabilities: []string{constants.CapabilityDialResult},
 	}
 
 	logger.Debug("device", "Sending RegisterRequest: device_id=%s, location=%s, tags=%v", c.deviceID, location, tags)
@@ -105,11 +107,12 @@ func (c *Client) Register(ctx context.Context, location string, tags []string) (
 	return registerResp, nil
 }
 
-// SendHeartbeat отправляет heartbeat
-func (c *Client) SendHeartbeat(ctx context.Context) error {
+// SendHeartbeat отправляет heartbeat с публичным IP device (пусто - неизвестен)
+func (c *Client) SendHeartbeat(ctx context.Context, egressIP string) error {
 	req := &pb.HeartbeatRequest{
 		DeviceId:  c.deviceID,
 		Timestamp: time.Now().Unix(),
+		EgressIp:  egressIP,
 	}
 
 	logger.Debug("device", "Sending HeartbeatRequest: device_id=%s, timestamp=%d", c.deviceID, req.Timestamp)
@@ -118,8 +121,16 @@ func (c *Client) SendHeartbeat(ctx context.Context) error {
 		return fmt.Errorf("failed to send heartbeat: %w", err)
 	}
 
-	// Читаем ответ
+	// Читаем ответ (DrainNotice может прийти раньше ответа)
 	resp, err := c.readMessage(ctx)
+	for err == nil {
+		notice, ok := resp.(*pb.DrainNotice)
+		if !ok {
+			break
+		}
+		c.handler.HandleDrainNotice(notice)
+		resp, err = c.readMessage(ctx)
+	}
 	if err != nil {
 		logger.Error("device", "Failed to read heartbeat response: %v", err)
 		return fmt.Errorf("failed to read heartbeat response: %w", err)
@@ -206,6 +217,11 @@ func (c *Client) HandleMessages(ctx context.Context) error {
 			continue
 		}
 
+		if notice, ok := msg.(*pb.DrainNotice); ok {
+			c.handler.HandleDrainNotice(notice)
+			continue
+		}
+
 		// Обрабатываем только команды
 		if cmd, ok := msg.(*pb.Command); ok {
 			logger.Debug("device", "Processing command: conn_id=%s", cmd.ConnId)
@@ -15,6 +15,7 @@ type Handler struct {
 	onOpenTCP func(connID, targetAddress string) error
 	onOpenUDP func(connID, targetAddress string) error
 	onClose   func(connID string) error
+	onDrain   func(notice *pb.DrainNotice)
 }
 
 // NewHandler создает новый handler
@@ -35,6 +36,19 @@ func (h *Handler) SetCallbacks(
 	h.onClose = onClose
 }
 
+// SetDrainCallback устанавливает callback для уведомления о draining POP
+func (h *Handler) SetDrainCallback(onDrain func(notice *pb.DrainNotice)) {
+	h.onDrain = onDrain
+}
+
+// HandleDrainNotice обрабатывает уведомление о завершении работы POP
+func (h *Handler) HandleDrainNotice(notice *pb.DrainNotice) {
+	logger.Debug("device", "Received drain notice: deadline=%d, reason=%s", notice.Deadline, notice.Reason)
+	if h.onDrain != nil {
+		h.onDrain(notice)
+	}
+}
+
 // HandleCommand обрабатывает команду от POP
 func (h *Handler) HandleCommand(ctx context.Context, cmd *pb.Command) error {
 	logger.Debug("device", "Received command: conn_id=%s", cmd.ConnId)
@@ -14,6 +14,10 @@ type DeviceStatus int
 const (
 	StatusOffline DeviceStatus = iota
 	StatusOnline
+	// StatusDraining активные соединения продолжаются, новые через устройство не направляются
+	StatusDraining
+	// StatusQuarantined устройство исключено из выбора, но остается подключенным для диагностики
+	StatusQuarantined
 )
 
 // Device представляет зарегистрированное устройство
@@ -26,6 +30,9 @@ type Device struct {
 	// Адрес из соединения (защита от подмены)
 	RemoteAddr string
 
+	// Публичный IP выхода в интернет, сообщенный устройством (пусто - неизвестен)
+	EgressIP string
+
 	// WSS control connection
 	WSSConn *websocket.Conn
 
@@ -35,12 +42,18 @@ type Device struct {
 	// Активные QUIC streams (conn_id → stream)
 	Streams map[string]*quic.Stream
 
-	// Статус
+	// Статус соединения (StatusOnline/StatusOffline)
+	// StatusDraining/StatusQuarantined задаются через Hold, итоговый статус - CurrentStatus
 	Status DeviceStatus
+	Hold   *StatusHold
+
+	// Исходы подключений для оценки здоровья (HealthScorer)
+	health healthStats
 
 	// Временные метки
 	LastHeartbeat time.Time
 	RegisteredAt  time.Time
+	FirstSeen     time.Time // Первая регистрация (сохраняется между перезапусками POP)
 
 	// Метрики
 	ActiveConns   int
@@ -51,6 +64,9 @@ type Device struct {
 	Location string
 	Capacity int
 	Tags     []string
+
+	// Расширения протокола, поддерживаемые устройством (constants.Capability*)
+	Capabilities []string
 }
 
 // NewDevice создает новое устройство
@@ -61,26 +77,77 @@ func NewDevice(id, remoteAddr string, metadata map[string]interface{}) *Device {
 		Status:     StatusOnline,
 		RegisteredAt: time.Now(),
 		LastHeartbeat: time.Now(),
+		FirstSeen:   time.Now(),
 		Streams:     make(map[string]*quic.Stream),
 	}
 
-	// Извлечь метаданные
+	d.applyMetadata(metadata)
+	return d
+}
+
+// applyMetadata извлекает метаданные регистрации
+// Значения приходят как из JSON (float64, []interface{}), так и из protobuf (int, []string)
+func (d *Device) applyMetadata(metadata map[string]interface{}) {
 	if location, ok := metadata["location"].(string); ok {
 		d.Location = location
 	}
-	if capacity, ok := metadata["capacity"].(float64); ok {
+	switch capacity := metadata["capacity"].(type) {
+	case float64:
 		d.Capacity = int(capacity)
+	case int:
+		d.Capacity = capacity
 	}
-	if tags, ok := metadata["tags"].([]interface{}); ok {
-		d.Tags = make([]string, 0, len(tags))
-		for _, tag := range tags {
-			if tagStr, ok := tag.(string); ok {
-				d.Tags = append(d.Tags, tagStr)
+	d.Tags = stringList(metadata["tags"])
+	d.Capabilities = stringList(metadata["capabilities"])
+}
+
+// stringList извлекает список строк из значения метаданных
+func stringList(value interface{}) []string {
+	switch list := value.(type) {
+	case []string:
+		return append([]string(nil), list...)
+	case []interface{}:
+		result := make([]string, 0, len(list))
+		for _, item := range list {
+			if itemStr, ok := item.(string); ok {
+				result = append(result, itemStr)
 			}
 		}
+		return result
 	}
+	return nil
+}
 
-	return d
+// UpdateRegistration обновляет адрес и метаданные при повторной регистрации устройства
+func (d *Device) UpdateRegistration(remoteAddr string, metadata map[string]interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.RemoteAddr = remoteAddr
+	d.RegisteredAt = time.Now()
+	if d.FirstSeen.IsZero() {
+		d.FirstSeen = d.RegisteredAt
+	}
+	d.Location, d.Capacity, d.Tags, d.Capabilities = "", 0, nil, nil
+	d.applyMetadata(metadata)
+}
+
+// Record возвращает сохраняемое состояние устройства
+func (d *Device) Record() *Record {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return &Record{
+		ID:            d.ID,
+		RemoteAddr:    d.RemoteAddr,
+		EgressIP:      d.EgressIP,
+		Location:      d.Location,
+		Capacity:      d.Capacity,
+		Tags:          append([]string(nil), d.Tags...),
+		FirstSeen:     d.FirstSeen,
+		LastSeen:      d.LastHeartbeat,
+		BytesSent:     d.BytesSent,
+		BytesReceived: d.BytesReceived,
+		Hold:          d.activeHoldLocked(),
+	}
 }
 
 // SetWSSConn устанавливает WSS connection
@@ -132,6 +199,39 @@ func (d *Device) RemoveStream(connID string) {
 	delete(d.Streams, connID)
 }
 
+// StreamCount возвращает количество активных QUIC streams (нагрузка устройства)
+func (d *Device) StreamCount() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.Streams)
+}
+
+// HasCapability проверяет, сообщило ли устройство при регистрации поддержку расширения протокола
+func (d *Device) HasCapability(capability string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, c := range d.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// SetEgressIP сохраняет публичный IP выхода в интернет, сообщенный устройством
+func (d *Device) SetEgressIP(ip string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.EgressIP = ip
+}
+
+// GetEgressIP возвращает публичный IP выхода в интернет (пусто - неизвестен)
+func (d *Device) GetEgressIP() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.EgressIP
+}
+
 // UpdateHeartbeat обновляет время последнего heartbeat
 func (d *Device) UpdateHeartbeat() {
 	d.mu.Lock()
@@ -196,6 +296,7 @@ type DeviceCriteria struct {
 	Status   DeviceStatus
 	Tags     []string
 	Location string
+	Exclude  []string // Идентификаторы устройств, которые не выбираются (например, уже не подключившиеся)
 	// Для будущего расширения:
 	// MinCapacity int
 	// MaxLatency  time.Duration
@@ -214,6 +315,31 @@ func (c *DeviceCriteria) WithTags(tags ...string) *DeviceCriteria {
 	return c
 }
 
+// Match проверяет идентификатор, локацию и теги устройства (статус не учитывается)
+func (c *DeviceCriteria) Match(id, location string, tags []string) bool {
+	if c.Location != "" && location != c.Location {
+		return false
+	}
+	for _, excluded := range c.Exclude {
+		if id == excluded {
+			return false
+		}
+	}
+	for _, requiredTag := range c.Tags {
+		found := false
+		for _, tag := range tags {
+			if tag == requiredTag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 // WithLocation добавляет локацию к критериям
 func (c *DeviceCriteria) WithLocation(location string) *DeviceCriteria {
 	c.Location = location
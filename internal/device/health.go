@@ -0,0 +1,235 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"example.com/me/myproxy/internal/logger"
+)
+
+// maxHealthSamples ограничивает число хранимых исходов подключений и соединений устройства
+const maxHealthSamples = 200
+
+// healthLatencyReference время подключения, выше которого оценка снижается
+const healthLatencyReference = time.Second
+
+// dialSample исход подключения через устройство
+type dialSample struct {
+	at      time.Time
+	ok      bool
+	latency time.Duration
+}
+
+// transferSample объем данных, полученных через устройство за соединение
+type transferSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// healthStats исходы последних подключений и соединений через устройство
+type healthStats struct {
+	dials     []dialSample
+	transfers []transferSample
+	probedAt  time.Time // Последняя повторная проверка после карантина
+}
+
+// RecordDial учитывает исход подключения через устройство и время до ответа устройства
+func (d *Device) RecordDial(ok bool, latency time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.health.dials = append(d.health.dials, dialSample{at: time.Now(), ok: ok, latency: latency})
+	if len(d.health.dials) > maxHealthSamples {
+		d.health.dials = d.health.dials[len(d.health.dials)-maxHealthSamples:]
+	}
+}
+
+// RecordTransfer учитывает объем данных, полученных через устройство за закрытое соединение
+func (d *Device) RecordTransfer(received int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.health.transfers = append(d.health.transfers, transferSample{at: time.Now(), bytes: received})
+	if len(d.health.transfers) > maxHealthSamples {
+		d.health.transfers = d.health.transfers[len(d.health.transfers)-maxHealthSamples:]
+	}
+}
+
+// ResetHealth очищает статистику устройства (устройство снова считается здоровым)
+func (d *Device) ResetHealth() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.health.dials = nil
+	d.health.transfers = nil
+}
+
+// HealthScore оценка здоровья устройства по подключениям в скользящем окне
+type HealthScore struct {
+	// Score от 0 до 1: доля успешных подключений, сниженная за медленные подключения
+	// и соединения без полученных данных. При недостатке данных - 1
+	Score        float64 `json:"score"`
+	Dials        int     `json:"dials"`
+	SuccessRate  float64 `json:"success_rate"`
+	LatencyMs    int64   `json:"latency_ms"` // Среднее время успешного подключения
+	Connections  int     `json:"connections"`
+	BytesPerConn int64   `json:"bytes_per_conn"`
+	EmptyRate    float64 `json:"empty_rate"` // Доля соединений без полученных данных
+}
+
+// HealthScorer вычисляет оценку здоровья устройств
+type HealthScorer struct {
+	Window   time.Duration // Окно статистики
+	MinDials int           // Минимум подключений (соединений) в окне для снижения оценки
+}
+
+// Score вычисляет оценку здоровья устройства
+func (s HealthScorer) Score(d *Device) *HealthScore {
+	since := time.Now().Add(-s.Window)
+	score := &HealthScore{Score: 1}
+
+	d.mu.RLock()
+	var successes int
+	var latency time.Duration
+	for _, sample := range d.health.dials {
+		if sample.at.Before(since) {
+			continue
+		}
+		score.Dials++
+		if sample.ok {
+			successes++
+			latency += sample.latency
+		}
+	}
+	var bytes int64
+	var empty int
+	for _, sample := range d.health.transfers {
+		if sample.at.Before(since) {
+			continue
+		}
+		score.Connections++
+		bytes += sample.bytes
+		if sample.bytes == 0 {
+			empty++
+		}
+	}
+	d.mu.RUnlock()
+
+	if score.Dials > 0 {
+		score.SuccessRate = roundScore(float64(successes) / float64(score.Dials))
+	}
+	if successes > 0 {
+		score.LatencyMs = (latency / time.Duration(successes)).Milliseconds()
+	}
+	if score.Connections > 0 {
+		score.BytesPerConn = bytes / int64(score.Connections)
+		score.EmptyRate = roundScore(float64(empty) / float64(score.Connections))
+	}
+
+	if score.Dials >= s.MinDials {
+		score.Score = score.SuccessRate
+		// Медленные подключения снижают оценку не более чем в 4 раза
+		if average := time.Duration(score.LatencyMs) * time.Millisecond; average > healthLatencyReference {
+			score.Score *= math.Max(0.25, float64(healthLatencyReference)/float64(average))
+		}
+	}
+	// Соединения без данных: captive portal или заблокированный адрес устройства принимают подключение, но не отвечают
+	if score.Connections >= s.MinDials {
+		score.Score *= 1 - score.EmptyRate/2
+	}
+	score.Score = roundScore(score.Score)
+	return score
+}
+
+// roundScore округляет долю до сотых
+func roundScore(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// Prober проверяет устройство после карантина, например подключением к известному адресу
+type Prober func(device *Device) error
+
+// ScoreCheck помещает в карантин устройство с оценкой здоровья ниже порога
+// После cooldown устройство проверяется prober (без prober - сразу возвращается в выбор)
+// и при успешной проверке возвращается в выбор с чистой статистикой
+type ScoreCheck struct {
+	scorer   HealthScorer
+	minScore float64
+	cooldown time.Duration
+	probe    Prober
+}
+
+// NewScoreCheck создает проверку оценки здоровья; probe может быть nil
+func NewScoreCheck(scorer HealthScorer, minScore float64, cooldown time.Duration, probe Prober) *ScoreCheck {
+	return &ScoreCheck{
+		scorer:   scorer,
+		minScore: minScore,
+		cooldown: cooldown,
+		probe:    probe,
+	}
+}
+
+// Name возвращает имя проверки
+func (c *ScoreCheck) Name() string {
+	return "score"
+}
+
+// Check проверяет оценку здоровья, а для устройства в карантине - выполняет повторную проверку после cooldown
+func (c *ScoreCheck) Check(device *Device) (DeviceStatus, string) {
+	if hold := device.GetHold(); hold != nil && hold.Source == HoldSourceHealth+c.Name() {
+		return c.recheck(device, hold)
+	}
+
+	score := c.scorer.Score(device)
+	if score.Score < c.minScore {
+		return StatusQuarantined, fmt.Sprintf("health score %.2f: %.0f%% of %d dials succeeded, %.0f%% of connections empty",
+			score.Score, score.SuccessRate*100, score.Dials, score.EmptyRate*100)
+	}
+	return StatusOnline, ""
+}
+
+// recheck повторно проверяет устройство в карантине, если с карантина или прошлой проверки прошло cooldown
+func (c *ScoreCheck) recheck(device *Device, hold *StatusHold) (DeviceStatus, string) {
+	device.mu.Lock()
+	last := hold.Since
+	if device.health.probedAt.After(last) {
+		last = device.health.probedAt
+	}
+	if time.Since(last) < c.cooldown {
+		device.mu.Unlock()
+		return StatusQuarantined, hold.Reason
+	}
+	device.health.probedAt = time.Now()
+	device.mu.Unlock()
+
+	if c.probe != nil {
+		if err := c.probe(device); err != nil {
+			logger.Debug("device", "Device %s probe failed: %v", device.ID, err)
+			return StatusQuarantined, fmt.Sprintf("probe failed: %v", err)
+		}
+	}
+	device.ResetHealth()
+	return StatusOnline, ""
+}
+
+// SetHealthScorer задает параметры оценки здоровья устройств
+func (r *Registry) SetHealthScorer(scorer HealthScorer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scorer = scorer
+}
+
+// DeviceHealth возвращает оценку здоровья устройства
+func (r *Registry) DeviceHealth(device *Device) *HealthScore {
+	r.mu.RLock()
+	scorer := r.scorer
+	r.mu.RUnlock()
+	return scorer.Score(device)
+}
+
+// HealthScores возвращает оценки здоровья всех устройств (device ID → оценка)
+func (r *Registry) HealthScores() map[string]*HealthScore {
+	scores := make(map[string]*HealthScore)
+	for _, device := range r.ListDevices() {
+		scores[device.ID] = r.DeviceHealth(device)
+	}
+	return scores
+}
@@ -0,0 +1,100 @@
+package device
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHealthScorer_Score(t *testing.T) {
+	scorer := HealthScorer{Window: time.Minute, MinDials: 10}
+	device := NewDevice("d1", "10.0.0.1:5000", nil)
+
+	// Недостаточно данных: устройство считается здоровым
+	for i := 0; i < 5; i++ {
+		device.RecordDial(false, 0)
+	}
+	if score := scorer.Score(device); score.Score != 1 || score.Dials != 5 || score.SuccessRate != 0 {
+		t.Errorf("При недостатке данных ожидалась оценка 1: %+v", score)
+	}
+
+	// 50% успешных подключений
+	for i := 0; i < 5; i++ {
+		device.RecordDial(true, 100*time.Millisecond)
+	}
+	score := scorer.Score(device)
+	if score.Score != 0.5 || score.SuccessRate != 0.5 || score.LatencyMs != 100 {
+		t.Errorf("Ожидалась оценка 0.5: %+v", score)
+	}
+
+	// Медленные подключения и соединения без данных снижают оценку
+	device.ResetHealth()
+	for i := 0; i < 10; i++ {
+		device.RecordDial(true, 2*time.Second)
+		device.RecordTransfer(int64(i % 2 * 1000))
+	}
+	score = scorer.Score(device)
+	if score.Score != 0.38 || score.EmptyRate != 0.5 || score.BytesPerConn != 500 {
+		t.Errorf("Ожидалась оценка 0.38 (0.5 за задержку, 0.75 за пустые соединения): %+v", score)
+	}
+
+	// Исходы за пределами окна не учитываются
+	device.mu.Lock()
+	for i := range device.health.dials {
+		device.health.dials[i].at = time.Now().Add(-2 * time.Minute)
+	}
+	device.mu.Unlock()
+	if score := scorer.Score(device); score.Dials != 0 {
+		t.Errorf("Устаревшие подключения не должны учитываться: %+v", score)
+	}
+}
+
+func TestScoreCheck_QuarantineAndProbe(t *testing.T) {
+	r := NewRegistry(30, 90)
+	t.Cleanup(func() { r.Close() }) // Выполняется после удаления заглушек соединений
+	d1 := connectDevice(t, r, "d1")
+
+	probeErr := errors.New("probe timeout")
+	probes := 0
+	scorer := HealthScorer{Window: time.Minute, MinDials: 10}
+	check := NewScoreCheck(scorer, 0.3, time.Hour, func(device *Device) error {
+		probes++
+		return probeErr
+	})
+	r.AddHealthCheck(check)
+
+	for i := 0; i < 10; i++ {
+		d1.RecordDial(i == 0, 0)
+	}
+	r.runHealthChecks()
+	hold := d1.GetHold()
+	if hold == nil || hold.Status != StatusQuarantined || hold.Source != "health:score" {
+		t.Fatalf("Устройство с низкой оценкой должно быть в карантине: %+v", hold)
+	}
+
+	// До окончания cooldown устройство не проверяется
+	r.runHealthChecks()
+	if probes != 0 || d1.IsAvailable() {
+		t.Fatalf("Проверка до cooldown: probes=%d, статус %s", probes, d1.CurrentStatus())
+	}
+
+	// После cooldown неудачная проверка продлевает карантин
+	check.cooldown = 0
+	r.runHealthChecks()
+	if hold := d1.GetHold(); probes != 1 || hold == nil || hold.Reason != "probe failed: probe timeout" {
+		t.Fatalf("Неудачная проверка должна продлить карантин: probes=%d, %+v", probes, hold)
+	}
+
+	// Успешная проверка возвращает устройство в выбор с чистой статистикой
+	probeErr = nil
+	r.runHealthChecks()
+	if !d1.IsAvailable() {
+		t.Fatalf("Устройство должно вернуться в выбор, статус %s", d1.CurrentStatus())
+	}
+	if score := r.DeviceHealth(d1); score.Dials != 0 || score.Score != 1 {
+		t.Errorf("Статистика должна быть очищена: %+v", score)
+	}
+	if scores := r.HealthScores(); scores["d1"] == nil {
+		t.Error("Оценка устройства должна быть доступна через registry")
+	}
+}
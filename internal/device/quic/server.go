@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -14,6 +15,7 @@ import (
 	"example.com/me/myproxy/internal/device"
 	"example.com/me/myproxy/internal/logger"
 	tlsconfig "example.com/me/myproxy/internal/tls"
+	"example.com/me/myproxy/internal/upgrade"
 	"github.com/quic-go/quic-go"
 )
 
@@ -23,6 +25,9 @@ type Server struct {
 	port      int
 	tlsConfig *tls.Config
 	listener  *quic.Listener
+	transport *quic.Transport
+	udpConn   net.PacketConn
+	upgrader  *upgrade.Upgrader
 }
 
 // NewServer создает новый QUIC server
@@ -34,18 +39,21 @@ func NewServer(registry *device.Registry, port int, tlsConfig *tls.Config) *Serv
 	}
 }
 
+// SetUpgrader задает Upgrader для создания UDP сокета (передача сокета при обновлении бинарника)
+func (s *Server) SetUpgrader(upgrader *upgrade.Upgrader) {
+	s.upgrader = upgrader
+}
+
 // Start запускает QUIC server
 func (s *Server) Start() error {
 	addr := fmt.Sprintf(":%d", s.port)
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
-	if err != nil {
-		return fmt.Errorf("failed to resolve UDP address: %w", err)
-	}
-
-	udpConn, err := net.ListenUDP("udp", udpAddr)
+	// Отдельный Transport позволяет закрыть listener (прекратить прием новых соединений),
+	// не разрывая уже установленные соединения устройств
+	transport, err := s.upgrader.ListenQUIC("udp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to listen UDP: %w", err)
 	}
+	udpConn := transport.Conn
 
 	config := &quic.Config{
 		// Настройки QUIC
@@ -67,11 +75,15 @@ func (s *Server) Start() error {
 		logger.Debug("device", "Using self-signed certificate for QUIC (testing only)")
 	}
 
-	listener, err := quic.Listen(udpConn, tlsConf, config)
+	listener, err := transport.Listen(tlsConf, config)
 	if err != nil {
+		transport.Close()
+		udpConn.Close()
 		return fmt.Errorf("failed to create QUIC listener: %w", err)
 	}
 
+	s.udpConn = udpConn
+	s.transport = transport
 	s.listener = listener
 	logger.Info("device", "QUIC data-plane server starting on port %d", s.port)
 
@@ -86,6 +98,9 @@ func (s *Server) acceptLoop() {
 	for {
 		conn, err := s.listener.Accept(context.Background())
 		if err != nil {
+			if errors.Is(err, quic.ErrServerClosed) {
+				return
+			}
 			logger.Error("device", "Failed to accept QUIC connection: %v", err)
 			continue
 		}
@@ -201,12 +216,27 @@ func (s *Server) handleStreams(conn *quic.Conn, deviceID string) {
 	}
 }
 
-// Stop останавливает QUIC server
-func (s *Server) Stop() error {
+// StopAccepting прекращает прием новых QUIC соединений, установленные продолжают работать
+func (s *Server) StopAccepting() error {
 	if s.listener != nil {
 		return s.listener.Close()
 	}
 	return nil
 }
 
+// Stop останавливает QUIC server и закрывает все соединения
+func (s *Server) Stop() error {
+	if err := s.StopAccepting(); err != nil {
+		logger.Debug("device", "Error closing QUIC listener: %v", err)
+	}
+	if s.transport != nil {
+		if err := s.transport.Close(); err != nil {
+			return err
+		}
+		// Transport не закрывает переданный ему сокет
+		return s.udpConn.Close()
+	}
+	return nil
+}
+
 
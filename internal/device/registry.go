@@ -17,8 +17,22 @@ type Registry struct {
 	heartbeatTimeout  time.Duration
 	heartbeatInterval time.Duration
 	stopChan          chan struct{}
+
+	// Хранилище состояния устройств (nil - только в памяти)
+	store   RegistryStore
+	saved   map[string]*Record // Последние сохраненные записи (для сохранения только изменений)
+	flushMu sync.Mutex         // Сериализует сохранения
+
+	healthChecks []HealthCheck
+	scorer       HealthScorer
 }
 
+// defaultHealthScorer параметры оценки здоровья устройств по умолчанию
+var defaultHealthScorer = HealthScorer{Window: 5 * time.Minute, MinDials: 10}
+
+// healthCheckInterval интервал проверки heartbeat timeout и проверок здоровья устройств
+const healthCheckInterval = 10 * time.Second
+
 // NewRegistry создает новый registry
 func NewRegistry(heartbeatInterval, heartbeatTimeout int) *Registry {
 	r := &Registry{
@@ -26,6 +40,8 @@ func NewRegistry(heartbeatInterval, heartbeatTimeout int) *Registry {
 		heartbeatInterval: time.Duration(heartbeatInterval) * time.Second,
 		heartbeatTimeout:  time.Duration(heartbeatTimeout) * time.Second,
 		stopChan:          make(chan struct{}),
+		saved:             make(map[string]*Record),
+		scorer:            defaultHealthScorer,
 	}
 
 	// Запускаем фоновую проверку heartbeat timeout
@@ -61,6 +77,9 @@ func (r *Registry) RegisterWithWSS(deviceID, remoteAddr string, metadata map[str
 		// Создаем новое устройство
 		device = NewDevice(deviceID, remoteAddr, metadata)
 		r.devices[deviceID] = device
+	} else {
+		// Повторная регистрация (в том числе устройства, восстановленного из хранилища)
+		device.UpdateRegistration(remoteAddr, metadata)
 	}
 
 	// Устанавливаем WSS connection
@@ -117,30 +136,9 @@ func (r *Registry) FindDevice(criteria *DeviceCriteria) (*Device, error) {
 	// Получаем список доступных устройств
 	availableDevices := make([]*Device, 0)
 	for _, device := range r.devices {
-		if device.Status == criteria.Status && device.IsOnline() {
-			// Проверка тегов
-			if len(criteria.Tags) > 0 {
-				hasAllTags := true
-				for _, requiredTag := range criteria.Tags {
-					found := false
-					for _, deviceTag := range device.Tags {
-						if deviceTag == requiredTag {
-							found = true
-							break
-						}
-					}
-					if !found {
-						hasAllTags = false
-						break
-					}
-				}
-				if !hasAllTags {
-					continue
-				}
-			}
-
-			// Проверка локации
-			if criteria.Location != "" && device.Location != criteria.Location {
+		if device.CurrentStatus() == criteria.Status {
+			// Проверка тегов и локации
+			if !criteria.Match(device.ID, device.Location, device.Tags) {
 				continue
 			}
 
@@ -195,6 +193,18 @@ func (r *Registry) GetDeviceCount() int {
 	return count
 }
 
+// ListDevices возвращает все зарегистрированные устройства (включая offline)
+func (r *Registry) ListDevices() []*Device {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	devices := make([]*Device, 0, len(r.devices))
+	for _, device := range r.devices {
+		devices = append(devices, device)
+	}
+	return devices
+}
+
 // GetAvailableDevices возвращает список доступных устройств по критериям
 func (r *Registry) GetAvailableDevices(criteria *DeviceCriteria) []*Device {
 	r.mu.RLock()
@@ -202,30 +212,10 @@ func (r *Registry) GetAvailableDevices(criteria *DeviceCriteria) []*Device {
 
 	availableDevices := make([]*Device, 0)
 	for _, device := range r.devices {
-		if device.Status == StatusOnline && device.IsOnline() {
-			// Проверка тегов
-			if len(criteria.Tags) > 0 {
-				hasAllTags := true
-				for _, requiredTag := range criteria.Tags {
-					found := false
-					for _, deviceTag := range device.Tags {
-						if deviceTag == requiredTag {
-							found = true
-							break
-						}
-					}
-					if !found {
-						hasAllTags = false
-						break
-					}
-				}
-				if !hasAllTags {
-					continue
-				}
-			}
-
-			// Проверка локации
-			if criteria.Location != "" && device.Location != criteria.Location {
+		// Draining и quarantined устройства не выбираются для новых соединений
+		if device.IsAvailable() {
+			// Проверка тегов и локации
+			if !criteria.Match(device.ID, device.Location, device.Tags) {
 				continue
 			}
 
@@ -238,13 +228,14 @@ func (r *Registry) GetAvailableDevices(criteria *DeviceCriteria) []*Device {
 
 // checkHeartbeatLoop проверяет heartbeat timeout в фоне
 func (r *Registry) checkHeartbeatLoop() {
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(healthCheckInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
 			r.checkHeartbeatTimeout()
+			r.runHealthChecks()
 		case <-r.stopChan:
 			return
 		}
@@ -268,16 +259,27 @@ func (r *Registry) checkHeartbeatTimeout() {
 }
 
 // Close закрывает registry и все соединения
+// Состояние устройств сохраняется в хранилище перед его закрытием
 func (r *Registry) Close() error {
 	close(r.stopChan)
 
 	r.mu.Lock()
-	defer r.mu.Unlock()
-
 	for id, device := range r.devices {
 		device.MarkOffline()
 		logger.Debug("device", "Device %s closed", id)
 	}
+	store := r.store
+	r.mu.Unlock()
 
+	if store == nil {
+		return nil
+	}
+	flushErr := r.Flush()
+	if err := store.Close(); err != nil && flushErr == nil {
+		flushErr = err
+	}
+	if flushErr != nil {
+		return fmt.Errorf("failed to save device registry: %w", flushErr)
+	}
 	return nil
 }
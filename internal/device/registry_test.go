@@ -0,0 +1,142 @@
+package device
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/constants"
+)
+
+// memoryStore хранилище в памяти, запоминающее сохраненные пакеты
+type memoryStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+	saves   [][]string // ID записей в каждом вызове Save
+	closed  bool
+}
+
+func newMemoryStore(records ...*Record) *memoryStore {
+	s := &memoryStore{records: make(map[string]*Record)}
+	for _, record := range records {
+		s.records[record.ID] = record
+	}
+	return s
+}
+
+func (s *memoryStore) Load() ([]*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]*Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *memoryStore) Save(records ...*Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, record := range records {
+		s.records[record.ID] = record
+		ids = append(ids, record.ID)
+	}
+	s.saves = append(s.saves, ids)
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestRegistry_Store(t *testing.T) {
+	firstSeen := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	store := newMemoryStore(&Record{
+		ID:            "d1",
+		Location:      "old",
+		Tags:          []string{"old"},
+		FirstSeen:     firstSeen,
+		LastSeen:      firstSeen,
+		BytesSent:     100,
+		BytesReceived: 200,
+	})
+
+	r := NewRegistry(30, 90)
+	if err := r.SetStore(store, time.Hour); err != nil {
+		t.Fatalf("SetStore: %v", err)
+	}
+
+	// Восстановленное устройство offline и не выбирается для соединений
+	restored, err := r.GetDevice("d1")
+	if err != nil {
+		t.Fatalf("Устройство не восстановлено: %v", err)
+	}
+	if restored.IsOnline() || len(r.GetAvailableDevices(NewDeviceCriteria())) != 0 {
+		t.Error("Восстановленное устройство не должно быть доступно до регистрации")
+	}
+
+	// Без изменений сохранять нечего
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if len(store.saves) != 0 {
+		t.Errorf("Неизмененные устройства не должны сохраняться: %v", store.saves)
+	}
+
+	// Повторная регистрация обновляет метаданные, сохраняя счетчики и время первого появления
+	metadata := map[string]interface{}{"location": "eu", "capacity": 3, "tags": []string{"mobile"},
+		"capabilities": []interface{}{constants.CapabilityDialResult}}
+	device, err := r.RegisterWithWSS("d1", "10.0.0.1:5000", metadata, nil)
+	if err != nil {
+		t.Fatalf("RegisterWithWSS: %v", err)
+	}
+	device.AddBytes(1, 2)
+	if !device.HasCapability(constants.CapabilityDialResult) {
+		t.Error("Поддержка результата подключения не учтена при регистрации")
+	}
+	if _, err := r.RegisterWithWSS("d2", "10.0.0.2:5000", nil, nil); err != nil {
+		t.Fatalf("RegisterWithWSS: %v", err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !store.closed {
+		t.Error("Хранилище должно быть закрыто вместе с registry")
+	}
+
+	saved := store.records["d1"]
+	if saved.Location != "eu" || saved.Capacity != 3 || len(saved.Tags) != 1 || saved.Tags[0] != "mobile" {
+		t.Errorf("Метаданные не обновлены: %+v", saved)
+	}
+	if saved.BytesSent != 101 || saved.BytesReceived != 202 {
+		t.Errorf("Счетчики не сохранены: sent=%d received=%d", saved.BytesSent, saved.BytesReceived)
+	}
+	if !saved.FirstSeen.Equal(firstSeen) {
+		t.Errorf("Время первого появления изменено: %v", saved.FirstSeen)
+	}
+	if _, ok := store.records["d2"]; !ok {
+		t.Error("Новое устройство не сохранено")
+	}
+}
+
+func TestRegistry_ExcludeCriteria(t *testing.T) {
+	r := NewRegistry(30, 90)
+	t.Cleanup(func() { r.Close() }) // Выполняется после удаления заглушек соединений
+	connectDevice(t, r, "d1")
+	connectDevice(t, r, "d2")
+
+	criteria := NewDeviceCriteria()
+	criteria.Exclude = []string{"d1"}
+	available := r.GetAvailableDevices(criteria)
+	if len(available) != 1 || available[0].ID != "d2" {
+		t.Errorf("Исключенное устройство не должно выбираться: %v", available)
+	}
+	if found, err := r.FindDevice(criteria); err != nil || found.ID != "d2" {
+		t.Errorf("FindDevice: ожидалось d2, получено %v, %v", found, err)
+	}
+}
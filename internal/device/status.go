@@ -0,0 +1,259 @@
+package device
+
+import (
+	"fmt"
+	"time"
+
+	"example.com/me/myproxy/internal/logger"
+)
+
+// Источники состояния устройства
+const (
+	// HoldSourceManual состояние задано оператором (API), проверки здоровья его не меняют
+	HoldSourceManual = "manual"
+	// HoldSourceHealth префикс источника для состояний, заданных проверками здоровья ("health:<name>")
+	HoldSourceHealth = "health:"
+)
+
+// String возвращает имя статуса
+func (s DeviceStatus) String() string {
+	switch s {
+	case StatusOffline:
+		return "offline"
+	case StatusOnline:
+		return "online"
+	case StatusDraining:
+		return "draining"
+	case StatusQuarantined:
+		return "quarantined"
+	}
+	return fmt.Sprintf("DeviceStatus(%d)", int(s))
+}
+
+// ParseDeviceStatus возвращает статус по имени
+func ParseDeviceStatus(name string) (DeviceStatus, error) {
+	for _, status := range []DeviceStatus{StatusOffline, StatusOnline, StatusDraining, StatusQuarantined} {
+		if status.String() == name {
+			return status, nil
+		}
+	}
+	return StatusOffline, fmt.Errorf("unknown device status %q", name)
+}
+
+// MarshalText сериализует статус именем (JSON, хранилище)
+func (s DeviceStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText разбирает статус по имени
+func (s *DeviceStatus) UnmarshalText(text []byte) error {
+	status, err := ParseDeviceStatus(string(text))
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
+
+// StatusHold состояние, исключающее подключенное устройство из выбора (StatusDraining или StatusQuarantined)
+// Сохраняется при переподключении устройства и в хранилище реестра
+type StatusHold struct {
+	Status  DeviceStatus `json:"status"`
+	Reason  string       `json:"reason,omitempty"`
+	Source  string       `json:"source"` // HoldSourceManual или HoldSourceHealth + имя проверки
+	Since   time.Time    `json:"since"`
+	Expires time.Time    `json:"expires,omitzero"` // Нулевое значение - бессрочно
+}
+
+// Active проверяет, действует ли состояние в момент now
+func (h *StatusHold) Active(now time.Time) bool {
+	return h != nil && (h.Expires.IsZero() || now.Before(h.Expires))
+}
+
+// activeHoldLocked возвращает копию действующего состояния (вызывается под mu)
+func (d *Device) activeHoldLocked() *StatusHold {
+	if !d.Hold.Active(time.Now()) {
+		return nil
+	}
+	hold := *d.Hold
+	return &hold
+}
+
+// GetHold возвращает действующее состояние draining/quarantined или nil
+func (d *Device) GetHold() *StatusHold {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.activeHoldLocked()
+}
+
+// SetHold задает состояние draining/quarantined (nil - вернуть устройство в выбор)
+func (d *Device) SetHold(hold *StatusHold) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Hold = hold
+}
+
+// compareAndSetHold заменяет состояние, только если оно не изменилось с момента чтения old
+func (d *Device) compareAndSetHold(old, hold *StatusHold) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.Hold != old {
+		return false
+	}
+	d.Hold = hold
+	return true
+}
+
+// CurrentStatus возвращает итоговый статус: offline, если нет соединений,
+// иначе состояние draining/quarantined, если оно действует, иначе online
+func (d *Device) CurrentStatus() DeviceStatus {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.Status != StatusOnline || d.WSSConn == nil || d.QUICConn == nil {
+		return StatusOffline
+	}
+	if hold := d.activeHoldLocked(); hold != nil {
+		return hold.Status
+	}
+	return StatusOnline
+}
+
+// IsAvailable проверяет, можно ли направлять через устройство новые соединения
+func (d *Device) IsAvailable() bool {
+	return d.CurrentStatus() == StatusOnline
+}
+
+// SetStatus задает состояние устройства оператором
+// StatusDraining/StatusQuarantined исключают устройство из выбора на duration (0 - бессрочно),
+// StatusOnline снимает состояние. Состояние, заданное оператором, не меняется проверками здоровья
+func (r *Registry) SetStatus(deviceID string, status DeviceStatus, reason string, duration time.Duration) error {
+	device, err := r.GetDevice(deviceID)
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case StatusOnline:
+		device.SetHold(nil)
+		logger.Info("device", "Device %s returned to selection", deviceID)
+	case StatusDraining, StatusQuarantined:
+		now := time.Now()
+		hold := &StatusHold{Status: status, Reason: reason, Source: HoldSourceManual, Since: now}
+		if duration > 0 {
+			hold.Expires = now.Add(duration)
+		}
+		device.SetHold(hold)
+		logger.Info("device", "Device %s set to %s: %s", deviceID, status, reason)
+	default:
+		return fmt.Errorf("status %s cannot be set", status)
+	}
+	return nil
+}
+
+// HealthCheck проверка устройства, выполняемая registry периодически для подключенных устройств
+type HealthCheck interface {
+	// Name имя проверки (используется в источнике состояния)
+	Name() string
+	// Check возвращает StatusDraining или StatusQuarantined с причиной, если устройство
+	// нужно исключить из выбора, или StatusOnline, если проверка пройдена
+	Check(device *Device) (DeviceStatus, string)
+}
+
+// AddHealthCheck добавляет проверку здоровья устройств
+// Состояние, заданное проверкой, действует до следующей успешной проверки
+// (с запасом healthHoldTTL на случай, если проверки перестанут выполняться)
+func (r *Registry) AddHealthCheck(check HealthCheck) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.healthChecks = append(r.healthChecks, check)
+}
+
+// healthHoldTTL срок состояния, заданного проверкой здоровья (продлевается при каждой проверке)
+const healthHoldTTL = 3 * healthCheckInterval
+
+// runHealthChecks выполняет проверки здоровья и снимает истекшие состояния
+func (r *Registry) runHealthChecks() {
+	r.mu.RLock()
+	checks := append([]HealthCheck(nil), r.healthChecks...)
+	devices := make([]*Device, 0, len(r.devices))
+	for _, device := range r.devices {
+		devices = append(devices, device)
+	}
+	r.mu.RUnlock()
+
+	now := time.Now()
+	for _, device := range devices {
+		device.mu.Lock()
+		if device.Hold != nil && !device.Hold.Active(now) {
+			logger.Info("device", "Device %s %s state expired", device.ID, device.Hold.Status)
+			device.Hold = nil
+		}
+		hold := device.Hold
+		device.mu.Unlock()
+
+		// Проверки выполняются только для подключенных устройств и не меняют состояние оператора
+		if device.CurrentStatus() == StatusOffline || (hold != nil && hold.Source == HoldSourceManual) {
+			continue
+		}
+		r.applyHealthChecks(device, hold, checks, now)
+	}
+}
+
+// applyHealthChecks задает состояние по первой непройденной проверке или снимает состояние проверок
+func (r *Registry) applyHealthChecks(device *Device, current *StatusHold, checks []HealthCheck, now time.Time) {
+	for _, check := range checks {
+		status, reason := check.Check(device)
+		if status != StatusDraining && status != StatusQuarantined {
+			continue
+		}
+
+		hold := &StatusHold{
+			Status:  status,
+			Reason:  reason,
+			Source:  HoldSourceHealth + check.Name(),
+			Since:   now,
+			Expires: now.Add(healthHoldTTL),
+		}
+		renewed := current != nil && current.Status == status && current.Source == hold.Source
+		if renewed {
+			hold.Since = current.Since
+		}
+		// Оператор мог задать состояние во время проверки
+		if device.compareAndSetHold(current, hold) && !renewed {
+			logger.Info("device", "Device %s set to %s by %s check: %s", device.ID, status, check.Name(), reason)
+		}
+		return
+	}
+
+	if current != nil && device.compareAndSetHold(current, nil) {
+		logger.Info("device", "Device %s passed health checks, returned to selection", device.ID)
+	}
+}
+
+// HeartbeatCheck переводит в draining устройство, пропустившее heartbeat:
+// пока оно не отключено по heartbeat_timeout, новые соединения через него не направляются
+type HeartbeatCheck struct {
+	lag time.Duration
+}
+
+// NewHeartbeatCheck создает проверку с допустимым временем с последнего heartbeat
+func NewHeartbeatCheck(lag time.Duration) *HeartbeatCheck {
+	return &HeartbeatCheck{lag: lag}
+}
+
+// Name возвращает имя проверки
+func (c *HeartbeatCheck) Name() string {
+	return "heartbeat"
+}
+
+// Check проверяет время последнего heartbeat
+func (c *HeartbeatCheck) Check(device *Device) (DeviceStatus, string) {
+	device.mu.RLock()
+	since := time.Since(device.LastHeartbeat)
+	device.mu.RUnlock()
+
+	if since > c.lag {
+		return StatusDraining, fmt.Sprintf("no heartbeat for %s", since.Round(time.Second))
+	}
+	return StatusOnline, ""
+}
@@ -0,0 +1,139 @@
+package device
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"nhooyr.io/websocket"
+)
+
+// connectDevice регистрирует устройство и помечает его подключенным
+// Соединения - заглушки, поэтому перед Close registry они убираются
+func connectDevice(t *testing.T, r *Registry, id string) *Device {
+	t.Helper()
+	device, err := r.RegisterWithWSS(id, "10.0.0.1:5000", nil, nil)
+	if err != nil {
+		t.Fatalf("RegisterWithWSS: %v", err)
+	}
+	device.mu.Lock()
+	device.WSSConn = &websocket.Conn{}
+	device.QUICConn = &quic.Conn{}
+	device.mu.Unlock()
+	t.Cleanup(func() {
+		device.mu.Lock()
+		device.WSSConn, device.QUICConn = nil, nil
+		device.mu.Unlock()
+	})
+	return device
+}
+
+// fixedCheck проверка здоровья с заданным результатом
+type fixedCheck struct {
+	status DeviceStatus
+}
+
+func (c *fixedCheck) Name() string { return "fixed" }
+
+func (c *fixedCheck) Check(device *Device) (DeviceStatus, string) {
+	return c.status, "test"
+}
+
+func TestRegistry_SetStatus(t *testing.T) {
+	r := NewRegistry(30, 90)
+	t.Cleanup(func() { r.Close() }) // Выполняется после удаления заглушек соединений
+	d1 := connectDevice(t, r, "d1")
+	connectDevice(t, r, "d2")
+
+	if len(r.GetAvailableDevices(NewDeviceCriteria())) != 2 {
+		t.Fatal("Оба устройства должны быть доступны")
+	}
+
+	if err := r.SetStatus("d1", StatusQuarantined, "blocked exit", 0); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+	available := r.GetAvailableDevices(NewDeviceCriteria())
+	if len(available) != 1 || available[0].ID != "d2" {
+		t.Errorf("Quarantined устройство не должно выбираться: %v", available)
+	}
+	if d1.CurrentStatus() != StatusQuarantined || !d1.IsOnline() {
+		t.Errorf("Устройство должно оставаться подключенным в статусе quarantined, получено %s", d1.CurrentStatus())
+	}
+	if found, err := r.FindDevice(&DeviceCriteria{Status: StatusQuarantined}); err != nil || found.ID != "d1" {
+		t.Errorf("FindDevice по статусу quarantined: %v, %v", found, err)
+	}
+
+	// Состояние сохраняется при переподключении и в записи для хранилища
+	connectDevice(t, r, "d1")
+	record := d1.Record()
+	if record.Hold == nil || record.Hold.Status != StatusQuarantined || record.Hold.Reason != "blocked exit" {
+		t.Errorf("Состояние не сохранено в записи: %+v", record.Hold)
+	}
+	data, _ := json.Marshal(record.Hold)
+	var decoded StatusHold
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Status != StatusQuarantined {
+		t.Errorf("Состояние не восстанавливается из JSON %s: %v", data, err)
+	}
+
+	if err := r.SetStatus("d1", StatusOnline, "", 0); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+	if !d1.IsAvailable() {
+		t.Error("Устройство должно вернуться в выбор")
+	}
+
+	// Истекшее состояние не действует
+	r.SetStatus("d1", StatusDraining, "maintenance", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if !d1.IsAvailable() {
+		t.Error("Истекшее состояние draining не должно действовать")
+	}
+
+	if err := r.SetStatus("missing", StatusDraining, "", 0); err == nil {
+		t.Error("Ожидалась ошибка для неизвестного устройства")
+	}
+}
+
+func TestRegistry_HealthChecks(t *testing.T) {
+	r := NewRegistry(30, 90)
+	t.Cleanup(func() { r.Close() }) // Выполняется после удаления заглушек соединений
+	d1 := connectDevice(t, r, "d1")
+	d2 := connectDevice(t, r, "d2")
+	check := &fixedCheck{status: StatusQuarantined}
+	r.AddHealthCheck(check)
+
+	// Состояние оператора не меняется проверками
+	r.SetStatus("d2", StatusDraining, "manual", 0)
+
+	r.runHealthChecks()
+	hold := d1.GetHold()
+	if hold == nil || hold.Status != StatusQuarantined || hold.Source != "health:fixed" || hold.Expires.IsZero() {
+		t.Fatalf("Проверка должна перевести устройство в quarantined со сроком: %+v", hold)
+	}
+	if hold := d2.GetHold(); hold.Source != HoldSourceManual || hold.Status != StatusDraining {
+		t.Errorf("Проверка изменила состояние оператора: %+v", hold)
+	}
+
+	// Пройденная проверка возвращает устройство в выбор
+	check.status = StatusOnline
+	r.runHealthChecks()
+	if !d1.IsAvailable() {
+		t.Errorf("Устройство должно вернуться в выбор, статус %s", d1.CurrentStatus())
+	}
+	if d2.CurrentStatus() != StatusDraining {
+		t.Error("Состояние оператора должно сохраниться")
+	}
+}
+
+func TestHeartbeatCheck(t *testing.T) {
+	device := NewDevice("d1", "10.0.0.1:5000", nil)
+	check := NewHeartbeatCheck(time.Minute)
+	if status, _ := check.Check(device); status != StatusOnline {
+		t.Errorf("Свежий heartbeat: ожидался online, получено %s", status)
+	}
+	device.LastHeartbeat = time.Now().Add(-2 * time.Minute)
+	if status, _ := check.Check(device); status != StatusDraining {
+		t.Errorf("Пропущенный heartbeat: ожидался draining, получено %s", status)
+	}
+}
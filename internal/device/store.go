@@ -0,0 +1,131 @@
+package device
+
+import (
+	"reflect"
+	"time"
+
+	"example.com/me/myproxy/internal/logger"
+	"github.com/quic-go/quic-go"
+)
+
+// Record сохраняемое состояние устройства
+// Соединения и статус online не сохраняются: после перезапуска POP устройства регистрируются заново
+type Record struct {
+	ID            string    `json:"id"`
+	RemoteAddr    string    `json:"remote_addr,omitempty"` // Адрес последней регистрации
+	EgressIP      string    `json:"egress_ip,omitempty"`   // Публичный IP выхода в интернет, сообщенный устройством
+	Location      string    `json:"location,omitempty"`
+	Capacity      int       `json:"capacity,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastSeen      time.Time `json:"last_seen"`
+	BytesSent     int64     `json:"bytes_sent"`
+	BytesReceived int64     `json:"bytes_received"`
+	// Draining/quarantined сохраняется, чтобы устройство не вернулось в выбор после перезапуска POP
+	Hold *StatusHold `json:"hold,omitempty"`
+}
+
+// RegistryStore хранилище состояния устройств между перезапусками POP
+type RegistryStore interface {
+	// Load возвращает все сохраненные записи
+	Load() ([]*Record, error)
+	// Save сохраняет (создает или заменяет) записи
+	Save(records ...*Record) error
+	// Close освобождает ресурсы хранилища
+	Close() error
+}
+
+// SetStore подключает хранилище: загружает сохраненные устройства (как offline)
+// и запускает периодическое сохранение изменений с интервалом flushInterval
+func (r *Registry) SetStore(store RegistryStore, flushInterval time.Duration) error {
+	records, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	for _, record := range records {
+		if _, exists := r.devices[record.ID]; exists {
+			continue
+		}
+		r.devices[record.ID] = newDeviceFromRecord(record)
+		r.saved[record.ID] = record
+	}
+	r.store = store
+	r.mu.Unlock()
+
+	logger.Info("device", "Restored %d devices from registry store", len(records))
+
+	go r.flushLoop(flushInterval)
+	return nil
+}
+
+// newDeviceFromRecord создает offline устройство из сохраненной записи
+func newDeviceFromRecord(record *Record) *Device {
+	return &Device{
+		ID:            record.ID,
+		RemoteAddr:    record.RemoteAddr,
+		EgressIP:      record.EgressIP,
+		Status:        StatusOffline,
+		FirstSeen:     record.FirstSeen,
+		LastHeartbeat: record.LastSeen,
+		BytesSent:     record.BytesSent,
+		BytesReceived: record.BytesReceived,
+		Location:      record.Location,
+		Capacity:      record.Capacity,
+		Tags:          append([]string(nil), record.Tags...),
+		Hold:          record.Hold,
+		Streams:       make(map[string]*quic.Stream),
+	}
+}
+
+// flushLoop периодически сохраняет изменившиеся устройства
+func (r *Registry) flushLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				logger.Error("device", "Failed to save device registry: %v", err)
+			}
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+// Flush сохраняет в хранилище устройства, изменившиеся с последнего сохранения
+func (r *Registry) Flush() error {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+
+	r.mu.RLock()
+	store := r.store
+	var changed []*Record
+	for id, device := range r.devices {
+		record := device.Record()
+		if saved, ok := r.saved[id]; ok && reflect.DeepEqual(saved, record) {
+			continue
+		}
+		changed = append(changed, record)
+	}
+	r.mu.RUnlock()
+
+	if store == nil || len(changed) == 0 {
+		return nil
+	}
+	if err := store.Save(changed...); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	for _, record := range changed {
+		r.saved[record.ID] = record
+	}
+	r.mu.Unlock()
+
+	logger.Debug("device", "Saved %d devices to registry store", len(changed))
+	return nil
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"example.com/me/myproxy/internal/device"
+)
+
+// FileStore хранит состояние устройств в одном JSON файле
+// Файл перезаписывается атомарно (временный файл + rename), поэтому не повреждается при сбое во время записи
+type FileStore struct {
+	mu      sync.Mutex
+	path    string
+	records map[string]*device.Record
+}
+
+// NewFileStore открывает хранилище в файле path (файл создается при первом сохранении)
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:    path,
+		records: make(map[string]*device.Record),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry store: %w", err)
+	}
+
+	var records []*device.Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse registry store %s: %w", path, err)
+	}
+	for _, record := range records {
+		s.records[record.ID] = record
+	}
+	return s, nil
+}
+
+// Load возвращает все сохраненные записи
+func (s *FileStore) Load() ([]*device.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]*device.Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Save сохраняет записи и перезаписывает файл
+func (s *FileStore) Save(records ...*device.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range records {
+		s.records[record.ID] = record
+	}
+	return s.writeLocked()
+}
+
+// writeLocked записывает все записи в файл (вызывается под mu)
+func (s *FileStore) writeLocked() error {
+	records := make([]*device.Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode registry store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write registry store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write registry store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write registry store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write registry store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write registry store: %w", err)
+	}
+	return nil
+}
+
+// Close закрывает хранилище (данные уже записаны при Save)
+func (s *FileStore) Close() error {
+	return nil
+}
@@ -0,0 +1,292 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"example.com/me/myproxy/internal/device"
+)
+
+// DefaultRedisPrefix префикс ключей по умолчанию
+const DefaultRedisPrefix = "myproxy:"
+
+// redisTimeout таймаут подключения и выполнения команд
+const redisTimeout = 5 * time.Second
+
+// RedisStore хранит состояние устройств в Redis (или совместимом сервере по протоколу RESP)
+// Каждое устройство - JSON строка по ключу <prefix>device:<id>, список ID - множество <prefix>devices
+type RedisStore struct {
+	mu       sync.Mutex
+	address  string
+	password string
+	db       int
+	prefix   string
+
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisStore создает хранилище и проверяет подключение к серверу
+func NewRedisStore(address, password string, db int, prefix string) (*RedisStore, error) {
+	if prefix == "" {
+		prefix = DefaultRedisPrefix
+	}
+	s := &RedisStore{
+		address:  address,
+		password: password,
+		db:       db,
+		prefix:   prefix,
+	}
+
+	if _, err := s.do([]string{"PING"}); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to connect to redis %s: %w", address, err)
+	}
+	return s, nil
+}
+
+// Load возвращает все сохраненные записи
+func (s *RedisStore) Load() ([]*device.Record, error) {
+	replies, err := s.do([]string{"SMEMBERS", s.prefix + "devices"})
+	if err != nil {
+		return nil, err
+	}
+	ids, _ := replies[0].([]interface{})
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	command := []string{"MGET"}
+	for _, id := range ids {
+		command = append(command, s.deviceKey(fmt.Sprint(id)))
+	}
+	replies, err = s.do(command)
+	if err != nil {
+		return nil, err
+	}
+
+	values, _ := replies[0].([]interface{})
+	records := make([]*device.Record, 0, len(values))
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// Ключ удален, а ID остался в множестве
+			continue
+		}
+		var record device.Record
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, fmt.Errorf("failed to parse device %v: %w", ids[i], err)
+		}
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+// Save сохраняет записи одним пакетом команд
+func (s *RedisStore) Save(records ...*device.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	commands := make([][]string, 0, len(records)+1)
+	members := []string{"SADD", s.prefix + "devices"}
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode device %s: %w", record.ID, err)
+		}
+		commands = append(commands, []string{"SET", s.deviceKey(record.ID), string(data)})
+		members = append(members, record.ID)
+	}
+	commands = append(commands, members)
+
+	_, err := s.do(commands...)
+	return err
+}
+
+// Close закрывает соединение с сервером
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeLocked()
+}
+
+// deviceKey ключ записи устройства
+func (s *RedisStore) deviceKey(id string) string {
+	return s.prefix + "device:" + id
+}
+
+// do выполняет команды одним пакетом (pipelining) и возвращает ответы
+// При сетевой ошибке соединение закрывается и будет установлено заново при следующем вызове
+func (s *RedisStore) do(commands ...[]string) ([]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.connectLocked(); err != nil {
+		return nil, err
+	}
+
+	replies, err := s.roundTripLocked(commands)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		s.closeLocked()
+	}
+	return replies, err
+}
+
+// connectLocked устанавливает соединение, выполняя AUTH и SELECT
+func (s *RedisStore) connectLocked() error {
+	if s.conn != nil {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", s.address, redisTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+
+	var setup [][]string
+	if s.password != "" {
+		setup = append(setup, []string{"AUTH", s.password})
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	if len(setup) > 0 {
+		if _, err := s.roundTripLocked(setup); err != nil {
+			s.closeLocked()
+			return err
+		}
+	}
+	return nil
+}
+
+// roundTripLocked отправляет команды и читает ответ на каждую
+// Первая ошибка сервера возвращается после чтения всех ответов
+func (s *RedisStore) roundTripLocked(commands [][]string) ([]interface{}, error) {
+	s.conn.SetDeadline(time.Now().Add(redisTimeout))
+	defer s.conn.SetDeadline(time.Time{})
+
+	writer := bufio.NewWriter(s.conn)
+	for _, command := range commands {
+		writeCommand(writer, command)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(commands))
+	var firstErr error
+	for i := range commands {
+		reply, err := readReply(s.reader)
+		if err != nil {
+			var redisErr redisError
+			if !errors.As(err, &redisErr) {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", commands[i][0], err)
+			}
+		}
+		replies[i] = reply
+	}
+	return replies, firstErr
+}
+
+// closeLocked закрывает соединение (вызывается под mu)
+func (s *RedisStore) closeLocked() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	s.reader = nil
+	return err
+}
+
+// redisError ошибка, возвращенная сервером (ответ "-ERR ...")
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// writeCommand записывает команду в формате RESP (массив bulk строк)
+func writeCommand(w *bufio.Writer, args []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// readReply читает один ответ RESP
+// Возвращает string (simple/bulk), int64, []interface{} или nil (null bulk/array)
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid array length %q", line)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected redis reply %q", line)
+	}
+}
+
+// readLine читает строку RESP без завершающего \r\n
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("malformed redis reply %q", line)
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,20 @@
+package store
+
+import (
+	"fmt"
+
+	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/device"
+)
+
+// New создает хранилище реестра устройств по конфигурации
+func New(cfg *config.RegistryStoreConfig) (device.RegistryStore, error) {
+	switch cfg.Type {
+	case "file":
+		return NewFileStore(cfg.Path)
+	case "redis":
+		return NewRedisStore(cfg.Address, cfg.Password, cfg.DB, cfg.Prefix)
+	default:
+		return nil, fmt.Errorf("unsupported registry store type: %s", cfg.Type)
+	}
+}
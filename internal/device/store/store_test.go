@@ -0,0 +1,235 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/device"
+)
+
+// fakeRedis минимальный сервер RESP для тестов (SET, MGET, SADD, SMEMBERS, AUTH, SELECT, PING)
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu   sync.Mutex
+	dbs  map[string]map[string]string          // db -> key -> value
+	sets map[string]map[string]map[string]bool // db -> key -> members
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	f := &fakeRedis{
+		listener: listener,
+		password: password,
+		dbs:      make(map[string]map[string]string),
+		sets:     make(map[string]map[string]map[string]bool),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authed := f.password == ""
+	db := "0"
+
+	for {
+		reply, err := readReply(reader)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i] = fmt.Sprint(item)
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		command := strings.ToUpper(args[0])
+		if !authed && command != "AUTH" {
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+
+		f.mu.Lock()
+		if f.dbs[db] == nil {
+			f.dbs[db] = make(map[string]string)
+			f.sets[db] = make(map[string]map[string]bool)
+		}
+		values, sets := f.dbs[db], f.sets[db]
+		switch command {
+		case "AUTH":
+			if args[1] == f.password {
+				authed = true
+				fmt.Fprint(conn, "+OK\r\n")
+			} else {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+			}
+		case "SELECT":
+			db = args[1]
+			fmt.Fprint(conn, "+OK\r\n")
+		case "PING":
+			fmt.Fprint(conn, "+PONG\r\n")
+		case "SET":
+			values[args[1]] = args[2]
+			fmt.Fprint(conn, "+OK\r\n")
+		case "MGET":
+			fmt.Fprintf(conn, "*%d\r\n", len(args)-1)
+			for _, key := range args[1:] {
+				if value, ok := values[key]; ok {
+					fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+				} else {
+					fmt.Fprint(conn, "$-1\r\n")
+				}
+			}
+		case "SADD":
+			if sets[args[1]] == nil {
+				sets[args[1]] = make(map[string]bool)
+			}
+			for _, member := range args[2:] {
+				sets[args[1]][member] = true
+			}
+			fmt.Fprintf(conn, ":%d\r\n", len(args)-2)
+		case "SMEMBERS":
+			members := sets[args[1]]
+			fmt.Fprintf(conn, "*%d\r\n", len(members))
+			for member := range members {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(member), member)
+			}
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		f.mu.Unlock()
+	}
+}
+
+// testRecords записи для проверки сохранения
+func testRecords() []*device.Record {
+	firstSeen := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []*device.Record{
+		{ID: "d1", Location: "eu", Tags: []string{"mobile"}, FirstSeen: firstSeen, LastSeen: firstSeen.Add(time.Hour), BytesSent: 100, BytesReceived: 200},
+		{ID: "d2", RemoteAddr: "10.0.0.2:5000", Capacity: 5, FirstSeen: firstSeen, LastSeen: firstSeen, BytesSent: 1},
+	}
+}
+
+// checkRecords сравнивает загруженные записи с ожидаемыми
+func checkRecords(t *testing.T, store device.RegistryStore, want []*device.Record) {
+	t.Helper()
+	got, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].ID < got[j].ID })
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Загруженные записи не совпадают:\nполучено  %+v\nожидалось %+v", got, want)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	records := testRecords()
+	if err := s.Save(records...); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	s.Close()
+
+	// Состояние восстанавливается после повторного открытия
+	s, err = NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer s.Close()
+	checkRecords(t, s, records)
+
+	// Save заменяет запись, остальные сохраняются
+	updated := *records[0]
+	updated.BytesSent = 500
+	if err := s.Save(&updated); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	checkRecords(t, reopened, []*device.Record{&updated, records[1]})
+}
+
+func TestRedisStore(t *testing.T) {
+	server := startFakeRedis(t, "secret")
+
+	s, err := NewRedisStore(server.addr(), "secret", 2, "test:")
+	if err != nil {
+		t.Fatalf("NewRedisStore: %v", err)
+	}
+	records := testRecords()
+	if err := s.Save(records...); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	s.Close()
+
+	s, err = NewRedisStore(server.addr(), "secret", 2, "test:")
+	if err != nil {
+		t.Fatalf("NewRedisStore: %v", err)
+	}
+	defer s.Close()
+	checkRecords(t, s, records)
+
+	// Записи лежат в выбранной базе под префиксом
+	server.mu.Lock()
+	_, ok := server.dbs["2"]["test:device:d1"]
+	server.mu.Unlock()
+	if !ok {
+		t.Error("Запись должна храниться в базе 2 по ключу test:device:d1")
+	}
+
+	// Соединение восстанавливается после разрыва
+	s.mu.Lock()
+	s.conn.Close()
+	s.mu.Unlock()
+	if _, err := s.Load(); err == nil {
+		t.Fatal("Ожидалась ошибка запроса через закрытое соединение")
+	}
+	checkRecords(t, s, records)
+}
+
+func TestRedisStore_AuthFailure(t *testing.T) {
+	server := startFakeRedis(t, "secret")
+	if _, err := NewRedisStore(server.addr(), "wrong", 0, ""); err == nil {
+		t.Fatal("Ожидалась ошибка при неверном пароле")
+	}
+}
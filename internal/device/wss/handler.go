@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
+	"time"
 
 	"example.com/me/myproxy/internal/constants"
 	"example.com/me/myproxy/internal/device"
@@ -101,6 +103,9 @@ func (h *Handler) handleRegister(ctx context.Context, conn *websocket.Conn, req
 	if len(req.Tags) > 0 {
 		metadata["tags"] = req.Tags
 	}
+	if len(req.Capabilities) > 0 {
+		metadata["capabilities"] = req.Capabilities
+	}
 
 	// Регистрируем устройство
 	_, err := h.registry.RegisterWithWSS(req.DeviceId, remoteAddr, metadata, conn)
@@ -156,6 +161,15 @@ func (h *Handler) handleHeartbeat(ctx context.Context, conn *websocket.Conn, req
 		return h.sendMessage(ctx, conn, resp)
 	}
 
+	// Публичный IP устройства (сообщается, если device его определяет)
+	if req.EgressIp != "" {
+		if net.ParseIP(req.EgressIp) == nil {
+			logger.Debug("device", "Ignoring invalid egress IP %q from device %s", req.EgressIp, req.DeviceId)
+		} else if dev, err := h.registry.GetDevice(req.DeviceId); err == nil {
+			dev.SetEgressIP(req.EgressIp)
+		}
+	}
+
 	resp := &pb.HeartbeatResponse{
 		Status: constants.StatusOK,
 	}
@@ -208,3 +222,21 @@ func (h *Handler) SendCommand(ctx context.Context, deviceID string, cmd *pb.Comm
 	return h.sendMessage(ctx, wssConn, cmd)
 }
 
+// SendDrainNotice уведомляет устройство о том, что POP завершает работу
+func (h *Handler) SendDrainNotice(ctx context.Context, deviceID string, deadline time.Time, reason string) error {
+	dev, err := h.registry.GetDevice(deviceID)
+	if err != nil {
+		return fmt.Errorf("device not found: %w", err)
+	}
+
+	wssConn := dev.GetWSSConn()
+	if wssConn == nil {
+		return fmt.Errorf("WSS connection not established for device %s", deviceID)
+	}
+
+	notice := &pb.DrainNotice{
+		Deadline: deadline.Unix(),
+		Reason:   reason,
+	}
+	return h.sendMessage(ctx, wssConn, notice)
+}
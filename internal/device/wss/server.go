@@ -3,12 +3,14 @@ package wss
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"example.com/me/myproxy/internal/device"
 	"example.com/me/myproxy/internal/logger"
+	"example.com/me/myproxy/internal/upgrade"
 	"nhooyr.io/websocket"
 )
 
@@ -19,6 +21,7 @@ type Server struct {
 	tlsConfig  *tls.Config
 	httpServer *http.Server
 	handler    *Handler
+	upgrader   *upgrade.Upgrader
 }
 
 // NewServer создает новый WSS server
@@ -32,12 +35,23 @@ func NewServer(registry *device.Registry, port int, tlsConfig *tls.Config) *Serv
 	}
 }
 
+// SetUpgrader задает Upgrader для создания слушателя (передача сокета при обновлении бинарника)
+func (s *Server) SetUpgrader(upgrader *upgrade.Upgrader) {
+	s.upgrader = upgrader
+}
+
 // Start запускает WSS server
+// Слушатель создается синхронно, соединения обслуживаются в фоне
 func (s *Server) Start() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleWebSocket)
 
 	addr := fmt.Sprintf(":%d", s.port)
+	listener, err := s.upgrader.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen WSS: %w", err)
+	}
+
 	s.httpServer = &http.Server{
 		Addr:      addr,
 		Handler:   mux,
@@ -46,15 +60,27 @@ func (s *Server) Start() error {
 
 	logger.Info("device", "WSS control-plane server starting on port %d", s.port)
 
-	if s.tlsConfig != nil {
-		return s.httpServer.ListenAndServeTLS("", "")
-	}
-
-	// Для тестирования без TLS
-	return s.httpServer.ListenAndServe()
+	go func() {
+		var err error
+		if s.tlsConfig != nil {
+			err = s.httpServer.ServeTLS(listener, "", "")
+		} else {
+			// Для тестирования без TLS
+			err = s.httpServer.Serve(listener)
+		}
+
+		// Остановка через Stop - штатное завершение
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("device", "WSS server error: %v", err)
+		}
+	}()
+
+	return nil
 }
 
-// Stop останавливает WSS server
+// Stop останавливает прием новых WSS соединений
+// Уже установленные WebSocket соединения (hijacked) не закрываются,
+// они закрываются реестром устройств (MarkOffline)
 func (s *Server) Stop() error {
 	if s.httpServer == nil {
 		return nil
@@ -66,6 +92,23 @@ func (s *Server) Stop() error {
 	return s.httpServer.Shutdown(ctx)
 }
 
+// NotifyDraining рассылает DrainNotice всем подключенным устройствам
+// Возвращает количество уведомленных устройств
+func (s *Server) NotifyDraining(ctx context.Context, deadline time.Time, reason string) int {
+	notified := 0
+	for _, dev := range s.registry.ListDevices() {
+		if !dev.IsOnline() || dev.GetWSSConn() == nil {
+			continue
+		}
+		if err := s.handler.SendDrainNotice(ctx, dev.ID, deadline, reason); err != nil {
+			logger.Debug("device", "Failed to send drain notice to device %s: %v", dev.ID, err)
+			continue
+		}
+		notified++
+	}
+	return notified
+}
+
 // handleWebSocket обрабатывает WebSocket соединения
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Принимаем WebSocket соединение
@@ -0,0 +1,53 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/constants"
+)
+
+// New создает resolver по конфигурации dns (nil - системный resolver с кешем)
+func New(cfg *config.DNSConfig) (*Resolver, error) {
+	if cfg == nil {
+		return NewResolver(nil), nil
+	}
+
+	upstreams := make([]Upstream, 0, len(cfg.Servers))
+	for _, server := range cfg.Servers {
+		upstream, err := ParseUpstream(server)
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	resolver := NewResolver(upstreams)
+
+	hosts := make(map[string][]net.IP, len(cfg.Hosts))
+	for host, addresses := range cfg.Hosts {
+		for _, address := range addresses {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q for host %s", address, host)
+			}
+			hosts[host] = append(hosts[host], ip)
+		}
+	}
+	resolver.SetHosts(hosts)
+
+	if cfg.Timeout > 0 {
+		resolver.SetTimeout(time.Duration(cfg.Timeout) * time.Second)
+	}
+	cacheSize := cfg.CacheSize
+	if cacheSize == 0 {
+		cacheSize = constants.DefaultDNSCacheSize
+	}
+	maxTTL := cfg.MaxTTL
+	if maxTTL == 0 {
+		maxTTL = constants.DefaultDNSMaxTTL
+	}
+	resolver.SetCache(cacheSize, time.Duration(cfg.MinTTL)*time.Second, time.Duration(maxTTL)*time.Second)
+	return resolver, nil
+}
@@ -0,0 +1,102 @@
+package dns
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// answer IP адреса из ответа DNS сервера
+type answer struct {
+	ips []net.IP
+	ttl time.Duration // Минимальный TTL записей (0 - записей нет)
+}
+
+// buildQuery создает запрос записей qtype для host
+func buildQuery(host string, qtype dnsmessage.Type) (uint16, []byte, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid domain name %q: %w", host, err)
+	}
+	id := uint16(rand.Intn(1 << 16))
+	builder := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{ID: id, RecursionDesired: true})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return 0, nil, err
+	}
+	if err := builder.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return 0, nil, err
+	}
+	query, err := builder.Finish()
+	return id, query, err
+}
+
+// parseResponse извлекает A и AAAA записи из ответа на запрос с идентификатором id
+// NXDOMAIN возвращается как *net.DNSError с IsNotFound
+func parseResponse(host string, id uint16, response []byte) (*answer, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if header.ID != id {
+		return nil, fmt.Errorf("DNS response ID mismatch: expected %d, got %d", id, header.ID)
+	}
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	default:
+		return nil, fmt.Errorf("DNS server returned %s for %s", header.RCode, host)
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, fmt.Errorf("invalid DNS response: %w", err)
+	}
+
+	result := &answer{}
+	for {
+		resource, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid DNS response: %w", err)
+		}
+
+		var ip net.IP
+		switch resource.Type {
+		case dnsmessage.TypeA:
+			record, err := parser.AResource()
+			if err != nil {
+				return nil, fmt.Errorf("invalid A record: %w", err)
+			}
+			ip = net.IP(record.A[:])
+		case dnsmessage.TypeAAAA:
+			record, err := parser.AAAAResource()
+			if err != nil {
+				return nil, fmt.Errorf("invalid AAAA record: %w", err)
+			}
+			ip = net.IP(record.AAAA[:])
+		default:
+			// CNAME и прочие записи цепочки: адреса приходят в том же ответе
+			if err := parser.SkipAnswer(); err != nil {
+				return nil, fmt.Errorf("invalid DNS response: %w", err)
+			}
+			continue
+		}
+		ttl := time.Duration(resource.TTL) * time.Second
+		if len(result.ips) == 0 || ttl < result.ttl {
+			result.ttl = ttl
+		}
+		result.ips = append(result.ips, ip)
+	}
+	return result, nil
+}
+
+// truncated проверяет флаг TC ответа (ответ не поместился в UDP)
+func truncated(response []byte) bool {
+	return len(response) > 2 && response[2]&0x02 != 0
+}
@@ -0,0 +1,243 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/me/myproxy/internal/constants"
+	"example.com/me/myproxy/internal/logger"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// systemTTL время хранения в кеше ответа системного resolver (TTL неизвестен)
+	systemTTL = 60 * time.Second
+	// negativeTTL время хранения в кеше отсутствия адресов у домена
+	negativeTTL = 30 * time.Second
+)
+
+// Resolver разрешает домены в IP адреса: статические адреса hosts, кеш с учетом TTL,
+// затем DNS серверы по порядку (без серверов - системный resolver)
+type Resolver struct {
+	upstreams []Upstream
+	hosts     map[string][]net.IP
+	timeout   time.Duration // Таймаут запроса к одному серверу
+	minTTL    time.Duration
+	maxTTL    time.Duration
+	cacheSize int
+
+	mu    sync.Mutex
+	cache map[string]*cacheEntry
+}
+
+// cacheEntry ответ в кеше (ips пуст - у домена нет адресов)
+type cacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// NewResolver создает resolver с DNS серверами upstreams (пусто - системный resolver)
+func NewResolver(upstreams []Upstream) *Resolver {
+	return &Resolver{
+		upstreams: upstreams,
+		hosts:     make(map[string][]net.IP),
+		timeout:   constants.DefaultDNSTimeout * time.Second,
+		maxTTL:    constants.DefaultDNSMaxTTL * time.Second,
+		cacheSize: constants.DefaultDNSCacheSize,
+		cache:     make(map[string]*cacheEntry),
+	}
+}
+
+// SetHosts задает статические адреса доменов, они не запрашиваются у серверов
+func (r *Resolver) SetHosts(hosts map[string][]net.IP) {
+	r.hosts = make(map[string][]net.IP, len(hosts))
+	for host, ips := range hosts {
+		r.hosts[normalizeHost(host)] = ips
+	}
+}
+
+// SetTimeout задает таймаут запроса к одному серверу
+func (r *Resolver) SetTimeout(timeout time.Duration) {
+	r.timeout = timeout
+}
+
+// SetCache задает размер кеша (доменов) и границы TTL ответов в кеше
+func (r *Resolver) SetCache(size int, minTTL, maxTTL time.Duration) {
+	r.cacheSize = size
+	r.minTTL = minTTL
+	r.maxTTL = maxTTL
+}
+
+// LookupIP возвращает IP адреса host: сначала IPv4, затем IPv6
+// Отсутствие домена или адресов возвращается как *net.DNSError с IsNotFound
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	host = normalizeHost(host)
+	if ips, ok := r.hosts[host]; ok {
+		return ips, nil
+	}
+	if ips, ok := r.cached(host); ok {
+		return notFound(host, ips)
+	}
+
+	ips, ttl, err := r.lookup(ctx, host)
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		ttl = negativeTTL
+	}
+	r.store(host, ips, ttl)
+	return notFound(host, ips)
+}
+
+// lookup запрашивает адреса у серверов по порядку до первого ответа
+func (r *Resolver) lookup(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	if len(r.upstreams) == 0 {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+		return ips, systemTTL, err
+	}
+
+	var lastErr error
+	for _, upstream := range r.upstreams {
+		ips, ttl, err := r.lookupUpstream(ctx, upstream, host)
+		var dnsErr *net.DNSError
+		if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			return ips, ttl, err
+		}
+		logger.Debug("dns", "Failed to resolve %s via %s: %v", host, upstream, err)
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, 0, fmt.Errorf("failed to resolve %s: %w", host, lastErr)
+}
+
+// lookupUpstream параллельно запрашивает A и AAAA записи у одного сервера
+func (r *Resolver) lookupUpstream(ctx context.Context, upstream Upstream, host string) ([]net.IP, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	qtypes := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	answers := make([]*answer, len(qtypes))
+	errs := make([]error, len(qtypes))
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			answers[i], errs[i] = exchange(ctx, upstream, host, qtype)
+		}()
+	}
+	wg.Wait()
+
+	// Адреса одного типа возвращаются, даже если запрос другого не удался
+	var ips []net.IP
+	var ttl time.Duration
+	var lookupErr error
+	for i := range qtypes {
+		if errs[i] != nil {
+			// Ошибка запроса важнее NXDOMAIN: без адресов ответ не должен кешироваться как отсутствие домена
+			var dnsErr *net.DNSError
+			if lookupErr == nil || (errors.As(lookupErr, &dnsErr) && dnsErr.IsNotFound) {
+				lookupErr = errs[i]
+			}
+			continue
+		}
+		if len(answers[i].ips) > 0 && (len(ips) == 0 || answers[i].ttl < ttl) {
+			ttl = answers[i].ttl
+		}
+		ips = append(ips, answers[i].ips...)
+	}
+	if len(ips) == 0 && lookupErr != nil {
+		return nil, 0, lookupErr
+	}
+	if lookupErr != nil {
+		logger.Debug("dns", "Partial answer for %s via %s: %v", host, upstream, lookupErr)
+	}
+	return ips, ttl, nil
+}
+
+// exchange выполняет один запрос записей qtype
+func exchange(ctx context.Context, upstream Upstream, host string, qtype dnsmessage.Type) (*answer, error) {
+	id, query, err := buildQuery(host, qtype)
+	if err != nil {
+		return nil, err
+	}
+	response, err := upstream.Exchange(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return parseResponse(host, id, response)
+}
+
+// cached возвращает адреса из кеша, если срок их хранения не истек
+func (r *Resolver) cached(host string) ([]net.IP, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.cache[host]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(r.cache, host)
+		return nil, false
+	}
+	return entry.ips, true
+}
+
+// store сохраняет адреса в кеше с TTL в границах minTTL..maxTTL
+// Если кеш заполнен, удаляются устаревшие записи, а при их отсутствии - произвольная
+func (r *Resolver) store(host string, ips []net.IP, ttl time.Duration) {
+	ttl = max(ttl, r.minTTL)
+	if r.maxTTL > 0 {
+		ttl = min(ttl, r.maxTTL)
+	}
+	if ttl <= 0 || r.cacheSize <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.cache[host]; !exists && len(r.cache) >= r.cacheSize {
+		now := time.Now()
+		for key, entry := range r.cache {
+			if now.After(entry.expires) {
+				delete(r.cache, key)
+			}
+		}
+		for key := range r.cache {
+			if len(r.cache) < r.cacheSize {
+				break
+			}
+			delete(r.cache, key)
+		}
+	}
+	r.cache[host] = &cacheEntry{ips: ips, expires: time.Now().Add(ttl)}
+}
+
+// notFound возвращает ips или ошибку "no such host", если адресов нет
+func notFound(host string, ips []net.IP) ([]net.IP, error) {
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// normalizeHost приводит домен к нижнему регистру без завершающей точки
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,322 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tlsconfig "example.com/me/myproxy/internal/tls"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// stubServer DNS сервер для тестов: отвечает адресами из records с TTL 60,
+// для остальных доменов - NXDOMAIN
+type stubServer struct {
+	records   map[string][]net.IP
+	truncate  bool // Усекать ответы по UDP
+	queries   atomic.Int64
+	udpAddr   string
+	tcpAddr   string
+	tlsAddr   string
+	httpsURL  string
+	tlsClient *http.Client
+}
+
+func startStubServer(t *testing.T, records map[string][]net.IP, truncate bool) *stubServer {
+	t.Helper()
+	s := &stubServer{records: records, truncate: truncate}
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания TCP слушателя: %v", err)
+	}
+	t.Cleanup(func() { tcp.Close() })
+	s.tcpAddr = tcp.Addr().String()
+	go s.serveTCP(tcp)
+
+	// UDP на том же порту: усеченные ответы повторяются по TCP на тот же адрес
+	udp, err := net.ListenPacket("udp", s.tcpAddr)
+	if err != nil {
+		t.Fatalf("Ошибка создания UDP слушателя: %v", err)
+	}
+	t.Cleanup(func() { udp.Close() })
+	s.udpAddr = udp.LocalAddr().String()
+	go func() {
+		buf := make([]byte, maxMessageSize)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(s.respond(buf[:n], s.truncate), addr)
+		}
+	}()
+
+	cert, err := tlsconfig.GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Ошибка создания сертификата: %v", err)
+	}
+	dot, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("Ошибка создания TLS слушателя: %v", err)
+	}
+	t.Cleanup(func() { dot.Close() })
+	s.tlsAddr = dot.Addr().String()
+	go s.serveTCP(dot)
+
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(s.respond(query, false))
+	}))
+	t.Cleanup(doh.Close)
+	s.httpsURL = doh.URL + "/dns-query"
+	s.tlsClient = doh.Client()
+	return s
+}
+
+func (s *stubServer) serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(c net.Conn) {
+			defer c.Close()
+			var length [2]byte
+			if _, err := io.ReadFull(c, length[:]); err != nil {
+				return
+			}
+			query := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(c, query); err != nil {
+				return
+			}
+			response := s.respond(query, false)
+			c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(response))), response...))
+		}(conn)
+	}
+}
+
+// respond строит ответ на запрос; truncate - пустой ответ с флагом TC
+func (s *stubServer) respond(query []byte, truncate bool) []byte {
+	s.queries.Add(1)
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil
+	}
+
+	name := strings.TrimSuffix(question.Name.String(), ".")
+	ips, ok := s.records[name]
+	responseHeader := dnsmessage.Header{ID: header.ID, Response: true, RecursionDesired: true, Truncated: truncate}
+	if !ok {
+		responseHeader.RCode = dnsmessage.RCodeNameError
+	}
+	builder := dnsmessage.NewBuilder(nil, responseHeader)
+	builder.StartQuestions()
+	builder.Question(question)
+	builder.StartAnswers()
+	if !truncate {
+		resource := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}
+		for _, ip := range ips {
+			if ip4 := ip.To4(); ip4 != nil && question.Type == dnsmessage.TypeA {
+				builder.AResource(resource, dnsmessage.AResource{A: [4]byte(ip4)})
+			} else if ip4 == nil && question.Type == dnsmessage.TypeAAAA {
+				builder.AAAAResource(resource, dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())})
+			}
+		}
+	}
+	response, _ := builder.Finish()
+	return response
+}
+
+var testRecords = map[string][]net.IP{
+	"example.com": {net.ParseIP("93.184.216.34"), net.ParseIP("2606:2800:220:1::248")},
+	"v4.test":     {net.ParseIP("10.0.0.1")},
+}
+
+func TestResolver_Upstreams(t *testing.T) {
+	stub := startStubServer(t, testRecords, false)
+
+	upstreams := map[string]Upstream{
+		"udp":   NewUDPUpstream(stub.udpAddr),
+		"tcp":   NewTCPUpstream(stub.tcpAddr, nil),
+		"tls":   NewTCPUpstream(stub.tlsAddr, &tls.Config{InsecureSkipVerify: true}),
+		"https": NewHTTPSUpstream(stub.httpsURL, stub.tlsClient),
+	}
+	for name, upstream := range upstreams {
+		t.Run(name, func(t *testing.T) {
+			ips, err := NewResolver([]Upstream{upstream}).LookupIP(context.Background(), "Example.com.")
+			if err != nil {
+				t.Fatalf("Ошибка разрешения: %v", err)
+			}
+			if len(ips) != 2 || !ips[0].Equal(testRecords["example.com"][0]) || !ips[1].Equal(testRecords["example.com"][1]) {
+				t.Errorf("Неверные адреса (ожидались IPv4, затем IPv6): %v", ips)
+			}
+		})
+	}
+}
+
+func TestResolver_TruncatedRetriesTCP(t *testing.T) {
+	stub := startStubServer(t, testRecords, true)
+
+	ips, err := NewResolver([]Upstream{NewUDPUpstream(stub.udpAddr)}).LookupIP(context.Background(), "v4.test")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Ответ по TCP после усеченного UDP не получен: %v, %v", ips, err)
+	}
+}
+
+func TestResolver_Cache(t *testing.T) {
+	stub := startStubServer(t, testRecords, false)
+	resolver := NewResolver([]Upstream{NewUDPUpstream(stub.udpAddr)})
+
+	for i := 0; i < 3; i++ {
+		if _, err := resolver.LookupIP(context.Background(), "example.com"); err != nil {
+			t.Fatalf("Ошибка разрешения: %v", err)
+		}
+	}
+	// A и AAAA запросы только при первом разрешении
+	if got := stub.queries.Load(); got != 2 {
+		t.Errorf("Ожидалось 2 запроса к серверу, получено %d", got)
+	}
+
+	// Отсутствие домена тоже кешируется
+	for i := 0; i < 2; i++ {
+		_, err := resolver.LookupIP(context.Background(), "missing.test")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Fatalf("Ожидалась ошибка not found, получено %v", err)
+		}
+	}
+	if got := stub.queries.Load(); got != 4 {
+		t.Errorf("Ожидалось 4 запроса к серверу, получено %d", got)
+	}
+
+	// Истекший TTL
+	resolver.SetCache(10, 0, time.Millisecond)
+	resolver.LookupIP(context.Background(), "v4.test")
+	time.Sleep(5 * time.Millisecond)
+	resolver.LookupIP(context.Background(), "v4.test")
+	if got := stub.queries.Load(); got != 8 {
+		t.Errorf("Ожидалось 8 запросов к серверу после истечения TTL, получено %d", got)
+	}
+}
+
+func TestResolver_HostsAndFailover(t *testing.T) {
+	stub := startStubServer(t, testRecords, false)
+
+	// Недоступный первый сервер: запрос переходит ко второму
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedAddress := closed.Addr().String()
+	closed.Close()
+	resolver := NewResolver([]Upstream{NewTCPUpstream(closedAddress, nil), NewUDPUpstream(stub.udpAddr)})
+	resolver.SetHosts(map[string][]net.IP{"Internal.Example": {net.ParseIP("192.168.1.10")}})
+
+	ips, err := resolver.LookupIP(context.Background(), "internal.example")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.168.1.10")) {
+		t.Errorf("Неверный статический адрес: %v, %v", ips, err)
+	}
+	if stub.queries.Load() != 0 {
+		t.Error("Статический адрес запрошен у сервера")
+	}
+	if ips, err := resolver.LookupIP(context.Background(), "v4.test"); err != nil || len(ips) != 1 {
+		t.Errorf("Не удалось разрешить через второй сервер: %v, %v", ips, err)
+	}
+	if ips, err := resolver.LookupIP(context.Background(), "10.1.2.3"); err != nil || !ips[0].Equal(net.ParseIP("10.1.2.3")) {
+		t.Errorf("IP адрес должен возвращаться без разрешения: %v, %v", ips, err)
+	}
+}
+
+// failingUpstream upstream, запросы записей failTypes к которому завершаются ошибкой
+type failingUpstream struct {
+	Upstream
+	failTypes []dnsmessage.Type
+}
+
+func (u failingUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	if _, err := parser.Start(query); err != nil {
+		return nil, err
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(u.failTypes, question.Type) {
+		return nil, fmt.Errorf("query %s timed out", question.Type)
+	}
+	return u.Upstream.Exchange(ctx, query)
+}
+
+func TestResolver_PartialAnswer(t *testing.T) {
+	stub := startStubServer(t, testRecords, false)
+	upstream := NewUDPUpstream(stub.udpAddr)
+
+	// Ошибка AAAA запроса не отменяет адреса IPv4
+	resolver := NewResolver([]Upstream{failingUpstream{Upstream: upstream, failTypes: []dnsmessage.Type{dnsmessage.TypeAAAA}}})
+	ips, err := resolver.LookupIP(context.Background(), "example.com")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(testRecords["example.com"][0]) {
+		t.Errorf("Ожидался адрес IPv4: %v, %v", ips, err)
+	}
+
+	// Ошибка при отсутствии адресов другого типа не считается отсутствием домена
+	ips, err = resolver.LookupIP(context.Background(), "missing.test")
+	var dnsErr *net.DNSError
+	if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		t.Errorf("Ожидалась ошибка запроса, получено %v, %v", ips, err)
+	}
+
+	// Ошибка обоих запросов
+	resolver = NewResolver([]Upstream{failingUpstream{Upstream: upstream, failTypes: []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}}})
+	if ips, err := resolver.LookupIP(context.Background(), "example.com"); err == nil {
+		t.Errorf("Ожидалась ошибка, получено %v", ips)
+	}
+}
+
+func TestParseUpstream(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"1.1.1.1", "udp://1.1.1.1:53"},
+		{"udp://[2606:4700:4700::1111]", "udp://[2606:4700:4700::1111]:53"},
+		{"tcp://8.8.8.8:5353", "tcp://8.8.8.8:5353"},
+		{"tls://dns.google", "tls://dns.google:853"},
+		{"https://cloudflare-dns.com/dns-query", "https://cloudflare-dns.com/dns-query"},
+	}
+	for _, tt := range tests {
+		upstream, err := ParseUpstream(tt.address)
+		if err != nil {
+			t.Errorf("%s: ошибка: %v", tt.address, err)
+			continue
+		}
+		if upstream.String() != tt.want {
+			t.Errorf("%s: ожидалось %s, получено %s", tt.address, tt.want, upstream.String())
+		}
+	}
+
+	for _, address := range []string{"quic://1.1.1.1", "https://"} {
+		if _, err := ParseUpstream(address); err == nil {
+			t.Errorf("%s: ожидалась ошибка", address)
+		}
+	}
+}
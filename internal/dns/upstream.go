@@ -0,0 +1,213 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxMessageSize максимальный размер DNS сообщения
+const maxMessageSize = 65535
+
+// Upstream DNS сервер
+type Upstream interface {
+	// Exchange отправляет запрос и возвращает ответ сервера
+	Exchange(ctx context.Context, query []byte) ([]byte, error)
+	// String возвращает адрес сервера для логов
+	String() string
+}
+
+// ParseUpstream создает Upstream по адресу:
+// "1.1.1.1", "udp://1.1.1.1:53", "tcp://1.1.1.1:53", "tls://1.1.1.1:853" (DoT),
+// "https://cloudflare-dns.com/dns-query" (DoH)
+func ParseUpstream(address string) (Upstream, error) {
+	if !strings.Contains(address, "://") {
+		address = "udp://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS server %q: %w", address, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid DNS server %q: host is required", address)
+	}
+
+	switch u.Scheme {
+	case "udp":
+		return NewUDPUpstream(withDefaultPort(u.Host, "53")), nil
+	case "tcp":
+		return NewTCPUpstream(withDefaultPort(u.Host, "53"), nil), nil
+	case "tls":
+		return NewTCPUpstream(withDefaultPort(u.Host, "853"), &tls.Config{ServerName: u.Hostname()}), nil
+	case "https":
+		return NewHTTPSUpstream(u.String(), http.DefaultClient), nil
+	default:
+		return nil, fmt.Errorf("unsupported DNS server scheme %q (one of: udp, tcp, tls, https)", u.Scheme)
+	}
+}
+
+// withDefaultPort добавляет порт к адресу без порта
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// UDPUpstream DNS сервер по UDP; усеченные ответы повторяются по TCP
+type UDPUpstream struct {
+	address string
+	tcp     *TCPUpstream
+}
+
+// NewUDPUpstream создает UDP upstream для address (host:port)
+func NewUDPUpstream(address string) *UDPUpstream {
+	return &UDPUpstream{
+		address: address,
+		tcp:     NewTCPUpstream(address, nil),
+	}
+}
+
+// Exchange отправляет запрос по UDP
+func (u *UDPUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", u.address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ответы на другие запросы (с чужим ID) пропускаются
+		if n < 2 || !bytes.Equal(buf[:2], query[:2]) {
+			continue
+		}
+		if truncated(buf[:n]) {
+			return u.tcp.Exchange(ctx, query)
+		}
+		return buf[:n], nil
+	}
+}
+
+// String возвращает адрес сервера
+func (u *UDPUpstream) String() string {
+	return "udp://" + u.address
+}
+
+// TCPUpstream DNS сервер по TCP или TLS (DoT, RFC 7858)
+type TCPUpstream struct {
+	address   string
+	tlsConfig *tls.Config
+}
+
+// NewTCPUpstream создает TCP upstream для address (host:port); с tlsConfig - DNS over TLS
+func NewTCPUpstream(address string, tlsConfig *tls.Config) *TCPUpstream {
+	return &TCPUpstream{
+		address:   address,
+		tlsConfig: tlsConfig,
+	}
+}
+
+// Exchange отправляет запрос с двухбайтовым префиксом длины по отдельному соединению
+func (t *TCPUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	var conn net.Conn
+	var err error
+	if t.tlsConfig != nil {
+		dialer := &tls.Dialer{Config: t.tlsConfig}
+		conn, err = dialer.DialContext(ctx, "tcp", t.address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", t.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	request := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(query)), uint16(len(query)))
+	if _, err := conn.Write(append(request, query...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// String возвращает адрес сервера
+func (t *TCPUpstream) String() string {
+	if t.tlsConfig != nil {
+		return "tls://" + t.address
+	}
+	return "tcp://" + t.address
+}
+
+// HTTPSUpstream DNS сервер по HTTPS (DoH, RFC 8484)
+type HTTPSUpstream struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSUpstream создает DoH upstream для url
+func NewHTTPSUpstream(url string, client *http.Client) *HTTPSUpstream {
+	return &HTTPSUpstream{
+		url:    url,
+		client: client,
+	}
+}
+
+// Exchange отправляет запрос методом POST
+func (h *HTTPSUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/dns-message")
+	request.Header.Set("Accept", "application/dns-message")
+
+	response, err := h.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned %s", response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, maxMessageSize))
+}
+
+// String возвращает URL сервера
+func (h *HTTPSUpstream) String() string {
+	return h.url
+}
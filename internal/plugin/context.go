@@ -7,10 +7,17 @@ type ConnectionContext struct {
 	// Идентификаторы
 	InboundID  string // Идентификатор inbound (из конфигурации или метаданных)
 	OutboundID string // Идентификатор outbound (из конфигурации или метаданных)
+	UserID     string // Идентификатор пользователя (если inbound его определил)
 
 	// Метаданные соединения
 	RemoteAddr    string // Адрес клиента
 	TargetAddress string // Целевой адрес для подключения
+	ResolvedAddr  string // Адрес назначения после разрешения DNS на устройстве (пусто - неизвестен)
+	EgressIP      string // Публичный IP устройства, через которое установлено соединение (пусто - неизвестен)
+
+	// Определение протокола по первым байтам клиента (пусто - не выполнялось или не удалось)
+	SniffedProtocol string // "tls" или "http"
+	SniffedDomain   string // Домен из SNI или заголовка Host
 
 	// Временные метки
 	StartTime time.Time // Время начала соединения
@@ -33,3 +40,39 @@ func NewConnectionContext(remoteAddr, targetAddress string) *ConnectionContext {
 	}
 }
 
+// MetadataDialAttempts ключ Metadata с историей попыток подключения ([]DialAttempt)
+const MetadataDialAttempts = "dial_attempts"
+
+// DialAttempt попытка подключения к адресу назначения через outbound
+type DialAttempt struct {
+	OutboundID string        `json:"outbound_id"`
+	Error      string        `json:"error,omitempty"` // Пусто при успешном подключении
+	Duration   time.Duration `json:"duration"`
+}
+
+// AddDialAttempt добавляет попытку подключения в историю (Metadata[MetadataDialAttempts])
+func (c *ConnectionContext) AddDialAttempt(outboundID string, err error, duration time.Duration) {
+	attempt := DialAttempt{OutboundID: outboundID, Duration: duration}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	c.Metadata[MetadataDialAttempts] = append(c.DialAttempts(), attempt)
+}
+
+// DialAttempts возвращает историю попыток подключения
+func (c *ConnectionContext) DialAttempts() []DialAttempt {
+	attempts, _ := c.Metadata[MetadataDialAttempts].([]DialAttempt)
+	return attempts
+}
+
+// FailedOutbounds возвращает outbound, подключение через которые не удалось
+// Router исключает их при повторном выборе
+func (c *ConnectionContext) FailedOutbounds() []string {
+	var failed []string
+	for _, attempt := range c.DialAttempts() {
+		if attempt.Error != "" {
+			failed = append(failed, attempt.OutboundID)
+		}
+	}
+	return failed
+}
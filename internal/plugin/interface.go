@@ -1,5 +1,7 @@
 package plugin
 
+import "context"
+
 // Plugin базовый интерфейс для всех плагинов
 type Plugin interface {
 	// Name возвращает имя плагина
@@ -10,6 +12,14 @@ type Plugin interface {
 	Close() error
 }
 
+// ReloadablePlugin плагин, который применяет новую конфигурацию без пересоздания
+// Счетчики и накопленное состояние сохраняются, установленные соединения продолжают работать с тем же экземпляром
+type ReloadablePlugin interface {
+	Plugin
+	// Reload применяет новую конфигурацию; при ошибке текущая конфигурация не изменяется
+	Reload(config map[string]interface{}) error
+}
+
 // InboundPlugin плагин для обработки inbound событий
 type InboundPlugin interface {
 	Plugin
@@ -33,3 +43,11 @@ type TrafficPlugin interface {
 	OnConnectionClosed(ctx *ConnectionContext)
 }
 
+// LimiterPlugin плагин для ограничения трафика (квоты, shaping)
+type LimiterPlugin interface {
+	Plugin
+	// OnBeforeDataTransfer вызывается перед записью данных
+	// Может задержать запись (shaping) или вернуть ошибку, чтобы разорвать соединение.
+	// ctx отменяется при закрытии соединения: задержка должна прерываться
+	OnBeforeDataTransfer(ctx context.Context, connCtx *ConnectionContext, direction string, bytes int64) error
+}
@@ -1,6 +1,7 @@
 package plugin
 
 import (
+	"context"
 	"fmt"
 	"sync"
 )
@@ -10,6 +11,7 @@ type Manager struct {
 	inboundPlugins  []InboundPlugin
 	outboundPlugins []OutboundPlugin
 	trafficPlugins  []TrafficPlugin
+	limiterPlugins  []LimiterPlugin
 	mu              sync.RWMutex
 }
 
@@ -19,6 +21,7 @@ func NewManager() *Manager {
 		inboundPlugins:  make([]InboundPlugin, 0),
 		outboundPlugins: make([]OutboundPlugin, 0),
 		trafficPlugins:  make([]TrafficPlugin, 0),
+		limiterPlugins:  make([]LimiterPlugin, 0),
 	}
 }
 
@@ -43,6 +46,13 @@ func (m *Manager) RegisterTrafficPlugin(plugin TrafficPlugin) {
 	m.trafficPlugins = append(m.trafficPlugins, plugin)
 }
 
+// RegisterLimiterPlugin регистрирует limiter плагин
+func (m *Manager) RegisterLimiterPlugin(plugin LimiterPlugin) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limiterPlugins = append(m.limiterPlugins, plugin)
+}
+
 // OnInboundConnection вызывает OnInboundConnection hook для всех inbound плагинов
 func (m *Manager) OnInboundConnection(ctx *ConnectionContext) error {
 	m.mu.RLock()
@@ -85,6 +95,21 @@ func (m *Manager) OnDataTransfer(ctx *ConnectionContext, direction string, bytes
 	}
 }
 
+// OnBeforeDataTransfer вызывает OnBeforeDataTransfer hook для всех limiter плагинов
+func (m *Manager) OnBeforeDataTransfer(ctx context.Context, connCtx *ConnectionContext, direction string, bytes int64) error {
+	m.mu.RLock()
+	plugins := make([]LimiterPlugin, len(m.limiterPlugins))
+	copy(plugins, m.limiterPlugins)
+	m.mu.RUnlock()
+
+	for _, plugin := range plugins {
+		if err := plugin.OnBeforeDataTransfer(ctx, connCtx, direction, bytes); err != nil {
+			return fmt.Errorf("plugin %s OnBeforeDataTransfer error: %w", plugin.Name(), err)
+		}
+	}
+	return nil
+}
+
 // OnConnectionClosed вызывает OnConnectionClosed hook для всех traffic плагинов
 func (m *Manager) OnConnectionClosed(ctx *ConnectionContext) {
 	m.mu.RLock()
@@ -122,6 +147,12 @@ func (m *Manager) Close() error {
 		}
 	}
 
+	for _, plugin := range m.limiterPlugins {
+		if err := plugin.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s close error: %w", plugin.Name(), err))
+		}
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("errors closing plugins: %v", errs)
 	}
@@ -0,0 +1,287 @@
+package connlimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/logger"
+	"example.com/me/myproxy/internal/plugin"
+	"example.com/me/myproxy/internal/ratelimit"
+)
+
+// metadataKey ключ в ConnectionContext.Metadata, отмечающий учтенное соединение
+const metadataKey = "connlimit.counted"
+
+// idleBucketTTL время, после которого неиспользуемый token bucket удаляется
+const idleBucketTTL = 5 * time.Minute
+
+// Config представляет конфигурацию плагина (0 = без ограничения)
+type Config struct {
+	MaxConnections        int     `json:"max_connections,omitempty"`          // Всего одновременных соединений
+	MaxConnectionsPerIP   int     `json:"max_connections_per_ip,omitempty"`   // Одновременных соединений с одного IP
+	MaxConnectionsPerUser int     `json:"max_connections_per_user,omitempty"` // Одновременных соединений пользователя
+	Rate                  float64 `json:"rate,omitempty"`                     // Новых соединений в секунду всего
+	Burst                 float64 `json:"burst,omitempty"`
+	RatePerIP             float64 `json:"rate_per_ip,omitempty"` // Новых соединений в секунду с одного IP
+	BurstPerIP            float64 `json:"burst_per_ip,omitempty"`
+	RatePerUser           float64 `json:"rate_per_user,omitempty"` // Новых соединений в секунду пользователя
+	BurstPerUser          float64 `json:"burst_per_user,omitempty"`
+}
+
+// bucketEntry token bucket с временем последнего использования
+type bucketEntry struct {
+	bucket   *ratelimit.TokenBucket
+	lastUsed time.Time
+}
+
+// Plugin ограничивает количество и частоту новых соединений
+// глобально, по IP клиента и по пользователю (UserID)
+type Plugin struct {
+	mu          sync.Mutex
+	cfg         Config
+	total       int
+	perIP       map[string]int
+	perUser     map[string]int
+	global      *ratelimit.TokenBucket
+	ipBuckets   map[string]*bucketEntry
+	userBuckets map[string]*bucketEntry
+	rejected    int64
+	stopChan    chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewPlugin создает новый плагин лимитов соединений
+func NewPlugin() *Plugin {
+	return &Plugin{
+		perIP:       make(map[string]int),
+		perUser:     make(map[string]int),
+		ipBuckets:   make(map[string]*bucketEntry),
+		userBuckets: make(map[string]*bucketEntry),
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Name возвращает имя плагина
+func (p *Plugin) Name() string {
+	return "connlimit"
+}
+
+// Init инициализирует плагин с конфигурацией
+func (p *Plugin) Init(config map[string]interface{}) error {
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	p.apply(cfg)
+
+	go p.cleanupLoop()
+
+	logger.Debug("plugin", "ConnLimit plugin initialized")
+	return nil
+}
+
+// Reload применяет новые лимиты, сохраняя счетчики активных соединений
+// Token buckets пересоздаются только при изменении соответствующей частоты
+func (p *Plugin) Reload(config map[string]interface{}) error {
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	p.apply(cfg)
+
+	logger.Debug("plugin", "ConnLimit plugin reloaded")
+	return nil
+}
+
+// apply заменяет конфигурацию и token buckets, частота которых изменилась
+func (p *Plugin) apply(cfg Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.global == nil || cfg.Rate != p.cfg.Rate || cfg.Burst != p.cfg.Burst {
+		p.global = nil
+		if cfg.Rate > 0 {
+			p.global = ratelimit.NewTokenBucket(cfg.Rate, cfg.Burst)
+		}
+	}
+	if cfg.RatePerIP != p.cfg.RatePerIP || cfg.BurstPerIP != p.cfg.BurstPerIP {
+		p.ipBuckets = make(map[string]*bucketEntry)
+	}
+	if cfg.RatePerUser != p.cfg.RatePerUser || cfg.BurstPerUser != p.cfg.BurstPerUser {
+		p.userBuckets = make(map[string]*bucketEntry)
+	}
+	p.cfg = cfg
+}
+
+// Close останавливает плагин
+func (p *Plugin) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.stopChan)
+		logger.Debug("plugin", "ConnLimit plugin closed")
+	})
+	return nil
+}
+
+// OnInboundConnection проверяет лимиты и отклоняет соединение при превышении
+func (p *Plugin) OnInboundConnection(ctx *plugin.ConnectionContext) error {
+	ip := clientIP(ctx.RemoteAddr)
+	userID := ctx.UserID
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if reason := p.check(ip, userID); reason != "" {
+		p.rejected++
+		logger.Debug("plugin", "ConnLimit: rejecting connection from %s (user=%q): %s", ctx.RemoteAddr, userID, reason)
+		return fmt.Errorf("%w: %s", connerr.ErrNotAllowed, reason)
+	}
+
+	p.total++
+	p.perIP[ip]++
+	if userID != "" {
+		p.perUser[userID]++
+	}
+	ctx.Metadata[metadataKey] = true
+	return nil
+}
+
+// OnDataTransfer не используется
+func (p *Plugin) OnDataTransfer(ctx *plugin.ConnectionContext, direction string, bytes int64) {
+}
+
+// OnConnectionClosed освобождает слот соединения
+func (p *Plugin) OnConnectionClosed(ctx *plugin.ConnectionContext) {
+	if counted, _ := ctx.Metadata[metadataKey].(bool); !counted {
+		return
+	}
+	delete(ctx.Metadata, metadataKey)
+
+	ip := clientIP(ctx.RemoteAddr)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.total--
+	decrement(p.perIP, ip)
+	if ctx.UserID != "" {
+		decrement(p.perUser, ctx.UserID)
+	}
+}
+
+// ActiveConnections возвращает количество активных соединений (всего, с IP, пользователя)
+func (p *Plugin) ActiveConnections(ip, userID string) (total, perIP, perUser int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total, p.perIP[ip], p.perUser[userID]
+}
+
+// Rejected возвращает количество отклоненных соединений
+func (p *Plugin) Rejected() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rejected
+}
+
+// check проверяет лимиты и возвращает причину отказа (вызывается под mu)
+// Лимиты параллельных соединений проверяются до token buckets,
+// чтобы отклоненное соединение не расходовало токены
+func (p *Plugin) check(ip, userID string) string {
+	if p.cfg.MaxConnections > 0 && p.total >= p.cfg.MaxConnections {
+		return "too many connections"
+	}
+	if p.cfg.MaxConnectionsPerIP > 0 && p.perIP[ip] >= p.cfg.MaxConnectionsPerIP {
+		return "too many connections from " + ip
+	}
+	if userID != "" && p.cfg.MaxConnectionsPerUser > 0 && p.perUser[userID] >= p.cfg.MaxConnectionsPerUser {
+		return "too many connections for user " + userID
+	}
+
+	if p.global != nil && !p.global.Allow(1) {
+		return "connection rate exceeded"
+	}
+	if p.cfg.RatePerIP > 0 && !p.entry(p.ipBuckets, ip, p.cfg.RatePerIP, p.cfg.BurstPerIP).Allow(1) {
+		return "connection rate exceeded for " + ip
+	}
+	if userID != "" && p.cfg.RatePerUser > 0 && !p.entry(p.userBuckets, userID, p.cfg.RatePerUser, p.cfg.BurstPerUser).Allow(1) {
+		return "connection rate exceeded for user " + userID
+	}
+	return ""
+}
+
+// entry возвращает token bucket по ключу, создавая его при необходимости (вызывается под mu)
+func (p *Plugin) entry(buckets map[string]*bucketEntry, key string, rate, burst float64) *ratelimit.TokenBucket {
+	e, exists := buckets[key]
+	if !exists {
+		e = &bucketEntry{bucket: ratelimit.NewTokenBucket(rate, burst)}
+		buckets[key] = e
+	}
+	e.lastUsed = time.Now()
+	return e.bucket
+}
+
+// cleanupLoop периодически удаляет неиспользуемые token buckets
+func (p *Plugin) cleanupLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.cleanup(time.Now())
+		case <-p.stopChan:
+			return
+		}
+	}
+}
+
+// cleanup удаляет token buckets, не использовавшиеся дольше idleBucketTTL
+func (p *Plugin) cleanup(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, buckets := range []map[string]*bucketEntry{p.ipBuckets, p.userBuckets} {
+		for key, e := range buckets {
+			if now.Sub(e.lastUsed) > idleBucketTTL {
+				delete(buckets, key)
+			}
+		}
+	}
+}
+
+// parseConfig разбирает конфигурацию плагина
+func parseConfig(config map[string]interface{}) (Config, error) {
+	var cfg Config
+	if config == nil {
+		return cfg, nil
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid connlimit config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid connlimit config: %w", err)
+	}
+	return cfg, nil
+}
+
+// decrement уменьшает счетчик и удаляет нулевые записи
+func decrement(counts map[string]int, key string) {
+	if counts[key] <= 1 {
+		delete(counts, key)
+		return
+	}
+	counts[key]--
+}
+
+// clientIP извлекает IP из адреса клиента
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,132 @@
+package connlimit
+
+import (
+	"errors"
+	"testing"
+
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/plugin"
+)
+
+func newPlugin(t *testing.T, config map[string]interface{}) *Plugin {
+	p := NewPlugin()
+	if err := p.Init(config); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestPlugin_MaxConnectionsPerIP(t *testing.T) {
+	p := newPlugin(t, map[string]interface{}{"max_connections_per_ip": 2})
+
+	ctx1 := plugin.NewConnectionContext("10.0.0.1:1000", "example.com:80")
+	ctx2 := plugin.NewConnectionContext("10.0.0.1:1001", "example.com:80")
+	ctx3 := plugin.NewConnectionContext("10.0.0.1:1002", "example.com:80")
+
+	if err := p.OnInboundConnection(ctx1); err != nil {
+		t.Fatalf("Expected first connection to be allowed, got %v", err)
+	}
+	if err := p.OnInboundConnection(ctx2); err != nil {
+		t.Fatalf("Expected second connection to be allowed, got %v", err)
+	}
+	if err := p.OnInboundConnection(ctx3); !errors.Is(err, connerr.ErrNotAllowed) {
+		t.Fatalf("Expected ErrNotAllowed, got %v", err)
+	}
+
+	// Другой IP не затронут
+	other := plugin.NewConnectionContext("10.0.0.2:1000", "example.com:80")
+	if err := p.OnInboundConnection(other); err != nil {
+		t.Errorf("Expected connection from other IP to be allowed, got %v", err)
+	}
+
+	// Отклоненное соединение не должно освобождать слот
+	p.OnConnectionClosed(ctx3)
+	if _, perIP, _ := p.ActiveConnections("10.0.0.1", ""); perIP != 2 {
+		t.Errorf("Expected 2 active connections, got %d", perIP)
+	}
+
+	p.OnConnectionClosed(ctx1)
+	if err := p.OnInboundConnection(ctx3); err != nil {
+		t.Errorf("Expected connection after close to be allowed, got %v", err)
+	}
+	if p.Rejected() != 1 {
+		t.Errorf("Expected 1 rejected connection, got %d", p.Rejected())
+	}
+}
+
+func TestPlugin_MaxConnectionsPerUser(t *testing.T) {
+	p := newPlugin(t, map[string]interface{}{"max_connections_per_user": 1, "max_connections": 3})
+
+	alice1 := plugin.NewConnectionContext("10.0.0.1:1000", "example.com:80")
+	alice1.UserID = "alice"
+	alice2 := plugin.NewConnectionContext("10.0.0.2:1000", "example.com:80")
+	alice2.UserID = "alice"
+
+	if err := p.OnInboundConnection(alice1); err != nil {
+		t.Fatalf("Expected connection to be allowed, got %v", err)
+	}
+	if err := p.OnInboundConnection(alice2); !errors.Is(err, connerr.ErrNotAllowed) {
+		t.Fatalf("Expected per-user limit, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		ctx := plugin.NewConnectionContext("10.0.0.3:1000", "example.com:80")
+		if err := p.OnInboundConnection(ctx); err != nil {
+			t.Fatalf("Expected connection %d to be allowed, got %v", i, err)
+		}
+	}
+	ctx := plugin.NewConnectionContext("10.0.0.4:1000", "example.com:80")
+	if err := p.OnInboundConnection(ctx); !errors.Is(err, connerr.ErrNotAllowed) {
+		t.Errorf("Expected global limit, got %v", err)
+	}
+}
+
+func TestPlugin_RatePerIP(t *testing.T) {
+	p := newPlugin(t, map[string]interface{}{"rate_per_ip": 1, "burst_per_ip": 2})
+
+	for i := 0; i < 2; i++ {
+		ctx := plugin.NewConnectionContext("10.0.0.1:1000", "example.com:80")
+		if err := p.OnInboundConnection(ctx); err != nil {
+			t.Fatalf("Expected connection %d within burst to be allowed, got %v", i, err)
+		}
+	}
+	ctx := plugin.NewConnectionContext("10.0.0.1:1000", "example.com:80")
+	if err := p.OnInboundConnection(ctx); !errors.Is(err, connerr.ErrNotAllowed) {
+		t.Errorf("Expected rate limit, got %v", err)
+	}
+}
+
+func TestPlugin_ReloadKeepsCounters(t *testing.T) {
+	p := newPlugin(t, map[string]interface{}{"max_connections_per_ip": 3})
+
+	ctx1 := plugin.NewConnectionContext("10.0.0.1:1000", "example.com:80")
+	ctx2 := plugin.NewConnectionContext("10.0.0.1:1001", "example.com:80")
+	for _, ctx := range []*plugin.ConnectionContext{ctx1, ctx2} {
+		if err := p.OnInboundConnection(ctx); err != nil {
+			t.Fatalf("Expected connection to be allowed, got %v", err)
+		}
+	}
+
+	if err := p.Reload(map[string]interface{}{"max_connections_per_ip": 2}); err != nil {
+		t.Fatalf("Reload error: %v", err)
+	}
+
+	// Соединения, установленные до перезагрузки, учитываются в новом лимите
+	ctx3 := plugin.NewConnectionContext("10.0.0.1:1002", "example.com:80")
+	if err := p.OnInboundConnection(ctx3); !errors.Is(err, connerr.ErrNotAllowed) {
+		t.Fatalf("Expected ErrNotAllowed after reload, got %v", err)
+	}
+
+	p.OnConnectionClosed(ctx1)
+	if _, perIP, _ := p.ActiveConnections("10.0.0.1", ""); perIP != 1 {
+		t.Errorf("Expected 1 active connection, got %d", perIP)
+	}
+
+	if err := p.Reload(map[string]interface{}{"max_connections_per_ip": "invalid"}); err == nil {
+		t.Error("Expected error for invalid config")
+	}
+	if err := p.OnInboundConnection(ctx3); err != nil {
+		t.Errorf("Expected previous limits to be kept after failed reload, got %v", err)
+	}
+}
@@ -0,0 +1,341 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/logger"
+	"example.com/me/myproxy/internal/plugin"
+	"example.com/me/myproxy/internal/ratelimit"
+)
+
+// DefaultSaveInterval интервал сохранения состояния квот в секундах
+const DefaultSaveInterval = 30
+
+// ErrQuotaExceeded возвращается, когда квота пользователя исчерпана
+var ErrQuotaExceeded = fmt.Errorf("traffic quota exceeded: %w", connerr.ErrNotAllowed)
+
+// Limits представляет лимиты пользователя (0 = без ограничения)
+type Limits struct {
+	DailyBytes   int64 `json:"daily_bytes,omitempty"`   // Квота байт в сутки
+	MonthlyBytes int64 `json:"monthly_bytes,omitempty"` // Квота байт в месяц
+	UploadBPS    int64 `json:"upload_bps,omitempty"`    // Скорость client -> target (байт/с)
+	DownloadBPS  int64 `json:"download_bps,omitempty"`  // Скорость target -> client (байт/с)
+}
+
+// Config представляет конфигурацию плагина квот
+type Config struct {
+	StateFile    string            `json:"state_file,omitempty"`    // Файл состояния (пусто = без сохранения)
+	SaveInterval int               `json:"save_interval,omitempty"` // Интервал сохранения (секунды, default: 30)
+	Default      Limits            `json:"default"`                 // Лимиты по умолчанию
+	Users        map[string]Limits `json:"users,omitempty"`         // Лимиты для конкретных пользователей
+}
+
+// userBuckets token buckets пользователя по направлениям
+type userBuckets struct {
+	upload   *ratelimit.TokenBucket
+	download *ratelimit.TokenBucket
+}
+
+// Plugin плагин квот трафика и ограничения скорости по пользователю
+// Пользователь определяется по UserID, а если он не установлен - по InboundID
+type Plugin struct {
+	mu        sync.Mutex
+	cfg       Config
+	usage     map[string]*Usage
+	buckets   map[string]*userBuckets
+	dirty     bool
+	now       func() time.Time
+	interval  chan time.Duration // Новый интервал сохранения после Reload
+	stopChan  chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPlugin создает новый плагин квот
+func NewPlugin() *Plugin {
+	return &Plugin{
+		usage:    make(map[string]*Usage),
+		buckets:  make(map[string]*userBuckets),
+		now:      time.Now,
+		interval: make(chan time.Duration, 1),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Name возвращает имя плагина
+func (p *Plugin) Name() string {
+	return "quota"
+}
+
+// Init инициализирует плагин и восстанавливает сохраненное состояние
+func (p *Plugin) Init(config map[string]interface{}) error {
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+
+	usage, err := loadState(cfg.StateFile)
+	if err != nil {
+		return err
+	}
+	p.cfg = cfg
+	p.usage = usage
+
+	go p.saveLoop(time.Duration(cfg.SaveInterval) * time.Second)
+
+	logger.Debug("plugin", "Quota plugin initialized (%d users restored)", len(usage))
+	return nil
+}
+
+// Reload применяет новые лимиты, сохраняя накопленное использование квот
+// При смене файла состояния текущее использование записывается в новый файл
+func (p *Plugin) Reload(config map[string]interface{}) error {
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if cfg.StateFile != p.cfg.StateFile {
+		p.dirty = true
+	}
+	if cfg.SaveInterval != p.cfg.SaveInterval {
+		select {
+		case <-p.interval:
+		default:
+		}
+		p.interval <- time.Duration(cfg.SaveInterval) * time.Second
+	}
+	if !reflect.DeepEqual(cfg.Default, p.cfg.Default) || !reflect.DeepEqual(cfg.Users, p.cfg.Users) {
+		p.buckets = make(map[string]*userBuckets)
+	}
+	p.cfg = cfg
+	p.mu.Unlock()
+
+	logger.Debug("plugin", "Quota plugin reloaded")
+	return nil
+}
+
+// Close сохраняет состояние и останавливает плагин
+func (p *Plugin) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.stopChan)
+		err = p.save()
+		logger.Debug("plugin", "Quota plugin closed")
+	})
+	return err
+}
+
+// OnInboundConnection отклоняет новые соединения при исчерпанной квоте
+func (p *Plugin) OnInboundConnection(ctx *plugin.ConnectionContext) error {
+	userID := userKey(ctx)
+	if userID == "" {
+		return nil
+	}
+
+	if p.exceeded(userID) {
+		logger.Info("plugin", "Quota exceeded for user %s, rejecting connection from %s", userID, ctx.RemoteAddr)
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// OnBeforeDataTransfer разрывает соединение при исчерпанной квоте и ограничивает скорость
+// Ожидание лимита скорости прерывается отменой ctx (закрытие соединения)
+func (p *Plugin) OnBeforeDataTransfer(ctx context.Context, connCtx *plugin.ConnectionContext, direction string, bytes int64) error {
+	userID := userKey(connCtx)
+	if userID == "" {
+		return nil
+	}
+
+	if p.exceeded(userID) {
+		logger.Debug("plugin", "Quota exceeded for user %s, closing connection to %s", userID, connCtx.TargetAddress)
+		return ErrQuotaExceeded
+	}
+
+	if bucket := p.bucket(userID, direction); bucket != nil {
+		return bucket.Wait(ctx, float64(bytes))
+	}
+	return nil
+}
+
+// OnDataTransfer учитывает переданные байты в квоте пользователя
+func (p *Plugin) OnDataTransfer(ctx *plugin.ConnectionContext, direction string, bytes int64) {
+	userID := userKey(ctx)
+	if userID == "" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u := p.currentUsage(userID)
+	u.DayBytes += bytes
+	u.MonthBytes += bytes
+	p.dirty = true
+}
+
+// OnConnectionClosed вызывается при закрытии соединения
+func (p *Plugin) OnConnectionClosed(ctx *plugin.ConnectionContext) {
+}
+
+// GetUsage возвращает копию использования квоты пользователем
+func (p *Plugin) GetUsage(userID string) Usage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return *p.currentUsage(userID)
+}
+
+// limits возвращает лимиты пользователя (вызывается под mu)
+func (p *Plugin) limits(userID string) Limits {
+	if l, ok := p.cfg.Users[userID]; ok {
+		return l
+	}
+	return p.cfg.Default
+}
+
+// exceeded проверяет, исчерпана ли квота пользователя
+func (p *Plugin) exceeded(userID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l := p.limits(userID)
+	if l.DailyBytes <= 0 && l.MonthlyBytes <= 0 {
+		return false
+	}
+
+	u := p.currentUsage(userID)
+	if l.DailyBytes > 0 && u.DayBytes >= l.DailyBytes {
+		return true
+	}
+	if l.MonthlyBytes > 0 && u.MonthBytes >= l.MonthlyBytes {
+		return true
+	}
+	return false
+}
+
+// currentUsage возвращает использование с учетом смены периодов (вызывается под mu)
+func (p *Plugin) currentUsage(userID string) *Usage {
+	now := p.now().UTC()
+	day := now.Format("2006-01-02")
+	month := now.Format("2006-01")
+
+	u, exists := p.usage[userID]
+	if !exists {
+		u = &Usage{Day: day, Month: month}
+		p.usage[userID] = u
+	}
+	if u.Day != day {
+		u.Day = day
+		u.DayBytes = 0
+		p.dirty = true
+	}
+	if u.Month != month {
+		u.Month = month
+		u.MonthBytes = 0
+		p.dirty = true
+	}
+	return u
+}
+
+// bucket возвращает token bucket пользователя для направления (nil = без ограничения)
+func (p *Plugin) bucket(userID, direction string) *ratelimit.TokenBucket {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l := p.limits(userID)
+	if l.UploadBPS <= 0 && l.DownloadBPS <= 0 {
+		return nil
+	}
+
+	b, exists := p.buckets[userID]
+	if !exists {
+		b = &userBuckets{}
+		if l.UploadBPS > 0 {
+			b.upload = ratelimit.NewTokenBucket(float64(l.UploadBPS), 0)
+		}
+		if l.DownloadBPS > 0 {
+			b.download = ratelimit.NewTokenBucket(float64(l.DownloadBPS), 0)
+		}
+		p.buckets[userID] = b
+	}
+
+	switch direction {
+	case "sent":
+		return b.upload
+	case "received":
+		return b.download
+	}
+	return nil
+}
+
+// saveLoop периодически сохраняет состояние квот
+func (p *Plugin) saveLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.save(); err != nil {
+				logger.Error("plugin", "Failed to save quota state: %v", err)
+			}
+		case interval := <-p.interval:
+			ticker.Reset(interval)
+		case <-p.stopChan:
+			return
+		}
+	}
+}
+
+// save сохраняет состояние квот, если оно изменилось
+// Без файла состояния изменения остаются помеченными, чтобы записаться после Reload с файлом
+func (p *Plugin) save() error {
+	p.mu.Lock()
+	stateFile := p.cfg.StateFile
+	if !p.dirty || stateFile == "" {
+		p.mu.Unlock()
+		return nil
+	}
+	snapshot := make(map[string]*Usage, len(p.usage))
+	for id, u := range p.usage {
+		copied := *u
+		snapshot[id] = &copied
+	}
+	p.dirty = false
+	p.mu.Unlock()
+
+	return saveState(stateFile, snapshot)
+}
+
+// parseConfig разбирает конфигурацию плагина и заполняет значения по умолчанию
+func parseConfig(config map[string]interface{}) (Config, error) {
+	var cfg Config
+	if config != nil {
+		data, err := json.Marshal(config)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid quota config: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid quota config: %w", err)
+		}
+	}
+	if cfg.SaveInterval <= 0 {
+		cfg.SaveInterval = DefaultSaveInterval
+	}
+	return cfg, nil
+}
+
+// userKey определяет пользователя соединения
+func userKey(ctx *plugin.ConnectionContext) string {
+	if ctx.UserID != "" {
+		return ctx.UserID
+	}
+	return ctx.InboundID
+}
@@ -0,0 +1,207 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/plugin"
+)
+
+func newTestContext(inboundID string) *plugin.ConnectionContext {
+	ctx := plugin.NewConnectionContext("127.0.0.1:1234", "example.com:80")
+	ctx.InboundID = inboundID
+	return ctx
+}
+
+func TestPlugin_DailyQuota(t *testing.T) {
+	p := NewPlugin()
+	err := p.Init(map[string]interface{}{
+		"default": map[string]interface{}{"daily_bytes": 100},
+	})
+	if err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	defer p.Close()
+
+	ctx := newTestContext("inbound-1")
+	if err := p.OnInboundConnection(ctx); err != nil {
+		t.Fatalf("Expected connection to be allowed, got %v", err)
+	}
+	if err := p.OnBeforeDataTransfer(context.Background(), ctx, "sent", 60); err != nil {
+		t.Fatalf("Expected transfer to be allowed, got %v", err)
+	}
+	p.OnDataTransfer(ctx, "sent", 60)
+	p.OnDataTransfer(ctx, "received", 40)
+
+	// Квота исчерпана: существующее соединение разрывается
+	if err := p.OnBeforeDataTransfer(context.Background(), ctx, "received", 10); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded mid-stream, got %v", err)
+	}
+	// и новые соединения отклоняются
+	if err := p.OnInboundConnection(newTestContext("inbound-1")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded for new connection, got %v", err)
+	}
+	// Другие пользователи не затронуты
+	if err := p.OnInboundConnection(newTestContext("inbound-2")); err != nil {
+		t.Errorf("Expected other user to be allowed, got %v", err)
+	}
+}
+
+func TestPlugin_PeriodRollover(t *testing.T) {
+	now := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+	p := NewPlugin()
+	p.now = func() time.Time { return now }
+	err := p.Init(map[string]interface{}{
+		"users": map[string]interface{}{
+			"alice": map[string]interface{}{"daily_bytes": 50, "monthly_bytes": 1000},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	defer p.Close()
+
+	ctx := newTestContext("inbound-1")
+	ctx.UserID = "alice"
+	p.OnDataTransfer(ctx, "sent", 50)
+	if err := p.OnInboundConnection(ctx); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected daily quota to be exceeded, got %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := p.OnInboundConnection(ctx); err != nil {
+		t.Errorf("Expected quota to reset on new day, got %v", err)
+	}
+	usage := p.GetUsage("alice")
+	if usage.DayBytes != 0 || usage.MonthBytes != 0 {
+		t.Errorf("Expected usage to reset on new month, got %+v", usage)
+	}
+}
+
+func TestPlugin_StatePersistence(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "quota.json")
+	config := map[string]interface{}{
+		"state_file": stateFile,
+		"default":    map[string]interface{}{"monthly_bytes": 1000},
+	}
+
+	p := NewPlugin()
+	if err := p.Init(config); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	p.OnDataTransfer(newTestContext("inbound-1"), "received", 700)
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	restored := NewPlugin()
+	if err := restored.Init(config); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	defer restored.Close()
+
+	if usage := restored.GetUsage("inbound-1"); usage.MonthBytes != 700 {
+		t.Errorf("Expected 700 bytes restored, got %d", usage.MonthBytes)
+	}
+}
+
+func TestPlugin_BandwidthLimit(t *testing.T) {
+	p := NewPlugin()
+	err := p.Init(map[string]interface{}{
+		"default": map[string]interface{}{"download_bps": 1000},
+	})
+	if err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	defer p.Close()
+
+	ctx := newTestContext("inbound-1")
+	start := time.Now()
+	// Первая 1000 байт из burst, следующие 200 ждут ~200ms
+	p.OnBeforeDataTransfer(context.Background(), ctx, "received", 1000)
+	p.OnBeforeDataTransfer(context.Background(), ctx, "received", 200)
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected transfer to be throttled, took %v", elapsed)
+	}
+
+	// Upload не ограничен
+	start = time.Now()
+	p.OnBeforeDataTransfer(context.Background(), ctx, "sent", 100000)
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected upload not to be throttled, took %v", elapsed)
+	}
+}
+
+func TestPlugin_ReloadKeepsUsage(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "quota.json")
+	p := NewPlugin()
+	if err := p.Init(map[string]interface{}{
+		"default": map[string]interface{}{"daily_bytes": 1000},
+	}); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	defer p.Close()
+
+	ctx := newTestContext("inbound-1")
+	p.OnDataTransfer(ctx, "received", 600)
+
+	// Новый лимит ниже уже использованного объема: квота должна считаться исчерпанной
+	if err := p.Reload(map[string]interface{}{
+		"state_file": stateFile,
+		"default":    map[string]interface{}{"daily_bytes": 500},
+	}); err != nil {
+		t.Fatalf("Reload error: %v", err)
+	}
+	if usage := p.GetUsage("inbound-1"); usage.DayBytes != 600 {
+		t.Errorf("Expected 600 bytes after reload, got %d", usage.DayBytes)
+	}
+	if err := p.OnInboundConnection(ctx); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded after reload, got %v", err)
+	}
+
+	// Использование, накопленное до появления файла состояния, сохраняется в новый файл
+	if err := p.save(); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	usage, err := loadState(stateFile)
+	if err != nil {
+		t.Fatalf("loadState error: %v", err)
+	}
+	if u := usage["inbound-1"]; u == nil || u.DayBytes != 600 {
+		t.Errorf("Expected 600 bytes in state file, got %+v", u)
+	}
+
+	if err := p.Reload(map[string]interface{}{"default": "invalid"}); err == nil {
+		t.Error("Expected error for invalid config")
+	}
+	if err := p.OnInboundConnection(ctx); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected previous limits to be kept after failed reload, got %v", err)
+	}
+}
+
+func TestPlugin_BandwidthWaitCancelled(t *testing.T) {
+	p := NewPlugin()
+	err := p.Init(map[string]interface{}{
+		"default": map[string]interface{}{"download_bps": 1000},
+	})
+	if err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	defer p.Close()
+
+	// Долг в 10 секунд: закрытие соединения прерывает ожидание
+	ctx := newTestContext("inbound-1")
+	transferCtx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err = p.OnBeforeDataTransfer(transferCtx, ctx, "received", 11000)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected wait to be interrupted, took %v", elapsed)
+	}
+}
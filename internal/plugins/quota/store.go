@@ -0,0 +1,82 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Usage представляет учтенный трафик пользователя за текущие периоды
+type Usage struct {
+	Day        string `json:"day"`         // Текущий день (YYYY-MM-DD)
+	DayBytes   int64  `json:"day_bytes"`   // Байт за день
+	Month      string `json:"month"`       // Текущий месяц (YYYY-MM)
+	MonthBytes int64  `json:"month_bytes"` // Байт за месяц
+}
+
+// state представляет сохраняемое состояние квот
+type state struct {
+	Users map[string]*Usage `json:"users"`
+}
+
+// loadState загружает состояние квот из файла
+// Отсутствующий файл не является ошибкой
+func loadState(path string) (map[string]*Usage, error) {
+	usage := make(map[string]*Usage)
+	if path == "" {
+		return usage, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return usage, nil
+		}
+		return nil, fmt.Errorf("failed to read quota state: %w", err)
+	}
+
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse quota state: %w", err)
+	}
+	for id, u := range st.Users {
+		if u != nil {
+			usage[id] = u
+		}
+	}
+	return usage, nil
+}
+
+// saveState атомарно сохраняет состояние квот в файл
+func saveState(path string, usage map[string]*Usage) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(state{Users: usage}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal quota state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to write quota state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to close quota state: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to replace quota state: %w", err)
+	}
+	return nil
+}
@@ -28,6 +28,7 @@ type RegisterRequest struct {
 	Location      string                 `protobuf:"bytes,2,opt,name=location,proto3" json:"location,omitempty"`
 	Capacity      int32                  `protobuf:"varint,3,opt,name=capacity,proto3" json:"capacity,omitempty"`
 	Tags          []string               `protobuf:"bytes,4,rep,name=tags,proto3" json:"tags,omitempty"`
+	Capabilities  []string               `protobuf:"bytes,5,rep,name=capabilities,proto3" json:"capabilities,omitempty"` // Поддерживаемые расширения протокола
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -90,6 +91,13 @@ func (x *RegisterRequest) GetTags() []string {
 	return nil
 }
 
+func (x *RegisterRequest) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
 // RegisterResponse представляет ответ на регистрацию
 type RegisterResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -156,6 +164,7 @@ type HeartbeatRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	DeviceId      string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
 	Timestamp     int64                  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	EgressIp      string                 `protobuf:"bytes,17,opt,name=egress_ip,json=egressIp,proto3" json:"egress_ip,omitempty"` // Публичный IP device (пусто - неизвестен)
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -204,6 +213,13 @@ func (x *HeartbeatRequest) GetTimestamp() int64 {
 	return 0
 }
 
+func (x *HeartbeatRequest) GetEgressIp() string {
+	if x != nil {
+		return x.EgressIp
+	}
+	return ""
+}
+
 // HeartbeatResponse представляет ответ heartbeat
 type HeartbeatResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -621,23 +637,80 @@ func (x *CommandResponse) GetError() string {
 	return ""
 }
 
+// DrainNotice уведомляет device о том, что POP завершает работу (draining):
+// новые соединения не назначаются, активные закрываются не позже deadline.
+// Номера полей не пересекаются с другими сообщениями, чтобы UnmarshalMessage мог определить тип
+type DrainNotice struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Deadline      int64                  `protobuf:"varint,15,opt,name=deadline,proto3" json:"deadline,omitempty"` // Unix time (секунды), после которого POP принудительно закроет соединения
+	Reason        string                 `protobuf:"bytes,16,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DrainNotice) Reset() {
+	*x = DrainNotice{}
+	mi := &file_control_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DrainNotice) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DrainNotice) ProtoMessage() {}
+
+func (x *DrainNotice) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DrainNotice.ProtoReflect.Descriptor instead.
+func (*DrainNotice) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *DrainNotice) GetDeadline() int64 {
+	if x != nil {
+		return x.Deadline
+	}
+	return 0
+}
+
+func (x *DrainNotice) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
 var File_control_proto protoreflect.FileDescriptor
 
 const file_control_proto_rawDesc = "" +
 	"\n" +
-	"\rcontrol.proto\x12\x02pb\"z\n" +
+	"\rcontrol.proto\x12\x02pb\"\x9e\x01\n" +
 	"\x0fRegisterRequest\x12\x1b\n" +
 	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x1a\n" +
 	"\blocation\x18\x02 \x01(\tR\blocation\x12\x1a\n" +
 	"\bcapacity\x18\x03 \x01(\x05R\bcapacity\x12\x12\n" +
-	"\x04tags\x18\x04 \x03(\tR\x04tags\"j\n" +
+	"\x04tags\x18\x04 \x03(\tR\x04tags\x12\"\n" +
+	"\fcapabilities\x18\x05 \x03(\tR\fcapabilities\"j\n" +
 	"\x10RegisterResponse\x12\x16\n" +
 	"\x06status\x18\x01 \x01(\tR\x06status\x12\x1b\n" +
 	"\tdevice_id\x18\x02 \x01(\tR\bdeviceId\x12!\n" +
-	"\fquic_address\x18\x03 \x01(\tR\vquicAddress\"M\n" +
+	"\fquic_address\x18\x03 \x01(\tR\vquicAddress\"j\n" +
 	"\x10HeartbeatRequest\x12\x1b\n" +
 	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x1c\n" +
-	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\"+\n" +
+	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x12\x1b\n" +
+	"\tegress_ip\x18\x11 \x01(\tR\begressIp\"+\n" +
 	"\x11HeartbeatResponse\x12\x16\n" +
 	"\x06status\x18\x01 \x01(\tR\x06status\"\xb0\x01\n" +
 	"\n" +
@@ -662,7 +735,10 @@ const file_control_proto_rawDesc = "" +
 	"\x0fCommandResponse\x12\x17\n" +
 	"\aconn_id\x18\x01 \x01(\tR\x06connId\x12\x18\n" +
 	"\asuccess\x18\x02 \x01(\bR\asuccess\x12\x14\n" +
-	"\x05error\x18\x03 \x01(\tR\x05errorB-Z+example.com/me/myproxy/internal/protocol/pbb\x06proto3"
+	"\x05error\x18\x03 \x01(\tR\x05error\"A\n" +
+	"\vDrainNotice\x12\x1a\n" +
+	"\bdeadline\x18\x0f \x01(\x03R\bdeadline\x12\x16\n" +
+	"\x06reason\x18\x10 \x01(\tR\x06reasonB-Z+example.com/me/myproxy/internal/protocol/pbb\x06proto3"
 
 var (
 	file_control_proto_rawDescOnce sync.Once
@@ -676,7 +752,7 @@ func file_control_proto_rawDescGZIP() []byte {
 	return file_control_proto_rawDescData
 }
 
-var file_control_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_control_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
 var file_control_proto_goTypes = []any{
 	(*RegisterRequest)(nil),   // 0: pb.RegisterRequest
 	(*RegisterResponse)(nil),  // 1: pb.RegisterResponse
@@ -688,6 +764,7 @@ var file_control_proto_goTypes = []any{
 	(*OpenUDP)(nil),           // 7: pb.OpenUDP
 	(*Close)(nil),             // 8: pb.Close
 	(*CommandResponse)(nil),   // 9: pb.CommandResponse
+	(*DrainNotice)(nil),       // 10: pb.DrainNotice
 }
 var file_control_proto_depIdxs = []int32{
 	6, // 0: pb.Command.open_tcp:type_name -> pb.OpenTCP
@@ -716,7 +793,7 @@ func file_control_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_control_proto_rawDesc), len(file_control_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   10,
+			NumMessages:   11,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
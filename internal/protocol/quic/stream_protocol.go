@@ -5,7 +5,9 @@ import (
 	"io"
 	"strings"
 
+	"example.com/me/myproxy/internal/connerr"
 	"example.com/me/myproxy/internal/constants"
+	"example.com/me/myproxy/internal/protocol/socks5"
 	"github.com/quic-go/quic-go"
 )
 
@@ -25,7 +27,7 @@ func WriteTargetAddress(stream *quic.Stream, address string) error {
 func ReadTargetAddress(stream *quic.Stream) (string, error) {
 	var targetAddressBytes []byte
 	buf := make([]byte, 1)
-	
+
 	for {
 		n, err := (*stream).Read(buf)
 		if n > 0 {
@@ -49,3 +51,124 @@ func ReadTargetAddress(stream *quic.Stream) (string, error) {
 	return targetAddress, nil
 }
 
+// Коды результата подключения device к target address
+const (
+	dialResultOK               = "OK"
+	dialCodeNotAllowed         = "not_allowed"
+	dialCodeNetworkUnreachable = "network_unreachable"
+	dialCodeHostUnreachable    = "host_unreachable"
+	dialCodeConnectionRefused  = "refused"
+	dialCodeTimeout            = "timeout"
+	dialCodeGeneralFailure     = "failed"
+)
+
+// DialResult сведения об установленном device соединении
+type DialResult struct {
+	ResolvedAddr string // Адрес назначения после разрешения DNS (ip:port, пусто - не сообщен)
+	EgressIP     string // Публичный IP device (пусто - неизвестен)
+}
+
+// WriteDialResult записывает результат подключения к target address в QUIC stream
+// Формат: "OK\n" или "ERR <code> <message>\n"
+func WriteDialResult(stream *quic.Stream, dialErr error) error {
+	return writeDialResult(stream, DialResult{}, dialErr)
+}
+
+// WriteDialSuccess записывает успешный результат подключения со сведениями о соединении
+// Формат: "OK <resolved_addr> [<egress_ip>]\n"
+func WriteDialSuccess(stream *quic.Stream, result DialResult) error {
+	return writeDialResult(stream, result, nil)
+}
+
+// writeDialResult записывает строку результата подключения
+func writeDialResult(stream *quic.Stream, result DialResult, dialErr error) error {
+	line := dialResultOK
+	switch {
+	case dialErr != nil:
+		message := strings.ReplaceAll(dialErr.Error(), "\n", " ")
+		line = fmt.Sprintf("ERR %s %s", dialErrorCode(dialErr), message)
+	case result.ResolvedAddr != "":
+		line = strings.TrimSpace(strings.Join([]string{dialResultOK, result.ResolvedAddr, result.EgressIP}, " "))
+	}
+	if len(line) > constants.MaxTargetAddressLen {
+		line = line[:constants.MaxTargetAddressLen]
+	}
+	if _, err := (*stream).Write([]byte(line + "\n")); err != nil {
+		return fmt.Errorf("failed to write dial result: %w", err)
+	}
+	return nil
+}
+
+// ReadDialResult читает результат подключения из QUIC stream
+// Возвращает сведения о соединении при успехе (пустые, если device их не сообщил) или *DialError
+func ReadDialResult(stream *quic.Stream) (DialResult, error) {
+	line, err := ReadTargetAddress(stream)
+	if err != nil {
+		return DialResult{}, fmt.Errorf("failed to read dial result: %w", err)
+	}
+	if fields := strings.Fields(line); len(fields) > 0 && fields[0] == dialResultOK && len(fields) <= 3 {
+		var result DialResult
+		if len(fields) > 1 {
+			result.ResolvedAddr = fields[1]
+		}
+		if len(fields) > 2 {
+			result.EgressIP = fields[2]
+		}
+		return result, nil
+	}
+	if line == "" {
+		return DialResult{}, fmt.Errorf("device closed stream without dial result")
+	}
+	if !strings.HasPrefix(line, "ERR ") {
+		return DialResult{}, fmt.Errorf("invalid dial result: %q", line)
+	}
+
+	code, message, _ := strings.Cut(strings.TrimPrefix(line, "ERR "), " ")
+	return DialResult{}, &DialError{Code: code, Message: message}
+}
+
+// DialError ошибка подключения, полученная от device
+// Unwrap возвращает соответствующую ошибку connerr для определения reply code
+type DialError struct {
+	Code    string
+	Message string
+}
+
+func (e *DialError) Error() string {
+	return "device: " + e.Message
+}
+
+// Unwrap возвращает типизированную ошибку по коду
+func (e *DialError) Unwrap() error {
+	switch e.Code {
+	case dialCodeNotAllowed:
+		return connerr.ErrNotAllowed
+	case dialCodeNetworkUnreachable:
+		return connerr.ErrNetworkUnreachable
+	case dialCodeHostUnreachable:
+		return connerr.ErrHostUnreachable
+	case dialCodeConnectionRefused:
+		return connerr.ErrConnectionRefused
+	case dialCodeTimeout:
+		return connerr.ErrTimeout
+	}
+	return nil
+}
+
+// dialErrorCode определяет код результата по ошибке подключения
+func dialErrorCode(err error) string {
+	switch socks5.ReplyCodeForError(err) {
+	case socks5.ReplyConnectionNotAllowed:
+		return dialCodeNotAllowed
+	case socks5.ReplyNetworkUnreachable:
+		return dialCodeNetworkUnreachable
+	case socks5.ReplyHostUnreachable:
+		return dialCodeHostUnreachable
+	case socks5.ReplyConnectionRefused:
+		return dialCodeConnectionRefused
+	case socks5.ReplyTTLExpired:
+		return dialCodeTimeout
+	default:
+		return dialCodeGeneralFailure
+	}
+}
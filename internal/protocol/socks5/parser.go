@@ -62,3 +62,24 @@ func ParseAddress(reader io.Reader) (string, error) {
 	}
 }
 
+
+// ParseUserPassAuth парсит запрос аутентификации по логину и паролю (RFC 1929)
+// Читает из reader: [VER=0x01, ULEN, UNAME, PLEN, PASSWD]
+func ParseUserPassAuth(reader io.Reader) (string, string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", "", fmt.Errorf("failed to read authentication request: %w", err)
+	}
+	if header[0] != 0x01 {
+		return "", "", fmt.Errorf("unsupported authentication version: %d", header[0])
+	}
+	username := make([]byte, int(header[1])+1) // UNAME + PLEN
+	if _, err := io.ReadFull(reader, username); err != nil {
+		return "", "", fmt.Errorf("failed to read username: %w", err)
+	}
+	password := make([]byte, int(username[len(username)-1]))
+	if _, err := io.ReadFull(reader, password); err != nil {
+		return "", "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return string(username[:len(username)-1]), string(password), nil
+}
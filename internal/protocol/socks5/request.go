@@ -7,48 +7,97 @@ import (
 	"strconv"
 )
 
+// Commands
+const (
+	CmdConnect      = 0x01
+	CmdUDPAssociate = 0x03
+)
+
+// Authentication methods
+const (
+	MethodNoAuth       = 0x00
+	MethodUserPass     = 0x02
+	MethodNoAcceptable = 0xFF
+)
+
 // BuildRequest строит SOCKS5 connection request
 // Формат: [VER, CMD, RSV, ATYP, address, port]
 // VER = 0x05 (SOCKS5)
 // CMD = 0x01 (CONNECT)
 // RSV = 0x00 (reserved)
 func BuildRequest(address string) ([]byte, error) {
-	host, portStr, err := net.SplitHostPort(address)
+	_, port, err := splitAddress(address)
 	if err != nil {
-		return nil, fmt.Errorf("invalid address format: %w", err)
+		return nil, err
 	}
-	port, err := strconv.Atoi(portStr)
-	if err != nil {
-		return nil, fmt.Errorf("invalid port: %w", err)
-	}
-	if port < 1 || port > 65535 {
+	if port < 1 {
 		return nil, fmt.Errorf("port out of range: %d", port)
 	}
+	return BuildCommandRequest(CmdConnect, address)
+}
+
+// BuildCommandRequest строит SOCKS5 request с командой cmd
+// Для CmdUDPAssociate address - адрес, с которого клиент будет отправлять датаграммы (0.0.0.0:0 - неизвестен)
+func BuildCommandRequest(cmd byte, address string) ([]byte, error) {
+	return AppendAddress([]byte{0x05, cmd, 0x00}, address) // VER, CMD, RSV
+}
 
-	request := []byte{0x05, 0x01, 0x00} // VER, CMD=CONNECT, RSV
+// AppendAddress добавляет к b адрес в формате SOCKS5: [ATYP, address, port]
+func AppendAddress(b []byte, address string) ([]byte, error) {
+	host, port, err := splitAddress(address)
+	if err != nil {
+		return nil, err
+	}
 
 	ip := net.ParseIP(host)
 	if ip != nil {
 		if ipv4 := ip.To4(); ipv4 != nil {
-			request = append(request, 0x01) // ATYP = IPv4
-			request = append(request, ipv4...)
+			b = append(b, 0x01) // ATYP = IPv4
+			b = append(b, ipv4...)
 		} else {
-			request = append(request, 0x04) // ATYP = IPv6
-			request = append(request, ip...)
+			b = append(b, 0x04) // ATYP = IPv6
+			b = append(b, ip.To16()...)
 		}
 	} else {
-		request = append(request, 0x03) // ATYP = Domain name
-		if len(host) > 255 {
-			return nil, fmt.Errorf("domain name too long: %s", host)
+		if len(host) == 0 || len(host) > 255 {
+			return nil, fmt.Errorf("invalid domain name length: %d", len(host))
 		}
-		request = append(request, byte(len(host)))
-		request = append(request, []byte(host)...)
+		b = append(b, 0x03) // ATYP = Domain name
+		b = append(b, byte(len(host)))
+		b = append(b, host...)
 	}
 
-	portBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(portBytes, uint16(port))
-	request = append(request, portBytes...)
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
 
-	return request, nil
+// splitAddress разбирает "host:port", порт 0-65535
+func splitAddress(address string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid address format: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port: %w", err)
+	}
+	if port < 0 || port > 65535 {
+		return "", 0, fmt.Errorf("port out of range: %d", port)
+	}
+	return host, port, nil
 }
 
+// BuildUserPassAuth строит запрос аутентификации по логину и паролю (RFC 1929)
+// Формат: [VER=0x01, ULEN, UNAME, PLEN, PASSWD]
+func BuildUserPassAuth(username, password string) ([]byte, error) {
+	if len(username) == 0 || len(username) > 255 {
+		return nil, fmt.Errorf("invalid username length: %d", len(username))
+	}
+	if len(password) > 255 {
+		return nil, fmt.Errorf("password too long: %d bytes", len(password))
+	}
+
+	request := []byte{0x01, byte(len(username))}
+	request = append(request, username...)
+	request = append(request, byte(len(password)))
+	return append(request, password...), nil
+}
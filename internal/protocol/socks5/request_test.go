@@ -0,0 +1,107 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBuildRequest(t *testing.T) {
+	t.Run("IPv4 address", func(t *testing.T) {
+		request, err := BuildRequest("192.168.1.1:80")
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+
+		if len(request) != 10 {
+			t.Errorf("Invalid request length: expected 10, got %d", len(request))
+		}
+
+		if request[0] != 0x05 || request[1] != 0x01 || request[3] != 0x01 {
+			t.Error("Invalid request format")
+		}
+
+		port := binary.BigEndian.Uint16(request[8:10])
+		if port != 80 {
+			t.Errorf("Invalid port: expected 80, got %d", port)
+		}
+	})
+
+	t.Run("domain name", func(t *testing.T) {
+		request, err := BuildRequest("example.com:443")
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+
+		if request[0] != 0x05 || request[1] != 0x01 || request[3] != 0x03 {
+			t.Error("Invalid request format")
+		}
+
+		domainLen := int(request[4])
+		domain := string(request[5 : 5+domainLen])
+		if domain != "example.com" {
+			t.Errorf("Invalid domain: expected example.com, got %s", domain)
+		}
+
+		port := binary.BigEndian.Uint16(request[5+domainLen : 5+domainLen+2])
+		if port != 443 {
+			t.Errorf("Invalid port: expected 443, got %d", port)
+		}
+	})
+
+	t.Run("invalid address", func(t *testing.T) {
+		_, err := BuildRequest("invalid")
+		if err == nil {
+			t.Error("Expected error for invalid address")
+		}
+	})
+}
+
+func TestUDPDatagram(t *testing.T) {
+	for _, address := range []string{"10.0.0.1:53", "[2001:db8::1]:53", "example.com:53"} {
+		datagram, err := BuildUDPDatagram(address, []byte("query"))
+		if err != nil {
+			t.Fatalf("Failed to build datagram for %s: %v", address, err)
+		}
+		source, payload, err := ParseUDPDatagram(datagram)
+		if err != nil {
+			t.Fatalf("Failed to parse datagram for %s: %v", address, err)
+		}
+		if source != address || string(payload) != "query" {
+			t.Errorf("Invalid datagram: got %s %q, expected %s \"query\"", source, payload, address)
+		}
+	}
+
+	if _, _, err := ParseUDPDatagram([]byte{0x00, 0x00, 0x01, 0x01, 10, 0, 0, 1, 0, 53}); err == nil {
+		t.Error("Expected error for fragmented datagram")
+	}
+}
+
+func TestErrorForReplyCode(t *testing.T) {
+	for _, reply := range []byte{ReplyConnectionNotAllowed, ReplyHostUnreachable, ReplyConnectionRefused, ReplyTTLExpired} {
+		if got := ReplyCodeForError(ErrorForReplyCode(reply)); got != reply {
+			t.Errorf("Reply code %d is not preserved: got %d", reply, got)
+		}
+	}
+	if ErrorForReplyCode(ReplySuccess) != nil {
+		t.Error("Expected nil error for success")
+	}
+}
+
+func TestUserPassAuth(t *testing.T) {
+	request, err := BuildUserPassAuth("alice", "secret")
+	if err != nil {
+		t.Fatalf("Failed to build authentication request: %v", err)
+	}
+	username, password, err := ParseUserPassAuth(bytes.NewReader(request))
+	if err != nil || username != "alice" || password != "secret" {
+		t.Errorf("Unexpected credentials: %q, %q, %v", username, password, err)
+	}
+
+	if _, _, err := ParseUserPassAuth(bytes.NewReader([]byte{0x05, 0x01, 'a', 0x00})); err == nil {
+		t.Error("Expected error for unsupported authentication version")
+	}
+	if _, _, err := ParseUserPassAuth(bytes.NewReader(request[:len(request)-1])); err == nil {
+		t.Error("Expected error for truncated password")
+	}
+}
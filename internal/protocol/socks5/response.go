@@ -1,5 +1,15 @@
 package socks5
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"example.com/me/myproxy/internal/connerr"
+)
+
 // BuildResponse строит SOCKS5 connection response
 // Формат: [VER, REP, RSV, ATYP, BND.ADDR, BND.PORT]
 // VER = 0x05 (SOCKS5)
@@ -29,3 +39,70 @@ const (
 	ReplyAddressTypeNotSupported = 0x08
 )
 
+// ReplyCodeForError определяет SOCKS5 reply code по ошибке установки соединения
+func ReplyCodeForError(err error) byte {
+	if err == nil {
+		return ReplySuccess
+	}
+
+	switch {
+	case errors.Is(err, connerr.ErrNotAllowed):
+		return ReplyConnectionNotAllowed
+	case errors.Is(err, connerr.ErrNetworkUnreachable), errors.Is(err, syscall.ENETUNREACH):
+		return ReplyNetworkUnreachable
+	case errors.Is(err, connerr.ErrHostUnreachable), errors.Is(err, syscall.EHOSTUNREACH):
+		return ReplyHostUnreachable
+	case errors.Is(err, connerr.ErrConnectionRefused), errors.Is(err, syscall.ECONNREFUSED):
+		return ReplyConnectionRefused
+	case errors.Is(err, connerr.ErrTimeout):
+		return ReplyTTLExpired
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ReplyHostUnreachable
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ReplyTTLExpired
+	}
+
+	return ReplyGeneralFailure
+}
+
+// ReadResponse читает SOCKS5 response: [VER, REP, RSV, ATYP, BND.ADDR, BND.PORT]
+// Возвращает адрес BND; если REP не успех, возвращает ошибку ErrorForReplyCode
+func ReadResponse(reader io.Reader) (string, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if header[0] != 0x05 {
+		return "", fmt.Errorf("invalid SOCKS version in response: %d", header[0])
+	}
+	if header[1] != ReplySuccess {
+		return "", ErrorForReplyCode(header[1])
+	}
+	return ParseAddress(reader)
+}
+
+// ErrorForReplyCode возвращает ошибку по reply code вышестоящего SOCKS5 прокси
+// Коды, которым соответствуют ошибки connerr, оборачивают их, чтобы клиент получил тот же reply code
+func ErrorForReplyCode(reply byte) error {
+	switch reply {
+	case ReplySuccess:
+		return nil
+	case ReplyConnectionNotAllowed:
+		return fmt.Errorf("upstream SOCKS5 proxy: %w", connerr.ErrNotAllowed)
+	case ReplyNetworkUnreachable:
+		return fmt.Errorf("upstream SOCKS5 proxy: %w", connerr.ErrNetworkUnreachable)
+	case ReplyHostUnreachable:
+		return fmt.Errorf("upstream SOCKS5 proxy: %w", connerr.ErrHostUnreachable)
+	case ReplyConnectionRefused:
+		return fmt.Errorf("upstream SOCKS5 proxy: %w", connerr.ErrConnectionRefused)
+	case ReplyTTLExpired:
+		return fmt.Errorf("upstream SOCKS5 proxy: %w", connerr.ErrTimeout)
+	default:
+		return fmt.Errorf("upstream SOCKS5 proxy: request failed with reply code %d", reply)
+	}
+}
@@ -0,0 +1,34 @@
+package socks5
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// BuildUDPDatagram строит UDP датаграмму для relay SOCKS5 прокси (RFC 1928, раздел 7)
+// Формат: [RSV(2), FRAG, ATYP, DST.ADDR, DST.PORT, DATA]
+func BuildUDPDatagram(address string, payload []byte) ([]byte, error) {
+	datagram, err := AppendAddress([]byte{0x00, 0x00, 0x00}, address) // RSV, FRAG=0
+	if err != nil {
+		return nil, err
+	}
+	return append(datagram, payload...), nil
+}
+
+// ParseUDPDatagram разбирает UDP датаграмму от relay SOCKS5 прокси
+// Возвращает адрес источника и данные; фрагментированные датаграммы не поддерживаются
+func ParseUDPDatagram(datagram []byte) (string, []byte, error) {
+	if len(datagram) < 4 {
+		return "", nil, fmt.Errorf("UDP datagram too short: %d bytes", len(datagram))
+	}
+	if datagram[2] != 0x00 {
+		return "", nil, fmt.Errorf("fragmented UDP datagram is not supported: frag %d", datagram[2])
+	}
+
+	reader := bytes.NewReader(datagram[3:])
+	address, err := ParseAddress(reader)
+	if err != nil {
+		return "", nil, err
+	}
+	return address, datagram[len(datagram)-reader.Len():], nil
+}
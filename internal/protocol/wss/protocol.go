@@ -202,6 +202,12 @@ func UnmarshalMessage(data []byte) (proto.Message, error) {
 		return &cmdResp, nil
 	}
 
+	// Пробуем DrainNotice (поля с номерами 15+ не встречаются в других сообщениях)
+	var drainNotice pb.DrainNotice
+	if err := proto.Unmarshal(data, &drainNotice); err == nil && drainNotice.Deadline > 0 {
+		return &drainNotice, nil
+	}
+
 	return nil, fmt.Errorf("unknown message type")
 }
 
@@ -0,0 +1,73 @@
+package wss
+
+import (
+	"testing"
+
+	pb "example.com/me/myproxy/internal/protocol/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUnmarshalMessage_DrainNotice(t *testing.T) {
+	data, err := proto.Marshal(&pb.DrainNotice{Deadline: 1700000000, Reason: "shutdown"})
+	if err != nil {
+		t.Fatalf("Ошибка маршалинга: %v", err)
+	}
+
+	msg, err := UnmarshalMessage(data)
+	if err != nil {
+		t.Fatalf("Ошибка определения типа: %v", err)
+	}
+	notice, ok := msg.(*pb.DrainNotice)
+	if !ok {
+		t.Fatalf("Ожидался DrainNotice, получено %T", msg)
+	}
+	if notice.Deadline != 1700000000 || notice.Reason != "shutdown" {
+		t.Errorf("Неверные поля: %+v", notice)
+	}
+}
+
+func TestUnmarshalMessage_NotDrainNotice(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  proto.Message
+	}{
+		{"heartbeat request", &pb.HeartbeatRequest{DeviceId: "device-1", Timestamp: 1700000000}},
+		{"heartbeat response", &pb.HeartbeatResponse{Status: "ok"}},
+		{"register response", &pb.RegisterResponse{Status: "ok", DeviceId: "device-1", QuicAddress: "127.0.0.1:443"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := proto.Marshal(tt.msg)
+			if err != nil {
+				t.Fatalf("Ошибка маршалинга: %v", err)
+			}
+			msg, err := UnmarshalMessage(data)
+			if err != nil {
+				t.Fatalf("Ошибка определения типа: %v", err)
+			}
+			if _, ok := msg.(*pb.DrainNotice); ok {
+				t.Errorf("%T распознан как DrainNotice", tt.msg)
+			}
+		})
+	}
+}
+
+func TestUnmarshalMessage_HeartbeatEgressIP(t *testing.T) {
+	data, err := proto.Marshal(&pb.HeartbeatRequest{DeviceId: "device-1", Timestamp: 1700000000, EgressIp: "203.0.113.7"})
+	if err != nil {
+		t.Fatalf("Ошибка маршалинга: %v", err)
+	}
+
+	msg, err := UnmarshalMessage(data)
+	if err != nil {
+		t.Fatalf("Ошибка определения типа: %v", err)
+	}
+	heartbeat, ok := msg.(*pb.HeartbeatRequest)
+	if !ok {
+		t.Fatalf("Ожидался HeartbeatRequest, получено %T", msg)
+	}
+	if heartbeat.EgressIp != "203.0.113.7" {
+		t.Errorf("Неверный egress_ip: %q", heartbeat.EgressIp)
+	}
+}
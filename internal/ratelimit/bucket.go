@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket реализует алгоритм token bucket
+// Допускает "долг": запрос больше burst не блокируется навсегда,
+// а возвращает пропорционально большее время ожидания
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Скорость пополнения (токенов в секунду)
+	burst  float64 // Максимальное количество накопленных токенов
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket создает новый token bucket
+// rate - токенов в секунду, burst - емкость (если <= 0, равна rate)
+func NewTokenBucket(rate, burst float64) *TokenBucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// refill пополняет bucket (вызывается под mu)
+func (b *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Allow списывает n токенов, если они доступны прямо сейчас
+func (b *TokenBucket) Allow(n float64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// Reserve списывает n токенов и возвращает время, которое нужно подождать
+// перед использованием
+func (b *TokenBucket) Reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 || b.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Wait списывает n токенов и блокируется до их доступности
+// Отмена ctx прерывает ожидание и возвращает ctx.Err() (списанные токены не возвращаются)
+func (b *TokenBucket) Wait(ctx context.Context, n float64) error {
+	d := b.Reserve(n)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// near проверяет, что d отличается от want не больше чем на допуск
+func near(d, want time.Duration) bool {
+	return math.Abs(float64(d-want)) <= float64(20*time.Millisecond)
+}
+
+func TestTokenBucket_ReserveDebt(t *testing.T) {
+	bucket := NewTokenBucket(100, 50)
+
+	// Накопленные токены выдаются без ожидания
+	if d := bucket.Reserve(50); d != 0 {
+		t.Errorf("Ожидание для накопленных токенов: %v", d)
+	}
+	// Запрос больше burst не отклоняется, а уходит в долг
+	if d := bucket.Reserve(100); !near(d, time.Second) {
+		t.Errorf("Ожидание для долга 100 токенов: %v, ожидалось около 1s", d)
+	}
+	// Долг учитывается следующими запросами
+	if bucket.Allow(1) {
+		t.Error("Allow выдал токен при долге")
+	}
+	if d := bucket.Reserve(50); !near(d, 1500*time.Millisecond) {
+		t.Errorf("Ожидание с учетом долга: %v, ожидалось около 1.5s", d)
+	}
+}
+
+func TestTokenBucket_Refill(t *testing.T) {
+	bucket := NewTokenBucket(100, 50)
+	bucket.Reserve(50)
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	start := bucket.last
+	tokens := bucket.tokens
+
+	// Пополнение пропорционально прошедшему времени
+	bucket.refill(start.Add(200 * time.Millisecond))
+	if got := bucket.tokens - tokens; math.Abs(got-20) > 1e-6 {
+		t.Errorf("За 200ms пополнено %g токенов, ожидалось 20", got)
+	}
+	// Время назад (например, коррекция часов) не уменьшает токены
+	before := bucket.tokens
+	bucket.refill(start)
+	if bucket.tokens != before {
+		t.Errorf("Токены изменились при отрицательном интервале: %g -> %g", before, bucket.tokens)
+	}
+	// Накопление ограничено burst
+	bucket.refill(start.Add(time.Hour))
+	if bucket.tokens != 50 {
+		t.Errorf("Токенов после долгого простоя %g, ожидалось 50", bucket.tokens)
+	}
+}
+
+func TestTokenBucket_Wait(t *testing.T) {
+	bucket := NewTokenBucket(10, 10)
+
+	// Без долга Wait не блокируется
+	start := time.Now()
+	if err := bucket.Wait(context.Background(), 10); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Wait без долга занял %v", elapsed)
+	}
+
+	// Ожидание пополнения
+	start = time.Now()
+	if err := bucket.Wait(context.Background(), 1); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Wait вернулся через %v, до пополнения токена", elapsed)
+	}
+}
+
+func TestTokenBucket_WaitCanceled(t *testing.T) {
+	bucket := NewTokenBucket(1, 1)
+	bucket.Reserve(1)
+
+	// Долг в 100 секунд: отмена ctx прерывает ожидание сразу
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err := bucket.Wait(ctx, 100)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Ожидалась context.Canceled, получено %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Wait вернулся через %v после отмены", elapsed)
+	}
+
+	// Уже отмененный ctx
+	if err := bucket.Wait(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Ожидалась context.Canceled для отмененного ctx, получено %v", err)
+	}
+}
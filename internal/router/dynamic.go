@@ -1,15 +1,32 @@
 package router
 
 import (
+	"errors"
+	"math/rand"
+
 	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/cluster"
 	"example.com/me/myproxy/internal/device"
 	"example.com/me/myproxy/internal/plugin"
 )
 
+// ErrNoDeviceAvailable возвращается при повторной попытке, когда все подходящие устройства
+// пула уже исключены: соединение не должно уходить через статический outbound
+var ErrNoDeviceAvailable = errors.New("no device available")
+
+// RemoteDirectory устройства, подключенные к другим POP кластера
+type RemoteDirectory interface {
+	RemoteDevices(criteria *device.DeviceCriteria) []*cluster.RemoteDevice
+}
+
 // DynamicRouter реализует Router с выбором из пула устройств
 type DynamicRouter struct {
 	registry *device.Registry
 	strategy Strategy
+
+	// Устройства других POP кластера (nil - только локальные) и надбавка к их стоимости
+	remote        RemoteDirectory
+	remotePenalty int
 }
 
 // NewDynamicRouter создает новый Dynamic Router
@@ -20,6 +37,15 @@ func NewDynamicRouter(registry *device.Registry, strategy Strategy) *DynamicRout
 	}
 }
 
+// SetRemote подключает устройства других POP кластера
+// Стоимость устройства - количество активных соединений через него, к стоимости
+// устройства другого POP добавляется penalty. Устройство другого POP выбирается,
+// только если оно дешевле устройства, выбранного стратегией среди локальных
+func (d *DynamicRouter) SetRemote(remote RemoteDirectory, penalty int) {
+	d.remote = remote
+	d.remotePenalty = penalty
+}
+
 // SelectOutbound выбирает outbound из пула устройств
 func (d *DynamicRouter) SelectOutbound(
 	ctx *plugin.ConnectionContext,
@@ -28,18 +54,26 @@ func (d *DynamicRouter) SelectOutbound(
 	currentOutboundConfig *config.OutboundConfig,
 ) (string, *config.OutboundConfig, error) {
 	// Создаем критерии поиска
+	// Устройства, подключение через которые уже не удалось, исключаются (повторная попытка)
 	criteria := device.NewDeviceCriteria()
+	criteria.Exclude = ctx.FailedOutbounds()
 
 	// Выбираем устройство через стратегию
 	selectedDevice, err := d.strategy.Select(d.registry, criteria, targetAddress)
-	if err != nil {
-		// Fallback на статический outbound если пул пуст
-		return "", nil, nil
+	// Проверяем что reverse connection активна и устройство не в draining/quarantined
+	if err != nil || !selectedDevice.IsAvailable() {
+		selectedDevice = nil
 	}
 
-	// Проверяем что reverse connection активна
-	if !selectedDevice.IsOnline() {
-		// Fallback на статический outbound
+	if remote := d.selectRemote(criteria, selectedDevice); remote != nil {
+		return remote.ID, nil, nil
+	}
+
+	if selectedDevice == nil {
+		if len(criteria.Exclude) > 0 {
+			return "", nil, ErrNoDeviceAvailable
+		}
+		// Fallback на статический outbound если пул пуст
 		return "", nil, nil
 	}
 
@@ -47,3 +81,27 @@ func (d *DynamicRouter) SelectOutbound(
 	return selectedDevice.ID, nil, nil
 }
 
+// selectRemote возвращает наименее загруженное устройство другого POP,
+// если его стоимость с надбавкой меньше стоимости локального устройства local (nil - нет локального)
+func (d *DynamicRouter) selectRemote(criteria *device.DeviceCriteria, local *device.Device) *cluster.RemoteDevice {
+	if d.remote == nil {
+		return nil
+	}
+
+	var best []*cluster.RemoteDevice
+	for _, candidate := range d.remote.RemoteDevices(criteria) {
+		if len(best) == 0 || candidate.Load < best[0].Load {
+			best = []*cluster.RemoteDevice{candidate}
+		} else if candidate.Load == best[0].Load {
+			best = append(best, candidate)
+		}
+	}
+	if len(best) == 0 {
+		return nil
+	}
+	if local != nil && local.StreamCount() <= best[0].Load+d.remotePenalty {
+		return nil
+	}
+	// Равнозагруженные устройства выбираются случайно, чтобы распределить соединения
+	return best[rand.Intn(len(best))]
+}
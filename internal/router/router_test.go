@@ -1,9 +1,16 @@
 package router
 
 import (
+	"errors"
+
 	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/cluster"
+	"example.com/me/myproxy/internal/device"
 	"example.com/me/myproxy/internal/plugin"
 	"testing"
+
+	"github.com/quic-go/quic-go"
+	"nhooyr.io/websocket"
 )
 
 func TestStaticRouter_SelectOutbound(t *testing.T) {
@@ -29,3 +36,81 @@ func TestStaticRouter_SelectOutbound(t *testing.T) {
 	}
 }
 
+
+// fakeDirectory устройства других POP для тестов
+type fakeDirectory []*cluster.RemoteDevice
+
+func (f fakeDirectory) RemoteDevices(criteria *device.DeviceCriteria) []*cluster.RemoteDevice {
+	return f
+}
+
+func TestDynamicRouter_RemoteDevices(t *testing.T) {
+	registry := device.NewRegistry(30, 90)
+	defer registry.Close()
+	rtr := NewDynamicRouter(registry, NewRoundRobinStrategy())
+	ctx := plugin.NewConnectionContext("127.0.0.1:1234", "example.com:80")
+
+	// Без кластера и локальных устройств используется текущий outbound
+	if outboundID, _, _ := rtr.SelectOutbound(ctx, "example.com:80", "out", nil); outboundID != "" {
+		t.Errorf("Ожидался текущий outbound, получено %s", outboundID)
+	}
+
+	// Выбирается наименее загруженное устройство другого POP
+	rtr.SetRemote(fakeDirectory{
+		{DeviceInfo: cluster.DeviceInfo{ID: "busy", Load: 5}, NodeID: "b"},
+		{DeviceInfo: cluster.DeviceInfo{ID: "idle", Load: 1}, NodeID: "c"},
+	}, 100)
+	if outboundID, _, _ := rtr.SelectOutbound(ctx, "example.com:80", "out", nil); outboundID != "idle" {
+		t.Errorf("Ожидалось устройство idle, получено %q", outboundID)
+	}
+}
+
+func TestDynamicRouter_AllDevicesExcluded(t *testing.T) {
+	registry := device.NewRegistry(30, 90)
+	defer registry.Close()
+	only, err := registry.RegisterWithWSS("only", "10.0.0.1:5000", nil, nil)
+	if err != nil {
+		t.Fatalf("RegisterWithWSS: %v", err)
+	}
+	// Соединения - заглушки, поэтому перед Close registry они убираются
+	only.WSSConn, only.QUICConn = &websocket.Conn{}, &quic.Conn{}
+	defer func() { only.WSSConn, only.QUICConn = nil, nil }()
+	rtr := NewDynamicRouter(registry, NewRoundRobinStrategy())
+	ctx := plugin.NewConnectionContext("127.0.0.1:1234", "example.com:80")
+
+	if outboundID, _, err := rtr.SelectOutbound(ctx, "example.com:80", "out", nil); err != nil || outboundID != "only" {
+		t.Fatalf("Ожидалось устройство only, получено %q, %v", outboundID, err)
+	}
+
+	// После неудачной попытки через единственное устройство соединение не уходит через текущий outbound
+	ctx.AddDialAttempt("only", errors.New("dial failed"), 0)
+	if outboundID, _, err := rtr.SelectOutbound(ctx, "example.com:80", "out", nil); !errors.Is(err, ErrNoDeviceAvailable) {
+		t.Errorf("Ожидалась ошибка ErrNoDeviceAvailable, получено %q, %v", outboundID, err)
+	}
+}
+
+func TestRoundRobinStrategy_PrefersHealthy(t *testing.T) {
+	registry := device.NewRegistry(30, 90)
+	defer registry.Close()
+	for _, id := range []string{"good", "bad"} {
+		if _, err := registry.RegisterWithWSS(id, "10.0.0.1:5000", nil, nil); err != nil {
+			t.Fatalf("RegisterWithWSS: %v", err)
+		}
+	}
+	good, _ := registry.GetDevice("good")
+	bad, _ := registry.GetDevice("bad")
+	for i := 0; i < 20; i++ {
+		good.RecordDial(true, 0)
+		bad.RecordDial(i%5 == 0, 0) // 20% успешных подключений
+	}
+
+	// Деградировавшее устройство не выбирается, пока есть более здоровое
+	healthy := preferHealthy(registry, []*device.Device{bad, good})
+	if len(healthy) != 1 || healthy[0] != good {
+		t.Errorf("Ожидалось только устройство good, получено %v", healthy)
+	}
+	// Без более здоровых деградировавшее устройство остается кандидатом
+	if healthy := preferHealthy(registry, []*device.Device{bad}); len(healthy) != 1 {
+		t.Errorf("Единственное устройство должно остаться кандидатом, получено %v", healthy)
+	}
+}
@@ -0,0 +1,140 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/acl"
+	"example.com/me/myproxy/internal/constants"
+	"example.com/me/myproxy/internal/logger"
+	"example.com/me/myproxy/internal/plugin"
+)
+
+// rule правило выбора outbound по домену или подсети назначения
+type rule struct {
+	domains  []string
+	nets     []*net.IPNet
+	outbound *config.OutboundConfig
+}
+
+// RuleRouter выбирает outbound первого правила, которому соответствует назначение,
+// остальные соединения передает следующему router
+type RuleRouter struct {
+	rules    []rule
+	resolver acl.Resolver // Разрешение доменов назначения для правил подсетей (nil - домены не проверяются по IP)
+	next     Router
+}
+
+// NewRuleRouter создает Rule Router по правилам конфигурации
+// next выбирает outbound для соединений, не совпавших ни с одним правилом
+func NewRuleRouter(cfg *config.RoutingConfig, resolver acl.Resolver, next Router) (*RuleRouter, error) {
+	r := &RuleRouter{resolver: resolver, next: next}
+	for i := range cfg.Rules {
+		ruleCfg := &cfg.Rules[i]
+		nets, err := acl.ParseCIDRs(ruleCfg.IPs)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		domains := make([]string, 0, len(ruleCfg.Domains))
+		for _, domain := range ruleCfg.Domains {
+			domains = append(domains, normalizeDomain(domain))
+		}
+		r.rules = append(r.rules, rule{domains: domains, nets: nets, outbound: &ruleCfg.Outbound})
+	}
+	return r, nil
+}
+
+// SelectOutbound возвращает конфигурацию outbound совпавшего правила
+// Домен назначения сопоставляется с подсетями по всем его IP от resolver;
+// домен, который не удалось разрешить, с подсетями не совпадает
+func (r *RuleRouter) SelectOutbound(
+	ctx *plugin.ConnectionContext,
+	targetAddress string,
+	currentOutboundID string,
+	currentOutboundConfig *config.OutboundConfig,
+) (string, *config.OutboundConfig, error) {
+	host, _, err := net.SplitHostPort(targetAddress)
+	if err != nil {
+		host = targetAddress
+	}
+
+	var ips []net.IP
+	domain := ""
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		domain = normalizeDomain(host)
+	}
+	// Домен разрешается только при первом правиле с подсетями
+	resolved := domain == ""
+
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if domain != "" && rule.matchDomain(domain) {
+			logger.Debug("router", "Target %s matched routing rule %d by domain", targetAddress, i)
+			return "", rule.outbound, nil
+		}
+		if len(rule.nets) == 0 {
+			continue
+		}
+		if !resolved {
+			ips = r.lookup(host)
+			resolved = true
+		}
+		if rule.matchIPs(ips) {
+			logger.Debug("router", "Target %s matched routing rule %d by address", targetAddress, i)
+			return "", rule.outbound, nil
+		}
+	}
+
+	return r.next.SelectOutbound(ctx, targetAddress, currentOutboundID, currentOutboundConfig)
+}
+
+// lookup разрешает домен назначения для правил подсетей
+func (r *RuleRouter) lookup(host string) []net.IP {
+	if r.resolver == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultDNSTimeout*time.Second)
+	defer cancel()
+	ips, err := r.resolver.LookupIP(ctx, host)
+	if err != nil {
+		logger.Debug("router", "Failed to resolve %s for routing rules: %v", host, err)
+		return nil
+	}
+	return ips
+}
+
+// matchDomain проверяет совпадение домена или поддомена
+func (r *rule) matchDomain(host string) bool {
+	for _, domain := range r.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIPs проверяет, входит ли хотя бы один из адресов в подсети правила
+func (r *rule) matchIPs(ips []net.IP) bool {
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		for _, n := range r.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeDomain приводит домен к нижнему регистру без завершающей точки
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
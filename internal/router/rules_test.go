@@ -0,0 +1,116 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/plugin"
+)
+
+// stubResolver разрешает домены по таблице и считает запросы
+type stubResolver struct {
+	hosts   map[string][]net.IP
+	lookups int
+}
+
+func (r *stubResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	r.lookups++
+	ips, ok := r.hosts[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return ips, nil
+}
+
+// fixedRouter всегда выбирает одно устройство пула
+type fixedRouter string
+
+func (f fixedRouter) SelectOutbound(ctx *plugin.ConnectionContext, targetAddress, currentOutboundID string, currentOutboundConfig *config.OutboundConfig) (string, *config.OutboundConfig, error) {
+	return string(f), nil, nil
+}
+
+func TestRuleRouter_SelectOutbound(t *testing.T) {
+	resolver := &stubResolver{hosts: map[string][]net.IP{
+		"internal.corp":   {net.ParseIP("10.1.2.3")},
+		"dual.example":    {net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.10")},
+		"public.example":  {net.ParseIP("203.0.113.5")},
+		"blocked.example": {net.ParseIP("198.51.100.1")},
+	}}
+	rtr, err := NewRuleRouter(&config.RoutingConfig{Rules: []config.RoutingRuleConfig{
+		{Domains: []string{"Ads.Example."}, Outbound: config.OutboundConfig{Type: "block", ID: "ads"}},
+		{IPs: []string{"10.0.0.0/8", "192.0.2.0/24"}, Outbound: config.OutboundConfig{Type: "direct", ID: "lan"}},
+		{IPs: []string{"198.51.100.1"}, Outbound: config.OutboundConfig{Type: "block", ID: "host"}},
+	}}, resolver, fixedRouter("device-1"))
+	if err != nil {
+		t.Fatalf("NewRuleRouter: %v", err)
+	}
+
+	tests := []struct {
+		target     string
+		outboundID string // ID outbound совпавшего правила
+		deviceID   string // Устройство следующего router (без совпадений)
+	}{
+		{"ads.example:443", "ads", ""},
+		{"tracker.ads.example:80", "ads", ""},
+		{"badads.example:80", "", "device-1"},
+		{"10.20.30.40:22", "lan", ""},
+		{"[2001:db8::1]:443", "", "device-1"},
+		{"internal.corp:443", "lan", ""},
+		{"dual.example:443", "lan", ""},
+		{"blocked.example:25", "host", ""},
+		{"public.example:443", "", "device-1"},
+		{"unknown.example:443", "", "device-1"},
+	}
+	for _, tt := range tests {
+		ctx := plugin.NewConnectionContext("127.0.0.1:1234", tt.target)
+		deviceID, outboundConfig, err := rtr.SelectOutbound(ctx, tt.target, "", nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.target, err)
+			continue
+		}
+		if deviceID != tt.deviceID {
+			t.Errorf("%s: устройство %q, ожидалось %q", tt.target, deviceID, tt.deviceID)
+		}
+		gotID := ""
+		if outboundConfig != nil {
+			gotID = outboundConfig.ID
+		}
+		if gotID != tt.outboundID {
+			t.Errorf("%s: outbound %q, ожидался %q", tt.target, gotID, tt.outboundID)
+		}
+	}
+}
+
+func TestRuleRouter_ResolvesOnlyForIPRules(t *testing.T) {
+	resolver := &stubResolver{hosts: map[string][]net.IP{"example.com": {net.ParseIP("192.0.2.1")}}}
+	rtr, err := NewRuleRouter(&config.RoutingConfig{Rules: []config.RoutingRuleConfig{
+		{Domains: []string{"example.com"}, Outbound: config.OutboundConfig{Type: "direct"}},
+	}}, resolver, NewStaticRouter())
+	if err != nil {
+		t.Fatalf("NewRuleRouter: %v", err)
+	}
+
+	ctx := plugin.NewConnectionContext("127.0.0.1:1234", "other.com:80")
+	if _, outboundConfig, _ := rtr.SelectOutbound(ctx, "other.com:80", "", nil); outboundConfig != nil {
+		t.Errorf("Ожидался текущий outbound, получено %v", outboundConfig)
+	}
+	if _, outboundConfig, _ := rtr.SelectOutbound(ctx, "example.com:80", "", nil); outboundConfig == nil {
+		t.Error("Правило по домену не совпало")
+	}
+	// Без правил подсетей домены назначения не разрешаются
+	if resolver.lookups != 0 {
+		t.Errorf("Запросов к resolver: %d, ожидалось 0", resolver.lookups)
+	}
+}
+
+func TestNewRuleRouter_InvalidCIDR(t *testing.T) {
+	_, err := NewRuleRouter(&config.RoutingConfig{Rules: []config.RoutingRuleConfig{
+		{IPs: []string{"10.0.0.0/33"}, Outbound: config.OutboundConfig{Type: "direct"}},
+	}}, nil, NewStaticRouter())
+	if err == nil {
+		t.Error("Ожидалась ошибка для некорректной подсети")
+	}
+}
@@ -11,6 +11,9 @@ import (
 type Strategy interface {
 	// Select выбирает устройство из registry по критериям
 	// Работает с registry напрямую, не получает список устройств (масштабируемо)
+	// Выбирает только устройства, доступные для новых соединений (GetAvailableDevices, Device.IsAvailable):
+	// draining и quarantined устройства не выбираются, устройства с низкой оценкой здоровья
+	// (Registry.DeviceHealth) выбираются, только если нет более здоровых (preferHealthy)
 	Select(registry *device.Registry, criteria *device.DeviceCriteria, targetAddress string) (*device.Device, error)
 }
 
@@ -32,6 +35,7 @@ func (r *RoundRobinStrategy) Select(registry *device.Registry, criteria *device.
 	if len(devices) == 0 {
 		return nil, fmt.Errorf("no available devices matching criteria")
 	}
+	devices = preferHealthy(registry, devices)
 
 	r.mu.Lock()
 	index := int(r.index % int64(len(devices)))
@@ -41,3 +45,24 @@ func (r *RoundRobinStrategy) Select(registry *device.Registry, criteria *device.
 	return devices[index], nil
 }
 
+
+// degradedScoreRatio доля от лучшей оценки здоровья, ниже которой устройство считается деградировавшим
+const degradedScoreRatio = 0.5
+
+// preferHealthy исключает деградировавшие устройства, если есть более здоровые
+func preferHealthy(registry *device.Registry, devices []*device.Device) []*device.Device {
+	scores := make([]float64, len(devices))
+	best := 0.0
+	for i, dev := range devices {
+		scores[i] = registry.DeviceHealth(dev).Score
+		best = max(best, scores[i])
+	}
+
+	healthy := make([]*device.Device, 0, len(devices))
+	for i, dev := range devices {
+		if scores[i] >= best*degradedScoreRatio {
+			healthy = append(healthy, dev)
+		}
+	}
+	return healthy
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"example.com/me/myproxy/internal/admin"
+	"example.com/me/myproxy/internal/device"
+)
+
+// deviceView состояние устройства в ответе GET /devices
+type deviceView struct {
+	*device.Record
+	Status  device.DeviceStatus `json:"status"`
+	Streams int                 `json:"streams"`
+	Health  *device.HealthScore `json:"health"`
+}
+
+// statusRequest тело запроса PUT /devices/{id}/status
+type statusRequest struct {
+	Status   string `json:"status"` // "draining", "quarantined" или "online" (снять состояние)
+	Reason   string `json:"reason,omitempty"`
+	Duration int    `json:"duration,omitempty"` // Срок состояния в секундах (0 - бессрочно)
+}
+
+// handleDevices обрабатывает GET /devices административного API
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	devices := s.deviceRegistry.ListDevices()
+	views := make([]*deviceView, 0, len(devices))
+	for _, dev := range devices {
+		views = append(views, s.viewDevice(dev))
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].ID < views[j].ID })
+	admin.WriteJSON(w, http.StatusOK, views)
+}
+
+// handleDeviceStatus обрабатывает PUT /devices/{id}/status административного API
+func (s *Server) handleDeviceStatus(w http.ResponseWriter, r *http.Request) {
+	var req statusRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	status, err := device.ParseDeviceStatus(req.Status)
+	if err == nil && status == device.StatusOffline {
+		err = fmt.Errorf("status offline cannot be set")
+	}
+	if err == nil && req.Duration < 0 {
+		err = fmt.Errorf("duration must not be negative")
+	}
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	id := r.PathValue("id")
+	if err := s.deviceRegistry.SetStatus(id, status, req.Reason, time.Duration(req.Duration)*time.Second); err != nil {
+		admin.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	dev, err := s.deviceRegistry.GetDevice(id)
+	if err != nil {
+		admin.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, s.viewDevice(dev))
+}
+
+// viewDevice возвращает состояние устройства для административного API
+func (s *Server) viewDevice(dev *device.Device) *deviceView {
+	return &deviceView{
+		Record:  dev.Record(),
+		Status:  dev.CurrentStatus(),
+		Streams: dev.StreamCount(),
+		Health:  s.deviceRegistry.DeviceHealth(dev),
+	}
+}
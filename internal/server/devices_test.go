@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/me/myproxy/internal/device"
+)
+
+func TestServer_DeviceStatusAPI(t *testing.T) {
+	registry := device.NewRegistry(30, 90)
+	defer registry.Close()
+	if _, err := registry.RegisterWithWSS("d1", "10.0.0.1:5000", nil, nil); err != nil {
+		t.Fatalf("RegisterWithWSS: %v", err)
+	}
+	s := &Server{deviceRegistry: registry}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /devices", s.handleDevices)
+	mux.HandleFunc("PUT /devices/{id}/status", s.handleDeviceStatus)
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return recorder
+	}
+
+	resp := request("PUT", "/devices/d1/status", `{"status": "quarantined", "reason": "captive portal", "duration": 600}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Ожидался 200, получено %d %s", resp.Code, resp.Body)
+	}
+	hold := registry.ListDevices()[0].GetHold()
+	if hold == nil || hold.Status != device.StatusQuarantined || hold.Reason != "captive portal" || hold.Expires.IsZero() {
+		t.Errorf("Состояние не задано: %+v", hold)
+	}
+
+	resp = request("GET", "/devices", "")
+	var views []map[string]interface{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &views); err != nil || len(views) != 1 {
+		t.Fatalf("Неверный ответ GET /devices: %s", resp.Body)
+	}
+	// Устройство без соединений offline, но состояние quarantined видно
+	if views[0]["status"] != "offline" || views[0]["hold"].(map[string]interface{})["status"] != "quarantined" {
+		t.Errorf("Неверное состояние устройства: %v", views[0])
+	}
+
+	tests := []struct {
+		path, body string
+		code       int
+	}{
+		{"/devices/d1/status", `{"status": "offline"}`, http.StatusBadRequest},
+		{"/devices/d1/status", `{"status": "broken"}`, http.StatusBadRequest},
+		{"/devices/d1/status", `{"status": "draining", "duration": -1}`, http.StatusBadRequest},
+		{"/devices/d1/status", `{"state": "draining"}`, http.StatusBadRequest},
+		{"/devices/missing/status", `{"status": "draining"}`, http.StatusNotFound},
+		{"/devices/d1/status", `{"status": "online"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if resp := request("PUT", tt.path, tt.body); resp.Code != tt.code {
+			t.Errorf("PUT %s %s: ожидался %d, получено %d %s", tt.path, tt.body, tt.code, resp.Code, resp.Body)
+		}
+	}
+	if hold := registry.ListDevices()[0].GetHold(); hold != nil {
+		t.Errorf("Состояние должно быть снято: %+v", hold)
+	}
+}
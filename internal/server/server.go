@@ -1,48 +1,156 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"example.com/me/myproxy/config"
 	"example.com/me/myproxy/inbound"
+	"example.com/me/myproxy/internal/acl"
+	"example.com/me/myproxy/internal/admin"
+	"example.com/me/myproxy/internal/cluster"
 	"example.com/me/myproxy/internal/constants"
 	"example.com/me/myproxy/internal/device"
 	"example.com/me/myproxy/internal/device/quic"
+	"example.com/me/myproxy/internal/device/store"
 	"example.com/me/myproxy/internal/device/wss"
+	"example.com/me/myproxy/internal/dns"
 	"example.com/me/myproxy/internal/logger"
 	"example.com/me/myproxy/internal/plugin"
+	"example.com/me/myproxy/internal/plugins/connlimit"
+	"example.com/me/myproxy/internal/plugins/quota"
 	"example.com/me/myproxy/internal/plugins/traffic"
 	"example.com/me/myproxy/internal/router"
 	tlsconfig "example.com/me/myproxy/internal/tls"
+	"example.com/me/myproxy/internal/upgrade"
 	"example.com/me/myproxy/outbound"
 	"example.com/me/myproxy/proxy"
 )
 
 // Server представляет proxy server
 type Server struct {
+	// mu защищает компоненты, заменяемые при перезагрузке конфигурации
+	// (cfg, inbound, outbound, pluginManager, plugins, destPolicy, resolver, outbounds, connOptions)
+	mu       sync.RWMutex
+	reloadMu sync.Mutex // Сериализует перезагрузки
+
 	cfg            *config.Config
 	inbound        inbound.Inbound
 	outbound       outbound.Outbound
 	pluginManager  *plugin.Manager
+	plugins        map[string]*pluginInstance
 	router         router.Router
 	outboundPool   *outbound.Pool
 	deviceRegistry *device.Registry
 	wssServer      *wss.Server
 	quicServer     *quic.Server
+	cluster        *cluster.Node
+	destPolicy     *acl.Policy
+	resolver       *dns.Resolver      // Разрешение доменов на POP (политика "local", проверка IP доменов)
+	outbounds      *outboundFactory   // Создание outbound текущей конфигурации
+	connOptions    *proxy.ConnOptions // Обработка соединений с компонентами текущей конфигурации
+	certStore      *tlsconfig.CertStore
+	admin          *admin.Server
+	handler        inbound.Handler
+	conns          *connTracker
+	stopping       atomic.Bool
+	upgrader       *upgrade.Upgrader
+	upgraded       atomic.Bool // Соединения передаются новому процессу (причина drain - upgrade)
+
+	// ctx отменяется при остановке server: прерывает незавершенные подключения outbound
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// ErrServerDraining возвращается для соединений, пришедших во время остановки server
+var ErrServerDraining = errors.New("server is shutting down")
+
+// staticRouter используется вместо пула, когда outbound сам подключается через устройства
+var staticRouter = router.NewStaticRouter()
+
+const (
+	// forceCloseWait время ожидания завершения обработчиков после принудительного закрытия соединений
+	forceCloseWait = 5 * time.Second
+	// upgradeReadyTimeout время ожидания готовности нового процесса при обновлении бинарника
+	upgradeReadyTimeout = 30 * time.Second
+	// deviceProbeTimeout время проверки устройства подключением через него (см. probeDevice)
+	deviceProbeTimeout = 10 * time.Second
+)
+
+// pluginInstance созданный плагин вместе с конфигурацией, с которой он инициализирован
+type pluginInstance struct {
+	cfg    *config.PluginConfig
+	plugin plugin.Plugin
+}
+
+// pluginFactory описывает плагин, включаемый через конфигурацию
+type pluginFactory struct {
+	name   string
+	config func(cfg *config.PluginsConfig) *config.PluginConfig
+	create func() plugin.Plugin
+}
+
+// pluginFactories известные плагины в порядке регистрации
+var pluginFactories = []pluginFactory{
+	{
+		name:   "traffic_inbound",
+		config: func(cfg *config.PluginsConfig) *config.PluginConfig { return cfg.TrafficInbound },
+		create: func() plugin.Plugin { return traffic.NewInboundCounter() },
+	},
+	{
+		name:   "traffic_outbound",
+		config: func(cfg *config.PluginsConfig) *config.PluginConfig { return cfg.TrafficOutbound },
+		create: func() plugin.Plugin { return traffic.NewOutboundCounter() },
+	},
+	{
+		name:   "quota",
+		config: func(cfg *config.PluginsConfig) *config.PluginConfig { return cfg.Quota },
+		create: func() plugin.Plugin { return quota.NewPlugin() },
+	},
+	{
+		name:   "connlimit",
+		config: func(cfg *config.PluginsConfig) *config.PluginConfig { return cfg.ConnLimit },
+		create: func() plugin.Plugin { return connlimit.NewPlugin() },
+	},
 }
 
 // NewServer создает новый server
 func NewServer(cfg *config.Config) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Server{
-		cfg: cfg,
+		cfg:    cfg,
+		conns:  newConnTracker(),
+		ctx:    ctx,
+		cancel: cancel,
 	}
 }
 
+// SetUpgrader задает Upgrader, через который создаются слушающие сокеты
+// Вызывается до Initialize; без него обновление бинарника без простоя недоступно
+func (s *Server) SetUpgrader(upgrader *upgrade.Upgrader) {
+	s.upgrader = upgrader
+}
+
 // Initialize инициализирует все компоненты server
 func (s *Server) Initialize() error {
+	// Initialize DNS resolver (used by outbounds with local resolution and the destination policy)
+	outbounds, err := s.newOutboundFactory(s.cfg.DNS)
+	if err != nil {
+		return err
+	}
+	s.resolver = outbounds.resolver
+	s.outbounds = outbounds
+
 	// Initialize Router and OutboundPool
 	if s.cfg.OutboundPool != nil && s.cfg.OutboundPool.Enabled {
 		if err := s.initializeOutboundPool(); err != nil {
@@ -53,21 +161,41 @@ func (s *Server) Initialize() error {
 		s.router = router.NewStaticRouter()
 	}
 
-	// Initialize Plugin Manager
-	s.pluginManager = plugin.NewManager()
+	// Initialize cluster node (remote devices are used through the outbound pool)
+	if s.cfg.Cluster != nil && s.outboundPool != nil {
+		s.initializeCluster()
+	}
+
+	// Initialize destination policy
+	destPolicy, err := newDestinationPolicy(s.cfg.DestinationPolicy, s.resolver)
+	if err != nil {
+		return fmt.Errorf("invalid destination policy: %w", err)
+	}
+	s.destPolicy = destPolicy
+	s.outbounds.destPolicy = destPolicy
 
 	// Load and initialize plugins
-	if err := s.initializePlugins(); err != nil {
+	pluginManager, plugins, err := buildPlugins(&s.cfg.Plugins, nil)
+	if err != nil {
 		return fmt.Errorf("failed to initialize plugins: %w", err)
 	}
+	s.pluginManager = pluginManager
+	s.plugins = plugins
 
 	// Initialize outbound
-	if err := s.initializeOutbound(); err != nil {
+	s.outbound, err = s.outbounds.newOutbound(&s.cfg.Outbound)
+	if err != nil {
 		return fmt.Errorf("failed to initialize outbound: %w", err)
 	}
 
+	s.connOptions, err = s.newConnOptions(s.cfg, s.outbound, s.outbounds, s.pluginManager, s.destPolicy)
+	if err != nil {
+		return err
+	}
+
 	// Initialize inbound
-	if err := s.initializeInbound(); err != nil {
+	s.inbound, err = newInbound(&s.cfg.Inbound, s.upgrader)
+	if err != nil {
 		return fmt.Errorf("failed to initialize inbound: %w", err)
 	}
 
@@ -87,155 +215,1117 @@ func (s *Server) initializeOutboundPool() error {
 	}
 
 	s.deviceRegistry = device.NewRegistry(heartbeatInterval, heartbeatTimeout)
+	// Device that missed a heartbeat gets no new connections until it is back or times out
+	s.deviceRegistry.AddHealthCheck(device.NewHeartbeatCheck(time.Duration(heartbeatTimeout) * time.Second / 2))
+	// Score devices by dial outcomes and quarantine bad exits automatically
+	s.initializeDeviceHealth()
+
+	// Restore device metadata and counters if a registry store is configured
+	if storeCfg := s.cfg.OutboundPool.Store; storeCfg != nil {
+		registryStore, err := store.New(storeCfg)
+		if err != nil {
+			return fmt.Errorf("failed to open registry store: %w", err)
+		}
+		flushInterval := storeCfg.FlushInterval
+		if flushInterval == 0 {
+			flushInterval = constants.DefaultRegistryFlushInterval
+		}
+		if err := s.deviceRegistry.SetStore(registryStore, time.Duration(flushInterval)*time.Second); err != nil {
+			registryStore.Close()
+			return fmt.Errorf("failed to load registry store: %w", err)
+		}
+	}
 
 	// Initialize OutboundPool
-	s.outboundPool = outbound.NewPool(s.deviceRegistry)
+	dialTimeout := s.cfg.OutboundPool.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = constants.DefaultDeviceDialTimeout
+	}
+	s.outboundPool = outbound.NewPool(s.deviceRegistry, time.Duration(dialTimeout)*time.Second)
+	if s.cfg.OutboundPool.Resolve == "local" {
+		s.outboundPool.SetResolver(currentResolver{server: s})
+	}
 
 	// Initialize Dynamic Router with RoundRobin strategy
 	strategy := router.NewRoundRobinStrategy()
 	s.router = router.NewDynamicRouter(s.deviceRegistry, strategy)
 
-	// Prepare TLS config if enabled
-	tlsConfig, err := s.prepareTLSConfig()
+	// Load TLS certificate if enabled
+	certStore, err := tlsconfig.NewCertStore(s.cfg.OutboundPool.TLS)
 	if err != nil {
 		return fmt.Errorf("failed to prepare TLS config: %w", err)
 	}
+	s.certStore = certStore
 
 	// Initialize WSS server for control-plane
 	wssPort := s.cfg.OutboundPool.WSSPort
 	if wssPort == 0 {
 		wssPort = constants.DefaultWSSPort
 	}
-	s.wssServer = wss.NewServer(s.deviceRegistry, wssPort, tlsConfig)
+	s.wssServer = wss.NewServer(s.deviceRegistry, wssPort, s.prepareTLSConfig())
+	s.wssServer.SetUpgrader(s.upgrader)
 
 	// Initialize QUIC server for data-plane
 	quicPort := s.cfg.OutboundPool.QUICPort
 	if quicPort == 0 {
 		quicPort = constants.DefaultQUICPort
 	}
-	s.quicServer = quic.NewServer(s.deviceRegistry, quicPort, tlsConfig)
+	s.quicServer = quic.NewServer(s.deviceRegistry, quicPort, s.prepareTLSConfig())
+	s.quicServer.SetUpgrader(s.upgrader)
 
 	logger.Info("server", "Outbound pool enabled: WSS control-plane on port %d, QUIC data-plane on port %d", wssPort, quicPort)
 	return nil
 }
 
-// prepareTLSConfig подготавливает TLS конфигурацию
-func (s *Server) prepareTLSConfig() (*tls.Config, error) {
-	return tlsconfig.NewTLSConfig(s.cfg.OutboundPool.TLS)
+// dialRetryPolicy возвращает параметры повторных попыток подключения через другие устройства пула
+func dialRetryPolicy(cfg *config.Config) proxy.RetryPolicy {
+	if cfg.OutboundPool == nil || !cfg.OutboundPool.Enabled {
+		return proxy.RetryPolicy{}
+	}
+	retry := cfg.OutboundPool.Retry
+	if retry == nil {
+		retry = &config.DialRetryConfig{}
+	}
+	attempts := retry.Attempts
+	if attempts == 0 {
+		attempts = constants.DefaultDialAttempts
+	}
+	budget := retry.Budget
+	if budget == 0 {
+		budget = constants.DefaultDialBudget
+	}
+	return proxy.RetryPolicy{Attempts: attempts, Budget: time.Duration(budget) * time.Second}
 }
 
-// initializePlugins инициализирует плагины
-func (s *Server) initializePlugins() error {
-	if s.cfg.Plugins.TrafficInbound != nil && s.cfg.Plugins.TrafficInbound.Enabled {
-		trafficInbound := traffic.NewInboundCounter()
-		if err := trafficInbound.Init(s.cfg.Plugins.TrafficInbound.Config); err != nil {
-			return fmt.Errorf("failed to initialize traffic_inbound plugin: %w", err)
+// sniffPolicy возвращает параметры определения домена назначения по первым байтам клиента
+// Домен, заменивший IP назначения, проверяется политикой адресов назначения
+func sniffPolicy(cfg *config.Config, destPolicy *acl.Policy) proxy.SniffPolicy {
+	sniffing := cfg.Inbound.Sniffing
+	if sniffing == nil || !sniffing.Enabled {
+		return proxy.SniffPolicy{}
+	}
+	timeout := sniffing.Timeout
+	if timeout == 0 {
+		timeout = constants.DefaultSniffTimeout
+	}
+	return proxy.SniffPolicy{
+		Enabled:    true,
+		Override:   sniffing.OverrideDestination,
+		Timeout:    time.Duration(timeout) * time.Millisecond,
+		Check:      destPolicy.CheckAddressContext,
+		EarlyReply: sniffing.EarlyReply,
+	}
+}
+
+// newConnOptions собирает параметры обработки соединений из конфигурации и ее компонентов
+func (s *Server) newConnOptions(cfg *config.Config, ob outbound.Outbound, outbounds *outboundFactory, pluginManager *plugin.Manager, destPolicy *acl.Policy) (*proxy.ConnOptions, error) {
+	// Outbound, подключающийся через устройства сам (например, цепочка "устройство -> прокси"),
+	// не должен подменяться устройством, выбранным router
+	var rtr router.Router = s.router
+	if cfg.Outbound.UsesDevice() {
+		rtr = staticRouter
+	}
+	// Правила routing проверяются раньше выбора устройства, домены для правил подсетей
+	// разрешаются resolver той же конфигурации
+	if cfg.Routing != nil && len(cfg.Routing.Rules) > 0 {
+		rules, err := router.NewRuleRouter(cfg.Routing, outbounds.resolver, rtr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid routing rules: %w", err)
 		}
-		s.pluginManager.RegisterInboundPlugin(trafficInbound)
-		s.pluginManager.RegisterTrafficPlugin(trafficInbound)
-		logger.Info("server", "Traffic inbound plugin enabled")
+		rtr = rules
 	}
+	return &proxy.ConnOptions{
+		Outbound:       ob,
+		OutboundID:     cfg.Outbound.ID,
+		OutboundConfig: &cfg.Outbound,
+		Router:         rtr,
+		Plugins:        pluginManager,
+		Pool:           s.outboundPool,
+		Factory:        outbounds.newOutbound,
+		Retry:          dialRetryPolicy(cfg),
+		Sniffing:       sniffPolicy(cfg, destPolicy),
+		CheckResolved:  destPolicy.CheckIP,
+	}, nil
+}
 
-	if s.cfg.Plugins.TrafficOutbound != nil && s.cfg.Plugins.TrafficOutbound.Enabled {
-		trafficOutbound := traffic.NewOutboundCounter()
-		if err := trafficOutbound.Init(s.cfg.Plugins.TrafficOutbound.Config); err != nil {
-			return fmt.Errorf("failed to initialize traffic_outbound plugin: %w", err)
+// initializeDeviceHealth включает оценку здоровья устройств по исходам подключений
+// и автоматический карантин устройств с низкой оценкой
+func (s *Server) initializeDeviceHealth() {
+	cfg := s.cfg.OutboundPool.Health
+	if cfg == nil {
+		cfg = &config.DeviceHealthConfig{}
+	}
+	window := cfg.Window
+	if window == 0 {
+		window = constants.DefaultHealthWindow
+	}
+	minDials := cfg.MinDials
+	if minDials == 0 {
+		minDials = constants.DefaultHealthMinDials
+	}
+	minScore := cfg.MinScore
+	if minScore == 0 {
+		minScore = constants.DefaultHealthMinScore
+	}
+	cooldown := cfg.Cooldown
+	if cooldown == 0 {
+		cooldown = constants.DefaultHealthCooldown
+	}
+
+	scorer := device.HealthScorer{Window: time.Duration(window) * time.Second, MinDials: minDials}
+	s.deviceRegistry.SetHealthScorer(scorer)
+
+	var probe device.Prober
+	if cfg.ProbeAddress != "" {
+		probe = s.probeDevice(cfg.ProbeAddress)
+	}
+	s.deviceRegistry.AddHealthCheck(device.NewScoreCheck(scorer, minScore, time.Duration(cooldown)*time.Second, probe))
+}
+
+// probeDevice возвращает проверку устройства подключением через него к address
+func (s *Server) probeDevice(address string) device.Prober {
+	return func(dev *device.Device) error {
+		ctx, cancel := context.WithTimeout(s.ctx, deviceProbeTimeout)
+		defer cancel()
+		conn, err := outbound.NewQUICOutbound(dev.ID, s.deviceRegistry, deviceProbeTimeout).DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
 		}
-		s.pluginManager.RegisterOutboundPlugin(trafficOutbound)
-		s.pluginManager.RegisterTrafficPlugin(trafficOutbound)
-		logger.Info("server", "Traffic outbound plugin enabled")
+		return conn.Close()
 	}
+}
 
-	return nil
+// initializeCluster подключает POP к кластеру: устройства других POP становятся
+// кандидатами DynamicRouter, а соединения через них передаются по inter-POP туннелю
+func (s *Server) initializeCluster() {
+	s.cluster = cluster.NewNode(s.cfg.Cluster, &clusterLocal{server: s})
+	s.cluster.SetUpgrader(s.upgrader)
+	s.outboundPool.SetRemote(s.cluster)
+
+	penalty := s.cfg.Cluster.RemotePenalty
+	if penalty == 0 {
+		penalty = constants.DefaultClusterRemotePenalty
+	}
+	if dynamic, ok := s.router.(*router.DynamicRouter); ok {
+		dynamic.SetRemote(s.cluster, penalty)
+	}
+
+	logger.Info("server", "Cluster mode enabled: node %s, inter-POP QUIC on %s", s.cfg.Cluster.NodeID, s.cfg.Cluster.Listen)
+}
+
+// deviceOutbound подключается через устройство пула (outbound типа "device")
+// Без deviceID устройство для каждого подключения выбирает router
+type deviceOutbound struct {
+	server   *Server
+	deviceID string
+}
+
+// DialContext устанавливает соединение с address через устройство
+func (d *deviceOutbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	deviceID := d.deviceID
+	if deviceID == "" {
+		selected, _, err := d.server.router.SelectOutbound(plugin.NewConnectionContext("", address), address, "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select device: %w", err)
+		}
+		if selected == "" {
+			return nil, fmt.Errorf("no available device")
+		}
+		deviceID = selected
+	}
+	ob, err := d.server.outboundPool.GetOutbound(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("server", "Dialing %s via device %s", address, deviceID)
+	return ob.DialContext(ctx, network, address)
+}
+
+// clusterLocal предоставляет кластеру устройства этого POP
+type clusterLocal struct {
+	server *Server
+}
+
+// Devices возвращает доступные устройства для публикации в кластере
+func (l *clusterLocal) Devices() []*cluster.DeviceInfo {
+	devices := l.server.deviceRegistry.GetAvailableDevices(device.NewDeviceCriteria())
+	infos := make([]*cluster.DeviceInfo, 0, len(devices))
+	for _, dev := range devices {
+		record := dev.Record()
+		infos = append(infos, &cluster.DeviceInfo{
+			ID:       record.ID,
+			Location: record.Location,
+			Tags:     record.Tags,
+			Load:     dev.StreamCount(),
+		})
+	}
+	return infos
+}
+
+// Dial подключается через устройство этого POP по запросу другого POP
+// Адрес проверяется политикой назначения этого POP
+func (l *clusterLocal) Dial(ctx context.Context, deviceID, address string) (net.Conn, error) {
+	l.server.mu.RLock()
+	destPolicy := l.server.destPolicy
+	l.server.mu.RUnlock()
+
+	if err := destPolicy.CheckAddressContext(ctx, address); err != nil {
+		return nil, err
+	}
+	// Список устройств другого POP мог устареть: устройство уже в draining/quarantined
+	dev, err := l.server.deviceRegistry.GetDevice(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if !dev.IsAvailable() {
+		return nil, fmt.Errorf("device %s is %s", deviceID, dev.CurrentStatus())
+	}
+	ob, err := l.server.outboundPool.LocalOutbound(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return ob.DialContext(ctx, "tcp", address)
+}
+
+// prepareTLSConfig подготавливает TLS конфигурацию (nil, если TLS выключен)
+// Сертификат берется из certStore, поэтому его можно заменить при перезагрузке
+func (s *Server) prepareTLSConfig() *tls.Config {
+	if s.certStore == nil {
+		return nil
+	}
+	return s.certStore.TLSConfig()
+}
+
+// buildPlugins создает плагины по конфигурации и регистрирует их в новом менеджере
+// Плагины из current с неизменной конфигурацией переиспользуются (сохраняют счетчики и состояние),
+// плагины с измененной конфигурацией, реализующие ReloadablePlugin, перенастраиваются на месте
+func buildPlugins(cfg *config.PluginsConfig, current map[string]*pluginInstance) (*plugin.Manager, map[string]*pluginInstance, error) {
+	manager := plugin.NewManager()
+	instances := make(map[string]*pluginInstance)
+
+	for _, factory := range pluginFactories {
+		pluginCfg := factory.config(cfg)
+		if pluginCfg == nil || !pluginCfg.Enabled {
+			continue
+		}
+
+		instance, ok := current[factory.name]
+		if ok && !reflect.DeepEqual(instance.cfg, pluginCfg) {
+			reloadable, canReload := instance.plugin.(plugin.ReloadablePlugin)
+			if canReload {
+				if err := reloadable.Reload(pluginCfg.Config); err != nil {
+					revertPlugins(instances, current)
+					closePluginsExcept(instances, current)
+					return nil, nil, fmt.Errorf("failed to reload %s plugin: %w", factory.name, err)
+				}
+				instance = &pluginInstance{cfg: pluginCfg, plugin: instance.plugin}
+				logger.Info("server", "Plugin %s reloaded", factory.name)
+			}
+			ok = canReload
+		}
+		if !ok {
+			p := factory.create()
+			if err := p.Init(pluginCfg.Config); err != nil {
+				revertPlugins(instances, current)
+				closePluginsExcept(instances, current)
+				return nil, nil, fmt.Errorf("failed to initialize %s plugin: %w", factory.name, err)
+			}
+			instance = &pluginInstance{cfg: pluginCfg, plugin: p}
+			logger.Info("server", "Plugin %s enabled", factory.name)
+		}
+		instances[factory.name] = instance
+		registerPlugin(manager, instance.plugin)
+	}
+
+	return manager, instances, nil
+}
+
+// registerPlugin регистрирует плагин во всех списках менеджера, интерфейсы которых он реализует
+func registerPlugin(manager *plugin.Manager, p plugin.Plugin) {
+	if inboundPlugin, ok := p.(plugin.InboundPlugin); ok {
+		manager.RegisterInboundPlugin(inboundPlugin)
+	}
+	if outboundPlugin, ok := p.(plugin.OutboundPlugin); ok {
+		manager.RegisterOutboundPlugin(outboundPlugin)
+	}
+	if trafficPlugin, ok := p.(plugin.TrafficPlugin); ok {
+		manager.RegisterTrafficPlugin(trafficPlugin)
+	}
+	if limiterPlugin, ok := p.(plugin.LimiterPlugin); ok {
+		manager.RegisterLimiterPlugin(limiterPlugin)
+	}
 }
 
-// initializeOutbound инициализирует outbound
-func (s *Server) initializeOutbound() error {
-	switch s.cfg.Outbound.Type {
+// revertPlugins возвращает прежнюю конфигурацию плагинам из instances,
+// перенастроенным на месте относительно current (откат неудачной перезагрузки)
+func revertPlugins(instances, current map[string]*pluginInstance) {
+	for name, instance := range instances {
+		old, ok := current[name]
+		if !ok || old.plugin != instance.plugin || old.cfg == instance.cfg {
+			continue
+		}
+		if err := instance.plugin.(plugin.ReloadablePlugin).Reload(old.cfg.Config); err != nil {
+			logger.Error("server", "Error restoring %s plugin config: %v", name, err)
+		}
+	}
+}
+
+// closePluginsExcept закрывает плагины из instances, которые не используются в keep
+func closePluginsExcept(instances, keep map[string]*pluginInstance) {
+	for name, instance := range instances {
+		if kept, ok := keep[name]; ok && kept.plugin == instance.plugin {
+			continue
+		}
+		if err := instance.plugin.Close(); err != nil {
+			logger.Error("server", "Error closing %s plugin: %v", name, err)
+		}
+	}
+}
+
+// outboundFactory создает outbound одной конфигурации: домены разрешаются resolver из ее секции dns
+type outboundFactory struct {
+	server         *Server
+	resolver       *dns.Resolver
+	directResolver outbound.Resolver       // Resolver direct outbound (nil без секции dns - средствами net.Dialer)
+	blocks         *outbound.BlockCounters // Счетчики outbound типа "block" по правилам (GET /blocks)
+	// Политика адресов назначения той же конфигурации: direct outbound проверяет ею IP, к которому
+	// подключается, иначе домен может разрешиться при подключении иначе, чем при проверке (DNS rebinding)
+	destPolicy *acl.Policy
+}
+
+// currentResolver разрешает домены resolver текущей конфигурации (он заменяется при перезагрузке)
+type currentResolver struct {
+	server *Server
+}
+
+// LookupIP разрешает домен resolver текущей конфигурации
+func (r currentResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	r.server.mu.RLock()
+	resolver := r.server.resolver
+	r.server.mu.RUnlock()
+	return resolver.LookupIP(ctx, host)
+}
+
+// newOutboundFactory создает resolver по секции dns и outboundFactory с ним
+// Счетчики блокировок правил, оставшихся в конфигурации, продолжаются со счетчиков текущей фабрики
+// (вызывается при инициализации или под reloadMu)
+func (s *Server) newOutboundFactory(cfg *config.DNSConfig) (*outboundFactory, error) {
+	resolver, err := dns.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize DNS resolver: %w", err)
+	}
+	var previousBlocks *outbound.BlockCounters
+	if s.outbounds != nil {
+		previousBlocks = s.outbounds.blocks
+	}
+	f := &outboundFactory{server: s, resolver: resolver, blocks: outbound.NewBlockCounters(previousBlocks)}
+	if cfg != nil {
+		f.directResolver = resolver
+	}
+	return f, nil
+}
+
+// newOutbound создает outbound по конфигурации
+// С resolve "local" домен назначения разрешается на POP, и outbound получает IP адрес
+func (f *outboundFactory) newOutbound(cfg *config.OutboundConfig) (outbound.Outbound, error) {
+	ob, err := f.createOutbound(cfg)
+	if err != nil || cfg.Resolve != "local" || cfg.Type == "direct" {
+		return ob, err
+	}
+	// Прокси с локальным разрешением остается звеном цепочки
+	if hop, ok := ob.(outbound.Hop); ok {
+		return outbound.NewResolvingHop(hop, f.resolver), nil
+	}
+	return outbound.NewResolvingOutbound(ob, f.resolver), nil
+}
+
+// createOutbound создает outbound указанного типа
+func (f *outboundFactory) createOutbound(cfg *config.OutboundConfig) (outbound.Outbound, error) {
+	switch cfg.Type {
 	case "direct":
-		s.outbound = outbound.NewDirectOutbound()
+		return newDirectOutbound(cfg, f.directResolver, f.destPolicy)
 	case "socks5":
-		if s.cfg.Outbound.ProxyAddress == "" {
-			return fmt.Errorf("proxy_address is required for SOCKS5 outbound")
+		if cfg.ProxyAddress == "" {
+			return nil, fmt.Errorf("proxy_address is required for SOCKS5 outbound")
+		}
+		socks := outbound.NewSOCKS5Outbound(cfg.ProxyAddress, time.Duration(cfg.ConnectTimeout)*time.Second, time.Duration(cfg.HandshakeTimeout)*time.Second)
+		socks.SetAuth(cfg.Username, cfg.Password)
+		return socks, nil
+	case "http":
+		if cfg.ProxyAddress == "" {
+			return nil, fmt.Errorf("proxy_address is required for HTTP outbound")
 		}
-		s.outbound = outbound.NewSOCKS5Outbound(s.cfg.Outbound.ProxyAddress)
+		host, _, _ := net.SplitHostPort(cfg.ProxyAddress)
+		tlsConfig, err := tlsconfig.NewClientTLSConfig(cfg.TLS, host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration for HTTP outbound: %w", err)
+		}
+		httpOutbound := outbound.NewHTTPOutbound(cfg.ProxyAddress, time.Duration(cfg.ConnectTimeout)*time.Second, time.Duration(cfg.HandshakeTimeout)*time.Second)
+		httpOutbound.SetTLS(tlsConfig)
+		httpOutbound.SetAuth(cfg.Username, cfg.Password)
+		httpOutbound.SetHeaders(cfg.Headers)
+		return httpOutbound, nil
+	case "group":
+		return f.newGroupOutbound(cfg)
+	case "chain":
+		return f.newChainOutbound(cfg)
+	case "block":
+		rule := cfg.ID
+		if rule == "" {
+			rule = "block"
+		}
+		return outbound.NewBlockOutbound(rule, cfg.BlockMode, f.blocks), nil
+	case "device":
+		if f.server.outboundPool == nil {
+			return nil, fmt.Errorf("device outbound requires enabled outbound pool")
+		}
+		return &deviceOutbound{server: f.server, deviceID: cfg.DeviceID}, nil
 	default:
-		return fmt.Errorf("unsupported outbound type: %s", s.cfg.Outbound.Type)
+		return nil, fmt.Errorf("unsupported outbound type: %s", cfg.Type)
 	}
-	return nil
 }
 
-// initializeInbound инициализирует inbound
-func (s *Server) initializeInbound() error {
-	switch s.cfg.Inbound.Type {
+// newDirectOutbound создает direct outbound с параметрами сокета из конфигурации
+// resolver - разрешение доменов назначения (nil - средствами net.Dialer),
+// destPolicy - проверка IP перед подключением (nil - без проверки)
+func newDirectOutbound(cfg *config.OutboundConfig, resolver outbound.Resolver, destPolicy *acl.Policy) (outbound.Outbound, error) {
+	direct := outbound.NewDirectOutbound(time.Duration(cfg.ConnectTimeout) * time.Second)
+	if resolver != nil {
+		direct.SetResolver(resolver)
+	}
+	if destPolicy != nil {
+		direct.SetDialControl(destPolicy.DialControl)
+	}
+	if len(cfg.SourceAddresses) > 0 {
+		sources := make([]net.IP, 0, len(cfg.SourceAddresses))
+		for _, source := range cfg.SourceAddresses {
+			ip := net.ParseIP(source)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid source address %q", source)
+			}
+			sources = append(sources, ip)
+		}
+		direct.SetSourceAddresses(sources)
+	}
+	if cfg.Interface != "" {
+		direct.SetInterface(cfg.Interface)
+	}
+	if cfg.Mark != 0 {
+		direct.SetMark(cfg.Mark)
+	}
+	if cfg.IPPreference != "" {
+		direct.SetIPPreference(cfg.IPPreference)
+	}
+	if cfg.TCPNoDelay != nil {
+		direct.SetNoDelay(*cfg.TCPNoDelay)
+	}
+	if keepAlive := cfg.KeepAlive; keepAlive != nil {
+		direct.SetKeepAlive(net.KeepAliveConfig{
+			Enable:   keepAlive.Enabled,
+			Idle:     time.Duration(keepAlive.Idle) * time.Second,
+			Interval: time.Duration(keepAlive.Interval) * time.Second,
+			Count:    keepAlive.Count,
+		})
+	}
+	return direct, nil
+}
+
+// newGroupOutbound создает группу outbound и ее участников
+func (f *outboundFactory) newGroupOutbound(cfg *config.OutboundConfig) (outbound.Outbound, error) {
+	members := make([]*outbound.GroupMember, 0, len(cfg.Outbounds))
+	closeMembers := func() {
+		for _, member := range members {
+			closeOutbound(member.Outbound)
+		}
+	}
+	for i := range cfg.Outbounds {
+		memberCfg := &cfg.Outbounds[i]
+		ob, err := f.newOutbound(memberCfg)
+		if err != nil {
+			closeMembers()
+			return nil, fmt.Errorf("group member %d: %w", i, err)
+		}
+		id := memberCfg.ID
+		if id == "" {
+			id = fmt.Sprintf("%s-%d", memberCfg.Type, i)
+		}
+		members = append(members, &outbound.GroupMember{ID: id, Outbound: ob, Weight: memberCfg.Weight})
+	}
+
+	strategy := cfg.Strategy
+	if strategy == "" {
+		strategy = outbound.GroupFailover
+	}
+	probeInterval := cfg.ProbeInterval
+	if probeInterval == 0 {
+		probeInterval = constants.DefaultGroupProbeInterval
+	}
+	return outbound.NewGroupOutbound(strategy, members, cfg.ProbeAddress, time.Duration(probeInterval)*time.Second), nil
+}
+
+// newChainOutbound создает цепочку outbound: звенья после первого должны уметь
+// подключаться поверх соединения предыдущего звена
+func (f *outboundFactory) newChainOutbound(cfg *config.OutboundConfig) (outbound.Outbound, error) {
+	if len(cfg.Outbounds) == 0 {
+		return nil, fmt.Errorf("chain outbound requires members")
+	}
+	first, err := f.newOutbound(&cfg.Outbounds[0])
+	if err != nil {
+		return nil, fmt.Errorf("chain member 0: %w", err)
+	}
+	hops := make([]outbound.Hop, 0, len(cfg.Outbounds)-1)
+	closeMembers := func() {
+		closeOutbound(first)
+		for _, hop := range hops {
+			closeOutbound(hop)
+		}
+	}
+	for i := 1; i < len(cfg.Outbounds); i++ {
+		ob, err := f.newOutbound(&cfg.Outbounds[i])
+		if err != nil {
+			closeMembers()
+			return nil, fmt.Errorf("chain member %d: %w", i, err)
+		}
+		hop, ok := ob.(outbound.Hop)
+		if !ok {
+			closeMembers()
+			closeOutbound(ob)
+			return nil, fmt.Errorf("chain member %d: %s outbound cannot dial over an existing connection", i, cfg.Outbounds[i].Type)
+		}
+		hops = append(hops, hop)
+	}
+	return outbound.NewChainOutbound(first, hops), nil
+}
+
+// newDestinationPolicy создает политику адресов назначения; с resolve_domains
+// домены проверяются и по их IP адресам
+func newDestinationPolicy(cfg *config.DestinationPolicyConfig, resolver *dns.Resolver) (*acl.Policy, error) {
+	policy, err := acl.NewPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg != nil && cfg.ResolveDomains {
+		policy.SetResolver(resolver)
+	}
+	return policy, nil
+}
+
+// closeOutbound освобождает ресурсы outbound (фоновые проверки группы), если они есть
+func closeOutbound(ob outbound.Outbound) {
+	if closer, ok := ob.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// newIPFilter создает фильтр клиентов inbound (nil, если allow/deny не заданы)
+func newIPFilter(cfg *config.InboundConfig) (*inbound.IPFilter, error) {
+	if len(cfg.Allow) == 0 && len(cfg.Deny) == 0 {
+		return nil, nil
+	}
+	filter, err := inbound.NewIPFilter(cfg.Allow, cfg.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inbound IP filter: %w", err)
+	}
+	return filter, nil
+}
+
+// newInbound создает inbound по конфигурации
+func newInbound(cfg *config.InboundConfig, upgrader *upgrade.Upgrader) (inbound.Inbound, error) {
+	filter, err := newIPFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Type {
 	case "socks5":
-		s.inbound = inbound.NewSOCKS5Inbound(s.cfg.Inbound.Port)
+		socks := inbound.NewSOCKS5Inbound(cfg.Listen, cfg.Port, filter)
+		socks.SetUpgrader(upgrader)
+		if len(cfg.Users) > 0 {
+			users := make(map[string]string, len(cfg.Users))
+			for _, user := range cfg.Users {
+				users[user.Username] = user.Password
+			}
+			socks.SetUsers(users)
+		}
+		return socks, nil
+	case "redirect":
+		redirect := inbound.NewRedirectInbound(cfg.Listen, cfg.Port, filter)
+		redirect.SetUpgrader(upgrader)
+		return redirect, nil
+	case "tproxy":
+		tproxy := inbound.NewTProxyInbound(cfg.Listen, cfg.Port, filter)
+		tproxy.SetUpgrader(upgrader)
+		if cfg.Network != "" {
+			tproxy.SetNetwork(cfg.Network != "udp", cfg.Network != "tcp")
+		}
+		if cfg.UDPTimeout > 0 {
+			tproxy.SetUDPTimeout(time.Duration(cfg.UDPTimeout) * time.Second)
+		}
+		return tproxy, nil
 	default:
-		return fmt.Errorf("unsupported inbound type: %s", s.cfg.Inbound.Type)
+		return nil, fmt.Errorf("unsupported inbound type: %s", cfg.Type)
 	}
-	return nil
 }
 
 // Start запускает server
 func (s *Server) Start() error {
 	// Connection handler
-	handler := func(conn net.Conn, targetAddress string, ctx *plugin.ConnectionContext) error {
+	s.handler = func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
 		if targetAddress == "" {
 			return fmt.Errorf("target address not specified")
 		}
+
+		// Учитываем соединение для graceful shutdown
+		if !s.conns.add(conn) {
+			return ErrServerDraining
+		}
+		defer s.conns.remove(conn)
+
+		// Соединение до конца работает с компонентами, действовавшими на момент его начала
+		s.mu.RLock()
+		cfg, opts, destPolicy := s.cfg, s.connOptions, s.destPolicy
+		s.mu.RUnlock()
+
+		// Проверяем адрес назначения до выбора outbound/устройства
+		if err := destPolicy.CheckAddressContext(ctx, targetAddress); err != nil {
+			logger.Info("server", "Connection from %s to %s rejected by destination policy: %v", conn.RemoteAddr(), targetAddress, err)
+			return err
+		}
 		// Устанавливаем InboundID из конфигурации
-		ctx.InboundID = s.cfg.Inbound.ID
-		return proxy.HandleConnection(conn, s.outbound, s.cfg.Outbound.ID, &s.cfg.Outbound, targetAddress, s.cfg.Inbound.ID, s.router, s.pluginManager, s.outboundPool)
+		connCtx.InboundID = cfg.Inbound.ID
+
+		// Подключение прерывается и отключением клиента, и остановкой server
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(s.ctx, cancel)
+		defer stop()
+		return proxy.HandleConnection(ctx, conn, targetAddress, connCtx, opts)
 	}
 
 	// Start inbound
-	if err := s.inbound.Start(handler); err != nil {
+	if err := s.inbound.Start(s.handler); err != nil {
 		return fmt.Errorf("failed to start inbound: %w", err)
 	}
 
 	// Start WSS server if enabled
 	if s.wssServer != nil {
-		go func() {
-			if err := s.wssServer.Start(); err != nil {
-				log.Fatalf("Failed to start WSS server: %v", err)
-			}
-		}()
+		if err := s.wssServer.Start(); err != nil {
+			return fmt.Errorf("failed to start WSS server: %w", err)
+		}
 	}
 
 	// Start QUIC server if enabled
 	if s.quicServer != nil {
-		go func() {
-			if err := s.quicServer.Start(); err != nil {
-				log.Fatalf("Failed to start QUIC server: %v", err)
-			}
-		}()
+		if err := s.quicServer.Start(); err != nil {
+			return fmt.Errorf("failed to start QUIC server: %w", err)
+		}
 	}
 
+	// Join the cluster if enabled
+	if s.cluster != nil {
+		if err := s.cluster.Start(); err != nil {
+			return fmt.Errorf("failed to start cluster node: %w", err)
+		}
+	}
+
+	// Start admin API if configured
+	if s.cfg.Admin != nil && s.cfg.Admin.Listen != "" {
+		s.admin = admin.NewServer(s.cfg.Admin.Listen, s.cfg.Admin.Token)
+		s.admin.Handle("POST /reload", s.handleReload)
+		s.admin.Handle("GET /blocks", s.handleBlocks)
+		if s.deviceRegistry != nil {
+			s.admin.Handle("GET /devices", s.handleDevices)
+			s.admin.Handle("PUT /devices/{id}/status", s.handleDeviceStatus)
+		}
+		if s.cluster != nil {
+			s.admin.Handle("GET /cluster", s.handleCluster)
+		}
+		if err := s.admin.Start(); err != nil {
+			return fmt.Errorf("failed to start admin API: %w", err)
+		}
+	}
+
+	listenAddr := net.JoinHostPort(s.cfg.Inbound.Listen, strconv.Itoa(s.cfg.Inbound.Port))
 	outboundType := s.cfg.Outbound.Type
 	if outboundType == "socks5" {
-		logger.Info("server", "SOCKS5 proxy started on port %d (SOCKS5 outbound via %s)", s.cfg.Inbound.Port, s.cfg.Outbound.ProxyAddress)
+		logger.Info("server", "SOCKS5 proxy started on %s (SOCKS5 outbound via %s)", listenAddr, s.cfg.Outbound.ProxyAddress)
 	} else {
-		logger.Info("server", "SOCKS5 proxy started on port %d (%s outbound)", s.cfg.Inbound.Port, outboundType)
+		logger.Info("server", "SOCKS5 proxy started on %s (%s outbound)", listenAddr, outboundType)
+	}
+
+	// Если процесс запущен через Upgrade, родитель может прекращать прием соединений
+	if err := s.upgrader.Ready(); err != nil {
+		return fmt.Errorf("failed to signal readiness: %w", err)
+	}
+
+	return nil
+}
+
+// Upgrade запускает новый процесс с тем же бинарником и аргументами и передает ему
+// слушающие сокеты (SOCKS, WSS, QUIC). После успешного возврата новые соединения
+// принимает новый процесс, а текущий должен быть остановлен через Stop (drain)
+func (s *Server) Upgrade() error {
+	if s.upgrader == nil {
+		return fmt.Errorf("upgrade is not supported: upgrader is not configured")
+	}
+	if s.stopping.Load() {
+		return ErrServerDraining
+	}
+
+	// Новый процесс читает конфигурацию заново, а stdin уже прочитан этим процессом
+	s.mu.RLock()
+	configPath := s.cfg.Path()
+	s.mu.RUnlock()
+	if configPath == config.StdinPath {
+		return fmt.Errorf("upgrade is not supported: configuration was read from stdin")
+	}
+
+	// Перезагрузка во время передачи сокетов может закрыть передаваемый слушатель
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	// Новый процесс восстанавливает устройства из хранилища: сохраняем актуальное состояние
+	if s.deviceRegistry != nil {
+		if err := s.deviceRegistry.Flush(); err != nil {
+			logger.Error("server", "Failed to save device registry before upgrade: %v", err)
+		}
+	}
+
+	if err := s.upgrader.Upgrade(upgradeReadyTimeout); err != nil {
+		return err
+	}
+	s.upgraded.Store(true)
+	return nil
+}
+
+// Reload перечитывает файл конфигурации и применяет изменения без разрыва соединений
+// При ошибке (невалидный файл, неверные параметры) продолжает работать старая конфигурация
+func (s *Server) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if s.stopping.Load() {
+		return ErrServerDraining
+	}
+
+	s.mu.RLock()
+	current := s.cfg
+	s.mu.RUnlock()
+
+	newCfg, err := current.Reload()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	if err := s.applyConfig(newCfg); err != nil {
+		return err
+	}
+
+	logger.Info("server", "Configuration reloaded from %s", newCfg.Path())
+	return nil
+}
+
+// applyConfig применяет новую конфигурацию (вызывается под reloadMu)
+// Сначала создаются все новые компоненты, и только если это удалось, они заменяют текущие.
+// Установленные соединения продолжают работать со старыми компонентами,
+// реестр устройств, WSS и QUIC серверы не пересоздаются
+func (s *Server) applyConfig(newCfg *config.Config) error {
+	oldCfg := s.cfg
+
+	// Подготовка: все проверки и создание компонентов до изменения состояния
+	outbounds, err := s.newOutboundFactory(newCfg.DNS)
+	if err != nil {
+		return err
+	}
+
+	destPolicy, err := newDestinationPolicy(newCfg.DestinationPolicy, outbounds.resolver)
+	if err != nil {
+		return fmt.Errorf("invalid destination policy: %w", err)
+	}
+	outbounds.destPolicy = destPolicy
+
+	newOb, err := outbounds.newOutbound(&newCfg.Outbound)
+	if err != nil {
+		return fmt.Errorf("invalid outbound: %w", err)
+	}
+
+	filter, err := newIPFilter(&newCfg.Inbound)
+	if err != nil {
+		closeOutbound(newOb)
+		return err
+	}
+
+	cert, err := s.loadReloadedCertificate(newCfg)
+	if err != nil {
+		closeOutbound(newOb)
+		return err
+	}
+
+	warnRestartRequired(oldCfg, newCfg)
+
+	pluginManager, plugins, err := buildPlugins(&newCfg.Plugins, s.plugins)
+	if err != nil {
+		closeOutbound(newOb)
+		return err
+	}
+
+	connOptions, err := s.newConnOptions(newCfg, newOb, outbounds, pluginManager, destPolicy)
+	if err != nil {
+		revertPlugins(plugins, s.plugins)
+		closePluginsExcept(plugins, s.plugins)
+		closeOutbound(newOb)
+		return err
 	}
 
+	// Inbound перезапускается последним из операций, которые могут завершиться ошибкой
+	if err := s.reloadInbound(&oldCfg.Inbound, &newCfg.Inbound, filter); err != nil {
+		revertPlugins(plugins, s.plugins)
+		closePluginsExcept(plugins, s.plugins)
+		closeOutbound(newOb)
+		return err
+	}
+
+	s.mu.Lock()
+	oldPlugins := s.plugins
+	oldOb := s.outbound
+	s.cfg = newCfg
+	s.outbound = newOb
+	s.pluginManager = pluginManager
+	s.plugins = plugins
+	s.destPolicy = destPolicy
+	s.resolver = outbounds.resolver
+	s.outbounds = outbounds
+	s.connOptions = connOptions
+	s.mu.Unlock()
+
+	if cert != nil {
+		s.certStore.Set(cert)
+		logger.Info("server", "TLS certificate reloaded")
+	}
+
+	// Плагины, исключенные из конфигурации или пересозданные, больше не получают новых соединений
+	closePluginsExcept(oldPlugins, plugins)
+	// Установленные через старый outbound соединения продолжают работать, останавливаются только его проверки
+	closeOutbound(oldOb)
+
 	return nil
 }
 
+// loadReloadedCertificate загружает сертификат из новой конфигурации outbound pool
+// Возвращает nil, если TLS не используется
+func (s *Server) loadReloadedCertificate(newCfg *config.Config) (*tls.Certificate, error) {
+	if s.certStore == nil || newCfg.OutboundPool == nil {
+		return nil, nil
+	}
+
+	cert, err := tlsconfig.LoadCertificate(newCfg.OutboundPool.TLS)
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil {
+		logger.Info("server", "Disabling TLS requires restart, keeping current certificate")
+	}
+	return cert, nil
+}
+
+// warnRestartRequired сообщает об изменениях, которые применяются только после перезапуска
+func warnRestartRequired(oldCfg, newCfg *config.Config) {
+	oldPool, newPool := poolWithoutTLS(oldCfg.OutboundPool), poolWithoutTLS(newCfg.OutboundPool)
+	if !reflect.DeepEqual(oldPool, newPool) {
+		logger.Info("server", "outbound_pool changes (except TLS certificate) require restart, registered devices are kept")
+	}
+	if !reflect.DeepEqual(oldCfg.Admin, newCfg.Admin) {
+		logger.Info("server", "admin changes require restart")
+	}
+	if !reflect.DeepEqual(oldCfg.Cluster, newCfg.Cluster) {
+		logger.Info("server", "cluster changes require restart")
+	}
+}
+
+// poolWithoutTLS возвращает копию конфигурации pool без TLS для сравнения
+func poolWithoutTLS(cfg *config.OutboundPoolConfig) *config.OutboundPoolConfig {
+	if cfg == nil {
+		return nil
+	}
+	copied := *cfg
+	copied.TLS = nil
+	return &copied
+}
+
+// reloadInbound применяет изменения inbound
+// Если адрес, параметры tproxy и учетные записи клиентов не изменились, заменяется только фильтр клиентов;
+// иначе слушатель перезапускается.
+// Перезапуск закрывает только слушатель, принятые соединения продолжают работать
+func (s *Server) reloadInbound(oldCfg, newCfg *config.InboundConfig, filter *inbound.IPFilter) error {
+	if oldCfg.Type == newCfg.Type && oldCfg.Listen == newCfg.Listen && oldCfg.Port == newCfg.Port &&
+		oldCfg.Network == newCfg.Network && oldCfg.UDPTimeout == newCfg.UDPTimeout && reflect.DeepEqual(oldCfg.Users, newCfg.Users) {
+		if setter, ok := s.inbound.(inbound.FilterSetter); ok {
+			setter.SetFilter(filter)
+			return nil
+		}
+		if reflect.DeepEqual(oldCfg.Allow, newCfg.Allow) && reflect.DeepEqual(oldCfg.Deny, newCfg.Deny) {
+			return nil
+		}
+	}
+
+	newIn, err := newInbound(newCfg, s.upgrader)
+	if err != nil {
+		return fmt.Errorf("invalid inbound: %w", err)
+	}
+
+	// Старый слушатель закрывается первым: новый может использовать тот же порт
+	if err := s.inbound.Stop(); err != nil {
+		logger.Error("server", "Error stopping inbound: %v", err)
+	}
+	if err := newIn.Start(s.handler); err != nil {
+		if restartErr := s.inbound.Start(s.handler); restartErr != nil {
+			logger.Error("server", "Failed to restore previous inbound: %v", restartErr)
+		}
+		return fmt.Errorf("failed to start inbound: %w", err)
+	}
+
+	s.mu.Lock()
+	s.inbound = newIn
+	s.mu.Unlock()
+
+	logger.Info("server", "Inbound restarted on %s", net.JoinHostPort(newCfg.Listen, strconv.Itoa(newCfg.Port)))
+	return nil
+}
+
+// drain ожидает завершения активных соединений не дольше shutdown_timeout,
+// по истечении времени закрывает оставшиеся соединения принудительно
+func (s *Server) drain() {
+	s.mu.RLock()
+	timeoutSeconds := s.cfg.ShutdownTimeout
+	s.mu.RUnlock()
+	if timeoutSeconds <= 0 {
+		timeoutSeconds = constants.DefaultShutdownTimeout
+	}
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	deadline := time.Now().Add(timeout)
+
+	// Уведомляем устройства: новых соединений не будет, активные будут закрыты до deadline
+	if s.wssServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		reason := "shutdown"
+		if s.upgraded.Load() {
+			reason = "upgrade"
+		}
+		notified := s.wssServer.NotifyDraining(ctx, deadline, reason)
+		cancel()
+		logger.Info("server", "Drain notice sent to %d devices", notified)
+	}
+
+	idle := s.conns.drain()
+	logger.Info("server", "Draining %d active connections (timeout %s)", s.conns.count(), timeout)
+
+	ticker := time.NewTicker(constants.DrainLogInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-idle:
+			logger.Info("server", "All connections finished")
+			return
+		case <-ticker.C:
+			logger.Info("server", "Draining: %d active connections, %s left", s.conns.count(), time.Until(deadline).Round(time.Second))
+		case <-timer.C:
+			closed := s.conns.closeAll()
+			logger.Info("server", "Shutdown timeout reached, force-closed %d connections", closed)
+			// Ждем завершения обработчиков, чтобы плагины получили OnConnectionClosed
+			select {
+			case <-idle:
+			case <-time.After(forceCloseWait):
+				logger.Error("server", "%d connections did not finish after force close", s.conns.count())
+			}
+			return
+		}
+	}
+}
+
+// handleReload обрабатывает POST /reload административного API
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.Reload(); err != nil {
+		logger.Error("server", "Configuration reload failed: %v", err)
+		admin.WriteError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+// handleBlocks обрабатывает GET /blocks административного API: количество
+// соединений, заблокированных outbound типа "block", по правилам (id outbound)
+func (s *Server) handleBlocks(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	outbounds := s.outbounds
+	s.mu.RUnlock()
+	admin.WriteJSON(w, http.StatusOK, outbounds.blocks.Counts())
+}
+
+// handleCluster обрабатывает GET /cluster административного API: известные POP и их устройства
+func (s *Server) handleCluster(w http.ResponseWriter, r *http.Request) {
+	admin.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"node_id": s.cluster.ID(),
+		"nodes":   s.cluster.Nodes(),
+	})
+}
+
 // Stop останавливает server
+// Сначала прекращается прием соединений (SOCKS, WSS, QUIC), устройства уведомляются о draining,
+// затем server ждет завершения активных соединений не дольше shutdown_timeout
+// и только после этого закрывает оставшиеся соединения, QUIC соединения устройств и реестр устройств
 func (s *Server) Stop() error {
 	var errs []error
 
-	if s.inbound != nil {
-		if err := s.inbound.Stop(); err != nil {
+	// Блокируем перезагрузки: inbound не должен быть перезапущен во время остановки
+	s.stopping.Store(true)
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if s.admin != nil {
+		if err := s.admin.Stop(); err != nil {
+			errs = append(errs, fmt.Errorf("error stopping admin API: %w", err))
+		}
+	}
+
+	s.mu.RLock()
+	currentInbound, currentOutbound, pluginManager := s.inbound, s.outbound, s.pluginManager
+	s.mu.RUnlock()
+
+	if currentInbound != nil {
+		if err := currentInbound.Stop(); err != nil {
 			errs = append(errs, fmt.Errorf("error stopping inbound: %w", err))
 		}
 	}
 
+	// Установленные соединения устройств продолжают работать до конца drain
 	if s.wssServer != nil {
 		if err := s.wssServer.Stop(); err != nil {
 			errs = append(errs, fmt.Errorf("error stopping WSS server: %w", err))
 		}
 	}
+	if s.quicServer != nil {
+		if err := s.quicServer.StopAccepting(); err != nil {
+			errs = append(errs, fmt.Errorf("error stopping QUIC listener: %w", err))
+		}
+	}
+
+	// Незавершенные подключения прерываются сразу: клиент получает ошибку, а не ждет конца drain.
+	// При обновлении бинарника они завершаются в этом процессе
+	if !s.upgraded.Load() {
+		s.cancel()
+	}
+	s.drain()
+	s.cancel()
+
+	// Соединения других POP через устройства этого POP закрываются вместе с устройствами
+	if s.cluster != nil {
+		if err := s.cluster.Stop(); err != nil {
+			errs = append(errs, fmt.Errorf("error stopping cluster node: %w", err))
+		}
+	}
 
 	if s.quicServer != nil {
 		if err := s.quicServer.Stop(); err != nil {
@@ -249,16 +1339,17 @@ func (s *Server) Stop() error {
 		}
 	}
 
-	if s.pluginManager != nil {
-		if err := s.pluginManager.Close(); err != nil {
+	if pluginManager != nil {
+		if err := pluginManager.Close(); err != nil {
 			errs = append(errs, fmt.Errorf("error closing plugins: %w", err))
 		}
 	}
 
+	closeOutbound(currentOutbound)
+
 	if len(errs) > 0 {
 		return fmt.Errorf("errors stopping server: %v", errs)
 	}
 
 	return nil
 }
-
@@ -0,0 +1,696 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/acl"
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/plugins/connlimit"
+	"example.com/me/myproxy/internal/plugins/quota"
+	"example.com/me/myproxy/internal/upgrade"
+)
+
+// freePort возвращает свободный TCP порт на loopback
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка поиска свободного порта: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startEchoServer запускает TCP echo server
+func startEchoServer(t *testing.T) *net.TCPAddr {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка запуска echo server: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr)
+}
+
+// dialSOCKS5 устанавливает CONNECT через SOCKS5 прокси
+func dialSOCKS5(proxyPort int, target *net.TCPAddr) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort), time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	request := []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01}
+	request = append(request, target.IP.To4()...)
+	request = binary.BigEndian.AppendUint16(request, uint16(target.Port))
+	if _, err := conn.Write(request); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reply := make([]byte, 2+10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if reply[3] != 0x00 {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS5 reply code %d", reply[3])
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// checkEcho проверяет, что соединение через прокси передает данные
+func checkEcho(t *testing.T, conn net.Conn, message string) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write([]byte(message)); err != nil {
+		t.Fatalf("Ошибка записи: %v", err)
+	}
+	buf := make([]byte, len(message))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Ошибка чтения: %v", err)
+	}
+	if string(buf) != message {
+		t.Errorf("Неверные данные: ожидалось %q, получено %q", message, buf)
+	}
+}
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Ошибка записи конфига: %v", err)
+	}
+}
+
+func TestServer_Reload(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	echoAddr := startEchoServer(t)
+	port1 := freePort(t)
+	port2 := freePort(t)
+
+	writeConfig(t, configFile, fmt.Sprintf(`{
+		"inbound": {"type": "socks5", "listen": "127.0.0.1", "port": %d},
+		"outbound": {"type": "direct"},
+		"plugins": {"traffic_inbound": {"enabled": true}}
+	}`, port1))
+
+	cfg, err := config.LoadFile(configFile)
+	if err != nil {
+		t.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+	srv := NewServer(cfg)
+	if err := srv.Initialize(); err != nil {
+		t.Fatalf("Ошибка инициализации: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Ошибка запуска: %v", err)
+	}
+	defer srv.Stop()
+
+	established, err := dialSOCKS5(port1, echoAddr)
+	if err != nil {
+		t.Fatalf("Ошибка подключения через прокси: %v", err)
+	}
+	defer established.Close()
+	checkEcho(t, established, "before reload")
+
+	trafficPlugin := srv.plugins["traffic_inbound"].plugin
+
+	t.Run("invalid JSON keeps old config", func(t *testing.T) {
+		writeConfig(t, configFile, `{"inbound": `)
+		if err := srv.Reload(); err == nil {
+			t.Fatal("Ожидалась ошибка для невалидного JSON")
+		}
+		if srv.cfg != cfg {
+			t.Error("Конфигурация заменена несмотря на ошибку")
+		}
+	})
+
+	t.Run("invalid outbound keeps old config", func(t *testing.T) {
+		writeConfig(t, configFile, fmt.Sprintf(`{
+			"inbound": {"type": "socks5", "listen": "127.0.0.1", "port": %d},
+			"outbound": {"type": "unknown"}
+		}`, port2))
+		if err := srv.Reload(); err == nil {
+			t.Fatal("Ожидалась ошибка для неизвестного outbound")
+		}
+		if srv.cfg != cfg {
+			t.Error("Конфигурация заменена несмотря на ошибку")
+		}
+		// Старый inbound продолжает принимать соединения
+		conn, err := dialSOCKS5(port1, echoAddr)
+		if err != nil {
+			t.Fatalf("Старый inbound не работает: %v", err)
+		}
+		conn.Close()
+	})
+
+	t.Run("valid config is applied", func(t *testing.T) {
+		writeConfig(t, configFile, fmt.Sprintf(`{
+			"inbound": {"type": "socks5", "listen": "127.0.0.1", "port": %d, "id": "reloaded"},
+			"outbound": {"type": "direct"},
+			"plugins": {
+				"traffic_inbound": {"enabled": true},
+				"connlimit": {"enabled": true, "config": {"max_connections": 10}}
+			}
+		}`, port2))
+		if err := srv.Reload(); err != nil {
+			t.Fatalf("Ошибка перезагрузки: %v", err)
+		}
+
+		if srv.cfg.Inbound.ID != "reloaded" {
+			t.Errorf("Конфигурация не применена: inbound id %q", srv.cfg.Inbound.ID)
+		}
+		if srv.plugins["traffic_inbound"].plugin != trafficPlugin {
+			t.Error("Плагин с неизмененной конфигурацией пересоздан")
+		}
+		if _, ok := srv.plugins["connlimit"]; !ok {
+			t.Error("Новый плагин не включен")
+		}
+
+		// Установленное соединение не разорвано
+		checkEcho(t, established, "after reload")
+
+		// Новый порт принимает соединения, старый закрыт
+		conn, err := dialSOCKS5(port2, echoAddr)
+		if err != nil {
+			t.Fatalf("Ошибка подключения к новому порту: %v", err)
+		}
+		checkEcho(t, conn, "new port")
+		conn.Close()
+
+		if _, err := dialSOCKS5(port1, echoAddr); err == nil {
+			t.Error("Старый порт продолжает принимать соединения")
+		}
+	})
+
+	t.Run("client filter is replaced", func(t *testing.T) {
+		writeConfig(t, configFile, fmt.Sprintf(`{
+			"inbound": {"type": "socks5", "listen": "127.0.0.1", "port": %d, "deny": ["127.0.0.0/8"]},
+			"outbound": {"type": "direct"}
+		}`, port2))
+		if err := srv.Reload(); err != nil {
+			t.Fatalf("Ошибка перезагрузки: %v", err)
+		}
+		if _, err := dialSOCKS5(port2, echoAddr); err == nil {
+			t.Error("Соединение не отклонено новым фильтром")
+		}
+		checkEcho(t, established, "after filter change")
+	})
+}
+
+func TestServer_ReloadMissingFileKeepsConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	echoAddr := startEchoServer(t)
+	port := freePort(t)
+	writeConfig(t, configFile, fmt.Sprintf(`{
+		"inbound": {"type": "socks5", "listen": "127.0.0.1", "port": %d},
+		"outbound": {"type": "direct"},
+		"destination_policy": {"deny_ports": [25]}
+	}`, port))
+
+	cfg, err := config.LoadFile(configFile)
+	if err != nil {
+		t.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+	srv := NewServer(cfg)
+	if err := srv.Initialize(); err != nil {
+		t.Fatalf("Ошибка инициализации: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Ошибка запуска: %v", err)
+	}
+	defer srv.Stop()
+
+	// Файл удален (например, во время выкладки): перезагрузка отклоняется вместо значений по умолчанию
+	os.Remove(configFile)
+	if err := srv.Reload(); err == nil {
+		t.Fatal("Ожидалась ошибка перезагрузки удаленного файла")
+	}
+	if srv.cfg != cfg {
+		t.Error("Текущая конфигурация заменена")
+	}
+	conn, err := dialSOCKS5(port, echoAddr)
+	if err != nil {
+		t.Fatalf("Inbound недоступен после неудачной перезагрузки: %v", err)
+	}
+	defer conn.Close()
+	checkEcho(t, conn, "after failed reload")
+}
+
+func TestServer_ReloadKeepsPluginState(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	echoAddr := startEchoServer(t)
+	port := freePort(t)
+	pluginsConfig := func(dailyBytes, maxPerIP int) string {
+		return fmt.Sprintf(`"plugins": {
+			"quota": {"enabled": true, "config": {"default": {"daily_bytes": %d}}},
+			"connlimit": {"enabled": true, "config": {"max_connections_per_ip": %d}}
+		}`, dailyBytes, maxPerIP)
+	}
+
+	writeConfig(t, configFile, fmt.Sprintf(`{
+		"inbound": {"type": "socks5", "listen": "127.0.0.1", "port": %d, "id": "main"},
+		"outbound": {"type": "direct"},
+		%s
+	}`, port, pluginsConfig(1000000, 5)))
+
+	cfg, err := config.LoadFile(configFile)
+	if err != nil {
+		t.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+	srv := NewServer(cfg)
+	if err := srv.Initialize(); err != nil {
+		t.Fatalf("Ошибка инициализации: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Ошибка запуска: %v", err)
+	}
+	defer srv.Stop()
+
+	established, err := dialSOCKS5(port, echoAddr)
+	if err != nil {
+		t.Fatalf("Ошибка подключения через прокси: %v", err)
+	}
+	defer established.Close()
+	checkEcho(t, established, "before reload")
+
+	quotaPlugin := srv.plugins["quota"].plugin.(*quota.Plugin)
+	limitPlugin := srv.plugins["connlimit"].plugin.(*connlimit.Plugin)
+	usedBefore := quotaPlugin.GetUsage("main").DayBytes
+	if usedBefore == 0 {
+		t.Fatal("Трафик не учтен в квоте")
+	}
+
+	// Лимиты меняются: плагины перенастраиваются на месте и сохраняют счетчики
+	writeConfig(t, configFile, fmt.Sprintf(`{
+		"inbound": {"type": "socks5", "listen": "127.0.0.1", "port": %d, "id": "main"},
+		"outbound": {"type": "direct"},
+		%s
+	}`, port, pluginsConfig(2000000, 1)))
+	if err := srv.Reload(); err != nil {
+		t.Fatalf("Ошибка перезагрузки: %v", err)
+	}
+
+	if srv.plugins["quota"].plugin != quotaPlugin || srv.plugins["connlimit"].plugin != limitPlugin {
+		t.Fatal("Плагины пересозданы при изменении конфигурации")
+	}
+	if used := quotaPlugin.GetUsage("main").DayBytes; used < usedBefore {
+		t.Errorf("Использование квоты потеряно: было %d, стало %d", usedBefore, used)
+	}
+	if _, perIP, _ := limitPlugin.ActiveConnections("127.0.0.1", ""); perIP != 1 {
+		t.Errorf("Счетчик соединений потерян: ожидалось 1, получено %d", perIP)
+	}
+	// Установленное соединение учитывается в новом лимите
+	if conn, err := dialSOCKS5(port, echoAddr); err == nil {
+		conn.Close()
+		t.Error("Новое соединение не отклонено новым лимитом")
+	}
+
+	// Соединение, установленное до перезагрузки, продолжает учитываться в квоте
+	usedBefore = quotaPlugin.GetUsage("main").DayBytes
+	checkEcho(t, established, "after reload")
+	if used := quotaPlugin.GetUsage("main").DayBytes; used <= usedBefore {
+		t.Error("Трафик после перезагрузки не учтен в квоте")
+	}
+
+	// Неудачная перезагрузка возвращает плагинам прежние лимиты
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка занятия порта: %v", err)
+	}
+	defer busy.Close()
+	writeConfig(t, configFile, fmt.Sprintf(`{
+		"inbound": {"type": "socks5", "listen": "127.0.0.1", "port": %d, "id": "main"},
+		"outbound": {"type": "direct"},
+		%s
+	}`, busy.Addr().(*net.TCPAddr).Port, pluginsConfig(2000000, 5)))
+	if err := srv.Reload(); err == nil {
+		t.Fatal("Ожидалась ошибка для занятого порта")
+	}
+	if conn, err := dialSOCKS5(port, echoAddr); err == nil {
+		conn.Close()
+		t.Error("Лимит не восстановлен после неудачной перезагрузки")
+	}
+}
+
+// dialSOCKS5Domain устанавливает CONNECT к домену через SOCKS5 прокси
+func dialSOCKS5Domain(proxyPort int, host string, port int) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort), time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	request := []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x03, byte(len(host))}
+	request = append(request, host...)
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reply := make([]byte, 2+10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if reply[3] != 0x00 {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS5 reply code %d", reply[3])
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func TestServer_ReloadDNS(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	echoAddr := startEchoServer(t)
+	port := freePort(t)
+
+	writeConfig(t, configFile, fmt.Sprintf(`{
+		"inbound": {"type": "socks5", "listen": "127.0.0.1", "port": %d},
+		"outbound": {"type": "direct"}
+	}`, port))
+	cfg, err := config.LoadFile(configFile)
+	if err != nil {
+		t.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+	srv := NewServer(cfg)
+	if err := srv.Initialize(); err != nil {
+		t.Fatalf("Ошибка инициализации: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Ошибка запуска: %v", err)
+	}
+	defer srv.Stop()
+
+	// Секция dns, добавленная перезагрузкой, применяется к direct outbound
+	writeConfig(t, configFile, fmt.Sprintf(`{
+		"inbound": {"type": "socks5", "listen": "127.0.0.1", "port": %d},
+		"outbound": {"type": "direct"},
+		"dns": {"hosts": {"echo.internal": ["127.0.0.1"]}}
+	}`, port))
+	if err := srv.Reload(); err != nil {
+		t.Fatalf("Ошибка перезагрузки: %v", err)
+	}
+	conn, err := dialSOCKS5Domain(port, "echo.internal", echoAddr.Port)
+	if err != nil {
+		t.Fatalf("Домен из новой секции dns не разрешен: %v", err)
+	}
+	checkEcho(t, conn, "reloaded dns")
+	conn.Close()
+
+	// Невалидная секция dns отклоняет перезагрузку
+	writeConfig(t, configFile, fmt.Sprintf(`{
+		"inbound": {"type": "socks5", "listen": "127.0.0.1", "port": %d},
+		"outbound": {"type": "direct"},
+		"dns": {"hosts": {"echo.internal": ["not-an-ip"]}}
+	}`, port))
+	if err := srv.Reload(); err == nil {
+		t.Fatal("Ожидалась ошибка для невалидной секции dns")
+	}
+	conn, err = dialSOCKS5Domain(port, "echo.internal", echoAddr.Port)
+	if err != nil {
+		t.Fatalf("Текущая секция dns не сохранена: %v", err)
+	}
+	conn.Close()
+}
+
+func TestServer_RoutingRulesMatchResolvedIPs(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	port := freePort(t)
+	cfg := &config.Config{
+		Inbound:  config.InboundConfig{Type: "socks5", Listen: "127.0.0.1", Port: port},
+		Outbound: config.OutboundConfig{Type: "block", ID: "default"},
+		DNS: &config.DNSConfig{Hosts: map[string][]string{
+			"echo.internal":  {"127.0.0.1"},
+			"other.internal": {"192.0.2.1"},
+		}},
+		Routing: &config.RoutingConfig{Rules: []config.RoutingRuleConfig{
+			{IPs: []string{"127.0.0.0/8"}, Outbound: config.OutboundConfig{Type: "direct", ID: "loopback"}},
+		}},
+	}
+	srv := NewServer(cfg)
+	if err := srv.Initialize(); err != nil {
+		t.Fatalf("Ошибка инициализации: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Ошибка запуска: %v", err)
+	}
+	defer srv.Stop()
+
+	// Домен совпадает с правилом по IP, разрешенному секцией dns
+	conn, err := dialSOCKS5Domain(port, "echo.internal", echoAddr.Port)
+	if err != nil {
+		t.Fatalf("Соединение по правилу подсети не установлено: %v", err)
+	}
+	checkEcho(t, conn, "routed")
+	conn.Close()
+
+	// Домен вне подсети правила получает outbound по умолчанию
+	if conn, err := dialSOCKS5Domain(port, "other.internal", echoAddr.Port); err == nil {
+		conn.Close()
+		t.Error("Соединение вне правила не заблокировано")
+	}
+	if count := srv.outbounds.blocks.Counts()["default"]; count != 1 {
+		t.Errorf("Счетчик блокировок %d, ожидался 1", count)
+	}
+}
+
+// startTestServer запускает server с direct outbound на свободном порту
+func startTestServer(t *testing.T, shutdownTimeout int) (*Server, int) {
+	t.Helper()
+	port := freePort(t)
+	cfg := &config.Config{
+		Inbound:         config.InboundConfig{Type: "socks5", Listen: "127.0.0.1", Port: port},
+		Outbound:        config.OutboundConfig{Type: "direct"},
+		ShutdownTimeout: shutdownTimeout,
+	}
+	srv := NewServer(cfg)
+	if err := srv.Initialize(); err != nil {
+		t.Fatalf("Ошибка инициализации: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Ошибка запуска: %v", err)
+	}
+	return srv, port
+}
+
+func TestServer_StopWaitsForConnections(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv, port := startTestServer(t, 10)
+
+	conn, err := dialSOCKS5(port, echoAddr)
+	if err != nil {
+		t.Fatalf("Ошибка подключения через прокси: %v", err)
+	}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.Stop() }()
+
+	// Пока соединение активно, Stop не завершается, а соединение продолжает работать
+	select {
+	case <-stopped:
+		t.Fatal("Stop завершился при активном соединении")
+	case <-time.After(300 * time.Millisecond):
+	}
+	checkEcho(t, conn, "during drain")
+
+	// Новые соединения не принимаются
+	if _, err := dialSOCKS5(port, echoAddr); err == nil {
+		t.Error("Новое соединение принято во время остановки")
+	}
+
+	conn.Close()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Ошибка остановки: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Stop не завершился после закрытия соединения")
+	}
+}
+
+func TestServer_StopForceClosesAfterTimeout(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv, port := startTestServer(t, 1)
+
+	conn, err := dialSOCKS5(port, echoAddr)
+	if err != nil {
+		t.Fatalf("Ошибка подключения через прокси: %v", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	if err := srv.Stop(); err != nil {
+		t.Errorf("Ошибка остановки: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 4*time.Second {
+		t.Errorf("Неожиданное время остановки: %s", elapsed)
+	}
+
+	// Соединение закрыто server
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Соединение не закрыто после таймаута остановки")
+	}
+
+	if err := srv.Reload(); err == nil {
+		t.Error("Перезагрузка после остановки должна возвращать ошибку")
+	}
+}
+
+func TestServer_UpgradeRejectsStdinConfig(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	originalStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = originalStdin }()
+	go func() {
+		w.Write([]byte(`{"inbound": {"type": "socks5", "listen": "127.0.0.1", "port": 1080}, "outbound": {"type": "direct"}}`))
+		w.Close()
+	}()
+
+	cfg, err := config.LoadFile(config.StdinPath)
+	if err != nil {
+		t.Fatalf("Ошибка загрузки конфигурации из stdin: %v", err)
+	}
+	upgrader, err := upgrade.New()
+	if err != nil {
+		t.Fatalf("Ошибка создания upgrader: %v", err)
+	}
+	srv := NewServer(cfg)
+	srv.SetUpgrader(upgrader)
+
+	// Новый процесс не получил бы конфигурацию: stdin уже прочитан
+	if err := srv.Upgrade(); err == nil || !strings.Contains(err.Error(), "stdin") {
+		t.Errorf("Ожидался отказ в обновлении для конфигурации из stdin, получено %v", err)
+	}
+}
+
+func TestOutboundFactory_ChainWithLocalResolve(t *testing.T) {
+	s := &Server{}
+	factory, err := s.newOutboundFactory(nil)
+	if err != nil {
+		t.Fatalf("Ошибка создания фабрики outbound: %v", err)
+	}
+
+	// Прокси с resolve "local" допустим как звено цепочки
+	cfg := &config.OutboundConfig{
+		Type: "chain",
+		Outbounds: []config.OutboundConfig{
+			{Type: "direct"},
+			{Type: "socks5", ProxyAddress: "127.0.0.1:1080", Resolve: "local"},
+			{Type: "http", ProxyAddress: "127.0.0.1:3128", Resolve: "local"},
+		},
+	}
+	ob, err := factory.newOutbound(cfg)
+	if err != nil {
+		t.Fatalf("Ошибка создания цепочки с resolve local: %v", err)
+	}
+	closeOutbound(ob)
+
+	// Ошибка в звене после уже созданных возвращается как ошибка цепочки
+	cfg.Outbounds = append(cfg.Outbounds, config.OutboundConfig{Type: "block"})
+	if _, err := factory.newOutbound(cfg); err == nil || !strings.Contains(err.Error(), "chain member 3") {
+		t.Errorf("Ожидалась ошибка звена 3, получено %v", err)
+	}
+}
+
+// rebindingResolver отвечает на первый запрос публичным адресом, на следующие - loopback
+type rebindingResolver struct {
+	lookups atomic.Int32
+}
+
+func (r *rebindingResolver) LookupIP(_ context.Context, host string) ([]net.IP, error) {
+	if r.lookups.Add(1) == 1 {
+		return []net.IP{net.ParseIP("93.184.216.34")}, nil
+	}
+	return []net.IP{net.ParseIP("127.0.0.1")}, nil
+}
+
+func TestOutboundFactory_DirectChecksDialedAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan struct{}, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+			accepted <- struct{}{}
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	address := net.JoinHostPort("rebind.example", port)
+
+	resolver := &rebindingResolver{}
+	destPolicy, err := acl.NewPolicy(&config.DestinationPolicyConfig{BlockPrivate: true, ResolveDomains: true})
+	if err != nil {
+		t.Fatalf("Ошибка создания политики: %v", err)
+	}
+	destPolicy.SetResolver(resolver)
+
+	s := &Server{}
+	factory, err := s.newOutboundFactory(nil)
+	if err != nil {
+		t.Fatalf("Ошибка создания фабрики outbound: %v", err)
+	}
+	factory.directResolver = resolver
+	factory.destPolicy = destPolicy
+	ob, err := factory.newOutbound(&config.OutboundConfig{Type: "direct"})
+	if err != nil {
+		t.Fatalf("Ошибка создания direct outbound: %v", err)
+	}
+
+	// Проверка политики видит публичный адрес, а при подключении домен разрешается в loopback
+	if err := destPolicy.CheckAddressContext(context.Background(), address); err != nil {
+		t.Fatalf("Домен должен пройти проверку политики: %v", err)
+	}
+	conn, err := ob.DialContext(context.Background(), "tcp", address)
+	if err == nil {
+		conn.Close()
+		t.Fatal("Ожидался отказ в подключении к адресу, запрещенному политикой")
+	}
+	if !errors.Is(err, connerr.ErrNotAllowed) {
+		t.Errorf("Ожидалась ошибка %v, получено %v", connerr.ErrNotAllowed, err)
+	}
+	select {
+	case <-accepted:
+		t.Error("Соединение с запрещенным адресом установлено")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net"
+	"sync"
+)
+
+// connTracker учитывает активные клиентские соединения для graceful shutdown
+type connTracker struct {
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	draining bool
+	idle     chan struct{} // Закрывается, когда в режиме draining не осталось соединений
+}
+
+// newConnTracker создает новый connTracker
+func newConnTracker() *connTracker {
+	return &connTracker{
+		conns: make(map[net.Conn]struct{}),
+		idle:  make(chan struct{}),
+	}
+}
+
+// add регистрирует соединение, возвращает false в режиме draining
+func (t *connTracker) add(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return false
+	}
+	t.conns[conn] = struct{}{}
+	return true
+}
+
+// remove снимает соединение с учета
+func (t *connTracker) remove(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns, conn)
+	t.signalIdleLocked()
+}
+
+// drain переводит трекер в режим draining и возвращает канал,
+// который закрывается после завершения всех соединений
+func (t *connTracker) drain() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.draining = true
+	t.signalIdleLocked()
+	return t.idle
+}
+
+// signalIdleLocked закрывает idle, если draining и соединений нет (вызывается под mu)
+func (t *connTracker) signalIdleLocked() {
+	if !t.draining || len(t.conns) > 0 {
+		return
+	}
+	select {
+	case <-t.idle:
+	default:
+		close(t.idle)
+	}
+}
+
+// count возвращает количество активных соединений
+func (t *connTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// closeAll принудительно закрывает все активные соединения, возвращает их количество
+func (t *connTracker) closeAll() int {
+	t.mu.Lock()
+	conns := make([]net.Conn, 0, len(t.conns))
+	for conn := range t.conns {
+		conns = append(conns, conn)
+	}
+	t.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return len(conns)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnTracker(t *testing.T) {
+	tracker := newConnTracker()
+	client, server := net.Pipe()
+	defer client.Close()
+
+	if !tracker.add(server) {
+		t.Fatal("Соединение не добавлено")
+	}
+	if tracker.count() != 1 {
+		t.Errorf("Неверное количество соединений: %d", tracker.count())
+	}
+
+	idle := tracker.drain()
+	select {
+	case <-idle:
+		t.Fatal("idle закрыт при активном соединении")
+	default:
+	}
+
+	if tracker.add(client) {
+		t.Error("Соединение добавлено в режиме draining")
+	}
+
+	if closed := tracker.closeAll(); closed != 1 {
+		t.Errorf("Неверное количество закрытых соединений: %d", closed)
+	}
+	tracker.remove(server)
+
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		t.Fatal("idle не закрыт после завершения всех соединений")
+	}
+
+	// Повторные drain/remove не паникуют
+	<-tracker.drain()
+	tracker.remove(server)
+}
@@ -0,0 +1,143 @@
+// Package sniff определяет протокол и домен назначения по первым байтам клиента
+// (TLS ClientHello SNI, HTTP Host), когда клиент передал адрес назначения IP адресом
+package sniff
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+)
+
+// MaxPeekSize максимальный объем данных для определения протокола: TLS запись
+// максимального размера с заголовком
+const MaxPeekSize = recordHeaderSize + maxRecordSize
+
+var (
+	// ErrIncomplete данных недостаточно, нужно прочитать еще
+	ErrIncomplete = errors.New("sniff: incomplete data")
+	// ErrUnknownProtocol данные не похожи ни на один из поддерживаемых протоколов
+	ErrUnknownProtocol = errors.New("sniff: unknown protocol")
+)
+
+// Протоколы, определяемые Sniff
+const (
+	ProtocolTLS  = "tls"
+	ProtocolHTTP = "http"
+)
+
+// Result результат определения протокола
+type Result struct {
+	Protocol string // ProtocolTLS или ProtocolHTTP
+	Domain   string // Домен назначения (пусто - клиент его не передал)
+}
+
+// sniffer определяет один протокол по началу данных
+type sniffer func(data []byte) (string, error)
+
+var sniffers = []struct {
+	protocol string
+	sniff    sniffer
+}{
+	{ProtocolTLS, TLS},
+	{ProtocolHTTP, HTTP},
+}
+
+// Sniff определяет протокол и домен по началу данных клиента
+// Возвращает ErrIncomplete, если какой-то протокол еще возможен, но данных недостаточно
+func Sniff(data []byte) (Result, error) {
+	incomplete := false
+	for _, s := range sniffers {
+		domain, err := s.sniff(data)
+		switch {
+		case err == nil:
+			return Result{Protocol: s.protocol, Domain: domain}, nil
+		case errors.Is(err, ErrIncomplete):
+			incomplete = true
+		}
+	}
+	if incomplete {
+		return Result{}, ErrIncomplete
+	}
+	return Result{}, ErrUnknownProtocol
+}
+
+// httpMethods методы HTTP запросов, по которым определяется протокол
+var httpMethods = []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+// HTTP возвращает домен из заголовка Host HTTP/1.x запроса
+// Пустой домен без ошибки - запрос без Host или Host с IP адресом
+func HTTP(data []byte) (string, error) {
+	if err := httpMethod(data); err != nil {
+		return "", err
+	}
+
+	// Первая строка - строка запроса, заголовки до пустой строки
+	lines := data
+	first := true
+	for {
+		end := bytes.Index(lines, []byte("\r\n"))
+		if end < 0 {
+			return "", ErrIncomplete
+		}
+		line := lines[:end]
+		lines = lines[end+2:]
+		if first {
+			if !bytes.Contains(line, []byte(" HTTP/1.")) {
+				return "", ErrUnknownProtocol
+			}
+			first = false
+			continue
+		}
+		if len(line) == 0 {
+			return "", nil
+		}
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		if ok && strings.EqualFold(string(name), "Host") {
+			return hostDomain(strings.TrimSpace(string(value))), nil
+		}
+	}
+}
+
+// httpMethod проверяет, что data начинается с метода HTTP и пробела
+func httpMethod(data []byte) error {
+	for _, method := range httpMethods {
+		prefix := method + " "
+		if len(data) >= len(prefix) {
+			if string(data[:len(prefix)]) == prefix {
+				return nil
+			}
+		} else if strings.HasPrefix(prefix, string(data)) {
+			return ErrIncomplete
+		}
+	}
+	return ErrUnknownProtocol
+}
+
+// hostDomain возвращает домен из значения Host (host или host:port), пусто - IP адрес или некорректный домен
+func hostDomain(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return normalizeDomain(host)
+}
+
+// normalizeDomain приводит домен к нижнему регистру без завершающей точки
+// Возвращает пусто для IP адреса и строки, не являющейся доменом
+func normalizeDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" || len(domain) > 253 || net.ParseIP(domain) != nil {
+		return ""
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 {
+			return ""
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return ""
+			}
+		}
+	}
+	return domain
+}
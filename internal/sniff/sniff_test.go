@@ -0,0 +1,82 @@
+package sniff
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+)
+
+// clientHello возвращает TLS ClientHello, который отправляет crypto/tls для serverName
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+		client.Close()
+	}()
+
+	buf := make([]byte, MaxPeekSize)
+	n := 0
+	for {
+		m, err := server.Read(buf[n:])
+		n += m
+		if _, sniffErr := TLS(buf[:n]); !errors.Is(sniffErr, ErrIncomplete) || err != nil {
+			return buf[:n]
+		}
+	}
+}
+
+func TestSniff_TLS(t *testing.T) {
+	hello := clientHello(t, "Example.COM")
+
+	result, err := Sniff(hello)
+	if err != nil {
+		t.Fatalf("Ошибка определения протокола: %v", err)
+	}
+	if result.Protocol != ProtocolTLS || result.Domain != "example.com" {
+		t.Errorf("Неверный результат: протокол %q, домен %q", result.Protocol, result.Domain)
+	}
+
+	// Неполный ClientHello
+	if _, err := Sniff(hello[:len(hello)-1]); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("Ожидалась ErrIncomplete, получено %v", err)
+	}
+
+	// ClientHello без SNI (подключение по IP)
+	result, err = Sniff(clientHello(t, "192.0.2.1"))
+	if err != nil || result.Protocol != ProtocolTLS || result.Domain != "" {
+		t.Errorf("Неверный результат без SNI: протокол %q, домен %q, ошибка %v", result.Protocol, result.Domain, err)
+	}
+}
+
+func TestSniff_HTTP(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		domain string
+		err    error
+	}{
+		{"host", "GET / HTTP/1.1\r\nUser-Agent: curl\r\nHost: www.Example.com\r\n\r\n", "www.example.com", nil},
+		{"host with port", "POST /api HTTP/1.1\r\nhost: example.com:8080\r\n", "example.com", nil},
+		{"ip host", "GET / HTTP/1.1\r\nHost: [2001:db8::1]:80\r\n\r\n", "", nil},
+		{"no host", "GET / HTTP/1.0\r\n\r\n", "", nil},
+		{"partial method", "PO", "", ErrIncomplete},
+		{"partial headers", "GET / HTTP/1.1\r\nUser-Agent: curl\r\n", "", ErrIncomplete},
+		{"ssh", "SSH-2.0-OpenSSH_9.6\r\n", "", ErrUnknownProtocol},
+		{"not http", "GET something\r\n", "", ErrUnknownProtocol},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Sniff([]byte(tt.data))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Ожидалась ошибка %v, получено %v", tt.err, err)
+			}
+			if err == nil && (result.Protocol != ProtocolHTTP || result.Domain != tt.domain) {
+				t.Errorf("Неверный результат: протокол %q, домен %q", result.Protocol, result.Domain)
+			}
+		})
+	}
+}
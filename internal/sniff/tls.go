@@ -0,0 +1,138 @@
+package sniff
+
+import "encoding/binary"
+
+const (
+	// recordHeaderSize размер заголовка TLS записи: тип, версия, длина
+	recordHeaderSize = 5
+	// maxRecordSize максимальный размер данных TLS записи
+	maxRecordSize = 16384
+
+	recordTypeHandshake      = 0x16
+	handshakeTypeClientHello = 0x01
+	extensionServerName      = 0x0000
+	serverNameTypeHostName   = 0x00
+)
+
+// TLS возвращает домен из расширения server_name (SNI) TLS ClientHello
+// Пустой домен без ошибки - ClientHello без SNI
+// ClientHello должен целиком помещаться в первую TLS запись
+func TLS(data []byte) (string, error) {
+	if len(data) < recordHeaderSize {
+		if len(data) > 0 && data[0] != recordTypeHandshake || len(data) > 1 && data[1] != 0x03 {
+			return "", ErrUnknownProtocol
+		}
+		return "", ErrIncomplete
+	}
+	if data[0] != recordTypeHandshake || data[1] != 0x03 {
+		return "", ErrUnknownProtocol
+	}
+	recordLen := int(binary.BigEndian.Uint16(data[3:5]))
+	if recordLen == 0 || recordLen > maxRecordSize {
+		return "", ErrUnknownProtocol
+	}
+	if len(data) < recordHeaderSize+recordLen {
+		return "", ErrIncomplete
+	}
+	record := data[recordHeaderSize : recordHeaderSize+recordLen]
+
+	// Заголовок handshake: тип и длина (3 байта)
+	if len(record) < 4 || record[0] != handshakeTypeClientHello {
+		return "", ErrUnknownProtocol
+	}
+	helloLen := int(record[1])<<16 | int(record[2])<<8 | int(record[3])
+	if helloLen > len(record)-4 {
+		return "", ErrUnknownProtocol
+	}
+	return clientHelloServerName(record[4 : 4+helloLen])
+}
+
+// clientHelloServerName разбирает тело ClientHello и возвращает SNI
+func clientHelloServerName(hello []byte) (string, error) {
+	r := reader(hello)
+	// client_version и random
+	if !r.skip(2 + 32) {
+		return "", ErrUnknownProtocol
+	}
+	// session_id, cipher_suites, compression_methods
+	if _, ok := r.vector(1); !ok {
+		return "", ErrUnknownProtocol
+	}
+	if _, ok := r.vector(2); !ok {
+		return "", ErrUnknownProtocol
+	}
+	if _, ok := r.vector(1); !ok {
+		return "", ErrUnknownProtocol
+	}
+	if len(r) == 0 {
+		// ClientHello без расширений
+		return "", nil
+	}
+	extensions, ok := r.vector(2)
+	if !ok {
+		return "", ErrUnknownProtocol
+	}
+
+	for len(extensions) > 0 {
+		if len(extensions) < 2 {
+			return "", ErrUnknownProtocol
+		}
+		extType := binary.BigEndian.Uint16(extensions)
+		extensions = extensions[2:]
+		extData, ok := extensions.vector(2)
+		if !ok {
+			return "", ErrUnknownProtocol
+		}
+		if extType != extensionServerName {
+			continue
+		}
+
+		names, ok := extData.vector(2)
+		if !ok {
+			return "", ErrUnknownProtocol
+		}
+		for len(names) > 0 {
+			nameType := names[0]
+			names = names[1:]
+			name, ok := names.vector(2)
+			if !ok {
+				return "", ErrUnknownProtocol
+			}
+			if nameType == serverNameTypeHostName {
+				return normalizeDomain(string(name)), nil
+			}
+		}
+		return "", nil
+	}
+	return "", nil
+}
+
+// reader последовательное чтение полей TLS сообщения
+type reader []byte
+
+// skip пропускает n байт
+func (r *reader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+// vector читает вектор с префиксом длины lenSize байт
+func (r *reader) vector(lenSize int) (reader, bool) {
+	if len(*r) < lenSize {
+		return nil, false
+	}
+	n := 0
+	for _, b := range (*r)[:lenSize] {
+		n = n<<8 | int(b)
+	}
+	*r = (*r)[lenSize:]
+	if len(*r) < n {
+		return nil, false
+	}
+	v := (*r)[:n]
+	*r = (*r)[n:]
+	return v, true
+}
@@ -0,0 +1,61 @@
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+
+	"example.com/me/myproxy/config"
+)
+
+// CertStore хранит текущий сертификат сервера с возможностью замены без перезапуска
+// Новые TLS handshake используют новый сертификат, установленные соединения не затрагиваются
+type CertStore struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewCertStore создает хранилище сертификата из конфига
+// Возвращает nil, если TLS выключен или сертификат не задан
+func NewCertStore(tlsConfig *config.TLSConfig) (*CertStore, error) {
+	cert, err := LoadCertificate(tlsConfig)
+	if err != nil || cert == nil {
+		return nil, err
+	}
+	return &CertStore{cert: cert}, nil
+}
+
+// LoadCertificate загружает сертификат из файлов конфига (nil, если TLS выключен)
+func LoadCertificate(tlsConfig *config.TLSConfig) (*tls.Certificate, error) {
+	if tlsConfig == nil || !tlsConfig.Enabled || tlsConfig.CertFile == "" || tlsConfig.KeyFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// Set заменяет текущий сертификат
+func (s *CertStore) Set(cert *tls.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cert = cert
+}
+
+// GetCertificate возвращает текущий сертификат (для tls.Config.GetCertificate)
+func (s *CertStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, nil
+}
+
+// TLSConfig создает TLS конфигурацию, использующую сертификат из хранилища
+// Каждый вызов возвращает отдельный tls.Config, чтобы серверы не влияли друг на друга (NextProtos)
+func (s *CertStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: s.GetCertificate,
+	}
+}
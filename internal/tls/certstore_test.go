@@ -0,0 +1,75 @@
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/me/myproxy/config"
+)
+
+// writeSelfSignedCert записывает самоподписанный сертификат в файлы
+func writeSelfSignedCert(t *testing.T, dir string) *config.TLSConfig {
+	t.Helper()
+
+	cert, err := GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Ошибка генерации сертификата: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("Ошибка сериализации ключа: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("Ошибка записи сертификата: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("Ошибка записи ключа: %v", err)
+	}
+
+	return &config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}
+}
+
+func TestCertStore(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		store, err := NewCertStore(&config.TLSConfig{Enabled: false})
+		if err != nil || store != nil {
+			t.Errorf("Ожидался nil store без ошибки, получено %v, %v", store, err)
+		}
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		_, err := NewCertStore(&config.TLSConfig{Enabled: true, CertFile: "/nonexistent.pem", KeyFile: "/nonexistent.key"})
+		if err == nil {
+			t.Error("Ожидалась ошибка для несуществующих файлов")
+		}
+	})
+
+	t.Run("replace certificate", func(t *testing.T) {
+		store, err := NewCertStore(writeSelfSignedCert(t, t.TempDir()))
+		if err != nil {
+			t.Fatalf("Ошибка создания store: %v", err)
+		}
+
+		first, _ := store.GetCertificate(nil)
+		second, err := LoadCertificate(writeSelfSignedCert(t, t.TempDir()))
+		if err != nil {
+			t.Fatalf("Ошибка загрузки сертификата: %v", err)
+		}
+		store.Set(second)
+
+		cfg := store.TLSConfig()
+		current, _ := cfg.GetCertificate(&tls.ClientHelloInfo{})
+		if current == first || current != second {
+			t.Error("Сертификат не заменен")
+		}
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/x509/pkix"
 	"fmt"
 	"math/big"
+	"os"
 	"time"
 
 	"example.com/me/myproxy/config"
@@ -32,6 +33,34 @@ func NewTLSConfig(tlsConfig *config.TLSConfig) (*tls.Config, error) {
 	return nil, nil
 }
 
+// NewClientTLSConfig создает TLS конфигурацию подключения к вышестоящему прокси (nil, если TLS выключен)
+// serverName используется для SNI и проверки сертификата, если server_name не задан
+func NewClientTLSConfig(tlsConfig *config.OutboundTLSConfig, serverName string) (*tls.Config, error) {
+	if tlsConfig == nil || !tlsConfig.Enabled {
+		return nil, nil
+	}
+
+	clientConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: tlsConfig.InsecureSkipVerify,
+	}
+	if tlsConfig.ServerName != "" {
+		clientConfig.ServerName = tlsConfig.ServerName
+	}
+	if tlsConfig.CAFile != "" {
+		pem, err := os.ReadFile(tlsConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", tlsConfig.CAFile)
+		}
+		clientConfig.RootCAs = roots
+	}
+	return clientConfig, nil
+}
+
 // GenerateSelfSignedCert генерирует самоподписанный TLS сертификат для тестирования
 func GenerateSelfSignedCert() (tls.Certificate, error) {
 	key, err := rsa.GenerateKey(rand.Reader, 2048)
@@ -0,0 +1,481 @@
+package upgrade
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"example.com/me/myproxy/internal/logger"
+	"github.com/quic-go/quic-go"
+)
+
+// Во время drain унаследованный QUIC сокет читают оба процесса, и ядро доставляет
+// каждый пакет случайному из них. Поколение процесса (номер обновления) записывается
+// в начало выдаваемых им connection ID, а пакеты соединений другого поколения
+// пересылаются процессу-владельцу через пару pipe.
+
+const (
+	// connIDLen длина connection ID: 2 байта поколения и 6 случайных байт
+	connIDLen = 8
+	// relayQueueSize пакетов в очереди пересылки; при переполнении пакеты отбрасываются, как в UDP
+	relayQueueSize = 1024
+	// packetQueueSize пакетов, ожидающих чтения QUIC Transport
+	packetQueueSize = 1024
+	// maxPacketSize максимальный размер UDP пакета
+	maxPacketSize = 65535
+
+	relayToParent = "relay-parent" // Дочерний процесс пишет, родитель читает
+	relayToChild  = "relay-child"  // Родитель пишет, дочерний процесс читает
+)
+
+// relayKey ключ pipe пересылки для сокета key
+func relayKey(direction, key string) string {
+	return direction + "/" + key
+}
+
+// ListenQUIC возвращает QUIC Transport на унаследованном или новом UDP сокете
+// Пока работают оба поколения процесса, каждое получает пакеты только своих соединений,
+// новые соединения принимает новый процесс
+func (u *Upgrader) ListenQUIC(network, address string) (*quic.Transport, error) {
+	conn, err := u.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if u == nil || !ok {
+		return &quic.Transport{Conn: conn}, nil
+	}
+
+	key := socketKey(network, address)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	routed := newRoutedConn(udpConn, u.generation)
+	r, okR := u.inherited[relayKey(relayToChild, key)]
+	w, okW := u.inherited[relayKey(relayToParent, key)]
+	if okR && okW {
+		delete(u.inherited, relayKey(relayToChild, key))
+		delete(u.inherited, relayKey(relayToParent, key))
+		link := newRelayLink(u.generation-1, r, w)
+		link.start(routed)
+		routed.setParent(link)
+		logger.Debug("upgrade", "Relaying QUIC packets of generation %d on %s to parent process", u.generation-1, key)
+	}
+	u.routed[key] = routed
+
+	return &quic.Transport{Conn: routed, ConnectionIDGenerator: connIDGenerator{generation: u.generation}}, nil
+}
+
+// openRelays создает pipe пересылки для QUIC сокетов и добавляет концы дочернего процесса к files
+// Связи начинают пересылку к дочернему процессу только после attachRelays
+func (u *Upgrader) openRelays(files []*os.File, spec []string) ([]*os.File, []string, []*pendingRelay, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var relays []*pendingRelay
+	for key, routed := range u.routed {
+		if routed.isClosed() {
+			delete(u.routed, key)
+			continue
+		}
+		toChildR, toChildW, err := os.Pipe()
+		if err != nil {
+			closeRelays(relays)
+			return files, spec, nil, fmt.Errorf("failed to create relay pipe: %w", err)
+		}
+		toParentR, toParentW, err := os.Pipe()
+		if err != nil {
+			toChildR.Close()
+			toChildW.Close()
+			closeRelays(relays)
+			return files, spec, nil, fmt.Errorf("failed to create relay pipe: %w", err)
+		}
+
+		spec = append(spec, fmt.Sprintf("%s=%d", relayKey(relayToChild, key), firstExtraFD+len(files)))
+		files = append(files, toChildR)
+		spec = append(spec, fmt.Sprintf("%s=%d", relayKey(relayToParent, key), firstExtraFD+len(files)))
+		files = append(files, toParentW)
+
+		link := newRelayLink(u.generation+1, toParentR, toChildW)
+		link.start(routed)
+		relays = append(relays, &pendingRelay{conn: routed, link: link})
+	}
+	return files, spec, relays, nil
+}
+
+// pendingRelay связь с дочерним процессом, подключаемая после его готовности
+type pendingRelay struct {
+	conn *routedConn
+	link *relayLink
+}
+
+// attachRelays направляет дочернему процессу новые соединения и соединения его поколения
+func attachRelays(relays []*pendingRelay) {
+	for _, relay := range relays {
+		relay.conn.setChild(relay.link)
+	}
+}
+
+// closeRelays закрывает связи, если дочерний процесс не запустился
+func closeRelays(relays []*pendingRelay) {
+	for _, relay := range relays {
+		relay.link.close()
+	}
+}
+
+// connIDGenerator выдает connection ID с префиксом поколения процесса
+type connIDGenerator struct {
+	generation uint16
+}
+
+func (g connIDGenerator) GenerateConnectionID() (quic.ConnectionID, error) {
+	b := make([]byte, connIDLen)
+	binary.BigEndian.PutUint16(b, g.generation)
+	if _, err := rand.Read(b[2:]); err != nil {
+		return quic.ConnectionID{}, err
+	}
+	return quic.ConnectionIDFromBytes(b), nil
+}
+
+func (g connIDGenerator) ConnectionIDLen() int {
+	return connIDLen
+}
+
+// packetGeneration возвращает поколение процесса из destination connection ID пакета
+// Первые пакеты нового соединения несут случайный ID клиента и попадают в произвольное поколение
+func packetGeneration(data []byte) (uint16, bool) {
+	var dcid []byte
+	switch {
+	case len(data) == 0:
+		return 0, false
+	case data[0]&0x80 != 0:
+		// Длинный заголовок: флаги, версия (4 байта), длина DCID, DCID
+		if len(data) < 6 || len(data) < 6+int(data[5]) {
+			return 0, false
+		}
+		dcid = data[6 : 6+int(data[5])]
+	default:
+		// Короткий заголовок: флаги, DCID известной длины
+		if len(data) < 1+connIDLen {
+			return 0, false
+		}
+		dcid = data[1 : 1+connIDLen]
+	}
+	if len(dcid) < 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(dcid), true
+}
+
+// relayedPacket пакет, полученный из сокета или от другого процесса
+type relayedPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+// routedConn UDP сокет QUIC Transport, пересылающий пакеты чужих соединений другому процессу
+// Не реализует ReadMsgUDP, поэтому quic-go читает пакеты через ReadFrom
+type routedConn struct {
+	conn       *net.UDPConn
+	generation uint16
+	packets    chan relayedPacket
+	closed     chan struct{}
+	closeOnce  sync.Once
+	readErr    error // Ошибка чтения сокета, записывается до закрытия closed
+
+	mu              sync.Mutex
+	parent          *relayLink
+	child           *relayLink
+	deadline        time.Time
+	deadlineChanged chan struct{}
+}
+
+// newRoutedConn создает routedConn и начинает чтение сокета
+func newRoutedConn(conn *net.UDPConn, generation uint16) *routedConn {
+	c := &routedConn{
+		conn:            conn,
+		generation:      generation,
+		packets:         make(chan relayedPacket, packetQueueSize),
+		closed:          make(chan struct{}),
+		deadlineChanged: make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+// readLoop читает сокет и пересылает пакеты чужих соединений
+func (c *routedConn) readLoop() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			c.closeOnce.Do(func() {
+				c.readErr = err
+				close(c.closed)
+			})
+			return
+		}
+		packet := relayedPacket{data: append([]byte(nil), buf[:n]...), addr: addr}
+		if link := c.route(packet.data); link != nil {
+			link.send(packet)
+			continue
+		}
+		c.deliver(packet)
+	}
+}
+
+// route возвращает связь с процессом-владельцем пакета (nil - пакет этого процесса)
+func (c *routedConn) route(data []byte) *relayLink {
+	generation, ok := packetGeneration(data)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case ok && generation == c.generation:
+		return nil
+	case ok && c.parent != nil && generation == c.parent.generation:
+		return c.parent
+	default:
+		// Новые соединения и соединения нового процесса (nil, если его нет)
+		return c.child
+	}
+}
+
+// deliver передает пакет QUIC Transport этого процесса
+func (c *routedConn) deliver(packet relayedPacket) {
+	select {
+	case c.packets <- packet:
+	case <-c.closed:
+	}
+}
+
+func (c *routedConn) setParent(link *relayLink) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.parent = link
+}
+
+func (c *routedConn) setChild(link *relayLink) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.child != nil {
+		c.child.close()
+	}
+	c.child = link
+}
+
+// detach отключает завершившуюся связь (другой процесс вышел)
+func (c *routedConn) detach(link *relayLink) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.parent == link {
+		c.parent = nil
+	}
+	if c.child == link {
+		c.child = nil
+	}
+}
+
+func (c *routedConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// ReadFrom возвращает следующий пакет соединений этого процесса
+func (c *routedConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, retry, err := c.readPacket(p)
+		if !retry {
+			return n, addr, err
+		}
+	}
+}
+
+// readPacket ждет пакет до дедлайна; retry - дедлайн изменен во время ожидания
+// (например, Transport.Close прерывает чтение)
+func (c *routedConn) readPacket(p []byte) (n int, addr net.Addr, retry bool, err error) {
+	c.mu.Lock()
+	deadline, changed := c.deadline, c.deadlineChanged
+	c.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, nil, false, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case packet := <-c.packets:
+		return copy(p, packet.data), packet.addr, false, nil
+	case <-c.closed:
+		if c.readErr != nil {
+			return 0, nil, false, c.readErr
+		}
+		return 0, nil, false, net.ErrClosed
+	case <-timeout:
+		return 0, nil, false, os.ErrDeadlineExceeded
+	case <-changed:
+		return 0, nil, true, nil
+	}
+}
+
+func (c *routedConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.conn.WriteTo(p, addr)
+}
+
+// Close закрывает сокет и связи с другими процессами
+func (c *routedConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	c.mu.Lock()
+	for _, link := range []*relayLink{c.parent, c.child} {
+		if link != nil {
+			link.close()
+		}
+	}
+	c.parent, c.child = nil, nil
+	c.mu.Unlock()
+	return c.conn.Close()
+}
+
+func (c *routedConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *routedConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *routedConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	close(c.deadlineChanged)
+	c.deadlineChanged = make(chan struct{})
+	return nil
+}
+
+func (c *routedConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetReadBuffer, SetWriteBuffer и SyscallConn позволяют quic-go настроить сокет
+func (c *routedConn) SetReadBuffer(bytes int) error {
+	return c.conn.SetReadBuffer(bytes)
+}
+
+func (c *routedConn) SetWriteBuffer(bytes int) error {
+	return c.conn.SetWriteBuffer(bytes)
+}
+
+func (c *routedConn) SyscallConn() (syscall.RawConn, error) {
+	return c.conn.SyscallConn()
+}
+
+// relayLink пересылает пакеты процессу другого поколения и принимает пакеты от него
+// Формат кадра: длина адреса (2 байта), длина пакета (2 байта), адрес отправителя, пакет
+type relayLink struct {
+	generation uint16 // Поколение процесса на другой стороне
+	r          *os.File
+	w          *os.File
+	queue      chan relayedPacket
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func newRelayLink(generation uint16, r, w *os.File) *relayLink {
+	return &relayLink{
+		generation: generation,
+		r:          r,
+		w:          w,
+		queue:      make(chan relayedPacket, relayQueueSize),
+		done:       make(chan struct{}),
+	}
+}
+
+// start запускает прием и отправку пакетов; связь отключается от c, когда другой процесс завершился
+func (l *relayLink) start(c *routedConn) {
+	go func() {
+		l.readLoop(c)
+		l.close()
+		c.detach(l)
+	}()
+	go func() {
+		l.writeLoop()
+		l.close()
+		c.detach(l)
+	}()
+}
+
+// send ставит пакет в очередь пересылки, при переполнении пакет отбрасывается
+func (l *relayLink) send(packet relayedPacket) {
+	select {
+	case l.queue <- packet:
+	default:
+	}
+}
+
+func (l *relayLink) readLoop(c *routedConn) {
+	reader := bufio.NewReaderSize(l.r, maxPacketSize)
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return
+		}
+		frame := make([]byte, int(binary.BigEndian.Uint16(header))+int(binary.BigEndian.Uint16(header[2:])))
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			return
+		}
+		addrLen := int(binary.BigEndian.Uint16(header))
+		addrPort, err := netip.ParseAddrPort(string(frame[:addrLen]))
+		if err != nil {
+			logger.Debug("upgrade", "Dropping relayed packet with invalid address: %v", err)
+			continue
+		}
+		c.deliver(relayedPacket{data: frame[addrLen:], addr: net.UDPAddrFromAddrPort(addrPort)})
+	}
+}
+
+func (l *relayLink) writeLoop() {
+	for {
+		select {
+		case packet := <-l.queue:
+			addr := packet.addr.String()
+			frame := make([]byte, 4, 4+len(addr)+len(packet.data))
+			binary.BigEndian.PutUint16(frame, uint16(len(addr)))
+			binary.BigEndian.PutUint16(frame[2:], uint16(len(packet.data)))
+			frame = append(append(frame, addr...), packet.data...)
+			if _, err := l.w.Write(frame); err != nil {
+				return
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (l *relayLink) close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.r.Close()
+		l.w.Close()
+	})
+}
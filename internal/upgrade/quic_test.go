@@ -0,0 +1,286 @@
+package upgrade
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	tlsconfig "example.com/me/myproxy/internal/tls"
+	"github.com/quic-go/quic-go"
+)
+
+// linkGenerations соединяет routedConn родителя и дочернего процесса парой pipe, как Upgrade
+func linkGenerations(t *testing.T, parent, child *routedConn) {
+	t.Helper()
+	toChildR, toChildW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	toParentR, toParentW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	parentLink := newRelayLink(child.generation, toParentR, toChildW)
+	parentLink.start(parent)
+	parent.setChild(parentLink)
+	childLink := newRelayLink(parent.generation, toChildR, toParentW)
+	childLink.start(child)
+	child.setParent(childLink)
+}
+
+// shortHeaderPacket пакет с коротким заголовком, адресованный соединению поколения generation
+func shortHeaderPacket(generation uint16, payload byte) []byte {
+	packet := make([]byte, 1+connIDLen+1)
+	packet[0] = 0x40
+	binary.BigEndian.PutUint16(packet[1:], generation)
+	packet[len(packet)-1] = payload
+	return packet
+}
+
+// readPackets читает count пакетов и возвращает их последние байты
+func readPackets(t *testing.T, conn *routedConn, count int, from net.Addr) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	var payloads []byte
+	buf := make([]byte, maxPacketSize)
+	for len(payloads) < count {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Получено %d из %d пакетов поколения %d: %v", len(payloads), count, conn.generation, err)
+		}
+		if addr.String() != from.String() {
+			t.Errorf("Адрес отправителя %s, ожидался %s", addr, from)
+		}
+		payloads = append(payloads, buf[n-1])
+	}
+	return payloads
+}
+
+func TestRoutedConn_RelaysPacketsByGeneration(t *testing.T) {
+	socket, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	// Оба поколения читают один сокет, как родитель и дочерний процесс во время drain
+	parent := newRoutedConn(socket, 1)
+	child := newRoutedConn(socket, 2)
+	defer child.Close()
+	defer parent.Close()
+	linkGenerations(t, parent, child)
+
+	client, err := net.DialUDP("udp", nil, socket.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("DialUDP: %v", err)
+	}
+	defer client.Close()
+
+	const count = 20
+	for i := 0; i < count; i++ {
+		client.Write(shortHeaderPacket(1, 'p'))
+		client.Write(shortHeaderPacket(2, 'c'))
+	}
+	// Initial пакет нового соединения со случайным ID клиента принимает новый процесс
+	initial := []byte{0xc0, 0, 0, 0, 1, 8, 0x77, 0x77, 1, 2, 3, 4, 5, 6, 'n'}
+	client.Write(initial)
+
+	parentPayloads := readPackets(t, parent, count, client.LocalAddr())
+	childPayloads := readPackets(t, child, count+1, client.LocalAddr())
+	for _, payload := range parentPayloads {
+		if payload != 'p' {
+			t.Errorf("Родитель получил пакет другого поколения: %q", payload)
+		}
+	}
+	newConns := 0
+	for _, payload := range childPayloads {
+		switch payload {
+		case 'n':
+			newConns++
+		case 'p':
+			t.Errorf("Дочерний процесс получил пакет родителя")
+		}
+	}
+	if newConns != 1 {
+		t.Errorf("Пакет нового соединения получен дочерним процессом %d раз, ожидался 1", newConns)
+	}
+}
+
+func TestRoutedConn_ParentExit(t *testing.T) {
+	socket, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	parentSocket, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	parent := newRoutedConn(parentSocket, 1)
+	child := newRoutedConn(socket, 2)
+	defer child.Close()
+	linkGenerations(t, parent, child)
+
+	// Родитель завершился: пакеты его поколения больше некуда пересылать, остальные доставляются
+	parent.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		child.mu.Lock()
+		detached := child.parent == nil
+		child.mu.Unlock()
+		if detached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Связь с завершившимся родителем не отключена")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client, err := net.DialUDP("udp", nil, socket.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("DialUDP: %v", err)
+	}
+	defer client.Close()
+	client.Write(shortHeaderPacket(2, 'c'))
+	if payloads := readPackets(t, child, 1, client.LocalAddr()); payloads[0] != 'c' {
+		t.Errorf("Получен пакет %q", payloads)
+	}
+}
+
+func TestRoutedConn_ReadDeadlineInterruptsRead(t *testing.T) {
+	socket, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	conn := newRoutedConn(socket, 0)
+	defer conn.Close()
+
+	// quic.Transport.Close прерывает чтение дедлайном в прошлом
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadFrom(make([]byte, maxPacketSize))
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	conn.SetReadDeadline(time.Now())
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Ожидалась ошибка дедлайна, получено: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Чтение не прервано дедлайном")
+	}
+}
+
+// echoQUIC принимает соединения listener и возвращает данные каждого stream
+func echoQUIC(listener *quic.Listener) {
+	for {
+		conn, err := listener.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			for {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(stream, stream)
+					stream.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// echoOnce отправляет сообщение в новом stream и проверяет ответ
+func echoOnce(t *testing.T, conn *quic.Conn, message string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+	stream.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stream.Write([]byte(message)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	stream.Close()
+	reply, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(reply) != message {
+		t.Errorf("Ответ %q, ожидался %q", reply, message)
+	}
+}
+
+func TestListenQUIC_ConnectionsSurviveHandover(t *testing.T) {
+	socket, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	serverTLS, err := tlsconfig.NewTLSConfigForQUIC(nil, []string{"test"})
+	if err != nil {
+		t.Fatalf("NewTLSConfigForQUIC: %v", err)
+	}
+	clientTLS := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test"}}
+
+	parentConn := newRoutedConn(socket, 1)
+	parent := &quic.Transport{Conn: parentConn, ConnectionIDGenerator: connIDGenerator{generation: 1}}
+	defer parentConn.Close()
+	defer parent.Close()
+	parentListener, err := parent.Listen(serverTLS, nil)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go echoQUIC(parentListener)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	oldConn, err := quic.DialAddr(ctx, socket.LocalAddr().String(), clientTLS, nil)
+	if err != nil {
+		t.Fatalf("DialAddr: %v", err)
+	}
+	defer oldConn.CloseWithError(0, "")
+	echoOnce(t, oldConn, "before")
+
+	// Новый процесс читает тот же сокет, старый перестает принимать соединения (drain)
+	childConn := newRoutedConn(socket, 2)
+	child := &quic.Transport{Conn: childConn, ConnectionIDGenerator: connIDGenerator{generation: 2}}
+	defer childConn.Close()
+	defer child.Close()
+	childListener, err := child.Listen(serverTLS, nil)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go echoQUIC(childListener)
+	linkGenerations(t, parentConn, childConn)
+	parentListener.Close()
+
+	newConn, err := quic.DialAddr(ctx, socket.LocalAddr().String(), clientTLS, nil)
+	if err != nil {
+		t.Fatalf("Новое соединение не принято новым процессом: %v", err)
+	}
+	defer newConn.CloseWithError(0, "")
+
+	for i := 0; i < 20; i++ {
+		echoOnce(t, oldConn, "old")
+		echoOnce(t, newConn, "new")
+	}
+	// Пакет, прочитанный чужим процессом без пересылки, клиент считает потерянным
+	for _, conn := range []*quic.Conn{oldConn, newConn} {
+		if lost := conn.ConnectionStats().PacketsLost; lost != 0 {
+			t.Errorf("Потеряно пакетов: %d", lost)
+		}
+	}
+}
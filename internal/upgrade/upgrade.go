@@ -0,0 +1,335 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/me/myproxy/internal/logger"
+)
+
+const (
+	// envFDs список унаследованных сокетов: "tcp/:1080=3;udp/:443=4"
+	envFDs = "MYPROXY_UPGRADE_FDS"
+	// envReady номер fd pipe, через который дочерний процесс сообщает о готовности
+	envReady = "MYPROXY_UPGRADE_READY"
+	// envGeneration поколение процесса (число обновлений), префикс его QUIC connection ID
+	envGeneration = "MYPROXY_UPGRADE_GENERATION"
+
+	// firstExtraFD номер первого fd из exec.Cmd.ExtraFiles
+	firstExtraFD = 3
+)
+
+// ErrUpgradeInProgress возвращается при повторном вызове Upgrade до завершения предыдущего
+var ErrUpgradeInProgress = errors.New("upgrade already in progress")
+
+// fileSocket сокет, который можно передать дочернему процессу
+type fileSocket interface {
+	File() (*os.File, error)
+}
+
+// Upgrader передает слушающие сокеты новому процессу при обновлении бинарника
+// Компоненты создают слушатели через Listen/ListenPacket: в дочернем процессе
+// они получают унаследованные сокеты вместо новых, поэтому адрес не освобождается ни на мгновение
+// Методы nil-safe: nil Upgrader просто создает новые сокеты
+type Upgrader struct {
+	mu         sync.Mutex
+	inherited  map[string]*os.File    // Унаследованные от родителя, еще не использованные
+	active     map[string]fileSocket  // Сокеты текущего процесса (network/address -> сокет)
+	routed     map[string]*routedConn // QUIC сокеты с пересылкой пакетов между поколениями
+	ready      *os.File               // Pipe готовности (только в дочернем процессе)
+	generation uint16
+	upgrading  bool
+}
+
+// New создает Upgrader, забирая унаследованные сокеты из окружения
+func New() (*Upgrader, error) {
+	u := &Upgrader{
+		inherited: make(map[string]*os.File),
+		active:    make(map[string]fileSocket),
+		routed:    make(map[string]*routedConn),
+	}
+
+	if value := os.Getenv(envFDs); value != "" {
+		for _, entry := range strings.Split(value, ";") {
+			key, fdStr, ok := strings.Cut(entry, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid %s entry: %q", envFDs, entry)
+			}
+			fd, err := strconv.Atoi(fdStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fd in %s entry %q: %w", envFDs, entry, err)
+			}
+			u.inherited[key] = os.NewFile(uintptr(fd), key)
+		}
+	}
+
+	if value := os.Getenv(envReady); value != "" {
+		fd, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envReady, err)
+		}
+		u.ready = os.NewFile(uintptr(fd), "upgrade-ready")
+	}
+
+	if value := os.Getenv(envGeneration); value != "" {
+		generation, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envGeneration, err)
+		}
+		u.generation = uint16(generation)
+	}
+
+	// Окружение не должно попасть к следующему поколению процессов
+	os.Unsetenv(envFDs)
+	os.Unsetenv(envReady)
+	os.Unsetenv(envGeneration)
+
+	if len(u.inherited) > 0 {
+		logger.Info("upgrade", "Inherited %d sockets from parent process", len(u.inherited))
+	}
+	return u, nil
+}
+
+// HasParent возвращает true, если процесс запущен через Upgrade
+func (u *Upgrader) HasParent() bool {
+	return u != nil && u.ready != nil
+}
+
+// socketKey ключ сокета для сопоставления между процессами
+func socketKey(network, address string) string {
+	return network + "/" + address
+}
+
+// Listen возвращает унаследованный TCP слушатель для адреса или создает новый
+func (u *Upgrader) Listen(network, address string) (net.Listener, error) {
+	return u.ListenConfig(&net.ListenConfig{}, network, address)
+}
+
+// ListenConfig как Listen, новый слушатель создается через lc (например, с опциями сокета)
+// Унаследованный слушатель сохраняет опции, заданные при его создании
+func (u *Upgrader) ListenConfig(lc *net.ListenConfig, network, address string) (net.Listener, error) {
+	if u == nil {
+		return lc.Listen(context.Background(), network, address)
+	}
+
+	key := socketKey(network, address)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if file, ok := u.inherited[key]; ok {
+		delete(u.inherited, key)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to use inherited listener %s: %w", key, err)
+		}
+		logger.Debug("upgrade", "Using inherited listener %s", key)
+		u.trackLocked(key, listener)
+		return listener, nil
+	}
+
+	listener, err := lc.Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	u.trackLocked(key, listener)
+	return listener, nil
+}
+
+// ListenPacket возвращает унаследованный UDP сокет для адреса или создает новый
+func (u *Upgrader) ListenPacket(network, address string) (net.PacketConn, error) {
+	return u.ListenPacketConfig(&net.ListenConfig{}, network, address)
+}
+
+// ListenPacketConfig как ListenPacket, новый сокет создается через lc
+func (u *Upgrader) ListenPacketConfig(lc *net.ListenConfig, network, address string) (net.PacketConn, error) {
+	if u == nil {
+		return lc.ListenPacket(context.Background(), network, address)
+	}
+
+	key := socketKey(network, address)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if file, ok := u.inherited[key]; ok {
+		delete(u.inherited, key)
+		conn, err := net.FilePacketConn(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to use inherited packet conn %s: %w", key, err)
+		}
+		logger.Debug("upgrade", "Using inherited packet conn %s", key)
+		u.trackLocked(key, conn)
+		return conn, nil
+	}
+
+	conn, err := lc.ListenPacket(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	u.trackLocked(key, conn)
+	return conn, nil
+}
+
+// trackLocked запоминает сокет для передачи при обновлении (вызывается под mu)
+func (u *Upgrader) trackLocked(key string, socket interface{}) {
+	if fs, ok := socket.(fileSocket); ok {
+		u.active[key] = fs
+	}
+}
+
+// Ready сообщает родительскому процессу, что новый процесс принимает соединения,
+// и закрывает унаследованные сокеты, которые не понадобились (например, после смены порта)
+func (u *Upgrader) Ready() error {
+	if u == nil {
+		return nil
+	}
+
+	u.mu.Lock()
+	for key, file := range u.inherited {
+		logger.Debug("upgrade", "Closing unused inherited socket %s", key)
+		file.Close()
+		delete(u.inherited, key)
+	}
+	ready := u.ready
+	u.ready = nil
+	u.mu.Unlock()
+
+	if ready == nil {
+		return nil
+	}
+	defer ready.Close()
+	if _, err := ready.Write([]byte{1}); err != nil {
+		return fmt.Errorf("failed to notify parent process: %w", err)
+	}
+	return nil
+}
+
+// Upgrade запускает новый экземпляр текущего бинарника с теми же аргументами,
+// передает ему слушающие сокеты и ждет сигнала готовности не дольше timeout.
+// После успешного возврата вызывающий должен прекратить прием соединений и завершиться (drain)
+func (u *Upgrader) Upgrade(timeout time.Duration) error {
+	u.mu.Lock()
+	if u.upgrading {
+		u.mu.Unlock()
+		return ErrUpgradeInProgress
+	}
+	u.upgrading = true
+	u.mu.Unlock()
+
+	err := u.upgrade(timeout)
+
+	u.mu.Lock()
+	u.upgrading = false
+	u.mu.Unlock()
+	return err
+}
+
+// upgrade выполняет запуск дочернего процесса
+func (u *Upgrader) upgrade(timeout time.Duration) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+
+	files, spec := u.collectFiles()
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	// Пакеты QUIC соединений пересылаются между процессами, пока старый процесс дорабатывает
+	files, spec, relays, err := u.openRelays(files, spec)
+	if err != nil {
+		return err
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create ready pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(os.Environ(),
+		envFDs+"="+strings.Join(spec, ";"),
+		envReady+"="+strconv.Itoa(firstExtraFD+len(files)),
+		envGeneration+"="+strconv.Itoa(int(u.generation+1)),
+	)
+
+	if err := cmd.Start(); err != nil {
+		readyW.Close()
+		closeRelays(relays)
+		return fmt.Errorf("failed to start new process: %w", err)
+	}
+	// Копия write-конца у родителя не нужна: EOF на readyR означает, что дочерний процесс завершился
+	readyW.Close()
+	logger.Info("upgrade", "Started new process %d with %d sockets", cmd.Process.Pid, len(files))
+
+	readyChan := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := readyR.Read(buf); err != nil {
+			readyChan <- fmt.Errorf("new process exited before becoming ready: %w", err)
+			return
+		}
+		readyChan <- nil
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-readyChan:
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			closeRelays(relays)
+			return err
+		}
+	case <-timer.C:
+		cmd.Process.Kill()
+		cmd.Wait()
+		closeRelays(relays)
+		return fmt.Errorf("new process did not become ready within %s", timeout)
+	}
+	attachRelays(relays)
+
+	// Дочерний процесс продолжает работу самостоятельно, собираем его статус в фоне
+	go cmd.Wait()
+	logger.Info("upgrade", "New process %d is ready", cmd.Process.Pid)
+	return nil
+}
+
+// collectFiles дублирует активные сокеты для передачи дочернему процессу
+// Закрытые сокеты (например, inbound после перезагрузки конфигурации) пропускаются
+func (u *Upgrader) collectFiles() ([]*os.File, []string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var files []*os.File
+	var spec []string
+	for key, socket := range u.active {
+		file, err := socket.File()
+		if err != nil {
+			logger.Debug("upgrade", "Skipping socket %s: %v", key, err)
+			delete(u.active, key)
+			continue
+		}
+		spec = append(spec, fmt.Sprintf("%s=%d", key, firstExtraFD+len(files)))
+		files = append(files, file)
+	}
+	return files, spec
+}
@@ -0,0 +1,184 @@
+package upgrade
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	// envTestChild режим работы тестового бинарника, запущенного через Upgrade
+	envTestChild = "UPGRADE_TEST_CHILD"
+
+	testTCPAddr = "127.0.0.1:0"
+	testUDPAddr = "127.0.0.1:0"
+)
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(envTestChild) {
+	case "":
+		os.Exit(m.Run())
+	case "serve":
+		runChild()
+	case "fail":
+		os.Exit(1)
+	case "hang":
+		time.Sleep(time.Minute)
+		os.Exit(1)
+	}
+}
+
+// runChild дочерний процесс: забирает сокеты родителя и отвечает "child" по TCP и UDP
+func runChild() {
+	u, err := New()
+	// Дочерний процесс - следующее поколение: его QUIC connection ID отличаются от родительских
+	if err != nil || !u.HasParent() || u.generation != 1 {
+		os.Exit(2)
+	}
+	listener, err := u.Listen("tcp", testTCPAddr)
+	if err != nil {
+		os.Exit(3)
+	}
+	packetConn, err := u.ListenPacket("udp", testUDPAddr)
+	if err != nil {
+		os.Exit(4)
+	}
+	if err := u.Ready(); err != nil {
+		os.Exit(5)
+	}
+
+	// Тест проверяет UDP последним: после ответа дочерний процесс завершается
+	go func() {
+		buf := make([]byte, 64)
+		_, addr, err := packetConn.ReadFrom(buf)
+		if err != nil {
+			os.Exit(6)
+		}
+		packetConn.WriteTo([]byte("child"), addr)
+		os.Exit(0)
+	}()
+
+	// Процесс не должен пережить тест
+	time.AfterFunc(10*time.Second, func() { os.Exit(0) })
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			os.Exit(0)
+		}
+		conn.Write([]byte("child\n"))
+		conn.Close()
+	}
+}
+
+func TestUpgrader_HandsOverSockets(t *testing.T) {
+	u, err := New()
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if u.HasParent() {
+		t.Fatal("процесс теста не должен иметь родителя-Upgrader")
+	}
+
+	listener, err := u.Listen("tcp", testTCPAddr)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	packetConn, err := u.ListenPacket("udp", testUDPAddr)
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	tcpAddr := listener.Addr().String()
+	udpAddr := packetConn.LocalAddr().String()
+
+	t.Setenv(envTestChild, "serve")
+	if err := u.Upgrade(10 * time.Second); err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+
+	// Родитель прекращает прием: адрес продолжает обслуживать дочерний процесс
+	listener.Close()
+	packetConn.Close()
+
+	conn, err := net.DialTimeout("tcp", tcpAddr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("TCP адрес недоступен после передачи: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "child\n" {
+		t.Fatalf("ожидался ответ дочернего процесса по TCP, получено %q (%v)", line, err)
+	}
+
+	udpConn, err := net.Dial("udp", udpAddr)
+	if err != nil {
+		t.Fatalf("Dial UDP: %v", err)
+	}
+	defer udpConn.Close()
+	udpConn.Write([]byte("ping"))
+	udpConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64)
+	n, err := udpConn.Read(buf)
+	if err != nil || string(buf[:n]) != "child" {
+		t.Fatalf("ожидался ответ дочернего процесса по UDP, получено %q (%v)", buf[:n], err)
+	}
+}
+
+func TestUpgrader_ChildFailure(t *testing.T) {
+	tests := []struct {
+		mode    string
+		timeout time.Duration
+	}{
+		{mode: "fail", timeout: 10 * time.Second},
+		{mode: "hang", timeout: 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			u, err := New()
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			listener, err := u.Listen("tcp", testTCPAddr)
+			if err != nil {
+				t.Fatalf("Listen: %v", err)
+			}
+			defer listener.Close()
+
+			t.Setenv(envTestChild, tt.mode)
+			if err := u.Upgrade(tt.timeout); err == nil {
+				t.Fatal("ожидалась ошибка, если дочерний процесс не сообщил о готовности")
+			}
+
+			// Текущий процесс продолжает принимать соединения
+			conn, err := net.DialTimeout("tcp", listener.Addr().String(), time.Second)
+			if err != nil {
+				t.Fatalf("слушатель должен остаться рабочим после неудачного обновления: %v", err)
+			}
+			conn.Close()
+
+			// Повторная попытка возможна
+			t.Setenv(envTestChild, "fail")
+			if err := u.Upgrade(tt.timeout); err == nil || err == ErrUpgradeInProgress {
+				t.Fatalf("ожидалась ошибка дочернего процесса, получено %v", err)
+			}
+		})
+	}
+}
+
+func TestUpgrader_NilSafe(t *testing.T) {
+	var u *Upgrader
+	listener, err := u.Listen("tcp", testTCPAddr)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	listener.Close()
+	if u.HasParent() {
+		t.Fatal("nil Upgrader не имеет родителя")
+	}
+	if err := u.Ready(); err != nil {
+		t.Fatalf("Ready: %v", err)
+	}
+}
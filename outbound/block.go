@@ -0,0 +1,135 @@
+package outbound
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/logger"
+)
+
+// Режимы блокировки
+const (
+	// BlockReject соединение отклоняется ошибкой connerr.ErrNotAllowed
+	BlockReject = "reject"
+	// BlockHTTP соединение принимается, на HTTP запрос отвечается 403, остальное закрывается
+	BlockHTTP = "http"
+)
+
+// blockHTTPReadTimeout время ожидания запроса клиента в режиме BlockHTTP
+const blockHTTPReadTimeout = 10 * time.Second
+
+// blockHTTPBody тело ответа 403 в режиме BlockHTTP
+const blockHTTPBody = "Access to this site is blocked by proxy policy\n"
+
+// BlockCounters счетчики заблокированных соединений по правилам
+// Общие для всех BlockOutbound с одним правилом, созданных с этими счетчиками
+type BlockCounters struct {
+	mu       sync.Mutex
+	counters map[string]*atomic.Int64
+	carried  map[string]*atomic.Int64 // Счетчики прежней конфигурации, значения которых продолжаются
+}
+
+// NewBlockCounters создает счетчики правил блокировки
+// Правило, которое было в previous (nil - нет), продолжает его счетчик; правила, которые
+// больше не используются, в новые счетчики не попадают
+func NewBlockCounters(previous *BlockCounters) *BlockCounters {
+	c := &BlockCounters{counters: make(map[string]*atomic.Int64)}
+	if previous != nil {
+		previous.mu.Lock()
+		c.carried = maps.Clone(previous.counters)
+		previous.mu.Unlock()
+	}
+	return c
+}
+
+// counter возвращает счетчик правила rule, при необходимости создавая его
+func (c *BlockCounters) counter(rule string) *atomic.Int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counter, ok := c.counters[rule]
+	if ok {
+		return counter
+	}
+	if counter, ok = c.carried[rule]; !ok {
+		counter = new(atomic.Int64)
+	}
+	c.counters[rule] = counter
+	return counter
+}
+
+// Counts возвращает количество заблокированных соединений по правилам
+func (c *BlockCounters) Counts() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int64, len(c.counters))
+	for rule, counter := range c.counters {
+		counts[rule] = counter.Load()
+	}
+	return counts
+}
+
+// BlockOutbound завершающий outbound, который блокирует все соединения
+type BlockOutbound struct {
+	rule    string
+	mode    string
+	blocked *atomic.Int64
+}
+
+// NewBlockOutbound создает outbound блокировки для правила rule
+// mode - BlockReject или BlockHTTP (пусто - BlockReject),
+// counters - счетчики правил (nil - собственный счетчик outbound)
+func NewBlockOutbound(rule, mode string, counters *BlockCounters) *BlockOutbound {
+	if mode == "" {
+		mode = BlockReject
+	}
+	blocked := new(atomic.Int64)
+	if counters != nil {
+		blocked = counters.counter(rule)
+	}
+	return &BlockOutbound{
+		rule:    rule,
+		mode:    mode,
+		blocked: blocked,
+	}
+}
+
+// DialContext блокирует соединение: возвращает ошибку connerr.ErrNotAllowed или,
+// в режиме BlockHTTP для TCP, соединение с ответом 403
+func (b *BlockOutbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	total := b.blocked.Add(1)
+	logger.Info("outbound", "Connection to %s blocked by rule %s (total blocked: %d)", address, b.rule, total)
+
+	if b.mode == BlockHTTP && network == "tcp" {
+		client, server := net.Pipe()
+		go serveBlockedHTTP(server)
+		return client, nil
+	}
+	return nil, fmt.Errorf("blocked by rule %s: %w", b.rule, connerr.ErrNotAllowed)
+}
+
+// Blocked возвращает количество соединений, заблокированных правилом этого outbound
+func (b *BlockOutbound) Blocked() int64 {
+	return b.blocked.Load()
+}
+
+// serveBlockedHTTP отвечает 403 на HTTP запрос клиента и закрывает соединение
+// Если клиент отправил не HTTP запрос (например, TLS) или ничего не отправил, соединение просто закрывается
+func serveBlockedHTTP(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(blockHTTPReadTimeout))
+	request, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	request.Body.Close()
+	fmt.Fprintf(conn, "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		len(blockHTTPBody), blockHTTPBody)
+}
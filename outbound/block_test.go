@@ -0,0 +1,89 @@
+package outbound
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+)
+
+func TestBlockOutbound_Reject(t *testing.T) {
+	counters := NewBlockCounters(nil)
+	block := NewBlockOutbound("test-reject", "", counters)
+	_, err := block.DialContext(context.Background(), "tcp", "example.com:443")
+	if !errors.Is(err, connerr.ErrNotAllowed) {
+		t.Fatalf("Ожидалась ошибка %v, получено %v", connerr.ErrNotAllowed, err)
+	}
+
+	// Счетчик общий для outbound с одним правилом
+	NewBlockOutbound("test-reject", BlockReject, counters).DialContext(context.Background(), "udp", "example.com:53")
+	if block.Blocked() != 2 {
+		t.Errorf("Ожидалось 2 блокировки, получено %d", block.Blocked())
+	}
+	if counts := counters.Counts(); len(counts) != 1 || counts["test-reject"] != 2 {
+		t.Errorf("Неверные счетчики по правилам: %v", counts)
+	}
+}
+
+func TestBlockCounters_Reload(t *testing.T) {
+	previous := NewBlockCounters(nil)
+	kept := NewBlockOutbound("ads", BlockReject, previous)
+	NewBlockOutbound("removed", BlockReject, previous).DialContext(context.Background(), "tcp", "example.com:443")
+	kept.DialContext(context.Background(), "tcp", "example.com:443")
+
+	// Правило, оставшееся в конфигурации, продолжает счетчик (и для соединений старого outbound),
+	// удаленное правило не попадает в новые счетчики
+	counters := NewBlockCounters(previous)
+	NewBlockOutbound("ads", BlockReject, counters).DialContext(context.Background(), "tcp", "example.com:443")
+	kept.DialContext(context.Background(), "tcp", "example.com:443")
+	NewBlockOutbound("trackers", BlockReject, counters)
+	if counts := counters.Counts(); len(counts) != 2 || counts["ads"] != 3 || counts["trackers"] != 0 {
+		t.Errorf("Неверные счетчики после перезагрузки: %v", counts)
+	}
+}
+
+func TestBlockOutbound_HTTP(t *testing.T) {
+	block := NewBlockOutbound("test-http", BlockHTTP, nil)
+
+	conn, err := block.DialContext(context.Background(), "tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	go conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Ошибка чтения ответа: %v", err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusForbidden || string(body) != blockHTTPBody {
+		t.Errorf("Неверный ответ: %d %q", response.StatusCode, body)
+	}
+
+	// Не HTTP данные (TLS ClientHello) - соединение закрывается без ответа
+	conn, err = block.DialContext(context.Background(), "tcp", "example.com:443")
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	go conn.Write([]byte{0x16, 0x03, 0x01, 0x00, 0x05, 0x01, 0x00, 0x00, 0x01, 0x00, '\r', '\n'})
+	if n, err := conn.Read(make([]byte, 16)); err != io.EOF {
+		t.Errorf("Ожидалось закрытие соединения, получено %d байт, %v", n, err)
+	}
+
+	// UDP в режиме http отклоняется
+	if _, err := block.DialContext(context.Background(), "udp", "example.com:53"); !errors.Is(err, connerr.ErrNotAllowed) {
+		t.Errorf("Ожидалась ошибка %v для udp, получено %v", connerr.ErrNotAllowed, err)
+	}
+	if block.Blocked() != 3 {
+		t.Errorf("Ожидалось 3 блокировки, получено %d", block.Blocked())
+	}
+}
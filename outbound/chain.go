@@ -0,0 +1,66 @@
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+
+	"example.com/me/myproxy/internal/logger"
+)
+
+// ChainOutbound соединяет outbound в цепочку: первое звено подключается к прокси второго,
+// второе через это соединение - к прокси третьего и так далее, последнее - к целевому адресу
+type ChainOutbound struct {
+	first Outbound
+	hops  []Hop
+}
+
+// NewChainOutbound создает цепочку из первого звена first (любой outbound) и
+// следующих звеньев hops (прокси, подключающиеся поверх соединения предыдущего звена)
+func NewChainOutbound(first Outbound, hops []Hop) *ChainOutbound {
+	return &ChainOutbound{
+		first: first,
+		hops:  hops,
+	}
+}
+
+// DialContext устанавливает соединение с address через все звенья цепочки
+// Промежуточные соединения всегда TCP, network относится к последнему звену
+func (c *ChainOutbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if len(c.hops) == 0 {
+		return c.first.DialContext(ctx, network, address)
+	}
+
+	conn, err := c.first.DialContext(ctx, "tcp", c.hops[0].ProxyAddress())
+	if err != nil {
+		return nil, fmt.Errorf("chain hop 1: %w", err)
+	}
+	for i, hop := range c.hops {
+		nextNetwork, next := "tcp", address
+		if i+1 < len(c.hops) {
+			next = c.hops[i+1].ProxyAddress()
+		} else {
+			nextNetwork = network
+		}
+		logger.Debug("outbound", "Chain hop %d: connecting to %s via %s", i+2, next, hop.ProxyAddress())
+		conn, err = hop.DialConn(ctx, conn, nextNetwork, next)
+		if err != nil {
+			return nil, fmt.Errorf("chain hop %d: %w", i+2, err)
+		}
+	}
+	return conn, nil
+}
+
+// Close освобождает ресурсы звеньев (фоновые проверки групп)
+func (c *ChainOutbound) Close() error {
+	if closer, ok := c.first.(io.Closer); ok {
+		closer.Close()
+	}
+	for _, hop := range c.hops {
+		if closer, ok := hop.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	return nil
+}
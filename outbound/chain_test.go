@@ -0,0 +1,109 @@
+package outbound
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/protocol/socks5"
+)
+
+// startForwardingHTTPProxy запускает HTTP прокси, который действительно подключается
+// к адресу из CONNECT, и возвращает его адрес и канал адресов запросов
+func startForwardingHTTPProxy(t *testing.T) (string, chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	targets := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				request, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil {
+					return
+				}
+				targets <- request.Host
+				upstream, err := net.Dial("tcp", request.Host)
+				if err != nil {
+					c.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer upstream.Close()
+				c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go io.Copy(upstream, c)
+				io.Copy(c, upstream)
+			}(conn)
+		}
+	}()
+	return listener.Addr().String(), targets
+}
+
+func TestChainOutbound_Dial(t *testing.T) {
+	httpAddress, targets := startForwardingHTTPProxy(t)
+	socks := startFakeSOCKS5(t, "user", "secret", socks5.ReplySuccess)
+
+	hop := NewSOCKS5Outbound(socks.listener.Addr().String(), 0, 0)
+	hop.SetAuth("user", "secret")
+	chain := NewChainOutbound(NewHTTPOutbound(httpAddress, 0, 0), []Hop{hop})
+
+	conn, err := chain.DialContext(context.Background(), "tcp", "example.com:443")
+	if err != nil {
+		t.Fatalf("Ошибка подключения через цепочку: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// Первое звено подключается к прокси второго звена
+	if target := <-targets; target != socks.listener.Addr().String() {
+		t.Errorf("HTTP прокси подключился к %s, ожидался SOCKS5 прокси", target)
+	}
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Неверный ответ через цепочку: %q, %v", buf, err)
+	}
+}
+
+func TestChainOutbound_HopError(t *testing.T) {
+	httpAddress, _ := startForwardingHTTPProxy(t)
+	socks := startFakeSOCKS5(t, "", "", socks5.ReplyConnectionRefused)
+
+	chain := NewChainOutbound(NewHTTPOutbound(httpAddress, 0, 0), []Hop{NewSOCKS5Outbound(socks.listener.Addr().String(), 0, 0)})
+	_, err := chain.DialContext(context.Background(), "tcp", "example.com:443")
+	if !errors.Is(err, connerr.ErrConnectionRefused) {
+		t.Errorf("Ожидалась ошибка %v, получено %v", connerr.ErrConnectionRefused, err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "chain hop 2") {
+		t.Errorf("Ошибка не указывает звено цепочки: %v", err)
+	}
+
+	// UDP поверх промежуточного соединения не поддерживается
+	if _, err := chain.DialContext(context.Background(), "udp", "example.com:53"); err == nil {
+		t.Error("Ожидалась ошибка для udp через цепочку")
+	}
+
+	// Недоступное первое звено
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedAddress := listener.Addr().String()
+	listener.Close()
+	chain = NewChainOutbound(NewDirectOutbound(0), []Hop{NewHTTPOutbound(closedAddress, 0, 0)})
+	if _, err := chain.DialContext(context.Background(), "tcp", "example.com:443"); err == nil || !strings.Contains(err.Error(), "chain hop 1") {
+		t.Errorf("Ожидалась ошибка первого звена, получено %v", err)
+	}
+}
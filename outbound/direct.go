@@ -1,23 +1,219 @@
 package outbound
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/constants"
+)
+
+// Предпочтение семейства адресов для DirectOutbound
+const (
+	// IPv4Only подключение только по IPv4
+	IPv4Only = "ipv4"
+	// IPv6Only подключение только по IPv6
+	IPv6Only = "ipv6"
+	// PreferIPv4 IPv4, если у хоста есть IPv4 адреса, иначе IPv6
+	PreferIPv4 = "prefer_ipv4"
+	// PreferIPv6 IPv6, если у хоста есть IPv6 адреса, иначе IPv4
+	PreferIPv6 = "prefer_ipv6"
 )
 
 // DirectOutbound реализует прямое подключение
 type DirectOutbound struct {
 	dialer *net.Dialer
+
+	sources      []net.IP      // Локальные адреса, по очереди для каждого соединения
+	nextSource   atomic.Uint64 // Следующий адрес из sources
+	iface        string        // SO_BINDTODEVICE
+	mark         int           // SO_MARK
+	noDelay      *bool         // TCP_NODELAY (nil - по умолчанию Go, включен)
+	ipPreference string
+	resolver     Resolver // nil - разрешение доменов средствами net.Dialer
+	dialControl  func(network, address string, c syscall.RawConn) error
 }
 
 // NewDirectOutbound создает новый direct outbound
-func NewDirectOutbound() *DirectOutbound {
+// connectTimeout - таймаут TCP подключения (0 - default)
+func NewDirectOutbound(connectTimeout time.Duration) *DirectOutbound {
+	if connectTimeout == 0 {
+		connectTimeout = constants.DefaultConnectTimeout * time.Second
+	}
 	return &DirectOutbound{
-		dialer: &net.Dialer{},
+		dialer: &net.Dialer{Timeout: connectTimeout},
+	}
+}
+
+// SetSourceAddresses задает локальные адреса исходящих соединений
+// Несколько адресов используются по очереди; семейство адреса определяет семейство соединения
+func (d *DirectOutbound) SetSourceAddresses(sources []net.IP) {
+	d.sources = sources
+}
+
+// SetInterface привязывает соединения к сетевому интерфейсу (SO_BINDTODEVICE, только Linux)
+func (d *DirectOutbound) SetInterface(name string) {
+	d.iface = name
+	d.dialer.Control = d.control
+}
+
+// SetMark задает метку пакетов для policy routing (SO_MARK, только Linux, требует CAP_NET_ADMIN)
+func (d *DirectOutbound) SetMark(mark int) {
+	d.mark = mark
+	d.dialer.Control = d.control
+}
+
+// SetDialControl задает проверку фактического адреса подключения после разрешения домена
+// (например, acl.Policy.DialControl): ошибка отменяет подключение до его установки
+func (d *DirectOutbound) SetDialControl(control func(network, address string, c syscall.RawConn) error) {
+	d.dialControl = control
+	d.dialer.Control = d.control
+}
+
+// SetKeepAlive задает параметры TCP keepalive (Enable: false - отключить)
+func (d *DirectOutbound) SetKeepAlive(config net.KeepAliveConfig) {
+	d.dialer.KeepAliveConfig = config
+	if !config.Enable {
+		d.dialer.KeepAlive = -1
 	}
 }
 
-// Dial устанавливает прямое TCP соединение
-func (d *DirectOutbound) Dial(network, address string) (net.Conn, error) {
-	return d.dialer.Dial(network, address)
+// SetNoDelay включает или отключает TCP_NODELAY (алгоритм Нейгла)
+func (d *DirectOutbound) SetNoDelay(noDelay bool) {
+	d.noDelay = &noDelay
 }
 
+// SetIPPreference задает семейство адресов: IPv4Only, IPv6Only, PreferIPv4, PreferIPv6
+// (пусто - как решит resolver). С SetSourceAddresses семейство определяет локальный адрес
+func (d *DirectOutbound) SetIPPreference(preference string) {
+	d.ipPreference = preference
+}
+
+// SetResolver задает resolver доменов назначения
+// Адреса домена отбираются по семейству локального адреса и SetIPPreference
+func (d *DirectOutbound) SetResolver(resolver Resolver) {
+	d.resolver = resolver
+}
+
+// DialContext устанавливает прямое соединение
+func (d *DirectOutbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok && d.noDelay != nil {
+		tcpConn.SetNoDelay(*d.noDelay)
+	}
+	return conn, nil
+}
+
+// dial подключается с учетом локального адреса и предпочтения семейства адресов
+func (d *DirectOutbound) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := d.dialer
+	var source net.IP
+	if len(d.sources) > 0 {
+		source = d.sources[(d.nextSource.Add(1)-1)%uint64(len(d.sources))]
+		copied := *d.dialer
+		if strings.HasPrefix(network, "udp") {
+			copied.LocalAddr = &net.UDPAddr{IP: source}
+		} else {
+			copied.LocalAddr = &net.TCPAddr{IP: source}
+		}
+		dialer = &copied
+	}
+
+	if host, port, err := net.SplitHostPort(address); err == nil && d.resolver != nil && net.ParseIP(host) == nil {
+		ips, err := d.resolver.LookupIP(ctx, host)
+		if err != nil {
+			return nil, resolveError(host, err)
+		}
+		ips = d.filterIPs(ips, source)
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("%w: %s has no addresses of the allowed family", connerr.ErrHostUnreachable, host)
+		}
+		return dialResolved(ctx, ips, func(ip net.IP) (net.Conn, error) {
+			return dialer.DialContext(ctx, familyNetwork(network, ip.To4() == nil), net.JoinHostPort(ip.String(), port))
+		})
+	}
+
+	if source != nil {
+		return dialer.DialContext(ctx, familyNetwork(network, source.To4() == nil), address)
+	}
+	switch d.ipPreference {
+	case IPv4Only:
+		return dialer.DialContext(ctx, familyNetwork(network, false), address)
+	case IPv6Only:
+		return dialer.DialContext(ctx, familyNetwork(network, true), address)
+	case PreferIPv4, PreferIPv6:
+		preferIPv6 := d.ipPreference == PreferIPv6
+		conn, err := dialer.DialContext(ctx, familyNetwork(network, preferIPv6), address)
+		// Другое семейство используется, только если у хоста нет адресов предпочтительного
+		var addrErr *net.AddrError
+		if errors.As(err, &addrErr) {
+			return dialer.DialContext(ctx, familyNetwork(network, !preferIPv6), address)
+		}
+		return conn, err
+	default:
+		return dialer.DialContext(ctx, network, address)
+	}
+}
+
+// filterIPs оставляет адреса семейства локального адреса source (если задан) или
+// разрешенного SetIPPreference; при PreferIPv4/PreferIPv6 предпочтительное семейство идет первым
+func (d *DirectOutbound) filterIPs(ips []net.IP, source net.IP) []net.IP {
+	var ipv4, ipv6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			ipv4 = append(ipv4, ip)
+		} else {
+			ipv6 = append(ipv6, ip)
+		}
+	}
+	if source != nil {
+		if source.To4() != nil {
+			return ipv4
+		}
+		return ipv6
+	}
+	switch d.ipPreference {
+	case IPv4Only:
+		return ipv4
+	case IPv6Only:
+		return ipv6
+	case PreferIPv6:
+		return append(ipv6, ipv4...)
+	default:
+		return append(ipv4, ipv6...)
+	}
+}
+
+// control проверяет адрес подключения и применяет SO_BINDTODEVICE и SO_MARK к сокету до подключения
+func (d *DirectOutbound) control(network, address string, c syscall.RawConn) error {
+	if d.dialControl != nil {
+		if err := d.dialControl(network, address, c); err != nil {
+			return err
+		}
+	}
+	var err error
+	if controlErr := c.Control(func(fd uintptr) {
+		err = setSocketOptions(fd, d.iface, d.mark)
+	}); controlErr != nil {
+		return controlErr
+	}
+	return err
+}
+
+// familyNetwork возвращает network с явным семейством адресов ("tcp" -> "tcp4"/"tcp6")
+func familyNetwork(network string, ipv6 bool) string {
+	network = strings.TrimRight(network, "46")
+	if ipv6 {
+		return network + "6"
+	}
+	return network + "4"
+}
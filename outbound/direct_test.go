@@ -1,16 +1,17 @@
 package outbound
 
 import (
+	"context"
 	"net"
 	"testing"
 )
 
 func TestDirectOutbound_Dial(t *testing.T) {
-	outbound := NewDirectOutbound()
+	outbound := NewDirectOutbound(0)
 
 	// Тест: подключение к несуществующему адресу должно вернуть ошибку
 	t.Run("dial non-existent address", func(t *testing.T) {
-		conn, err := outbound.Dial("tcp", "127.0.0.1:99999")
+		conn, err := outbound.DialContext(context.Background(), "tcp", "127.0.0.1:99999")
 		if err == nil {
 			conn.Close()
 			t.Error("Ожидалась ошибка при подключении к несуществующему адресу")
@@ -29,7 +30,7 @@ func TestDirectOutbound_Dial(t *testing.T) {
 		addr := listener.Addr().String()
 
 		// Подключаемся через direct outbound
-		conn, err := outbound.Dial("tcp", addr)
+		conn, err := outbound.DialContext(context.Background(), "tcp", addr)
 		if err != nil {
 			t.Fatalf("Ошибка подключения: %v", err)
 		}
@@ -50,7 +51,7 @@ func TestDirectOutbound_Dial(t *testing.T) {
 		defer listener.Close()
 
 		addr := listener.Addr().String()
-		conn, err := outbound.Dial("tcp", addr)
+		conn, err := outbound.DialContext(context.Background(), "tcp", addr)
 		if err != nil {
 			t.Fatalf("Ошибка подключения: %v", err)
 		}
@@ -64,7 +65,7 @@ func TestDirectOutbound_Dial(t *testing.T) {
 }
 
 func TestNewDirectOutbound(t *testing.T) {
-	outbound := NewDirectOutbound()
+	outbound := NewDirectOutbound(0)
 
 	if outbound == nil {
 		t.Error("NewDirectOutbound вернул nil")
@@ -75,3 +76,73 @@ func TestNewDirectOutbound(t *testing.T) {
 	}
 }
 
+// acceptRemoteIPs принимает count соединений на listener и возвращает IP их источников
+func acceptRemoteIPs(t *testing.T, listener net.Listener, count int) chan string {
+	t.Helper()
+	ips := make(chan string, count)
+	go func() {
+		for i := 0; i < count; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			ips <- conn.RemoteAddr().(*net.TCPAddr).IP.String()
+			conn.Close()
+		}
+	}()
+	return ips
+}
+
+func TestDirectOutbound_SourceAddresses(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	defer listener.Close()
+	ips := acceptRemoteIPs(t, listener, 3)
+
+	// Все адреса 127.0.0.0/8 принадлежат loopback, отдельные алиасы не нужны
+	outbound := NewDirectOutbound(0)
+	outbound.SetSourceAddresses([]net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.3")})
+	for _, want := range []string{"127.0.0.2", "127.0.0.3", "127.0.0.2"} {
+		conn, err := outbound.DialContext(context.Background(), "tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Ошибка подключения: %v", err)
+		}
+		conn.Close()
+		if got := <-ips; got != want {
+			t.Errorf("Неверный адрес источника: ожидался %s, получен %s", want, got)
+		}
+	}
+}
+
+func TestDirectOutbound_IPPreference(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback недоступен: %v", err)
+	}
+	defer listener.Close()
+	acceptRemoteIPs(t, listener, 2)
+	address := listener.Addr().String()
+
+	tests := []struct {
+		preference string
+		wantErr    bool
+	}{
+		{IPv4Only, true},
+		{IPv6Only, false},
+		// У адреса нет IPv4 варианта - используется IPv6
+		{PreferIPv4, false},
+	}
+	for _, tt := range tests {
+		outbound := NewDirectOutbound(0)
+		outbound.SetIPPreference(tt.preference)
+		conn, err := outbound.DialContext(context.Background(), "tcp", address)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: неожиданный результат подключения к %s: %v", tt.preference, address, err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/logger"
+)
+
+// Стратегии выбора участника группы
+const (
+	// GroupFailover первый здоровый участник в порядке конфигурации
+	GroupFailover = "failover"
+	// GroupRoundRobin здоровые участники по очереди
+	GroupRoundRobin = "round_robin"
+	// GroupRandom случайный здоровый участник с учетом веса
+	GroupRandom = "random"
+)
+
+// groupProbeTimeout максимальное время проверки участника группы
+const groupProbeTimeout = 10 * time.Second
+
+// GroupMember участник группы outbound
+type GroupMember struct {
+	ID       string // Для логов (идентификатор outbound или тип с номером)
+	Outbound Outbound
+	Weight   int // Вес для стратегии GroupRandom (0 - 1)
+
+	healthy atomic.Bool
+	probing atomic.Bool
+}
+
+// GroupOutbound объединяет несколько outbound: выбирает участника по стратегии
+// и при неудачном подключении пробует следующих. Участники, не прошедшие
+// фоновую проверку подключением к probeAddress, выбираются только если здоровых нет
+type GroupOutbound struct {
+	strategy string
+	members  []*GroupMember
+	next     atomic.Uint64 // Следующий участник для GroupRoundRobin
+
+	probeAddress  string
+	probeInterval time.Duration
+	ctx           context.Context // Отменяется в Close (прерывает проверки)
+	cancel        context.CancelFunc
+}
+
+// NewGroupOutbound создает группу outbound
+// Если probeAddress не пустой, участники проверяются каждые probeInterval до вызова Close
+func NewGroupOutbound(strategy string, members []*GroupMember, probeAddress string, probeInterval time.Duration) *GroupOutbound {
+	g := &GroupOutbound{
+		strategy:      strategy,
+		members:       members,
+		probeAddress:  probeAddress,
+		probeInterval: probeInterval,
+	}
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	for _, member := range members {
+		member.healthy.Store(true)
+	}
+
+	if probeAddress != "" {
+		go g.probeLoop()
+	}
+	return g
+}
+
+// DialContext устанавливает соединение через участников группы в порядке стратегии
+// Запрет и отказ адреса назначения не зависят от участника и возвращаются сразу,
+// после отмены ctx следующие участники не используются
+func (g *GroupOutbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var lastErr error
+	for _, member := range g.order() {
+		conn, err := member.Outbound.DialContext(ctx, network, address)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if errors.Is(err, connerr.ErrNotAllowed) || errors.Is(err, connerr.ErrConnectionRefused) || ctx.Err() != nil {
+			break
+		}
+		logger.Debug("outbound", "Group member %s failed to connect to %s: %v", member.ID, address, err)
+	}
+	if lastErr == nil {
+		return nil, fmt.Errorf("outbound group has no members")
+	}
+	return nil, lastErr
+}
+
+// order возвращает участников в порядке попыток: сначала здоровые по стратегии, затем остальные
+func (g *GroupOutbound) order() []*GroupMember {
+	healthy := make([]*GroupMember, 0, len(g.members))
+	var unhealthy []*GroupMember
+	for _, member := range g.members {
+		if member.healthy.Load() {
+			healthy = append(healthy, member)
+		} else {
+			unhealthy = append(unhealthy, member)
+		}
+	}
+
+	switch g.strategy {
+	case GroupRoundRobin:
+		if len(healthy) > 0 {
+			start := int(g.next.Add(1)-1) % len(healthy)
+			healthy = append(healthy[start:], healthy[:start]...)
+		}
+	case GroupRandom:
+		healthy = weightedShuffle(healthy)
+	}
+	return append(healthy, unhealthy...)
+}
+
+// weightedShuffle возвращает участников в случайном порядке: участник с большим весом чаще оказывается раньше
+func weightedShuffle(members []*GroupMember) []*GroupMember {
+	rest := append([]*GroupMember(nil), members...)
+	shuffled := make([]*GroupMember, 0, len(members))
+	for len(rest) > 0 {
+		total := 0
+		for _, member := range rest {
+			total += max(member.Weight, 1)
+		}
+		pick := rand.Intn(total)
+		for i, member := range rest {
+			pick -= max(member.Weight, 1)
+			if pick < 0 {
+				shuffled = append(shuffled, member)
+				rest = append(rest[:i], rest[i+1:]...)
+				break
+			}
+		}
+	}
+	return shuffled
+}
+
+// probeLoop периодически проверяет участников группы
+func (g *GroupOutbound) probeLoop() {
+	ticker := time.NewTicker(g.probeInterval)
+	defer ticker.Stop()
+
+	g.probeMembers()
+	for {
+		select {
+		case <-ticker.C:
+			g.probeMembers()
+		case <-g.ctx.Done():
+			return
+		}
+	}
+}
+
+// probeMembers проверяет участников подключением к probeAddress
+// Участник, проверка которого еще не завершилась, пропускается. Блокировка не проверяется:
+// ей не нужно подключение, а проверка засчитывалась бы в счетчик ее правила
+func (g *GroupOutbound) probeMembers() {
+	for _, member := range g.members {
+		if _, ok := member.Outbound.(*BlockOutbound); ok {
+			continue
+		}
+		if !member.probing.CompareAndSwap(false, true) {
+			continue
+		}
+		go g.probe(member)
+	}
+}
+
+// probe проверяет участника и обновляет его состояние
+func (g *GroupOutbound) probe(member *GroupMember) {
+	defer member.probing.Store(false)
+
+	ctx, cancel := context.WithTimeout(g.ctx, min(g.probeInterval, groupProbeTimeout))
+	defer cancel()
+	conn, err := member.Outbound.DialContext(ctx, "tcp", g.probeAddress)
+	if err == nil {
+		conn.Close()
+	}
+
+	healthy := err == nil
+	if member.healthy.Swap(healthy) != healthy {
+		if healthy {
+			logger.Info("outbound", "Group member %s is healthy again", member.ID)
+		} else {
+			logger.Info("outbound", "Group member %s failed probe to %s: %v", member.ID, g.probeAddress, err)
+		}
+	}
+}
+
+// Close останавливает фоновые проверки группы и вложенных групп (установленные соединения не затрагиваются)
+func (g *GroupOutbound) Close() error {
+	g.cancel()
+	for _, member := range g.members {
+		if closer, ok := member.Outbound.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	return nil
+}
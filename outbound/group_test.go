@@ -0,0 +1,190 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+)
+
+// fakeOutbound outbound для тестов группы: возвращает err или локальное соединение
+type fakeOutbound struct {
+	mu    sync.Mutex
+	err   error
+	dials []string // Адреса подключений
+}
+
+func (f *fakeOutbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dials = append(f.dials, address)
+	if f.err != nil {
+		return nil, f.err
+	}
+	client, server := net.Pipe()
+	server.Close()
+	return client, nil
+}
+
+func (f *fakeOutbound) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakeOutbound) dialed(address string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, dialed := range f.dials {
+		if dialed == address {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeOutbound) dialCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.dials)
+}
+
+// newFakeGroup создает группу из n fakeOutbound
+func newFakeGroup(strategy string, n int, probeAddress string) (*GroupOutbound, []*fakeOutbound) {
+	fakes := make([]*fakeOutbound, n)
+	members := make([]*GroupMember, n)
+	for i := range fakes {
+		fakes[i] = &fakeOutbound{}
+		members[i] = &GroupMember{ID: fmt.Sprintf("m%d", i), Outbound: fakes[i], Weight: 1}
+	}
+	return NewGroupOutbound(strategy, members, probeAddress, 10*time.Millisecond), fakes
+}
+
+func TestGroupOutbound_Failover(t *testing.T) {
+	group, fakes := newFakeGroup(GroupFailover, 3, "")
+	defer group.Close()
+
+	fakes[0].setErr(errors.New("uplink down"))
+	conn, err := group.DialContext(context.Background(), "tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("Ожидалось подключение через второго участника: %v", err)
+	}
+	conn.Close()
+	if fakes[0].dialCount() != 1 || fakes[1].dialCount() != 1 || fakes[2].dialCount() != 0 {
+		t.Errorf("Неверный порядок участников: %d, %d, %d", fakes[0].dialCount(), fakes[1].dialCount(), fakes[2].dialCount())
+	}
+
+	// Отказ адреса назначения не зависит от участника
+	fakes[1].setErr(fmt.Errorf("device: %w", connerr.ErrConnectionRefused))
+	if _, err := group.DialContext(context.Background(), "tcp", "example.com:80"); !errors.Is(err, connerr.ErrConnectionRefused) {
+		t.Errorf("Ожидалась ошибка connection refused, получено %v", err)
+	}
+	if fakes[2].dialCount() != 0 {
+		t.Error("После отказа адреса назначения следующие участники не должны использоваться")
+	}
+}
+
+func TestGroupOutbound_RoundRobin(t *testing.T) {
+	group, fakes := newFakeGroup(GroupRoundRobin, 3, "")
+	defer group.Close()
+
+	for i := 0; i < 6; i++ {
+		conn, err := group.DialContext(context.Background(), "tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		conn.Close()
+	}
+	for i, fake := range fakes {
+		if fake.dialCount() != 2 {
+			t.Errorf("Участник %d: ожидалось 2 подключения, получено %d", i, fake.dialCount())
+		}
+	}
+}
+
+func TestGroupOutbound_RandomWeighted(t *testing.T) {
+	heavy, light := &fakeOutbound{}, &fakeOutbound{}
+	group := NewGroupOutbound(GroupRandom, []*GroupMember{
+		{ID: "heavy", Outbound: heavy, Weight: 9},
+		{ID: "light", Outbound: light, Weight: 1},
+	}, "", 0)
+	defer group.Close()
+
+	for i := 0; i < 1000; i++ {
+		conn, err := group.DialContext(context.Background(), "tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		conn.Close()
+	}
+	if heavy.dialCount() < 800 || light.dialCount() < 50 {
+		t.Errorf("Распределение не соответствует весам 9:1: heavy=%d, light=%d", heavy.dialCount(), light.dialCount())
+	}
+}
+
+func TestGroupOutbound_Probe(t *testing.T) {
+	group, fakes := newFakeGroup(GroupFailover, 2, "1.1.1.1:443")
+	defer group.Close()
+
+	// Участник, не прошедший проверку, используется только если здоровых нет
+	fakes[0].setErr(errors.New("blocked"))
+	waitFor(t, func() bool { return !group.members[0].healthy.Load() })
+	conn, err := group.DialContext(context.Background(), "tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+	if fakes[0].dialed("example.com:80") || !fakes[1].dialed("example.com:80") {
+		t.Error("Ожидалось подключение только через здорового участника")
+	}
+
+	// После успешной проверки участник снова выбирается первым
+	fakes[0].setErr(nil)
+	waitFor(t, func() bool { return group.members[0].healthy.Load() })
+}
+
+func TestGroupOutbound_ProbeSkipsBlock(t *testing.T) {
+	counters := NewBlockCounters(nil)
+	fake := &fakeOutbound{}
+	block := NewBlockOutbound("fallback", BlockReject, counters)
+	group := NewGroupOutbound(GroupFailover, []*GroupMember{
+		{ID: "proxy", Outbound: fake},
+		{ID: "fallback", Outbound: block},
+	}, "1.1.1.1:443", 10*time.Millisecond)
+	defer group.Close()
+
+	// Проверки идут, но блокировка ими не затрагивается и остается в выборе
+	waitFor(t, func() bool { return fake.dialCount() >= 3 })
+	if count := counters.Counts()["fallback"]; count != 0 {
+		t.Errorf("Проверки засчитаны в счетчик блокировок: %d", count)
+	}
+	if !group.members[1].healthy.Load() {
+		t.Error("Блокировка исключена из выбора проверкой")
+	}
+
+	// Соединение, дошедшее до блокировки, учитывается
+	fake.setErr(errors.New("down"))
+	if _, err := group.DialContext(context.Background(), "tcp", "example.com:80"); !errors.Is(err, connerr.ErrNotAllowed) {
+		t.Errorf("Ожидалась ErrNotAllowed, получено %v", err)
+	}
+	if count := counters.Counts()["fallback"]; count != 1 {
+		t.Errorf("Счетчик блокировок %d, ожидался 1", count)
+	}
+}
+
+// waitFor ждет выполнения условия не дольше секунды
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Условие не выполнено за секунду")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,191 @@
+package outbound
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/constants"
+	"example.com/me/myproxy/internal/logger"
+)
+
+// HTTPOutbound реализует подключение через HTTP прокси методом CONNECT
+type HTTPOutbound struct {
+	proxyAddress     string
+	tlsConfig        *tls.Config // nil - соединение с прокси без TLS
+	username         string
+	password         string
+	headers          map[string]string
+	dialer           *net.Dialer
+	handshakeTimeout time.Duration
+}
+
+// NewHTTPOutbound создает новый HTTP outbound
+// connectTimeout - таймаут TCP подключения к прокси, handshakeTimeout - таймаут
+// TLS handshake и ответа прокси на CONNECT (0 - default)
+func NewHTTPOutbound(proxyAddress string, connectTimeout, handshakeTimeout time.Duration) *HTTPOutbound {
+	if connectTimeout == 0 {
+		connectTimeout = constants.DefaultConnectTimeout * time.Second
+	}
+	if handshakeTimeout == 0 {
+		handshakeTimeout = constants.DefaultHandshakeTimeout * time.Second
+	}
+	return &HTTPOutbound{
+		proxyAddress:     proxyAddress,
+		dialer:           &net.Dialer{Timeout: connectTimeout},
+		handshakeTimeout: handshakeTimeout,
+	}
+}
+
+// SetTLS включает TLS до прокси (nil - без TLS)
+func (h *HTTPOutbound) SetTLS(tlsConfig *tls.Config) {
+	h.tlsConfig = tlsConfig
+}
+
+// SetAuth задает логин и пароль для Basic авторизации на прокси
+// Пустой username - без авторизации
+func (h *HTTPOutbound) SetAuth(username, password string) {
+	h.username = username
+	h.password = password
+}
+
+// SetHeaders задает дополнительные заголовки запроса CONNECT
+func (h *HTTPOutbound) SetHeaders(headers map[string]string) {
+	h.headers = headers
+}
+
+// ProxyAddress возвращает адрес HTTP прокси
+func (h *HTTPOutbound) ProxyAddress() string {
+	return h.proxyAddress
+}
+
+// DialContext устанавливает туннель к целевому адресу через HTTP прокси
+func (h *HTTPOutbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("unsupported network for HTTP outbound: %s", network)
+	}
+
+	logger.Debug("outbound", "Connecting to HTTP proxy at %s", h.proxyAddress)
+
+	conn, err := h.dialer.DialContext(ctx, "tcp", h.proxyAddress)
+	if err != nil {
+		logger.Debug("outbound", "Failed to connect to HTTP proxy %s: %v", h.proxyAddress, err)
+		return nil, fmt.Errorf("failed to connect to HTTP proxy: %w", err)
+	}
+	return h.DialConn(ctx, conn, network, address)
+}
+
+// DialConn устанавливает туннель к address через HTTP прокси поверх conn -
+// уже установленного соединения с прокси (например, предыдущим звеном цепочки)
+// При ошибке conn закрывается
+func (h *HTTPOutbound) DialConn(ctx context.Context, conn net.Conn, network, address string) (net.Conn, error) {
+	if network != "tcp" {
+		conn.Close()
+		return nil, fmt.Errorf("unsupported network for HTTP outbound: %s", network)
+	}
+
+	// TLS handshake и CONNECT ограничены handshakeTimeout, отмена ctx прерывает их через дедлайн соединения
+	conn.SetDeadline(time.Now().Add(h.handshakeTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	tunnel, err := h.connect(conn, address)
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		logger.Debug("outbound", "HTTP CONNECT to %s via %s failed: %v", address, h.proxyAddress, err)
+		return nil, fmt.Errorf("HTTP CONNECT failed: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	logger.Debug("outbound", "HTTP tunnel established to %s via %s", address, h.proxyAddress)
+	return tunnel, nil
+}
+
+// connect выполняет TLS handshake (если включен) и запрос CONNECT
+func (h *HTTPOutbound) connect(conn net.Conn, address string) (net.Conn, error) {
+	if h.tlsConfig != nil {
+		tlsConn := tls.Client(conn, h.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, fmt.Errorf("TLS handshake with proxy failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	for name, value := range h.headers {
+		request.Header.Set(name, value)
+	}
+	if h.username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(h.username + ":" + h.password))
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := request.Write(conn); err != nil {
+		return nil, fmt.Errorf("failed to send CONNECT request: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, &HTTPProxyError{StatusCode: response.StatusCode, Status: response.Status}
+	}
+
+	// Прокси мог отправить данные цели вместе с ответом: они остались в буфере reader
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// HTTPProxyError ответ HTTP прокси на CONNECT с кодом не 2xx
+// Unwrap возвращает ошибку connerr, соответствующую коду (inbound преобразует ее в reply code)
+type HTTPProxyError struct {
+	StatusCode int
+	Status     string // Код и текст, например "403 Forbidden"
+}
+
+func (e *HTTPProxyError) Error() string {
+	return "HTTP proxy responded " + e.Status
+}
+
+func (e *HTTPProxyError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusForbidden:
+		return connerr.ErrNotAllowed
+	case http.StatusNotFound, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return connerr.ErrHostUnreachable
+	case http.StatusGatewayTimeout:
+		return connerr.ErrTimeout
+	default:
+		return nil
+	}
+}
+
+// bufferedConn соединение, чтение из которого начинается с данных, уже прочитанных в reader
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	if c.reader.Buffered() > 0 {
+		return c.reader.Read(b)
+	}
+	return c.Conn.Read(b)
+}
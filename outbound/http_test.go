@@ -0,0 +1,135 @@
+package outbound
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+	tlsconfig "example.com/me/myproxy/internal/tls"
+)
+
+// startFakeHTTPProxy запускает HTTP прокси для тестов: передает запросы CONNECT в канал
+// и отвечает status; при успехе отправляет "hello" вместе с ответом и пересылает данные обратно
+func startFakeHTTPProxy(t *testing.T, status int, useTLS bool) (string, chan *http.Request) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	if useTLS {
+		cert, err := tlsconfig.GenerateSelfSignedCert()
+		if err != nil {
+			t.Fatalf("Ошибка создания сертификата: %v", err)
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	requests := make(chan *http.Request, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				reader := bufio.NewReader(c)
+				request, err := http.ReadRequest(reader)
+				if err != nil {
+					return
+				}
+				requests <- request
+				if status != http.StatusOK {
+					fmt.Fprintf(c, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\n\r\n", status, http.StatusText(status))
+					return
+				}
+				// Ответ и первые данные цели в одной записи
+				c.Write([]byte("HTTP/1.1 200 Connection established\r\nX-Proxy: fake\r\n\r\nhello"))
+				io.Copy(c, reader)
+			}(conn)
+		}
+	}()
+	return listener.Addr().String(), requests
+}
+
+func TestHTTPOutbound_Connect(t *testing.T) {
+	for _, useTLS := range []bool{false, true} {
+		t.Run(fmt.Sprintf("tls=%v", useTLS), func(t *testing.T) {
+			proxyAddress, requests := startFakeHTTPProxy(t, http.StatusOK, useTLS)
+
+			outbound := NewHTTPOutbound(proxyAddress, 0, 0)
+			if useTLS {
+				outbound.SetTLS(&tls.Config{InsecureSkipVerify: true})
+			}
+			outbound.SetAuth("user", "secret")
+			outbound.SetHeaders(map[string]string{"X-Session": "42"})
+
+			conn, err := outbound.DialContext(context.Background(), "tcp", "example.com:443")
+			if err != nil {
+				t.Fatalf("Ошибка подключения: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+			request := <-requests
+			if request.Method != http.MethodConnect || request.Host != "example.com:443" {
+				t.Errorf("Неверный запрос: %s %s", request.Method, request.Host)
+			}
+			if got := request.Header.Get("Proxy-Authorization"); got != "Basic dXNlcjpzZWNyZXQ=" {
+				t.Errorf("Неверный Proxy-Authorization: %q", got)
+			}
+			if got := request.Header.Get("X-Session"); got != "42" {
+				t.Errorf("Неверный заголовок X-Session: %q", got)
+			}
+
+			// Данные, пришедшие вместе с ответом прокси, не теряются
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+				t.Errorf("Данные после ответа прокси потеряны: %q, %v", buf, err)
+			}
+			conn.Write([]byte("ping"))
+			if _, err := io.ReadFull(conn, buf[:4]); err != nil || string(buf[:4]) != "ping" {
+				t.Errorf("Неверный ответ через туннель: %q, %v", buf[:4], err)
+			}
+		})
+	}
+}
+
+func TestHTTPOutbound_StatusErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusForbidden, connerr.ErrNotAllowed},
+		{http.StatusBadGateway, connerr.ErrHostUnreachable},
+		{http.StatusGatewayTimeout, connerr.ErrTimeout},
+	}
+	for _, tt := range tests {
+		proxyAddress, _ := startFakeHTTPProxy(t, tt.status, false)
+
+		_, err := NewHTTPOutbound(proxyAddress, 0, 0).DialContext(context.Background(), "tcp", "example.com:443")
+		var proxyErr *HTTPProxyError
+		if !errors.As(err, &proxyErr) || proxyErr.StatusCode != tt.status {
+			t.Errorf("Статус %d: ожидалась HTTPProxyError, получено %v", tt.status, err)
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("Статус %d: ожидалась ошибка %v, получено %v", tt.status, tt.want, err)
+		}
+	}
+
+	// Ответ без соответствующей ошибки connerr
+	proxyAddress, _ := startFakeHTTPProxy(t, http.StatusProxyAuthRequired, false)
+	_, err := NewHTTPOutbound(proxyAddress, 0, 0).DialContext(context.Background(), "tcp", "example.com:443")
+	if err == nil || errors.Is(err, connerr.ErrNotAllowed) {
+		t.Errorf("Неверная ошибка для 407: %v", err)
+	}
+}
@@ -1,10 +1,38 @@
 package outbound
 
-import "net"
+import (
+	"context"
+	"net"
+)
 
 // Outbound интерфейс для outbound обработчиков
 type Outbound interface {
-	// Dial устанавливает соединение с целевым адресом
-	Dial(network, address string) (net.Conn, error)
+	// DialContext устанавливает соединение с целевым адресом
+	// Отмена ctx (отключение клиента, остановка server) прерывает незавершенное подключение
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
 }
 
+// Hop outbound через прокси, который может быть следующим звеном цепочки:
+// соединение с прокси устанавливает предыдущее звено
+type Hop interface {
+	Outbound
+	// ProxyAddress возвращает адрес прокси, к которому подключается предыдущее звено
+	ProxyAddress() string
+	// DialConn устанавливает соединение с address через прокси поверх conn - уже
+	// установленного соединения с ним. При ошибке conn закрывается
+	DialConn(ctx context.Context, conn net.Conn, network, address string) (net.Conn, error)
+}
+
+// EgressConn реализуется соединениями через устройства пула: устройство сообщает адрес,
+// к которому подключилось после разрешения домена, и свой публичный IP
+type EgressConn interface {
+	// ResolvedAddr возвращает адрес назначения ip:port (пусто - устройство не сообщило)
+	ResolvedAddr() string
+	// EgressIP возвращает публичный IP устройства (пусто - неизвестен)
+	EgressIP() string
+}
+
+// Resolver разрешает домены в IP адреса (см. internal/dns)
+type Resolver interface {
+	LookupIP(ctx context.Context, host string) ([]net.IP, error)
+}
@@ -3,6 +3,7 @@ package outbound
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"example.com/me/myproxy/internal/device"
 	"example.com/me/myproxy/internal/logger"
@@ -10,21 +11,71 @@ import (
 
 // Pool управляет пулом outbound объектов для устройств
 type Pool struct {
-	mu       sync.RWMutex
-	outbounds map[string]Outbound // deviceID -> Outbound
-	registry  *device.Registry
+	mu          sync.RWMutex
+	outbounds   map[string]Outbound // deviceID -> Outbound
+	registry    *device.Registry
+	dialTimeout time.Duration // Таймаут подключения через устройство (см. NewQUICOutbound)
+	remote      Remote        // Устройства других POP кластера (nil - только локальные)
+	resolver    Resolver      // Разрешение доменов на POP (nil - устройство разрешает само)
+}
+
+// Remote источник outbound для устройств, подключенных к другим POP кластера
+type Remote interface {
+	// RemoteOutbound возвращает outbound, передающий соединения POP, к которому подключено устройство
+	RemoteOutbound(deviceID string) (Outbound, error)
 }
 
 // NewPool создает новый Pool
-func NewPool(registry *device.Registry) *Pool {
+func NewPool(registry *device.Registry, dialTimeout time.Duration) *Pool {
 	return &Pool{
-		outbounds: make(map[string]Outbound),
-		registry:  registry,
+		outbounds:   make(map[string]Outbound),
+		registry:    registry,
+		dialTimeout: dialTimeout,
 	}
 }
 
-// GetOutbound возвращает outbound для устройства, создавая его при необходимости
+// SetRemote подключает устройства других POP кластера
+// GetOutbound использует их, если устройство не подключено к этому POP
+func (p *Pool) SetRemote(remote Remote) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.remote = remote
+}
+
+// SetResolver включает разрешение доменов на POP: GetOutbound возвращает outbound,
+// передающий устройству IP адрес вместо домена
+func (p *Pool) SetResolver(resolver Resolver) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resolver = resolver
+}
+
+// GetOutbound возвращает outbound для устройства, подключенного к этому POP или к другому POP кластера
 func (p *Pool) GetOutbound(deviceID string) (Outbound, error) {
+	p.mu.RLock()
+	remote, resolver := p.remote, p.resolver
+	p.mu.RUnlock()
+
+	outbound, err := p.LocalOutbound(deviceID)
+	if err != nil {
+		if remote == nil {
+			return nil, err
+		}
+		remoteOutbound, remoteErr := remote.RemoteOutbound(deviceID)
+		if remoteErr != nil {
+			return nil, fmt.Errorf("%v; %w", err, remoteErr)
+		}
+		outbound = remoteOutbound
+	}
+
+	if resolver != nil {
+		return NewResolvingOutbound(outbound, resolver), nil
+	}
+	return outbound, nil
+}
+
+// LocalOutbound возвращает outbound для устройства, подключенного к этому POP, создавая его при необходимости
+func (p *Pool) LocalOutbound(deviceID string) (Outbound, error) {
 	p.mu.RLock()
 	outbound, exists := p.outbounds[deviceID]
 	p.mu.RUnlock()
@@ -67,7 +118,7 @@ func (p *Pool) GetOutbound(deviceID string) (Outbound, error) {
 	}
 
 	// Создаем QUICOutbound для устройства
-	outbound = NewQUICOutbound(deviceID, p.registry)
+	outbound = NewQUICOutbound(deviceID, p.registry, p.dialTimeout)
 
 	p.mu.Lock()
 	p.outbounds[deviceID] = outbound
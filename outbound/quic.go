@@ -2,11 +2,16 @@ package outbound
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/constants"
 	"example.com/me/myproxy/internal/device"
 	"example.com/me/myproxy/internal/logger"
 	quicproto "example.com/me/myproxy/internal/protocol/quic"
@@ -15,30 +20,35 @@ import (
 
 // QUICOutbound реализует подключение через QUIC stream от device
 type QUICOutbound struct {
-	deviceID  string
-	registry  *device.Registry
-	mu        sync.Mutex
-	streams   map[string]*quic.Stream // conn_id → stream
+	deviceID    string
+	registry    *device.Registry
+	dialTimeout time.Duration
+	nextID      atomic.Uint64 // Счетчик для conn_id
+	mu          sync.Mutex
+	streams     map[string]*quic.Stream // conn_id → stream
 }
 
 // NewQUICOutbound создает новый QUIC Outbound
-func NewQUICOutbound(deviceID string, registry *device.Registry) *QUICOutbound {
+// dialTimeout ограничивает открытие stream и ожидание ответа устройства (0 - default)
+func NewQUICOutbound(deviceID string, registry *device.Registry, dialTimeout time.Duration) *QUICOutbound {
+	if dialTimeout == 0 {
+		dialTimeout = constants.DefaultDeviceDialTimeout * time.Second
+	}
 	return &QUICOutbound{
-		deviceID: deviceID,
-		registry: registry,
-		streams:  make(map[string]*quic.Stream),
+		deviceID:    deviceID,
+		registry:    registry,
+		dialTimeout: dialTimeout,
+		streams:     make(map[string]*quic.Stream),
 	}
 }
 
-// Dial отправляет запрос через QUIC stream
-func (q *QUICOutbound) Dial(network, address string) (net.Conn, error) {
+// DialContext отправляет запрос через QUIC stream
+// Подключение, прерванное отменой ctx, не учитывается в оценке здоровья устройства
+func (q *QUICOutbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
 	if network != "tcp" {
 		return nil, fmt.Errorf("unsupported network for QUIC outbound: %s", network)
 	}
 
-	q.mu.Lock()
-	defer q.mu.Unlock()
-
 	// Получаем device
 	dev, err := q.registry.GetDevice(q.deviceID)
 	if err != nil {
@@ -51,41 +61,90 @@ func (q *QUICOutbound) Dial(network, address string) (net.Conn, error) {
 	}
 
 	// Генерируем conn_id
-	connID := fmt.Sprintf("%s-%d", q.deviceID, len(q.streams))
+	connID := fmt.Sprintf("%s-%d", q.deviceID, q.nextID.Add(1))
 
 	logger.Debug("outbound", "Opening QUIC stream for %s, conn_id=%s", address, connID)
 
-	// Открываем новый stream с таймаутом
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	dialCtx, cancel := context.WithTimeout(ctx, q.dialTimeout)
 	defer cancel()
-	stream, err := quicConn.OpenStreamSync(ctx)
+	start := time.Now()
+	record := func(err error) {
+		if ctx.Err() == nil {
+			recordDial(dev, err, time.Since(start))
+		}
+	}
+
+	// Открываем новый stream
+	stream, err := quicConn.OpenStreamSync(dialCtx)
 	if err != nil {
+		record(err)
 		return nil, fmt.Errorf("failed to open QUIC stream: %w", err)
 	}
 
 	// Сохраняем stream
+	q.mu.Lock()
 	q.streams[connID] = stream
+	q.mu.Unlock()
 	dev.AddStream(connID, stream)
 
-	// Отправляем target address через stream
-	if err := quicproto.WriteTargetAddress(stream, address); err != nil {
+	// Отправляем target address и ждем результат подключения device к target address
+	// Устройства без CapabilityDialResult результат не отправляют: stream сразу передает данные
+	// Таймаут и отмена ctx прерывают ожидание через дедлайн stream
+	stop := context.AfterFunc(dialCtx, func() { stream.SetDeadline(time.Now()) })
+	var result quicproto.DialResult
+	err = quicproto.WriteTargetAddress(stream, address)
+	if err != nil {
+		err = fmt.Errorf("failed to send target address: %w", err)
+	} else if dev.HasCapability(constants.CapabilityDialResult) {
+		result, err = quicproto.ReadDialResult(stream)
+	}
+	if !stop() {
+		err = fmt.Errorf("device %s did not respond: %w", q.deviceID, dialCtx.Err())
+	}
+	record(err)
+	if err != nil {
+		// Отмена чтения прерывает подключение device, если оно еще не завершено
+		stream.CancelRead(0)
 		stream.Close()
+		q.mu.Lock()
 		delete(q.streams, connID)
+		q.mu.Unlock()
 		dev.RemoveStream(connID)
-		return nil, fmt.Errorf("failed to send target address: %w", err)
+		return nil, err
 	}
+	stream.SetDeadline(time.Time{})
 
-	logger.Debug("outbound", "QUIC stream opened for %s, conn_id=%s", address, connID)
+	// Публичный IP из результата подключения новее сообщенного в heartbeat
+	if result.EgressIP != "" && net.ParseIP(result.EgressIP) != nil {
+		dev.SetEgressIP(result.EgressIP)
+	}
+
+	logger.Debug("outbound", "QUIC stream opened for %s, conn_id=%s, resolved=%s", address, connID, result.ResolvedAddr)
 
 	// Возвращаем wrapper для net.Conn
 	return &quicStreamConn{
-		stream:   stream,
-		connID:   connID,
-		outbound: q,
-		device:   dev,
+		stream:       stream,
+		connID:       connID,
+		outbound:     q,
+		device:       dev,
+		resolvedAddr: result.ResolvedAddr,
+		egressIP:     dev.GetEgressIP(),
 	}, nil
 }
 
+// recordDial учитывает исход подключения в оценке здоровья устройства
+// Запрет политикой устройства от его здоровья не зависит и не учитывается,
+// отказ в соединении означает, что устройство достигло адреса назначения
+func recordDial(dev *device.Device, err error, latency time.Duration) {
+	switch {
+	case errors.Is(err, connerr.ErrNotAllowed):
+	case err == nil, errors.Is(err, connerr.ErrConnectionRefused):
+		dev.RecordDial(true, latency)
+	default:
+		dev.RecordDial(false, latency)
+	}
+}
+
 // quicStreamConn обертка для quic.Stream, реализующая net.Conn
 type quicStreamConn struct {
 	stream   *quic.Stream
@@ -94,10 +153,16 @@ type quicStreamConn struct {
 	device   *device.Device
 	closed   bool
 	mu       sync.Mutex
+	received atomic.Int64 // Получено через устройство (для оценки здоровья)
+
+	resolvedAddr string // Адрес назначения, к которому подключилось устройство
+	egressIP     string // Публичный IP устройства на момент подключения
 }
 
 func (c *quicStreamConn) Read(b []byte) (n int, err error) {
-	return c.stream.Read(b)
+	n, err = c.stream.Read(b)
+	c.received.Add(int64(n))
+	return n, err
 }
 
 func (c *quicStreamConn) Write(b []byte) (n int, err error) {
@@ -120,6 +185,7 @@ func (c *quicStreamConn) Close() error {
 	delete(c.outbound.streams, c.connID)
 	c.outbound.mu.Unlock()
 	c.device.RemoveStream(c.connID)
+	c.device.RecordTransfer(c.received.Load())
 
 	return nil
 }
@@ -128,10 +194,24 @@ func (c *quicStreamConn) LocalAddr() net.Addr {
 	return &net.TCPAddr{}
 }
 
+// RemoteAddr возвращает адрес назначения, к которому подключилось устройство (если сообщен)
 func (c *quicStreamConn) RemoteAddr() net.Addr {
+	if addrPort, err := netip.ParseAddrPort(c.resolvedAddr); err == nil {
+		return net.TCPAddrFromAddrPort(addrPort)
+	}
 	return &net.TCPAddr{}
 }
 
+// ResolvedAddr возвращает адрес назначения после разрешения домена на устройстве
+func (c *quicStreamConn) ResolvedAddr() string {
+	return c.resolvedAddr
+}
+
+// EgressIP возвращает публичный IP устройства
+func (c *quicStreamConn) EgressIP() string {
+	return c.egressIP
+}
+
 func (c *quicStreamConn) SetDeadline(t time.Time) error {
 	return c.stream.SetDeadline(t)
 }
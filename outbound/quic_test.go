@@ -0,0 +1,134 @@
+package outbound
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/constants"
+	"example.com/me/myproxy/internal/device"
+	quicproto "example.com/me/myproxy/internal/protocol/quic"
+	tlsconfig "example.com/me/myproxy/internal/tls"
+	"github.com/quic-go/quic-go"
+)
+
+// startQUICDevice регистрирует устройство с QUIC соединением, которое подключается
+// ко всем адресам, кроме silent: на него устройство не отвечает
+// Устройство legacy не сообщает CapabilityDialResult и не отправляет результат подключения
+func startQUICDevice(t *testing.T, registry *device.Registry, deviceID, silent string, legacy bool) {
+	t.Helper()
+	cert, err := tlsconfig.GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Ошибка создания сертификата: %v", err)
+	}
+	listener, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"test"}}, nil)
+	if err != nil {
+		t.Fatalf("Ошибка создания QUIC слушателя: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	// Устройство - QUIC клиент, POP открывает на его соединении stream
+	go func() {
+		conn, err := quic.DialAddr(context.Background(), listener.Addr().String(),
+			&tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test"}}, nil)
+		if err != nil {
+			return
+		}
+		for {
+			stream, err := conn.AcceptStream(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				target, err := quicproto.ReadTargetAddress(stream)
+				if err != nil || target == silent {
+					return
+				}
+				if legacy {
+					stream.Write([]byte("hello"))
+					stream.Close()
+					return
+				}
+				quicproto.WriteDialSuccess(stream, quicproto.DialResult{ResolvedAddr: target})
+			}()
+		}
+	}()
+
+	conn, err := listener.Accept(context.Background())
+	if err != nil {
+		t.Fatalf("Ошибка приема QUIC соединения: %v", err)
+	}
+	var metadata map[string]interface{}
+	if !legacy {
+		metadata = map[string]interface{}{"capabilities": []string{constants.CapabilityDialResult}}
+	}
+	if err := registry.Register(deviceID, conn.RemoteAddr().String(), metadata); err != nil {
+		t.Fatalf("Ошибка регистрации устройства: %v", err)
+	}
+	if err := registry.RegisterQUICConnection(deviceID, conn); err != nil {
+		t.Fatalf("Ошибка регистрации QUIC соединения: %v", err)
+	}
+}
+
+func TestQUICOutbound_SilentDeviceDoesNotBlock(t *testing.T) {
+	registry := device.NewRegistry(30, 90)
+	defer registry.Close()
+	startQUICDevice(t, registry, "device-1", "silent.test:80", false)
+	outbound := NewQUICOutbound("device-1", registry, 500*time.Millisecond)
+
+	// Устройство не отвечает на первый запрос: он завершается по таймауту
+	silentErr := make(chan error, 1)
+	go func() {
+		_, err := outbound.DialContext(context.Background(), "tcp", "silent.test:80")
+		silentErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// Другие подключения через то же соединение не ждут ответа на первый
+	start := time.Now()
+	conn, err := outbound.DialContext(context.Background(), "tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	conn.Close()
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("Подключение ждало ответа на другой запрос: %v", elapsed)
+	}
+
+	select {
+	case err := <-silentErr:
+		if err == nil {
+			t.Error("Ожидалась ошибка таймаута ответа устройства")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Ожидание ответа устройства не прервано таймаутом")
+	}
+}
+
+func TestQUICOutbound_LegacyDeviceWithoutDialResult(t *testing.T) {
+	registry := device.NewRegistry(30, 90)
+	defer registry.Close()
+	startQUICDevice(t, registry, "device-1", "", true)
+	outbound := NewQUICOutbound("device-1", registry, 500*time.Millisecond)
+
+	// Устройство без CapabilityDialResult: подключение не ждет строку результата,
+	// а первые байты stream остаются данными target
+	start := time.Now()
+	conn, err := outbound.DialContext(context.Background(), "tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	defer conn.Close()
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("Подключение ждало результат от устройства без поддержки: %v", elapsed)
+	}
+	data := make([]byte, len("hello"))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatalf("Ошибка чтения: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("Данные target: %q, ожидалось %q", data, "hello")
+	}
+}
@@ -0,0 +1,118 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/logger"
+)
+
+// maxResolvedAttempts сколько адресов домена пробуется до отказа
+const maxResolvedAttempts = 2
+
+// ResolvingOutbound разрешает домен назначения на POP и передает outbound IP адрес
+// вместо домена (локальное разрешение для прокси и устройств)
+type ResolvingOutbound struct {
+	outbound Outbound
+	resolver Resolver
+}
+
+// NewResolvingOutbound создает outbound, разрешающий домены через resolver перед подключением через outbound
+func NewResolvingOutbound(outbound Outbound, resolver Resolver) *ResolvingOutbound {
+	return &ResolvingOutbound{
+		outbound: outbound,
+		resolver: resolver,
+	}
+}
+
+// DialContext разрешает домен address и подключается к его адресам по очереди
+func (r *ResolvingOutbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return r.outbound.DialContext(ctx, network, address)
+	}
+	ips, err := r.resolver.LookupIP(ctx, host)
+	if err != nil {
+		return nil, resolveError(host, err)
+	}
+	logger.Debug("outbound", "Resolved %s to %v", host, ips)
+	return dialResolved(ctx, ips, func(ip net.IP) (net.Conn, error) {
+		return r.outbound.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+	})
+}
+
+// Close освобождает ресурсы outbound (фоновые проверки группы)
+func (r *ResolvingOutbound) Close() error {
+	if closer, ok := r.outbound.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// ResolvingHop звено цепочки, разрешающее на POP адрес, к которому оно подключается
+// (адрес следующего прокси или, для последнего звена, адрес назначения)
+type ResolvingHop struct {
+	*ResolvingOutbound
+	hop Hop
+}
+
+// NewResolvingHop создает звено цепочки, разрешающее домены через resolver перед подключением через hop
+func NewResolvingHop(hop Hop, resolver Resolver) *ResolvingHop {
+	return &ResolvingHop{
+		ResolvingOutbound: NewResolvingOutbound(hop, resolver),
+		hop:               hop,
+	}
+}
+
+// ProxyAddress возвращает адрес прокси звена
+func (h *ResolvingHop) ProxyAddress() string {
+	return h.hop.ProxyAddress()
+}
+
+// DialConn разрешает домен address и подключается через прокси поверх conn к первому его адресу
+// (соединение с прокси одно, поэтому другие адреса не пробуются). При ошибке conn закрывается
+func (h *ResolvingHop) DialConn(ctx context.Context, conn net.Conn, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return h.hop.DialConn(ctx, conn, network, address)
+	}
+	ips, err := h.resolver.LookupIP(ctx, host)
+	if err == nil && len(ips) == 0 {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, resolveError(host, err)
+	}
+	logger.Debug("outbound", "Resolved %s to %v", host, ips)
+	return h.hop.DialConn(ctx, conn, network, net.JoinHostPort(ips[0].String(), port))
+}
+
+// dialResolved подключается к первым maxResolvedAttempts адресам по очереди до успеха
+func dialResolved(ctx context.Context, ips []net.IP, dial func(ip net.IP) (net.Conn, error)) (net.Conn, error) {
+	var lastErr error
+	for _, ip := range ips[:min(len(ips), maxResolvedAttempts)] {
+		conn, err := dial(ip)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// resolveError оборачивает ошибку разрешения домена; отсутствие домена - недоступный хост
+func resolveError(host string, err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return fmt.Errorf("%w: %s: %v", connerr.ErrHostUnreachable, host, err)
+	}
+	return fmt.Errorf("failed to resolve %s: %w", host, err)
+}
@@ -0,0 +1,135 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+)
+
+// staticResolver resolver с фиксированными адресами доменов
+type staticResolver map[string][]net.IP
+
+func (r staticResolver) LookupIP(_ context.Context, host string) ([]net.IP, error) {
+	if ips, ok := r[host]; ok {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestResolvingOutbound(t *testing.T) {
+	resolver := staticResolver{"example.com": {net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("192.0.2.3")}}
+	fake := &fakeOutbound{err: errors.New("dial failed")}
+	outbound := NewResolvingOutbound(fake, resolver)
+
+	// Outbound получает IP вместо домена; после отказа пробуется следующий адрес
+	if _, err := outbound.DialContext(context.Background(), "tcp", "example.com:443"); err == nil {
+		t.Fatal("Ожидалась ошибка подключения")
+	}
+	if !fake.dialed("192.0.2.1:443") || !fake.dialed("192.0.2.2:443") || fake.dialCount() != maxResolvedAttempts {
+		t.Errorf("Неверные адреса подключения: %v", fake.dials)
+	}
+
+	// IP адрес передается без разрешения
+	fake.setErr(nil)
+	conn, err := outbound.DialContext(context.Background(), "tcp", "198.51.100.1:80")
+	if err != nil || !fake.dialed("198.51.100.1:80") {
+		t.Errorf("IP адрес должен передаваться как есть: %v", err)
+	}
+	if conn != nil {
+		conn.Close()
+	}
+
+	// Несуществующий домен - хост недоступен
+	if _, err := outbound.DialContext(context.Background(), "tcp", "missing.example:443"); !errors.Is(err, connerr.ErrHostUnreachable) {
+		t.Errorf("Ожидалась ошибка %v, получено %v", connerr.ErrHostUnreachable, err)
+	}
+}
+
+func TestDirectOutbound_Resolver(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	defer listener.Close()
+	acceptRemoteIPs(t, listener, 2)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// IPv6 адрес не слушается, поэтому успех означает выбор IPv4
+	resolver := staticResolver{"dual.test": {net.ParseIP("::1"), net.ParseIP("127.0.0.1")}}
+	outbound := NewDirectOutbound(0)
+	outbound.SetResolver(resolver)
+	outbound.SetIPPreference(IPv4Only)
+	conn, err := outbound.DialContext(context.Background(), "tcp", net.JoinHostPort("dual.test", port))
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	if ip := conn.RemoteAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Ожидалось подключение к 127.0.0.1, получено %s", ip)
+	}
+	conn.Close()
+
+	outbound.SetIPPreference(IPv6Only)
+	if _, err := outbound.DialContext(context.Background(), "tcp", net.JoinHostPort("dual.test", port)); err == nil {
+		t.Error("Ожидалась ошибка подключения только по IPv6")
+	}
+
+	// Семейство адресов определяется локальным адресом
+	outbound.SetSourceAddresses([]net.IP{net.ParseIP("127.0.0.2")})
+	conn, err = outbound.DialContext(context.Background(), "tcp", net.JoinHostPort("dual.test", port))
+	if err != nil {
+		t.Fatalf("Ошибка подключения с IPv4 источником: %v", err)
+	}
+	conn.Close()
+}
+
+func TestResolvingHop_Chain(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c)
+			}(conn)
+		}
+	}()
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+
+	// Звено с локальным разрешением остается звеном цепочки и передает прокси IP вместо домена
+	httpAddress, targets := startForwardingHTTPProxy(t)
+	resolver := staticResolver{"echo.test": {net.ParseIP("127.0.0.1")}}
+	var hop Hop = NewResolvingHop(NewHTTPOutbound(httpAddress, 0, 0), resolver)
+	chain := NewChainOutbound(NewDirectOutbound(0), []Hop{hop})
+
+	conn, err := chain.DialContext(context.Background(), "tcp", net.JoinHostPort("echo.test", echoPort))
+	if err != nil {
+		t.Fatalf("Ошибка подключения через цепочку: %v", err)
+	}
+	defer conn.Close()
+	if target := <-targets; target != net.JoinHostPort("127.0.0.1", echoPort) {
+		t.Errorf("Прокси получил адрес %s, ожидался разрешенный IP", target)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Неверный ответ через цепочку: %q, %v", buf, err)
+	}
+
+	// Несуществующий домен - хост недоступен
+	if _, err := chain.DialContext(context.Background(), "tcp", "missing.example:443"); !errors.Is(err, connerr.ErrHostUnreachable) {
+		t.Errorf("Ожидалась ошибка %v, получено %v", connerr.ErrHostUnreachable, err)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package outbound
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setSocketOptions привязывает сокет к интерфейсу iface и задает метку mark (пустые значения пропускаются)
+func setSocketOptions(fd uintptr, iface string, mark int) error {
+	if iface != "" {
+		if err := syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface); err != nil {
+			return fmt.Errorf("failed to bind to interface %s: %w", iface, err)
+		}
+	}
+	if mark != 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark); err != nil {
+			return fmt.Errorf("failed to set socket mark %d: %w", mark, err)
+		}
+	}
+	return nil
+}
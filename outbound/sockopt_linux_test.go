@@ -0,0 +1,94 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// skipUnsupported пропускает тест, если опция сокета недоступна в окружении
+func skipUnsupported(t *testing.T, err error) {
+	t.Helper()
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOPROTOOPT) || errors.Is(err, syscall.EOPNOTSUPP) {
+		t.Skipf("Опция сокета недоступна: %v", err)
+	}
+}
+
+func TestDirectOutbound_SocketOptions(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	defer listener.Close()
+	acceptRemoteIPs(t, listener, 2)
+
+	outbound := NewDirectOutbound(0)
+	outbound.SetNoDelay(false)
+	outbound.SetKeepAlive(net.KeepAliveConfig{Enable: true, Idle: 30 * time.Second, Interval: 5 * time.Second, Count: 3})
+	conn, err := outbound.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	defer conn.Close()
+
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("Ошибка получения сокета: %v", err)
+	}
+	var noDelay, keepAlive, idle int
+	raw.Control(func(fd uintptr) {
+		noDelay, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+		keepAlive, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+		idle, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)
+	})
+	if noDelay != 0 {
+		t.Error("TCP_NODELAY не отключен")
+	}
+	if keepAlive != 1 || idle != 30 {
+		t.Errorf("Неверные параметры keepalive: SO_KEEPALIVE=%d, TCP_KEEPIDLE=%d", keepAlive, idle)
+	}
+}
+
+func TestDirectOutbound_Interface(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	defer listener.Close()
+	acceptRemoteIPs(t, listener, 1)
+
+	outbound := NewDirectOutbound(0)
+	outbound.SetInterface("lo")
+	conn, err := outbound.DialContext(context.Background(), "tcp", listener.Addr().String())
+	skipUnsupported(t, err)
+	if err != nil {
+		t.Fatalf("Ошибка подключения через lo: %v", err)
+	}
+	conn.Close()
+
+	outbound.SetInterface("nonexistent0")
+	if _, err := outbound.DialContext(context.Background(), "tcp", listener.Addr().String()); err == nil {
+		t.Error("Ожидалась ошибка для несуществующего интерфейса")
+	}
+}
+
+func TestDirectOutbound_Mark(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	defer listener.Close()
+	acceptRemoteIPs(t, listener, 1)
+
+	outbound := NewDirectOutbound(0)
+	outbound.SetMark(42)
+	conn, err := outbound.DialContext(context.Background(), "tcp", listener.Addr().String())
+	skipUnsupported(t, err)
+	if err != nil {
+		t.Fatalf("Ошибка подключения с SO_MARK: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package outbound
+
+import "fmt"
+
+// setSocketOptions привязка к интерфейсу и метка сокета поддерживаются только на Linux
+func setSocketOptions(fd uintptr, iface string, mark int) error {
+	if iface != "" || mark != 0 {
+		return fmt.Errorf("interface binding and socket mark are only supported on Linux")
+	}
+	return nil
+}
@@ -1,184 +1,307 @@
 package outbound
 
 import (
-	"encoding/binary"
+	"context"
 	"fmt"
+	"io"
 	"net"
+	"net/netip"
+	"time"
 
+	"example.com/me/myproxy/internal/constants"
 	"example.com/me/myproxy/internal/logger"
+	"example.com/me/myproxy/internal/protocol/socks5"
 )
 
+// maxUDPDatagram максимальный размер UDP датаграммы от relay
+const maxUDPDatagram = 65535
+
 // SOCKS5Outbound реализует подключение через SOCKS5 прокси
+// network "tcp" - CONNECT, "udp" - UDP ASSOCIATE
 type SOCKS5Outbound struct {
-	proxyAddress string
-	dialer       *net.Dialer
+	proxyAddress     string
+	username         string
+	password         string
+	dialer           *net.Dialer
+	handshakeTimeout time.Duration
 }
 
 // NewSOCKS5Outbound создает новый SOCKS5 outbound
-func NewSOCKS5Outbound(proxyAddress string) *SOCKS5Outbound {
+// connectTimeout - таймаут TCP подключения к прокси, handshakeTimeout - таймаут
+// SOCKS5 handshake, включая ответ прокси на CONNECT (0 - default)
+func NewSOCKS5Outbound(proxyAddress string, connectTimeout, handshakeTimeout time.Duration) *SOCKS5Outbound {
+	if connectTimeout == 0 {
+		connectTimeout = constants.DefaultConnectTimeout * time.Second
+	}
+	if handshakeTimeout == 0 {
+		handshakeTimeout = constants.DefaultHandshakeTimeout * time.Second
+	}
 	return &SOCKS5Outbound{
-		proxyAddress: proxyAddress,
-		dialer:       &net.Dialer{},
+		proxyAddress:     proxyAddress,
+		dialer:           &net.Dialer{Timeout: connectTimeout},
+		handshakeTimeout: handshakeTimeout,
 	}
 }
 
-// Dial устанавливает соединение с целевым адресом через SOCKS5 прокси
-func (s *SOCKS5Outbound) Dial(network, address string) (net.Conn, error) {
-	if network != "tcp" {
+// SetAuth задает логин и пароль для вышестоящего прокси (RFC 1929)
+// Пустой username - только без аутентификации
+func (s *SOCKS5Outbound) SetAuth(username, password string) {
+	s.username = username
+	s.password = password
+}
+
+// ProxyAddress возвращает адрес SOCKS5 прокси
+func (s *SOCKS5Outbound) ProxyAddress() string {
+	return s.proxyAddress
+}
+
+// DialContext устанавливает соединение с целевым адресом через SOCKS5 прокси
+func (s *SOCKS5Outbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" && network != "udp" {
 		return nil, fmt.Errorf("unsupported network: %s", network)
 	}
 
 	logger.Debug("outbound", "Connecting to SOCKS5 proxy at %s", s.proxyAddress)
 
 	// Connect to SOCKS5 proxy
-	conn, err := s.dialer.Dial("tcp", s.proxyAddress)
+	conn, err := s.dialer.DialContext(ctx, "tcp", s.proxyAddress)
 	if err != nil {
 		logger.Debug("outbound", "Failed to connect to SOCKS5 proxy %s: %v", s.proxyAddress, err)
 		return nil, fmt.Errorf("failed to connect to SOCKS5 proxy: %w", err)
 	}
+	return s.negotiate(ctx, conn, network, address)
+}
+
+// DialConn устанавливает TCP соединение с address через SOCKS5 прокси поверх conn -
+// уже установленного соединения с прокси (например, предыдущим звеном цепочки)
+// UDP ASSOCIATE требует отдельного UDP сокета до relay и поверх conn не поддерживается
+func (s *SOCKS5Outbound) DialConn(ctx context.Context, conn net.Conn, network, address string) (net.Conn, error) {
+	if network != "tcp" {
+		conn.Close()
+		return nil, fmt.Errorf("unsupported network over existing connection: %s", network)
+	}
+	return s.negotiate(ctx, conn, network, address)
+}
 
-	// Perform SOCKS5 handshake
-	if err := s.performSOCKS5Handshake(conn, address); err != nil {
+// negotiate выполняет SOCKS5 handshake и команду для network по соединению conn с прокси
+// При ошибке conn закрывается
+func (s *SOCKS5Outbound) negotiate(ctx context.Context, conn net.Conn, network, address string) (net.Conn, error) {
+	// Handshake ограничен handshakeTimeout, отмена ctx прерывает его через дедлайн соединения
+	conn.SetDeadline(time.Now().Add(s.handshakeTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	var result net.Conn
+	var err error
+	if network == "tcp" {
+		result, err = conn, s.connect(conn, address)
+	} else {
+		result, err = s.associate(ctx, conn, address)
+	}
+	if !stop() {
+		if result != nil && result != conn {
+			result.Close()
+		}
+		err = ctx.Err()
+	}
+	if err != nil {
 		conn.Close()
 		logger.Debug("outbound", "SOCKS5 handshake failed for %s: %v", address, err)
 		return nil, fmt.Errorf("SOCKS5 handshake failed: %w", err)
 	}
+	conn.SetDeadline(time.Time{})
 
-	logger.Debug("outbound", "SOCKS5 connection established to %s via %s", address, s.proxyAddress)
-	return conn, nil
+	logger.Debug("outbound", "SOCKS5 %s connection established to %s via %s", network, address, s.proxyAddress)
+	return result, nil
 }
 
-// performSOCKS5Handshake выполняет SOCKS5 handshake
-func (s *SOCKS5Outbound) performSOCKS5Handshake(conn net.Conn, targetAddress string) error {
-	// Step 1: Send greeting
-	// [VER=0x05, NMETHODS=1, METHOD=0x00 (no auth)]
-	greeting := []byte{0x05, 0x01, 0x00}
-	if _, err := conn.Write(greeting); err != nil {
-		return fmt.Errorf("failed to send greeting: %w", err)
+// connect выполняет handshake и CONNECT к address
+func (s *SOCKS5Outbound) connect(conn net.Conn, address string) error {
+	if err := s.handshake(conn); err != nil {
+		return err
 	}
 
-	// Read greeting response
-	response := make([]byte, 2)
-	if _, err := conn.Read(response); err != nil {
-		return fmt.Errorf("failed to read greeting response: %w", err)
+	request, err := socks5.BuildRequest(address)
+	if err != nil {
+		return fmt.Errorf("failed to build connection request: %w", err)
 	}
-
-	if response[0] != 0x05 {
-		return fmt.Errorf("unsupported SOCKS version in response: %d", response[0])
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("failed to send connection request: %w", err)
 	}
+	_, err = socks5.ReadResponse(conn)
+	return err
+}
 
-	if response[1] != 0x00 {
-		return fmt.Errorf("authentication required (not supported): method %d", response[1])
+// associate выполняет handshake и UDP ASSOCIATE, возвращает UDP соединение с address через relay прокси
+func (s *SOCKS5Outbound) associate(ctx context.Context, conn net.Conn, address string) (net.Conn, error) {
+	if err := s.handshake(conn); err != nil {
+		return nil, err
 	}
 
-	// Step 2: Send connection request
-	request, err := s.buildConnectionRequest(targetAddress)
+	// Адрес, с которого будут отправляться датаграммы, заранее неизвестен
+	request, err := socks5.BuildCommandRequest(socks5.CmdUDPAssociate, "0.0.0.0:0")
 	if err != nil {
-		return fmt.Errorf("failed to build connection request: %w", err)
+		return nil, err
 	}
-
 	if _, err := conn.Write(request); err != nil {
-		return fmt.Errorf("failed to send connection request: %w", err)
+		return nil, fmt.Errorf("failed to send UDP associate request: %w", err)
+	}
+	relay, err := socks5.ReadResponse(conn)
+	if err != nil {
+		return nil, err
 	}
 
-	// Read connection response
-	responseBuf := make([]byte, 4)
-	if _, err := conn.Read(responseBuf); err != nil {
-		return fmt.Errorf("failed to read connection response: %w", err)
+	// Прокси может вернуть 0.0.0.0: relay доступен по адресу самого прокси
+	relayHost, relayPort, err := net.SplitHostPort(relay)
+	if err != nil {
+		return nil, fmt.Errorf("invalid relay address %q: %w", relay, err)
+	}
+	if ip := net.ParseIP(relayHost); ip != nil && ip.IsUnspecified() {
+		relayHost, _, _ = net.SplitHostPort(s.proxyAddress)
 	}
 
-	if responseBuf[0] != 0x05 {
-		return fmt.Errorf("invalid SOCKS version in response: %d", responseBuf[0])
+	udpConn, err := s.dialer.DialContext(ctx, "udp", net.JoinHostPort(relayHost, relayPort))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SOCKS5 UDP relay: %w", err)
 	}
+	return newSOCKS5UDPConn(udpConn, conn, address), nil
+}
 
-	if responseBuf[1] != 0x00 {
-		return fmt.Errorf("connection failed: error code %d", responseBuf[1])
+// handshake согласует метод аутентификации и выполняет аутентификацию
+func (s *SOCKS5Outbound) handshake(conn net.Conn) error {
+	// [VER=0x05, NMETHODS, METHODS...]
+	greeting := []byte{0x05, 0x01, socks5.MethodNoAuth}
+	if s.username != "" {
+		greeting = []byte{0x05, 0x02, socks5.MethodNoAuth, socks5.MethodUserPass}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return fmt.Errorf("failed to send greeting: %w", err)
 	}
 
-	// Read remaining response based on address type
-	atyp := responseBuf[3]
-	var remainingBytes int
-	switch atyp {
-	case 0x01: // IPv4
-		remainingBytes = 6 // 4 bytes IP + 2 bytes port
-	case 0x03: // Domain name
-		domainLenBuf := make([]byte, 1)
-		if _, err := conn.Read(domainLenBuf); err != nil {
-			return fmt.Errorf("failed to read domain length: %w", err)
+	response := make([]byte, 2)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return fmt.Errorf("failed to read greeting response: %w", err)
+	}
+	if response[0] != 0x05 {
+		return fmt.Errorf("unsupported SOCKS version in response: %d", response[0])
+	}
+
+	switch response[1] {
+	case socks5.MethodNoAuth:
+		return nil
+	case socks5.MethodUserPass:
+		if s.username == "" {
+			return fmt.Errorf("proxy selected username/password authentication, but no credentials are configured")
+		}
+		return s.authenticate(conn)
+	case socks5.MethodNoAcceptable:
+		if s.username == "" {
+			return fmt.Errorf("authentication required (no credentials configured)")
 		}
-		remainingBytes = int(domainLenBuf[0]) + 2 // domain + port
-	case 0x04: // IPv6
-		remainingBytes = 18 // 16 bytes IP + 2 bytes port
+		return fmt.Errorf("proxy rejected offered authentication methods")
 	default:
-		return fmt.Errorf("unsupported address type in response: %d", atyp)
+		return fmt.Errorf("unsupported authentication method: %d", response[1])
 	}
+}
 
-	remaining := make([]byte, remainingBytes)
-	if _, err := conn.Read(remaining); err != nil {
-		return fmt.Errorf("failed to read remaining response: %w", err)
+// authenticate выполняет аутентификацию по логину и паролю (RFC 1929)
+func (s *SOCKS5Outbound) authenticate(conn net.Conn) error {
+	request, err := socks5.BuildUserPassAuth(s.username, s.password)
+	if err != nil {
+		return fmt.Errorf("invalid credentials: %w", err)
+	}
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("failed to send credentials: %w", err)
 	}
 
+	response := make([]byte, 2)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return fmt.Errorf("failed to read authentication response: %w", err)
+	}
+	if response[1] != 0x00 {
+		return fmt.Errorf("authentication failed for user %s: status %d", s.username, response[1])
+	}
 	return nil
 }
 
-// buildConnectionRequest строит SOCKS5 connection request
-func (s *SOCKS5Outbound) buildConnectionRequest(address string) ([]byte, error) {
-	host, port, err := net.SplitHostPort(address)
+// socks5UDPConn UDP соединение с target через relay SOCKS5 прокси
+// У каждой ассоциации свой UDP сокет; Read возвращает только датаграммы от target,
+// даже если relay один на несколько ассоциаций или пересылает датаграммы других отправителей.
+// Ассоциация действует, пока открыто управляющее TCP соединение control
+type socks5UDPConn struct {
+	net.Conn // UDP сокет, подключенный к relay
+	control  net.Conn
+	target   string
+	buf      []byte
+}
+
+// newSOCKS5UDPConn создает UDP соединение и закрывает его, когда прокси закрывает control
+func newSOCKS5UDPConn(udpConn, control net.Conn, target string) *socks5UDPConn {
+	c := &socks5UDPConn{
+		Conn:    udpConn,
+		control: control,
+		target:  target,
+		buf:     make([]byte, maxUDPDatagram),
+	}
+	go func() {
+		io.Copy(io.Discard, control)
+		udpConn.Close()
+	}()
+	return c
+}
+
+// Write отправляет датаграмму с заголовком SOCKS5 UDP
+func (c *socks5UDPConn) Write(b []byte) (int, error) {
+	datagram, err := socks5.BuildUDPDatagram(c.target, b)
 	if err != nil {
-		return nil, fmt.Errorf("invalid address format: %w", err)
+		return 0, err
+	}
+	if _, err := c.Conn.Write(datagram); err != nil {
+		return 0, err
 	}
+	return len(b), nil
+}
 
-	portNum := 0
-	if port != "" {
-		_, err := fmt.Sscanf(port, "%d", &portNum)
+// Read возвращает данные следующей датаграммы без заголовка SOCKS5 UDP
+// Некорректные, фрагментированные и пришедшие не от target датаграммы отбрасываются
+func (c *socks5UDPConn) Read(b []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(c.buf)
 		if err != nil {
-			return nil, fmt.Errorf("invalid port: %w", err)
+			return 0, err
 		}
-	}
-
-	portBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(portBytes, uint16(portNum))
-
-	// Try to parse as IP address
-	ip := net.ParseIP(host)
-	if ip != nil {
-		if ip.To4() != nil {
-			// IPv4
-			request := make([]byte, 10)
-			request[0] = 0x05 // VER
-			request[1] = 0x01 // CMD = CONNECT
-			request[2] = 0x00 // RSV
-			request[3] = 0x01 // ATYP = IPv4
-			copy(request[4:8], ip.To4())
-			copy(request[8:10], portBytes)
-			return request, nil
-		} else {
-			// IPv6
-			request := make([]byte, 22)
-			request[0] = 0x05 // VER
-			request[1] = 0x01 // CMD = CONNECT
-			request[2] = 0x00 // RSV
-			request[3] = 0x04 // ATYP = IPv6
-			copy(request[4:20], ip.To16())
-			copy(request[20:22], portBytes)
-			return request, nil
+		source, payload, err := socks5.ParseUDPDatagram(c.buf[:n])
+		if err != nil {
+			logger.Debug("outbound", "Dropped SOCKS5 UDP datagram from %s: %v", c.RemoteAddr(), err)
+			continue
+		}
+		if !c.fromTarget(source) {
+			logger.Debug("outbound", "Dropped SOCKS5 UDP datagram from %s: association is for %s", source, c.target)
+			continue
 		}
+		return copy(b, payload), nil
 	}
+}
 
-	// Domain name
-	hostBytes := []byte(host)
-	if len(hostBytes) > 255 {
-		return nil, fmt.Errorf("domain name too long: %d bytes", len(hostBytes))
+// fromTarget проверяет, что источник датаграммы - target
+// Домен target разрешает прокси, поэтому для него сравнивается только порт
+func (c *socks5UDPConn) fromTarget(source string) bool {
+	sourceHost, sourcePort, err := net.SplitHostPort(source)
+	if err != nil {
+		return false
 	}
+	targetHost, targetPort, err := net.SplitHostPort(c.target)
+	if err != nil || sourcePort != targetPort {
+		return false
+	}
+	targetIP, err := netip.ParseAddr(targetHost)
+	if err != nil {
+		return true
+	}
+	sourceIP, err := netip.ParseAddr(sourceHost)
+	return err == nil && sourceIP.Unmap() == targetIP.Unmap()
+}
 
-	request := make([]byte, 4+1+len(hostBytes)+2)
-	request[0] = 0x05 // VER
-	request[1] = 0x01 // CMD = CONNECT
-	request[2] = 0x00 // RSV
-	request[3] = 0x03 // ATYP = DOMAIN
-	request[4] = byte(len(hostBytes))
-	copy(request[5:5+len(hostBytes)], hostBytes)
-	copy(request[5+len(hostBytes):], portBytes)
-
-	return request, nil
+// Close завершает ассоциацию и закрывает UDP сокет
+func (c *socks5UDPConn) Close() error {
+	c.control.Close()
+	return c.Conn.Close()
 }
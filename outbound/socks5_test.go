@@ -1,9 +1,16 @@
 package outbound
 
 import (
-	"encoding/binary"
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"net"
 	"testing"
+	"time"
+
+	"example.com/me/myproxy/internal/connerr"
+	"example.com/me/myproxy/internal/protocol/socks5"
 )
 
 func TestSOCKS5Outbound_Dial(t *testing.T) {
@@ -61,10 +68,10 @@ func TestSOCKS5Outbound_Dial(t *testing.T) {
 		}
 	}()
 
-	outbound := NewSOCKS5Outbound(proxyAddr)
+	outbound := NewSOCKS5Outbound(proxyAddr, 0, 0)
 
 	t.Run("connect via SOCKS5 proxy", func(t *testing.T) {
-		conn, err := outbound.Dial("tcp", "example.com:80")
+		conn, err := outbound.DialContext(context.Background(), "tcp", "example.com:80")
 		if err != nil {
 			t.Fatalf("Failed to dial via SOCKS5: %v", err)
 		}
@@ -76,79 +83,296 @@ func TestSOCKS5Outbound_Dial(t *testing.T) {
 	})
 
 	t.Run("unsupported network", func(t *testing.T) {
-		_, err := outbound.Dial("udp", "example.com:80")
+		_, err := outbound.DialContext(context.Background(), "unix", "example.com:80")
 		if err == nil {
 			t.Error("Expected error for unsupported network")
 		}
 	})
 }
 
-func TestBuildConnectionRequest(t *testing.T) {
-	outbound := NewSOCKS5Outbound("127.0.0.1:1080")
+func TestSOCKS5Outbound_HandshakeDeadline(t *testing.T) {
+	// Proxy accepts connections but never answers the greeting
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
 
-	t.Run("IPv4 address", func(t *testing.T) {
-		request, err := outbound.buildConnectionRequest("192.168.1.1:80")
-		if err != nil {
-			t.Fatalf("Failed to build request: %v", err)
+	t.Run("handshake timeout", func(t *testing.T) {
+		outbound := NewSOCKS5Outbound(listener.Addr().String(), 0, 50*time.Millisecond)
+		_, err := outbound.DialContext(context.Background(), "tcp", "example.com:80")
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("Expected timeout error, got %v", err)
 		}
+	})
 
-		if len(request) != 10 {
-			t.Errorf("Invalid request length: expected 10, got %d", len(request))
+	t.Run("context canceled", func(t *testing.T) {
+		outbound := NewSOCKS5Outbound(listener.Addr().String(), 0, time.Minute)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, err := outbound.DialContext(ctx, "tcp", "example.com:80")
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Dial was not interrupted by cancel: %v", elapsed)
 		}
+	})
+}
+
+func TestNewSOCKS5Outbound(t *testing.T) {
+	outbound := NewSOCKS5Outbound("127.0.0.1:1080", 0, 0)
+
+	if outbound == nil {
+		t.Error("NewSOCKS5Outbound returned nil")
+	}
+
+	if outbound.proxyAddress != "127.0.0.1:1080" {
+		t.Errorf("Invalid proxy address: expected 127.0.0.1:1080, got %s", outbound.proxyAddress)
+	}
+
+	if outbound.dialer == nil {
+		t.Error("Dialer is not initialized")
+	}
+}
+
+
+// fakeSOCKS5 upstream SOCKS5 proxy for tests: checks credentials (if set),
+// answers CONNECT with reply and serves UDP ASSOCIATE with an echo relay
+type fakeSOCKS5 struct {
+	username string
+	password string
+	reply    byte
+	listener net.Listener
+	relay    net.PacketConn
+}
+
+func startFakeSOCKS5(t *testing.T, username, password string, reply byte) *fakeSOCKS5 {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create relay: %v", err)
+	}
+	f := &fakeSOCKS5{username: username, password: password, reply: reply, listener: listener, relay: relay}
+	t.Cleanup(func() {
+		listener.Close()
+		relay.Close()
+	})
 
-		if request[0] != 0x05 || request[1] != 0x01 || request[3] != 0x01 {
-			t.Error("Invalid request format")
+	go f.serveRelay()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
 		}
+	}()
+	return f
+}
 
-		port := binary.BigEndian.Uint16(request[8:10])
-		if port != 80 {
-			t.Errorf("Invalid port: expected 80, got %d", port)
+func (f *fakeSOCKS5) serve(c net.Conn) {
+	defer c.Close()
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return
+	}
+	method := byte(socks5.MethodNoAuth)
+	if f.username != "" {
+		method = socks5.MethodNoAcceptable
+		if bytes.IndexByte(methods, socks5.MethodUserPass) >= 0 {
+			method = socks5.MethodUserPass
 		}
-	})
+	}
+	c.Write([]byte{0x05, method})
+
+	switch method {
+	case socks5.MethodNoAcceptable:
+		return
+	case socks5.MethodUserPass:
+		username, password := readField(c, 2), readField(c, 1)
+		if username != f.username || password != f.password {
+			c.Write([]byte{0x01, 0x01})
+			return
+		}
+		c.Write([]byte{0x01, 0x00})
+	}
+
+	request := make([]byte, 3)
+	if _, err := io.ReadFull(c, request); err != nil {
+		return
+	}
+	if _, err := socks5.ParseAddress(c); err != nil {
+		return
+	}
+
+	if request[1] == socks5.CmdUDPAssociate {
+		// Unspecified BND.ADDR: the relay is reachable at the proxy address
+		port := f.relay.LocalAddr().(*net.UDPAddr).Port
+		c.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, byte(port >> 8), byte(port)})
+		io.Copy(io.Discard, c)
+		return
+	}
+	c.Write(socks5.BuildResponse(f.reply))
+	if f.reply == socks5.ReplySuccess {
+		io.Copy(c, c)
+	}
+}
 
-	t.Run("domain name", func(t *testing.T) {
-		request, err := outbound.buildConnectionRequest("example.com:443")
+// readField reads a length-prefixed RFC 1929 field after skip bytes
+func readField(c net.Conn, skip int) string {
+	buf := make([]byte, skip)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return ""
+	}
+	field := make([]byte, buf[skip-1])
+	io.ReadFull(c, field)
+	return string(field)
+}
+
+// serveRelay echoes datagram payloads back with the destination as the source address
+func (f *fakeSOCKS5) serveRelay() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := f.relay.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		target, payload, err := socks5.ParseUDPDatagram(buf[:n])
 		if err != nil {
-			t.Fatalf("Failed to build request: %v", err)
+			continue
 		}
+		reply, _ := socks5.BuildUDPDatagram(target, payload)
+		f.relay.WriteTo(reply, addr)
+	}
+}
+
+func TestSOCKS5Outbound_Auth(t *testing.T) {
+	upstream := startFakeSOCKS5(t, "user", "secret", socks5.ReplySuccess)
 
-		if request[0] != 0x05 || request[1] != 0x01 || request[3] != 0x03 {
-			t.Error("Invalid request format")
+	t.Run("valid credentials", func(t *testing.T) {
+		outbound := NewSOCKS5Outbound(upstream.listener.Addr().String(), 0, 0)
+		outbound.SetAuth("user", "secret")
+		conn, err := outbound.DialContext(context.Background(), "tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("Failed to dial with credentials: %v", err)
 		}
+		defer conn.Close()
 
-		domainLen := int(request[4])
-		domain := string(request[5 : 5+domainLen])
-		if domain != "example.com" {
-			t.Errorf("Invalid domain: expected example.com, got %s", domain)
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("Invalid echo: %q, %v", buf, err)
 		}
+	})
 
-		port := binary.BigEndian.Uint16(request[5+domainLen : 5+domainLen+2])
-		if port != 443 {
-			t.Errorf("Invalid port: expected 443, got %d", port)
+	t.Run("wrong password", func(t *testing.T) {
+		outbound := NewSOCKS5Outbound(upstream.listener.Addr().String(), 0, 0)
+		outbound.SetAuth("user", "wrong")
+		if _, err := outbound.DialContext(context.Background(), "tcp", "example.com:80"); err == nil {
+			t.Error("Expected authentication error")
 		}
 	})
 
-	t.Run("invalid address", func(t *testing.T) {
-		_, err := outbound.buildConnectionRequest("invalid")
-		if err == nil {
-			t.Error("Expected error for invalid address")
+	t.Run("no credentials", func(t *testing.T) {
+		outbound := NewSOCKS5Outbound(upstream.listener.Addr().String(), 0, 0)
+		if _, err := outbound.DialContext(context.Background(), "tcp", "example.com:80"); err == nil {
+			t.Error("Expected error without credentials")
 		}
 	})
 }
 
-func TestNewSOCKS5Outbound(t *testing.T) {
-	outbound := NewSOCKS5Outbound("127.0.0.1:1080")
+func TestSOCKS5Outbound_ReplyCode(t *testing.T) {
+	upstream := startFakeSOCKS5(t, "", "", socks5.ReplyConnectionRefused)
 
-	if outbound == nil {
-		t.Error("NewSOCKS5Outbound returned nil")
+	outbound := NewSOCKS5Outbound(upstream.listener.Addr().String(), 0, 0)
+	_, err := outbound.DialContext(context.Background(), "tcp", "example.com:80")
+	if !errors.Is(err, connerr.ErrConnectionRefused) {
+		t.Errorf("Expected connection refused, got %v", err)
 	}
+}
 
-	if outbound.proxyAddress != "127.0.0.1:1080" {
-		t.Errorf("Invalid proxy address: expected 127.0.0.1:1080, got %s", outbound.proxyAddress)
+func TestSOCKS5Outbound_UDPAssociate(t *testing.T) {
+	upstream := startFakeSOCKS5(t, "user", "secret", socks5.ReplySuccess)
+
+	outbound := NewSOCKS5Outbound(upstream.listener.Addr().String(), 0, 0)
+	outbound.SetAuth("user", "secret")
+	conn, err := outbound.DialContext(context.Background(), "udp", "10.0.0.1:53")
+	if err != nil {
+		t.Fatalf("Failed to associate: %v", err)
 	}
+	defer conn.Close()
 
-	if outbound.dialer == nil {
-		t.Error("Dialer is not initialized")
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("query")); err != nil {
+		t.Fatalf("Failed to send datagram: %v", err)
+	}
+	buf := make([]byte, 100)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "query" {
+		t.Errorf("Invalid datagram: %q, %v", buf[:n], err)
 	}
 }
 
+func TestSOCKS5Outbound_UDPAssociations(t *testing.T) {
+	upstream := startFakeSOCKS5(t, "", "", socks5.ReplySuccess)
+	outbound := NewSOCKS5Outbound(upstream.listener.Addr().String(), 0, 0)
+
+	// Two associations share one relay of the upstream proxy
+	first, err := outbound.DialContext(context.Background(), "udp", "10.0.0.1:53")
+	if err != nil {
+		t.Fatalf("Failed to associate: %v", err)
+	}
+	defer first.Close()
+	second, err := outbound.DialContext(context.Background(), "udp", "example.com:53")
+	if err != nil {
+		t.Fatalf("Failed to associate: %v", err)
+	}
+	defer second.Close()
+	if first.LocalAddr().String() == second.LocalAddr().String() {
+		t.Fatalf("Associations share socket %s", first.LocalAddr())
+	}
+
+	// Datagrams from other sources forwarded by the relay are dropped
+	for _, source := range []string{"10.0.0.2:53", "10.0.0.1:5353"} {
+		stray, _ := socks5.BuildUDPDatagram(source, []byte("stray"))
+		upstream.relay.WriteTo(stray, first.LocalAddr())
+	}
+	stray, _ := socks5.BuildUDPDatagram("10.0.0.1:5353", []byte("stray"))
+	upstream.relay.WriteTo(stray, second.LocalAddr())
+
+	for _, conn := range []net.Conn{first, second} {
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Write([]byte("query")); err != nil {
+			t.Fatalf("Failed to send datagram: %v", err)
+		}
+		buf := make([]byte, 100)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "query" {
+			t.Errorf("Invalid datagram: %q, %v", buf[:n], err)
+		}
+	}
+}
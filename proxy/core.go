@@ -1,37 +1,86 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
+	"slices"
+	"time"
 
 	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/internal/connerr"
 	"example.com/me/myproxy/internal/logger"
 	"example.com/me/myproxy/internal/plugin"
 	"example.com/me/myproxy/internal/router"
 	"example.com/me/myproxy/outbound"
 )
 
+// Replier реализуется inbound соединениями, которые откладывают ответ клиенту
+// (например, SOCKS5 reply) до установки outbound соединения
+// Если соединение не установлено, HandleConnection отвечает ошибкой до закрытия соединения
+type Replier interface {
+	// Reply отправляет клиенту результат установки соединения (err == nil - успех)
+	Reply(err error) error
+}
+
+// OutboundFactory создает outbound по конфигурации, выбранной router
+type OutboundFactory func(cfg *config.OutboundConfig) (outbound.Outbound, error)
+
+// RetryPolicy ограничивает повторные попытки подключения через другой outbound
+// (другое устройство пула), если подключение через выбранный не удалось
+type RetryPolicy struct {
+	Attempts int           // Всего попыток, включая первую (0 или 1 - без повторов)
+	Budget   time.Duration // Общее время на попытки (0 - без ограничения)
+}
+
+// ConnOptions компоненты и параметры обработки соединений inbound
+// Создается один раз для конфигурации и используется всеми соединениями, принятыми с ней
+type ConnOptions struct {
+	// Outbound конфигурации: используется, если router не выбрал другой
+	Outbound       outbound.Outbound
+	OutboundID     string
+	OutboundConfig *config.OutboundConfig
+
+	Router  router.Router
+	Plugins *plugin.Manager
+	Pool    *outbound.Pool  // Outbound устройств, выбранных router по ID (nil - без пула)
+	Factory OutboundFactory // Создает outbound для конфигурации, выбранной router (nil - недоступно)
+
+	Retry    RetryPolicy
+	Sniffing SniffPolicy
+	// CheckResolved проверяет адрес, в который outbound разрешил домен (nil - без проверки)
+	CheckResolved func(ip net.IP, port int) error
+}
+
 // HandleConnection обрабатывает соединение от inbound и пересылает через outbound
-func HandleConnection(
-	inboundConn net.Conn,
-	currentOutbound outbound.Outbound,
-	currentOutboundID string,
-	currentOutboundConfig *config.OutboundConfig,
-	targetAddress string,
-	inboundID string,
-	rtr router.Router,
-	pluginManager *plugin.Manager,
-	outboundPool *outbound.Pool,
-) error {
-	// Создаем контекст соединения
-	ctx := plugin.NewConnectionContext(inboundConn.RemoteAddr().String(), targetAddress)
-	ctx.InboundID = inboundID
-	ctx.OutboundID = currentOutboundID
+// Если подключение не удалось, router выбирает outbound повторно, исключая уже
+// не подключившиеся (см. ConnectionContext.FailedOutbounds), пока это позволяет opts.Retry.
+// Повторы выполняются до ответа клиенту, история попыток сохраняется в ConnectionContext.Metadata.
+// Отмена parent (отключение клиента, остановка server) прерывает подключение без повторов.
+// Если адрес назначения - IP, sniffing определяет домен по первым байтам клиента (см. SniffPolicy).
+// Соединение с локальным адресом UDP (например, UDP сессия tproxy inbound) пересылается через outbound по UDP.
+// Адрес, в который outbound разрешил домен (например, устройство), проверяется opts.CheckResolved до ответа клиенту.
+// Outbound для конфигурации, выбранной router, создает opts.Factory и закрывает после соединения.
+// connCtx - контекст соединения от inbound с InboundID и UserID (nil - создается новый)
+func HandleConnection(parent context.Context, inboundConn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext, opts *ConnOptions) (err error) {
+	pluginManager := opts.Plugins
+
+	// Контекст соединения, созданный inbound (InboundID, UserID), или новый
+	ctx := connCtx
+	if ctx == nil {
+		ctx = plugin.NewConnectionContext(inboundConn.RemoteAddr().String(), targetAddress)
+	}
+	ctx.OutboundID = opts.OutboundID
 
 	defer func() {
 		logger.Debug("proxy", "Outbound connection to %s closed", targetAddress)
 		pluginManager.OnConnectionClosed(ctx)
+		if replier, ok := inboundConn.(Replier); ok && err != nil {
+			replier.Reply(err)
+		}
 		inboundConn.Close()
 	}()
 
@@ -41,96 +90,180 @@ func HandleConnection(
 		return err
 	}
 
+	// Датаграммы пересылаются по UDP, остальное - по TCP
+	network := "tcp"
+	if _, ok := inboundConn.LocalAddr().(*net.UDPAddr); ok {
+		network = "udp"
+	}
+
+	// Домен назначения по первым байтам клиента для router и плагинов (только TCP:
+	// датаграммы нельзя склеивать)
+	clientConn := inboundConn
+	if opts.Sniffing.Enabled && network == "tcp" {
+		clientConn, targetAddress, err = applySniffing(parent, inboundConn, targetAddress, ctx, opts.Sniffing)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Budget ограничивает все попытки вместе: незавершенная попытка прерывается
+	dialCtx := parent
+	if opts.Retry.Budget > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(parent, opts.Retry.Budget)
+		defer cancel()
+	}
+
+	var outboundConn net.Conn
+	var dialErr error
+	for {
+		ob, outboundID, created, err := selectOutbound(ctx, targetAddress, opts)
+		if err != nil {
+			// Клиент получает ответ по ошибке последней попытки
+			if dialErr != nil && errors.Is(err, router.ErrNoDeviceAvailable) {
+				logger.Debug("proxy", "No other device to retry connection to %s", targetAddress)
+				return fmt.Errorf("%w (%w)", dialErr, err)
+			}
+			return err
+		}
+		if created {
+			defer closeOutbound(ob)
+		}
+		if dialErr != nil && slices.Contains(ctx.FailedOutbounds(), outboundID) {
+			logger.Debug("proxy", "No other outbound to retry connection to %s", targetAddress)
+			return dialErr
+		}
+		ctx.OutboundID = outboundID
+
+		// Вызываем hook OnOutboundConnection
+		if err := pluginManager.OnOutboundConnection(ctx); err != nil {
+			logger.Debug("proxy", "OnOutboundConnection hook error: %v", err)
+			return err
+		}
+
+		// Establish connection to target address through outbound
+		logger.Debug("proxy", "Establishing outbound %s connection to %s", network, targetAddress)
+		start := time.Now()
+		outboundConn, dialErr = ob.DialContext(dialCtx, network, targetAddress)
+		ctx.AddDialAttempt(outboundID, dialErr, time.Since(start))
+		if dialErr == nil {
+			break
+		}
+
+		attempts := len(ctx.DialAttempts())
+		if !retryable(dialErr) || attempts >= opts.Retry.Attempts || dialCtx.Err() != nil {
+			logger.Debug("proxy", "Failed to connect to %s: %v", targetAddress, dialErr)
+			return dialErr
+		}
+		logger.Debug("proxy", "Failed to connect to %s through %s (attempt %d): %v, retrying", targetAddress, outboundID, attempts, dialErr)
+	}
+	defer outboundConn.Close()
+
+	// Адрес назначения и публичный IP устройства для плагинов
+	if egressConn, ok := outboundConn.(outbound.EgressConn); ok {
+		ctx.ResolvedAddr = egressConn.ResolvedAddr()
+		ctx.EgressIP = egressConn.EgressIP()
+		logger.Debug("proxy", "Connection to %s through %s: resolved %s, egress %s", targetAddress, ctx.OutboundID, ctx.ResolvedAddr, ctx.EgressIP)
+		if err := checkResolvedAddr(ctx.ResolvedAddr, opts.CheckResolved); err != nil {
+			logger.Info("proxy", "Connection to %s through %s rejected: %v", targetAddress, ctx.OutboundID, err)
+			return err
+		}
+	}
+
+	// Сообщаем клиенту об успешном подключении (например, SOCKS5 reply)
+	if replier, ok := inboundConn.(Replier); ok {
+		if err := replier.Reply(nil); err != nil {
+			return err
+		}
+	}
+
+	logger.Debug("proxy", "Outbound connection to %s established, forwarding data", targetAddress)
+
+	// Forward data between connections with traffic counting
+	err = CopyDataWithCounting(outboundConn, clientConn, ctx, pluginManager)
+	if err != nil {
+		logger.Debug("proxy", "Outbound connection to %s closed with error: %v", targetAddress, err)
+	} else {
+		logger.Debug("proxy", "Outbound connection to %s closed normally", targetAddress)
+	}
+	return err
+}
+
+// selectOutbound выбирает outbound через router
+// created - outbound создан opts.Factory для этого соединения и должен быть закрыт
+func selectOutbound(ctx *plugin.ConnectionContext, targetAddress string, opts *ConnOptions) (ob outbound.Outbound, outboundID string, created bool, err error) {
+	currentOutbound, currentOutboundID := opts.Outbound, opts.OutboundID
+
 	// Вызываем Router для выбора outbound
 	logger.Debug("proxy", "Selecting outbound for target %s", targetAddress)
-	outboundID, outboundConfig, err := rtr.SelectOutbound(ctx, targetAddress, currentOutboundID, currentOutboundConfig)
+	outboundID, outboundConfig, err := opts.Router.SelectOutbound(ctx, targetAddress, currentOutboundID, opts.OutboundConfig)
 	if err != nil {
 		logger.Debug("proxy", "Router SelectOutbound error: %v", err)
-		return err
+		return nil, "", false, err
 	}
 
-	// Определяем какой outbound использовать
-	var ob outbound.Outbound
-	var finalOutboundID string
-
 	if outboundID != "" {
 		// Использовать существующий outbound из пула
-		if outboundPool != nil {
-			poolOutbound, err := outboundPool.GetOutbound(outboundID)
+		if opts.Pool != nil {
+			poolOutbound, err := opts.Pool.GetOutbound(outboundID)
 			if err != nil {
 				logger.Debug("proxy", "Failed to get outbound %s from pool: %v, using current", outboundID, err)
-				ob = currentOutbound
-				finalOutboundID = currentOutboundID
-			} else {
-				logger.Debug("proxy", "Router selected existing outbound %s from pool", outboundID)
-				ob = poolOutbound
-				finalOutboundID = outboundID
+				return currentOutbound, currentOutboundID, false, nil
 			}
-		} else {
-			logger.Debug("proxy", "Router selected existing outbound %s (pool not available, using current)", outboundID)
-			ob = currentOutbound
-			finalOutboundID = outboundID
+			logger.Debug("proxy", "Router selected existing outbound %s from pool", outboundID)
+			return poolOutbound, outboundID, false, nil
 		}
-	} else if outboundConfig != nil {
+		logger.Debug("proxy", "Router selected existing outbound %s (pool not available, using current)", outboundID)
+		return currentOutbound, outboundID, false, nil
+	}
+
+	if outboundConfig != nil {
 		// Создать новый outbound из конфигурации
 		logger.Debug("proxy", "Router selected new outbound: type=%s", outboundConfig.Type)
-		ob, err = createOutbound(outboundConfig)
+		if opts.Factory == nil {
+			return nil, "", false, fmt.Errorf("cannot create %s outbound selected by router: no outbound factory", outboundConfig.Type)
+		}
+		ob, err := opts.Factory(outboundConfig)
 		if err != nil {
 			logger.Debug("proxy", "Failed to create outbound: %v", err)
-			return err
+			return nil, "", false, err
 		}
-		finalOutboundID = outboundConfig.ID
-	} else {
-		// Использовать текущий outbound
-		logger.Debug("proxy", "Router selected current outbound")
-		ob = currentOutbound
-		finalOutboundID = currentOutboundID
+		return ob, outboundConfig.ID, true, nil
 	}
 
-	ctx.OutboundID = finalOutboundID
+	// Использовать текущий outbound
+	logger.Debug("proxy", "Router selected current outbound")
+	return currentOutbound, currentOutboundID, false, nil
+}
 
-	// Вызываем hook OnOutboundConnection
-	if err := pluginManager.OnOutboundConnection(ctx); err != nil {
-		logger.Debug("proxy", "OnOutboundConnection hook error: %v", err)
-		return err
+// checkResolvedAddr проверяет адрес ip:port, сообщенный outbound (пустой адрес или check nil - без проверки)
+func checkResolvedAddr(resolvedAddr string, check func(ip net.IP, port int) error) error {
+	if resolvedAddr == "" || check == nil {
+		return nil
 	}
-
-	// Establish connection to target address through outbound
-	logger.Debug("proxy", "Establishing outbound connection to %s", targetAddress)
-	outboundConn, err := ob.Dial("tcp", targetAddress)
+	addrPort, err := netip.ParseAddrPort(resolvedAddr)
 	if err != nil {
-		logger.Debug("proxy", "Failed to connect to %s: %v", targetAddress, err)
-		return err
+		return fmt.Errorf("%w: invalid resolved address %q", connerr.ErrNotAllowed, resolvedAddr)
 	}
-	defer outboundConn.Close()
-
-	logger.Debug("proxy", "Outbound connection to %s established, forwarding data", targetAddress)
-
-	// Forward data between connections with traffic counting
-	err = CopyDataWithCounting(outboundConn, inboundConn, ctx, pluginManager)
-	if err != nil {
-		logger.Debug("proxy", "Outbound connection to %s closed with error: %v", targetAddress, err)
-	} else {
-		logger.Debug("proxy", "Outbound connection to %s closed normally", targetAddress)
+	if err := check(addrPort.Addr().Unmap().AsSlice(), int(addrPort.Port())); err != nil {
+		return fmt.Errorf("resolved address %s: %w", resolvedAddr, err)
 	}
-	return err
+	return nil
 }
 
-// createOutbound создает outbound из конфигурации
-func createOutbound(cfg *config.OutboundConfig) (outbound.Outbound, error) {
-	switch cfg.Type {
-	case "direct":
-		return outbound.NewDirectOutbound(), nil
-	case "socks5":
-		if cfg.ProxyAddress == "" {
-			return nil, fmt.Errorf("proxy_address is required for SOCKS5 outbound")
-		}
-		return outbound.NewSOCKS5Outbound(cfg.ProxyAddress), nil
-	default:
-		return nil, fmt.Errorf("unsupported outbound type: %s", cfg.Type)
+// closeOutbound освобождает ресурсы outbound (например, фоновые проверки группы)
+func closeOutbound(ob outbound.Outbound) {
+	if closer, ok := ob.(io.Closer); ok {
+		closer.Close()
 	}
 }
 
+// retryable проверяет, может ли подключение через другой outbound завершиться иначе:
+// запрет политикой и отказ адреса назначения от outbound не зависят
+func retryable(err error) bool {
+	return !errors.Is(err, connerr.ErrNotAllowed) && !errors.Is(err, connerr.ErrConnectionRefused)
+}
+
 // CopyData пересылает данные между двумя соединениями
 func CopyData(dst net.Conn, src net.Conn) error {
 	done := make(chan error, 1)
@@ -155,12 +288,16 @@ func CopyData(dst net.Conn, src net.Conn) error {
 }
 
 // CopyDataWithCounting пересылает данные между двумя соединениями с подсчетом трафика
+// Когда одна из сторон завершилась (в том числе при принудительном закрытии соединений),
+// задержки limiter плагинов в другой стороне прерываются
 func CopyDataWithCounting(dst net.Conn, src net.Conn, ctx *plugin.ConnectionContext, pluginManager *plugin.Manager) error {
 	done := make(chan error, 1)
+	transferCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Создаем обертки для подсчета байтов
-	sentCounter := &countingWriter{writer: dst, ctx: ctx, pluginManager: pluginManager, direction: "sent"}
-	receivedCounter := &countingWriter{writer: src, ctx: ctx, pluginManager: pluginManager, direction: "received"}
+	sentCounter := &countingWriter{writer: dst, ctx: ctx, transferCtx: transferCtx, pluginManager: pluginManager, direction: "sent"}
+	receivedCounter := &countingWriter{writer: src, ctx: ctx, transferCtx: transferCtx, pluginManager: pluginManager, direction: "received"}
 
 	go func() {
 		_, err := io.Copy(sentCounter, src)
@@ -174,6 +311,7 @@ func CopyDataWithCounting(dst net.Conn, src net.Conn, ctx *plugin.ConnectionCont
 
 	// Ждем завершения одной из сторон
 	err := <-done
+	cancel()
 	dst.Close()
 	src.Close()
 	<-done
@@ -185,12 +323,18 @@ func CopyDataWithCounting(dst net.Conn, src net.Conn, ctx *plugin.ConnectionCont
 type countingWriter struct {
 	writer        net.Conn
 	ctx           *plugin.ConnectionContext
+	transferCtx   context.Context // Отменяется по завершении пересылки
 	pluginManager *plugin.Manager
 	direction     string
 	bytesWritten  int64
 }
 
 func (c *countingWriter) Write(p []byte) (int, error) {
+	// Limiter плагины могут задержать запись или разорвать соединение
+	if err := c.pluginManager.OnBeforeDataTransfer(c.transferCtx, c.ctx, c.direction, int64(len(p))); err != nil {
+		return 0, err
+	}
+
 	n, err := c.writer.Write(p)
 	if n > 0 {
 		c.bytesWritten += int64(n)
@@ -1,12 +1,20 @@
 package proxy
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
 	"example.com/me/myproxy/config"
+	"example.com/me/myproxy/inbound"
+	"example.com/me/myproxy/internal/connerr"
 	"example.com/me/myproxy/internal/plugin"
+	"example.com/me/myproxy/internal/protocol/socks5"
 	"example.com/me/myproxy/internal/router"
 	"example.com/me/myproxy/outbound"
 )
@@ -151,7 +159,7 @@ func TestHandleConnection(t *testing.T) {
 	clientConn, proxyConn := net.Pipe()
 
 	// Создаем mock outbound
-	mockOutbound := outbound.NewDirectOutbound()
+	mockOutbound := outbound.NewDirectOutbound(0)
 	
 	// Создаем router
 	rtr := router.NewStaticRouter()
@@ -166,7 +174,9 @@ func TestHandleConnection(t *testing.T) {
 	// Запускаем HandleConnection в отдельной горутине
 	done := make(chan error, 1)
 	go func() {
-		done <- HandleConnection(proxyConn, mockOutbound, "outbound-1", currentOutboundConfig, serverAddr, "inbound-1", rtr, pluginManager)
+		done <- HandleConnection(context.Background(), proxyConn, serverAddr, nil, &ConnOptions{
+			Outbound: mockOutbound, OutboundID: "outbound-1", OutboundConfig: currentOutboundConfig, Router: rtr, Plugins: pluginManager,
+		})
 	}()
 
 	// Отправляем данные от клиента
@@ -200,3 +210,524 @@ func TestHandleConnection(t *testing.T) {
 		t.Error("HandleConnection не завершился в течение 2 секунд")
 	}
 }
+
+// retryRouter выбирает outbound badConfig, пока он не исключен как не подключившийся, затем текущий
+// (с noFallback - ошибка router.ErrNoDeviceAvailable, как у пула устройств)
+type retryRouter struct {
+	badConfig  *config.OutboundConfig
+	noFallback bool
+	ctx        *plugin.ConnectionContext
+}
+
+func (r *retryRouter) SelectOutbound(ctx *plugin.ConnectionContext, targetAddress string, currentOutboundID string, currentOutboundConfig *config.OutboundConfig) (string, *config.OutboundConfig, error) {
+	r.ctx = ctx
+	for _, failed := range ctx.FailedOutbounds() {
+		if failed == r.badConfig.ID {
+			if r.noFallback {
+				return "", nil, router.ErrNoDeviceAvailable
+			}
+			return "", nil, nil
+		}
+	}
+	return "", r.badConfig, nil
+}
+
+// socks5Factory создает SOCKS5 outbound по конфигурации, выбранной router, и считает закрытые outbound
+type socks5Factory struct {
+	mu      sync.Mutex
+	created int
+	closed  int
+}
+
+func (f *socks5Factory) newOutbound(cfg *config.OutboundConfig) (outbound.Outbound, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created++
+	return &closingOutbound{Outbound: outbound.NewSOCKS5Outbound(cfg.ProxyAddress, 0, 0), factory: f}, nil
+}
+
+func (f *socks5Factory) counts() (created, closed int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.created, f.closed
+}
+
+type closingOutbound struct {
+	outbound.Outbound
+	factory *socks5Factory
+}
+
+func (o *closingOutbound) Close() error {
+	o.factory.mu.Lock()
+	defer o.factory.mu.Unlock()
+	o.factory.closed++
+	return nil
+}
+
+func TestHandleConnection_Retry(t *testing.T) {
+	echoListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания сервера: %v", err)
+	}
+	defer echoListener.Close()
+	go func() {
+		for {
+			conn, err := echoListener.Accept()
+			if err != nil {
+				return
+			}
+			go CopyData(conn, conn)
+		}
+	}()
+
+	// SOCKS5 прокси на закрытом порту: подключение через него не удается
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedAddr := closed.Addr().String()
+	closed.Close()
+	badConfig := &config.OutboundConfig{Type: "socks5", ID: "bad", ProxyAddress: closedAddr}
+
+	factory := &socks5Factory{}
+	run := func(retry RetryPolicy, noFallback bool) (*retryRouter, error) {
+		rtr := &retryRouter{badConfig: badConfig, noFallback: noFallback}
+		clientConn, proxyConn := net.Pipe()
+		defer clientConn.Close()
+		done := make(chan error, 1)
+		go func() {
+			done <- HandleConnection(context.Background(), proxyConn, echoListener.Addr().String(), nil, &ConnOptions{
+				Outbound: outbound.NewDirectOutbound(0), OutboundID: "direct", OutboundConfig: &config.OutboundConfig{Type: "direct"},
+				Router: rtr, Plugins: plugin.NewManager(), Factory: factory.newOutbound, Retry: retry,
+			})
+		}()
+
+		clientConn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := clientConn.Write([]byte("ping")); err != nil {
+			return rtr, <-done
+		}
+		buf := make([]byte, 4)
+		if n, err := clientConn.Read(buf); err != nil || string(buf[:n]) != "ping" {
+			t.Errorf("Неверный ответ: %q, %v", buf[:n], err)
+		}
+		clientConn.Close()
+		return rtr, <-done
+	}
+
+	// Повторная попытка через другой outbound после неудачного подключения
+	rtr, _ := run(RetryPolicy{Attempts: 3, Budget: time.Second}, false)
+	attempts := rtr.ctx.DialAttempts()
+	if len(attempts) != 2 || attempts[0].OutboundID != "bad" || attempts[0].Error == "" ||
+		attempts[1].OutboundID != "direct" || attempts[1].Error != "" {
+		t.Errorf("Неверная история попыток: %+v", attempts)
+	}
+	// Outbound, созданный по конфигурации router, закрывается после соединения
+	if created, closed := factory.counts(); created != 1 || closed != 1 {
+		t.Errorf("Создано %d outbound, закрыто %d", created, closed)
+	}
+
+	// Без повторов соединение завершается ошибкой первой попытки
+	rtr, err = run(RetryPolicy{}, false)
+	if err == nil || len(rtr.ctx.DialAttempts()) != 1 {
+		t.Errorf("Ожидалась одна неудачная попытка: %v, %+v", err, rtr.ctx.DialAttempts())
+	}
+
+	// Без других устройств соединение не уходит через текущий outbound
+	rtr, err = run(RetryPolicy{Attempts: 3, Budget: time.Second}, true)
+	if !errors.Is(err, router.ErrNoDeviceAvailable) || len(rtr.ctx.DialAttempts()) != 1 {
+		t.Errorf("Ожидалась ошибка ErrNoDeviceAvailable после одной попытки: %v, %+v", err, rtr.ctx.DialAttempts())
+	}
+
+	// Без фабрики outbound конфигурация router не используется
+	rtr = &retryRouter{badConfig: badConfig}
+	clientConn, proxyConn := net.Pipe()
+	defer clientConn.Close()
+	err = HandleConnection(context.Background(), proxyConn, echoListener.Addr().String(), nil, &ConnOptions{
+		Outbound: outbound.NewDirectOutbound(0), OutboundID: "direct", OutboundConfig: &config.OutboundConfig{Type: "direct"},
+		Router: rtr, Plugins: plugin.NewManager(),
+	})
+	if err == nil || len(rtr.ctx.DialAttempts()) != 0 {
+		t.Errorf("Ожидалась ошибка без фабрики outbound: %v, %+v", err, rtr.ctx.DialAttempts())
+	}
+}
+
+func TestHandleConnection_Canceled(t *testing.T) {
+	// SOCKS5 прокси принимает соединения, но не отвечает на handshake
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания сервера: %v", err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	rtr := &retryRouter{badConfig: &config.OutboundConfig{Type: "socks5", ID: "silent", ProxyAddress: silent.Addr().String()}}
+	clientConn, proxyConn := net.Pipe()
+	defer clientConn.Close()
+
+	// Клиент отключился во время подключения: повторов нет, handler завершается сразу
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		done <- HandleConnection(ctx, proxyConn, "127.0.0.1:9", nil, &ConnOptions{
+			Outbound: outbound.NewDirectOutbound(0), OutboundID: "direct", OutboundConfig: &config.OutboundConfig{Type: "direct"},
+			Router: rtr, Plugins: plugin.NewManager(), Factory: (&socks5Factory{}).newOutbound, Retry: RetryPolicy{Attempts: 3},
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Ожидалась ошибка context.Canceled, получено %v", err)
+		}
+		if attempts := rtr.ctx.DialAttempts(); len(attempts) != 1 {
+			t.Errorf("После отмены не должно быть повторов: %+v", attempts)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("HandleConnection не завершился после отмены контекста")
+	}
+}
+
+// recordingRouter запоминает адрес назначения и контекст соединения, выбирает текущий outbound
+type recordingRouter struct {
+	targetAddress string
+	ctx           *plugin.ConnectionContext
+}
+
+func (r *recordingRouter) SelectOutbound(ctx *plugin.ConnectionContext, targetAddress string, currentOutboundID string, currentOutboundConfig *config.OutboundConfig) (string, *config.OutboundConfig, error) {
+	r.targetAddress, r.ctx = targetAddress, ctx
+	return "", nil, nil
+}
+
+// redirectOutbound подключается к target независимо от запрошенного адреса
+type redirectOutbound struct {
+	target string
+}
+
+func (o *redirectOutbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return net.Dial(network, o.target)
+}
+
+// resolvedConn соединение, для которого outbound сообщает адрес назначения (как устройство)
+type resolvedConn struct {
+	net.Conn
+	resolvedAddr string
+}
+
+func (c *resolvedConn) ResolvedAddr() string { return c.resolvedAddr }
+func (c *resolvedConn) EgressIP() string     { return "" }
+
+// resolvingOutbound подключается к target и сообщает resolvedAddr как адрес назначения
+type resolvingOutbound struct {
+	target       string
+	resolvedAddr string
+}
+
+func (o *resolvingOutbound) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := net.Dial(network, o.target)
+	if err != nil {
+		return nil, err
+	}
+	return &resolvedConn{Conn: conn, resolvedAddr: o.resolvedAddr}, nil
+}
+
+func TestHandleConnection_CheckResolved(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания сервера: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go CopyData(conn, conn)
+		}
+	}()
+
+	// Домен разрешен outbound в запрещенный адрес: клиент получает отказ, а не соединение
+	check := func(ip net.IP, port int) error {
+		if ip.IsPrivate() {
+			return fmt.Errorf("%w: destination %s is in a private range", connerr.ErrNotAllowed, ip)
+		}
+		return nil
+	}
+	run := func(resolvedAddr string) error {
+		clientConn, proxyConn := net.Pipe()
+		defer clientConn.Close()
+		ob := &resolvingOutbound{target: listener.Addr().String(), resolvedAddr: resolvedAddr}
+		done := make(chan error, 1)
+		go func() {
+			done <- HandleConnection(context.Background(), proxyConn, "rebind.example:80", nil, &ConnOptions{
+				Outbound: ob, OutboundID: "device", OutboundConfig: &config.OutboundConfig{Type: "direct"},
+				Router: router.NewStaticRouter(), Plugins: plugin.NewManager(), CheckResolved: check,
+			})
+		}()
+		clientConn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := clientConn.Write([]byte("ping")); err == nil {
+			buf := make([]byte, 4)
+			clientConn.Read(buf)
+		}
+		clientConn.Close()
+		return <-done
+	}
+
+	if err := run("10.0.0.1:80"); !errors.Is(err, connerr.ErrNotAllowed) {
+		t.Errorf("Ожидалась ошибка ErrNotAllowed для запрещенного адреса, получено %v", err)
+	}
+	if err := run("93.184.216.34:80"); err != nil {
+		t.Errorf("Ожидалось успешное соединение для разрешенного адреса, получено %v", err)
+	}
+}
+
+func TestHandleConnection_Sniffing(t *testing.T) {
+	echoListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания сервера: %v", err)
+	}
+	defer echoListener.Close()
+	go func() {
+		for {
+			conn, err := echoListener.Accept()
+			if err != nil {
+				return
+			}
+			go CopyData(conn, conn)
+		}
+	}()
+
+	request := "GET / HTTP/1.1\r\nHost: Example.com\r\n\r\n"
+	run := func(sniffing SniffPolicy, data string) (*recordingRouter, error) {
+		rtr := &recordingRouter{}
+		clientConn, proxyConn := net.Pipe()
+		defer clientConn.Close()
+		done := make(chan error, 1)
+		go func() {
+			done <- HandleConnection(context.Background(), proxyConn, "192.0.2.1:80", nil, &ConnOptions{
+				Outbound: &redirectOutbound{target: echoListener.Addr().String()}, OutboundID: "direct", OutboundConfig: &config.OutboundConfig{Type: "direct"},
+				Router: rtr, Plugins: plugin.NewManager(), Sniffing: sniffing,
+			})
+		}()
+
+		// Данные, прочитанные при определении протокола, пересылаются без потерь
+		clientConn.SetDeadline(time.Now().Add(2 * time.Second))
+		if data == "" {
+			time.Sleep(2 * sniffing.Timeout)
+		} else if _, err := clientConn.Write([]byte(data)); err != nil {
+			return rtr, <-done
+		}
+		buf := make([]byte, len(data)+4)
+		n := 0
+		for n < len(data) {
+			m, err := clientConn.Read(buf[n:])
+			n += m
+			if err != nil {
+				break
+			}
+		}
+		if string(buf[:n]) != data {
+			t.Errorf("Неверный ответ: %q", buf[:n])
+		}
+		clientConn.Write([]byte("ping"))
+		if m, err := io.ReadFull(clientConn, buf[:4]); err != nil || string(buf[:m]) != "ping" {
+			t.Errorf("Неверный ответ после определения протокола: %q, %v", buf[:m], err)
+		}
+		clientConn.Close()
+		return rtr, <-done
+	}
+
+	// Домен из заголовка Host заменяет IP назначения
+	rtr, _ := run(SniffPolicy{Enabled: true, Override: true, Timeout: time.Second}, request)
+	if rtr.targetAddress != "example.com:80" || rtr.ctx.SniffedProtocol != "http" || rtr.ctx.SniffedDomain != "example.com" {
+		t.Errorf("Неверный адрес назначения %q (протокол %q, домен %q)", rtr.targetAddress, rtr.ctx.SniffedProtocol, rtr.ctx.SniffedDomain)
+	}
+
+	// Без override домен только сохраняется в контексте
+	rtr, _ = run(SniffPolicy{Enabled: true, Timeout: time.Second}, request)
+	if rtr.targetAddress != "192.0.2.1:80" || rtr.ctx.SniffedDomain != "example.com" {
+		t.Errorf("Неверный адрес назначения %q (домен %q)", rtr.targetAddress, rtr.ctx.SniffedDomain)
+	}
+
+	// Клиент ждет данных от сервера: подключение к IP после таймаута
+	rtr, _ = run(SniffPolicy{Enabled: true, Override: true, Timeout: 50 * time.Millisecond}, "")
+	if rtr.targetAddress != "192.0.2.1:80" || rtr.ctx.SniffedProtocol != "" {
+		t.Errorf("Неверный адрес назначения %q (протокол %q)", rtr.targetAddress, rtr.ctx.SniffedProtocol)
+	}
+
+	// Домен проверяется политикой адресов назначения
+	denied := errors.New("denied")
+	check := func(ctx context.Context, address string) error {
+		if address == "example.com:80" {
+			return denied
+		}
+		return nil
+	}
+	clientConn, proxyConn := net.Pipe()
+	defer clientConn.Close()
+	go clientConn.Write([]byte(request))
+	err = HandleConnection(context.Background(), proxyConn, "192.0.2.1:80", nil, &ConnOptions{
+		Outbound: &redirectOutbound{target: echoListener.Addr().String()}, OutboundID: "direct", OutboundConfig: &config.OutboundConfig{Type: "direct"},
+		Router: &recordingRouter{}, Plugins: plugin.NewManager(),
+		Sniffing: SniffPolicy{Enabled: true, Override: true, Timeout: time.Second, Check: check},
+	})
+	if !errors.Is(err, denied) {
+		t.Errorf("Ожидалась ошибка политики, получено %v", err)
+	}
+}
+
+func TestHandleConnection_FailureReply(t *testing.T) {
+	// Ошибка подключения возвращается SOCKS5 клиенту reply code до закрытия соединения
+	blocked := outbound.NewBlockOutbound("test", outbound.BlockReject, nil)
+	// Свободный порт для inbound
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	socks := inbound.NewSOCKS5Inbound("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, nil)
+	err = socks.Start(func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		return HandleConnection(ctx, conn, targetAddress, connCtx, &ConnOptions{
+			Outbound: blocked, OutboundID: "block", OutboundConfig: &config.OutboundConfig{Type: "block"},
+			Router: router.NewStaticRouter(), Plugins: plugin.NewManager(),
+		})
+	})
+	if err != nil {
+		t.Fatalf("Ошибка запуска inbound: %v", err)
+	}
+	defer socks.Stop()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write([]byte{0x05, 0x01, 0x00})
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		t.Fatalf("Ошибка чтения ответа на приветствие: %v", err)
+	}
+	conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x50})
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Ошибка чтения reply: %v", err)
+	}
+	if reply[1] != socks5.ReplyConnectionNotAllowed {
+		t.Errorf("Неверный reply code: ожидалось %d, получено %d", socks5.ReplyConnectionNotAllowed, reply[1])
+	}
+}
+
+func TestHandleConnection_SniffingFailureReply(t *testing.T) {
+	blocked := outbound.NewBlockOutbound("test", outbound.BlockReject, nil)
+	// connect запрашивает CONNECT к IP адресу и возвращает reply code и время ожидания ответа
+	connect := func(sniffing SniffPolicy) (byte, time.Duration) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Ошибка создания слушателя: %v", err)
+		}
+		address := listener.Addr().String()
+		listener.Close()
+		socks := inbound.NewSOCKS5Inbound("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, nil)
+		err = socks.Start(func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+			return HandleConnection(ctx, conn, targetAddress, connCtx, &ConnOptions{
+				Outbound: blocked, OutboundID: "block", OutboundConfig: &config.OutboundConfig{Type: "block"},
+				Router: router.NewStaticRouter(), Plugins: plugin.NewManager(), Sniffing: sniffing,
+			})
+		})
+		if err != nil {
+			t.Fatalf("Ошибка запуска inbound: %v", err)
+		}
+		defer socks.Stop()
+
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("Ошибка подключения: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte{0x05, 0x01, 0x00})
+		greeting := make([]byte, 2)
+		if _, err := io.ReadFull(conn, greeting); err != nil {
+			t.Fatalf("Ошибка чтения ответа на приветствие: %v", err)
+		}
+		start := time.Now()
+		conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 192, 0, 2, 1, 0x01, 0xbb})
+		reply := make([]byte, 10)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			t.Fatalf("Ошибка чтения reply: %v", err)
+		}
+		return reply[1], time.Since(start)
+	}
+
+	// Клиент с включенным sniffing получает код ошибки подключения без ожидания своих данных
+	code, elapsed := connect(SniffPolicy{Enabled: true, Override: true, Timeout: 2 * time.Second})
+	if code != socks5.ReplyConnectionNotAllowed {
+		t.Errorf("Неверный reply code: ожидалось %d, получено %d", socks5.ReplyConnectionNotAllowed, code)
+	}
+	if elapsed >= time.Second {
+		t.Errorf("Reply отправлен через %v, после ожидания данных клиента", elapsed)
+	}
+
+	// С EarlyReply успех сообщается до подключения, ошибка видна только как закрытие соединения
+	code, _ = connect(SniffPolicy{Enabled: true, Timeout: 50 * time.Millisecond, EarlyReply: true})
+	if code != socks5.ReplySuccess {
+		t.Errorf("Неверный reply code с early reply: ожидалось %d, получено %d", socks5.ReplySuccess, code)
+	}
+}
+
+func TestHandleConnection_UserID(t *testing.T) {
+	// Логин, с которым клиент аутентифицировался в SOCKS5 inbound, доходит до router и плагинов
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка создания слушателя: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	rtr := &recordingRouter{}
+	done := make(chan error, 1)
+	socks := inbound.NewSOCKS5Inbound("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, nil)
+	socks.SetUsers(map[string]string{"alice": "secret"})
+	err = socks.Start(func(ctx context.Context, conn net.Conn, targetAddress string, connCtx *plugin.ConnectionContext) error {
+		err := HandleConnection(ctx, conn, targetAddress, connCtx, &ConnOptions{
+			Outbound: outbound.NewBlockOutbound("test", outbound.BlockReject, nil), OutboundID: "block", OutboundConfig: &config.OutboundConfig{Type: "block"},
+			Router: rtr, Plugins: plugin.NewManager(),
+		})
+		done <- err
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Ошибка запуска inbound: %v", err)
+	}
+	defer socks.Stop()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Ошибка подключения: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write([]byte{0x05, 0x01, socks5.MethodUserPass})
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil || greeting[1] != socks5.MethodUserPass {
+		t.Fatalf("Неверный ответ на приветствие: %v, %v", greeting, err)
+	}
+	auth, _ := socks5.BuildUserPassAuth("alice", "secret")
+	conn.Write(auth)
+	status := make([]byte, 2)
+	if _, err := io.ReadFull(conn, status); err != nil || status[1] != 0x00 {
+		t.Fatalf("Аутентификация не прошла: %v, %v", status, err)
+	}
+	conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x50})
+	<-done
+	if rtr.ctx == nil || rtr.ctx.UserID != "alice" {
+		t.Errorf("UserID не передан в контекст соединения: %+v", rtr.ctx)
+	}
+}
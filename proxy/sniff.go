@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"example.com/me/myproxy/internal/logger"
+	"example.com/me/myproxy/internal/plugin"
+	"example.com/me/myproxy/internal/sniff"
+)
+
+// SniffPolicy параметры определения домена назначения по первым байтам клиента
+// (TLS ClientHello SNI, HTTP Host), если клиент передал адрес назначения IP адресом
+type SniffPolicy struct {
+	Enabled bool
+	// Override подключаться к определенному домену с исходным портом вместо IP
+	Override bool
+	// Timeout время ожидания первых байт клиента
+	Timeout time.Duration
+	// Check проверяет адрес назначения после замены на домен (nil - без проверки)
+	Check func(ctx context.Context, address string) error
+	// EarlyReply определять домен и для соединений, откладывающих ответ клиенту (см. Replier):
+	// клиент отправляет данные только после ответа, поэтому успех сообщается до подключения,
+	// и ошибки подключения клиент видит как закрытие соединения. Без EarlyReply такие соединения
+	// не определяются и получают ответ по результату подключения
+	EarlyReply bool
+}
+
+// sniffConn соединение клиента, первые байты которого прочитаны для определения протокола
+// Read возвращает сначала прочитанные байты, затем ошибку чтения (если была), затем данные соединения
+type sniffConn struct {
+	net.Conn
+	peeked []byte
+	err    error
+}
+
+// Read возвращает сначала байты, прочитанные при определении протокола
+func (c *sniffConn) Read(b []byte) (int, error) {
+	if len(c.peeked) > 0 {
+		n := copy(b, c.peeked)
+		c.peeked = c.peeked[n:]
+		return n, nil
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(b)
+}
+
+// sniffDestination читает первые байты клиента и определяет протокол и домен назначения
+// Данные не теряются: возвращаемое соединение отдает их при чтении.
+// Данные читаются до определения протокола, policy.Timeout или MaxPeekSize байт
+func sniffDestination(conn net.Conn, policy SniffPolicy) (net.Conn, sniff.Result, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(policy.Timeout)); err != nil {
+		return conn, sniff.Result{}, err
+	}
+
+	buf := make([]byte, sniff.MaxPeekSize)
+	n := 0
+	var result sniff.Result
+	sniffErr := sniff.ErrIncomplete
+	var readErr error
+	for n < len(buf) && errors.Is(sniffErr, sniff.ErrIncomplete) {
+		var m int
+		m, readErr = conn.Read(buf[n:])
+		n += m
+		if m > 0 {
+			result, sniffErr = sniff.Sniff(buf[:n])
+		}
+		if readErr != nil {
+			break
+		}
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return conn, sniff.Result{}, err
+	}
+	// Истечение времени ожидания - клиент ждет данных от сервера (например, SMTP)
+	if errors.Is(readErr, os.ErrDeadlineExceeded) {
+		readErr = nil
+	}
+	peeked := &sniffConn{Conn: conn, peeked: buf[:n], err: readErr}
+	if sniffErr != nil {
+		return peeked, sniff.Result{}, nil
+	}
+	return peeked, result, nil
+}
+
+// applySniffing определяет домен назначения, если targetAddress - IP адрес, и сохраняет его в ctx
+// Возвращает соединение клиента для пересылки данных и адрес назначения
+// (домен с исходным портом, если policy.Override)
+func applySniffing(
+	parent context.Context,
+	inboundConn net.Conn,
+	targetAddress string,
+	ctx *plugin.ConnectionContext,
+	policy SniffPolicy,
+) (net.Conn, string, error) {
+	host, port, err := net.SplitHostPort(targetAddress)
+	if err != nil || net.ParseIP(host) == nil {
+		return inboundConn, targetAddress, nil
+	}
+
+	// Клиент отправляет данные только после ответа (например, SOCKS5 reply): с EarlyReply
+	// успех сообщается до подключения, иначе домен не определяется
+	if replier, ok := inboundConn.(Replier); ok {
+		if !policy.EarlyReply {
+			return inboundConn, targetAddress, nil
+		}
+		if err := replier.Reply(nil); err != nil {
+			return nil, "", err
+		}
+	}
+
+	clientConn, result, err := sniffDestination(inboundConn, policy)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to sniff destination: %w", err)
+	}
+	if result.Protocol == "" {
+		logger.Debug("proxy", "Failed to sniff protocol of connection to %s", targetAddress)
+		return clientConn, targetAddress, nil
+	}
+	ctx.SniffedProtocol = result.Protocol
+	ctx.SniffedDomain = result.Domain
+	logger.Debug("proxy", "Sniffed %s connection to %s: domain %q", result.Protocol, targetAddress, result.Domain)
+
+	if !policy.Override || result.Domain == "" {
+		return clientConn, targetAddress, nil
+	}
+	overridden := net.JoinHostPort(result.Domain, port)
+	if policy.Check != nil {
+		if err := policy.Check(parent, overridden); err != nil {
+			return nil, "", err
+		}
+	}
+	logger.Debug("proxy", "Destination %s overridden with sniffed %s", targetAddress, overridden)
+	ctx.TargetAddress = overridden
+	return clientConn, overridden, nil
+}